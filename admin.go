@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// AdminPlugin describes active plugin in admin API
+type AdminPlugin struct {
+	Pipeline    string `json:"pipeline,omitempty"`
+	Kind        string `json:"kind"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// NewAdminHandler returns handler of admin API:
+//
+//	/metrics - Prometheus metrics of all plugins
+//	/plugins - JSON list of active plugins
+//	/reload  - POST to reload filters and rewrite rules, same as SIGHUP
+func NewAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+
+	mux.HandleFunc("/plugins", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminPlugins())
+	})
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Use POST to reload config", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := reloadConfig(reloadArgs); err != nil {
+			http.Error(w, "Can't reload config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte("OK\n"))
+	})
+
+	return mux
+}
+
+// startAdminServer starts admin API on given address in background
+func startAdminServer(address string) {
+	go func() {
+		log.Println("Admin API:", http.ListenAndServe(address, NewAdminHandler()))
+	}()
+}
+
+func adminPlugins() []AdminPlugin {
+	plugins := []AdminPlugin{}
+
+	add := func(pipeline string, p *InOutPlugins) {
+		for _, in := range p.Inputs {
+			plugins = append(plugins, AdminPlugin{pipeline, "input", pluginType(in), fmt.Sprint(in)})
+		}
+		for _, out := range p.Outputs {
+			plugins = append(plugins, AdminPlugin{pipeline, "output", pluginType(out), fmt.Sprint(out)})
+		}
+	}
+
+	add("", Plugins)
+	for _, p := range Pipelines {
+		add(p.Name, p.Plugins)
+	}
+
+	return plugins
+}
+
+// pluginType returns type name of plugin, skipping limiter and pipeline wrappers
+func pluginType(plugin interface{}) string {
+	t := reflect.TypeOf(unwrapPlugin(plugin))
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Name()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdminAPI(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	input := NewTestInput()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		wg.Done()
+	}))
+	defer server.Close()
+
+	output := NewTestOutput(func(data []byte) {
+		wg.Done()
+	})
+
+	Plugins = &InOutPlugins{
+		Inputs:  []io.Reader{input},
+		Outputs: []io.Writer{output},
+		All:     []interface{}{input, output},
+	}
+
+	// Registered plugins are labeled by option name and address
+	if err := Plugins.registerPlugin("output-http", NewHTTPOutput, server.URL, &HTTPOutputConfig{workersMax: 2, workersMin: 2, Timeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := ioutil.TempDir("", "gor_admin")
+	defer os.RemoveAll(dir)
+	if err := Plugins.registerPlugin("output-file", NewFileOutput, dir+"/requests_%i.gor", &FileOutputConfig{flushInterval: time.Minute, sizeLimit: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	go Start(quit)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		input.EmitGET()
+	}
+
+	wg.Wait()
+
+	admin := httptest.NewServer(NewAdminHandler())
+	defer admin.Close()
+
+	scrape := func() string {
+		resp, err := http.Get(admin.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		return string(body)
+	}
+
+	metrics := scrape()
+
+	for _, line := range []string{
+		`gor_plugin_read_payloads_total{plugin="TestInput"} 10`,
+		`gor_plugin_written_payloads_total{plugin="TestOutput"} 10`,
+		`gor_plugin_written_payloads_total{plugin="output-file ` + dir + `/requests_%i.gor"} 10`,
+		`gor_plugin_workers{plugin="output-http ` + server.URL + `"} 2`,
+		`gor_plugin_queue_length{plugin="output-http ` + server.URL + `"}`,
+		`gor_plugin_latency_seconds_count{plugin="output-http ` + server.URL + `"} 10`,
+		`# TYPE gor_plugin_latency_seconds histogram`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Metrics should contain %q:\n%s", line, metrics)
+		}
+	}
+
+	// File output rotates chunks, but its series stay the same
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		input.EmitGET()
+	}
+	wg.Wait()
+
+	if labels, next := metricSeries(metrics), metricSeries(scrape()); !reflect.DeepEqual(labels, next) {
+		t.Errorf("Series should not change between scrapes:\n%v\n%v", labels, next)
+	}
+
+	resp, err := http.Get(admin.URL + "/plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plugins []AdminPlugin
+	json.NewDecoder(resp.Body).Decode(&plugins)
+	resp.Body.Close()
+
+	if len(plugins) != 4 {
+		t.Fatal("Should list 4 plugins:", plugins)
+	}
+
+	if plugins[0].Kind != "input" || plugins[0].Type != "TestInput" || plugins[0].Description != "Test Input" {
+		t.Error("Wrong input description:", plugins[0])
+	}
+
+	if plugins[2].Kind != "output" || plugins[2].Type != "HTTPOutput" || plugins[2].Description != "HTTP output: "+server.URL {
+		t.Error("Wrong output description:", plugins[2])
+	}
+
+	close(quit)
+}
+
+// metricSeries returns names and labels of all series, without values
+func metricSeries(metrics string) (series []string) {
+	for _, line := range strings.Split(metrics, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "gor_goroutines") {
+			continue
+		}
+
+		series = append(series, line[:strings.LastIndexByte(line, ' ')])
+	}
+	sort.Strings(series)
+
+	return
+}
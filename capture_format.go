@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+// Indexed capture format stores payloads as length-prefixed records, so bodies can contain any bytes,
+// including payload separator of the text format. File layout:
+//
+//	header:  "GORB" magic, 1 byte version, 3 reserved bytes
+//	record:  1 byte block type 'R', uint32 length, uint32 CRC-32 of data, data
+//	index:   1 byte block type 'I', uint32 length, uint32 CRC-32 of data, index entries
+//	trailer: uint64 index offset, uint64 number of records, "GORI" magic
+//
+// Each index entry is: uint64 record offset, int64 timestamp, 1 byte ID length, ID.
+// Index and trailer written when file closed, file without them, e.g. after crash, still can be read sequentially.
+// All numbers are big endian.
+
+const (
+	captureMagic        = "GORB"
+	captureVersion      = 1
+	captureHeaderSize   = 8
+	captureTrailerMagic = "GORI"
+	captureTrailerSize  = 20
+
+	captureRecordBlock = 'R'
+	captureIndexBlock  = 'I'
+	captureBlockHeader = 9
+
+	// Protects from allocating huge buffers on corrupted length
+	maxCaptureRecordSize = 1 << 30
+)
+
+// Capture file formats, used by --output-file-format
+const (
+	CaptureFormatText   = "text"
+	CaptureFormatBinary = "binary"
+	CaptureFormatJSON   = "json"
+)
+
+// ErrCaptureChecksum returned when record data does not match its checksum
+var ErrCaptureChecksum = errors.New("capture record checksum mismatch")
+
+type captureIndexEntry struct {
+	offset    int64
+	timestamp int64
+	id        string
+}
+
+// isCaptureFormat checks if stream starts with header of indexed capture format
+func isCaptureFormat(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(captureMagic))
+	return err == nil && string(magic) == captureMagic
+}
+
+// captureWriter writes payloads in indexed capture format
+type captureWriter struct {
+	w      io.Writer
+	offset int64
+	index  []captureIndexEntry
+}
+
+func newCaptureWriter(w io.Writer) (*captureWriter, error) {
+	header := make([]byte, captureHeaderSize)
+	copy(header, captureMagic)
+	header[len(captureMagic)] = captureVersion
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &captureWriter{w: w, offset: captureHeaderSize}, nil
+}
+
+func (c *captureWriter) writeBlock(blockType byte, data []byte) (int, error) {
+	var header [captureBlockHeader]byte
+	header[0] = blockType
+	binary.BigEndian.PutUint32(header[1:5], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[5:9], crc32.ChecksumIEEE(data))
+
+	if _, err := c.w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := c.w.Write(data); err != nil {
+		return 0, err
+	}
+
+	n := captureBlockHeader + len(data)
+	c.offset += int64(n)
+
+	return n, nil
+}
+
+// WriteRecord writes payload, and returns number of bytes written to the file
+func (c *captureWriter) WriteRecord(payload []byte) (int, error) {
+	entry := captureIndexEntry{offset: c.offset}
+	if meta := payloadMeta(payload); len(meta) >= 3 {
+		entry.id = string(meta[1])
+		entry.timestamp, _ = strconv.ParseInt(string(meta[2]), 10, 64)
+	}
+
+	n, err := c.writeBlock(captureRecordBlock, payload)
+	if err == nil {
+		c.index = append(c.index, entry)
+	}
+
+	return n, err
+}
+
+// Close writes index and trailer, it does not close underlying writer
+func (c *captureWriter) Close() error {
+	var buf bytes.Buffer
+	var num [8]byte
+
+	for _, e := range c.index {
+		id := e.id
+		if len(id) > 255 {
+			id = id[:255]
+		}
+
+		binary.BigEndian.PutUint64(num[:], uint64(e.offset))
+		buf.Write(num[:])
+		binary.BigEndian.PutUint64(num[:], uint64(e.timestamp))
+		buf.Write(num[:])
+		buf.WriteByte(byte(len(id)))
+		buf.WriteString(id)
+	}
+
+	indexOffset := c.offset
+	if _, err := c.writeBlock(captureIndexBlock, buf.Bytes()); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, captureTrailerSize)
+	binary.BigEndian.PutUint64(trailer[0:8], uint64(indexOffset))
+	binary.BigEndian.PutUint64(trailer[8:16], uint64(len(c.index)))
+	copy(trailer[16:], captureTrailerMagic)
+
+	_, err := c.w.Write(trailer)
+	return err
+}
+
+// newCaptureStream writes payloads in capture format to memory. Used by inputs which convert files of other formats,
+// binary format keeps bodies with any content, and its index allows seeking when stream is reopened.
+func newCaptureStream(payloads [][]byte) io.ReadCloser {
+	var buf bytes.Buffer
+
+	w, _ := newCaptureWriter(&buf)
+	for _, p := range payloads {
+		w.WriteRecord(p)
+	}
+	w.Close()
+
+	return captureStream{bytes.NewReader(buf.Bytes())}
+}
+
+type captureStream struct {
+	*bytes.Reader
+}
+
+func (captureStream) Close() error {
+	return nil
+}
+
+// captureReader reads payloads in indexed capture format.
+// If underlying reader is seekable and file has index, it supports seeking by time and request ID.
+type captureReader struct {
+	r      *bufio.Reader
+	seeker io.ReadSeeker
+	offset int64
+
+	// Set only if file has valid index
+	index       []captureIndexEntry
+	ids         map[string][]int64
+	indexOffset int64
+}
+
+func newCaptureReader(r io.Reader) (*captureReader, error) {
+	c := &captureReader{}
+
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		c.seeker = seeker
+
+		// Files without index can be read sequentially, so index errors ignored
+		c.loadIndex()
+
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	c.r = bufio.NewReader(r)
+
+	header := make([]byte, captureHeaderSize)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return nil, fmt.Errorf("can't read capture header: %v", err)
+	}
+
+	if string(header[:len(captureMagic)]) != captureMagic {
+		return nil, errors.New("not a capture file")
+	}
+
+	if v := header[len(captureMagic)]; v != captureVersion {
+		return nil, fmt.Errorf("unsupported capture format version: %d", v)
+	}
+
+	c.offset = captureHeaderSize
+
+	return c, nil
+}
+
+func (c *captureReader) loadIndex() error {
+	size, err := c.seeker.Seek(-captureTrailerSize, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	trailer := make([]byte, captureTrailerSize)
+	if _, err := io.ReadFull(c.seeker, trailer); err != nil {
+		return err
+	}
+
+	if string(trailer[16:]) != captureTrailerMagic {
+		return errors.New("capture file has no index")
+	}
+
+	indexOffset := int64(binary.BigEndian.Uint64(trailer[0:8]))
+	count := int(binary.BigEndian.Uint64(trailer[8:16]))
+
+	if indexOffset < captureHeaderSize || indexOffset > size {
+		return errors.New("wrong capture index offset")
+	}
+
+	if _, err := c.seeker.Seek(indexOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	blockType, data, err := readCaptureBlock(bufio.NewReader(io.LimitReader(c.seeker, size-indexOffset)))
+	if err != nil {
+		return err
+	}
+
+	if blockType != captureIndexBlock {
+		return errors.New("wrong capture index block")
+	}
+
+	if count > len(data)/17 {
+		return errors.New("capture index is corrupted")
+	}
+
+	index := make([]captureIndexEntry, 0, count)
+	ids := make(map[string][]int64)
+
+	for len(data) >= 17 {
+		e := captureIndexEntry{
+			offset:    int64(binary.BigEndian.Uint64(data[0:8])),
+			timestamp: int64(binary.BigEndian.Uint64(data[8:16])),
+		}
+
+		idLen := int(data[16])
+		if len(data) < 17+idLen {
+			break
+		}
+
+		e.id = string(data[17 : 17+idLen])
+		data = data[17+idLen:]
+
+		index = append(index, e)
+		ids[e.id] = append(ids[e.id], e.offset)
+	}
+
+	if len(index) != count {
+		return errors.New("capture index is corrupted")
+	}
+
+	c.index, c.ids, c.indexOffset = index, ids, indexOffset
+
+	return nil
+}
+
+func readCaptureBlock(r io.Reader) (blockType byte, data []byte, err error) {
+	var header [captureBlockHeader]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("capture file is truncated")
+		}
+		return
+	}
+
+	blockType = header[0]
+	size := binary.BigEndian.Uint32(header[1:5])
+	sum := binary.BigEndian.Uint32(header[5:9])
+
+	if blockType != captureRecordBlock && blockType != captureIndexBlock {
+		return 0, nil, fmt.Errorf("unknown capture block type: %q", blockType)
+	}
+
+	if size > maxCaptureRecordSize {
+		return 0, nil, fmt.Errorf("capture record is too large: %d", size)
+	}
+
+	data = make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errors.New("capture file is truncated")
+		}
+		return
+	}
+
+	if crc32.ChecksumIEEE(data) != sum {
+		return blockType, data, ErrCaptureChecksum
+	}
+
+	return
+}
+
+// Next returns next payload, io.EOF at the end of records, or ErrCaptureChecksum if payload is corrupted.
+// After checksum error reading can be continued.
+func (c *captureReader) Next() ([]byte, error) {
+	blockType, data, err := readCaptureBlock(c.r)
+	if err != nil && err != ErrCaptureChecksum {
+		return nil, err
+	}
+
+	c.offset += int64(captureBlockHeader + len(data))
+
+	if blockType == captureIndexBlock {
+		return nil, io.EOF
+	}
+
+	return data, err
+}
+
+// Count returns number of records, using index. Returns false if file has no index.
+func (c *captureReader) Count() (int, bool) {
+	if c.index == nil {
+		return 0, false
+	}
+
+	return len(c.index), true
+}
+
+// SeekRecord moves to the record with given number, in order of writing.
+// Returns false if file has no index.
+func (c *captureReader) SeekRecord(n int) (bool, error) {
+	if c.index == nil {
+		return false, nil
+	}
+
+	offset := c.indexOffset
+	if n < len(c.index) {
+		offset = c.index[n].offset
+	}
+
+	return true, c.seek(offset)
+}
+
+func (c *captureReader) seek(offset int64) error {
+	// Compressed stream can be read only forward
+	if c.seeker == nil {
+		if offset < c.offset {
+			return errors.New("can't seek backward in compressed capture file")
+		}
+
+		if _, err := c.r.Discard(int(offset - c.offset)); err != nil {
+			return err
+		}
+
+		c.offset = offset
+		return nil
+	}
+
+	if _, err := c.seeker.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	c.r.Reset(c.seeker)
+	c.offset = offset
+
+	return nil
+}
+
+// SeekTime moves to the first record, in order of writing, with timestamp not earlier than given one.
+// Returns false if file has no index.
+func (c *captureReader) SeekTime(timestamp int64) (bool, error) {
+	if c.index == nil {
+		return false, nil
+	}
+
+	offset := c.indexOffset
+	for _, e := range c.index {
+		if e.timestamp >= timestamp {
+			offset = e.offset
+			break
+		}
+	}
+
+	return true, c.seek(offset)
+}
+
+// ReadID returns all payloads with given request ID: request, response and replayed response.
+// Returns false if file has no index. Current reading position is not changed.
+func (c *captureReader) ReadID(id string) ([][]byte, bool, error) {
+	if c.index == nil {
+		return nil, false, nil
+	}
+
+	current := c.offset
+	defer c.seek(current)
+
+	var payloads [][]byte
+	for _, offset := range c.ids[id] {
+		if err := c.seek(offset); err != nil {
+			return nil, true, err
+		}
+
+		payload, err := c.Next()
+		if err != nil {
+			return nil, true, err
+		}
+
+		payloads = append(payloads, payload)
+	}
+
+	return payloads, true, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testCapturePayloads() [][]byte {
+	var payloads [][]byte
+
+	for i, body := range []string{"a=1", "separator" + payloadSeparator + "inside", "b=2"} {
+		header := payloadHeader(RequestPayload, []byte("id"+strconv.Itoa(i)), int64(100*(i+1)), -1)
+		payloads = append(payloads, append(header, []byte("POST / HTTP/1.1\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)...))
+	}
+
+	return payloads
+}
+
+func writeTestCapture(t *testing.T, payloads [][]byte, withIndex bool) *os.File {
+	f, _ := ioutil.TempFile("", "gor_capture")
+
+	w, err := newCaptureWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range payloads {
+		if _, err := w.WriteRecord(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if withIndex {
+		w.Close()
+	}
+
+	f.Seek(0, io.SeekStart)
+
+	return f
+}
+
+func TestCaptureFormat(t *testing.T) {
+	payloads := testCapturePayloads()
+
+	f := writeTestCapture(t, payloads, true)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	r, err := newCaptureReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, ok := r.Count(); !ok || n != 3 {
+		t.Error("Should count records using index:", n, ok)
+	}
+
+	for i, p := range payloads {
+		data, err := r.Next()
+		if err != nil || !bytes.Equal(data, p) {
+			t.Errorf("Record %d does not match: %v %q", i, err, data)
+		}
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Error("Should stop at index:", err)
+	}
+
+	if ok, err := r.SeekTime(150); !ok || err != nil {
+		t.Fatal("Should seek using index:", err)
+	}
+
+	if data, _ := r.Next(); !bytes.Equal(data, payloads[1]) {
+		t.Errorf("Should seek to the first record after given time: %q", data)
+	}
+
+	if ok, err := r.SeekRecord(2); !ok || err != nil {
+		t.Fatal("Should seek to record using index:", err)
+	}
+
+	if data, _ := r.Next(); !bytes.Equal(data, payloads[2]) {
+		t.Errorf("Should seek to the record by its number: %q", data)
+	}
+
+	r.SeekRecord(2)
+
+	found, ok, err := r.ReadID("id2")
+	if !ok || err != nil || len(found) != 1 || !bytes.Equal(found[0], payloads[2]) {
+		t.Errorf("Should find record by ID: %v %q", err, found)
+	}
+
+	if data, _ := r.Next(); !bytes.Equal(data, payloads[2]) {
+		t.Errorf("Lookup by ID should not change reading position: %q", data)
+	}
+}
+
+func TestCaptureFormatWithoutIndex(t *testing.T) {
+	payloads := testCapturePayloads()
+
+	f := writeTestCapture(t, payloads, false)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	r, err := newCaptureReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.Count(); ok {
+		t.Error("File without index should not be counted")
+	}
+
+	for range payloads {
+		if _, err := r.Next(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Error("Should read file until the end:", err)
+	}
+}
+
+func TestCaptureFormatChecksum(t *testing.T) {
+	payloads := testCapturePayloads()
+
+	f := writeTestCapture(t, payloads, true)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Damage body of the first record
+	f.WriteAt([]byte("X"), int64(captureHeaderSize+captureBlockHeader+len(payloads[0])-1))
+
+	r, err := newCaptureReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Next(); err != ErrCaptureChecksum {
+		t.Error("Should detect corrupted record:", err)
+	}
+
+	if data, err := r.Next(); err != nil || !bytes.Equal(data, payloads[1]) {
+		t.Error("Should continue after corrupted record:", err)
+	}
+}
+
+func TestFileOutputBinaryFormat(t *testing.T) {
+	f, _ := ioutil.TempFile("", "gor_capture")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	payloads := testCapturePayloads()
+
+	output := NewFileOutput(f.Name(), &FileOutputConfig{flushInterval: time.Minute, append: true, format: CaptureFormatBinary})
+	for _, p := range payloads {
+		output.Write(p)
+	}
+	output.Close()
+
+	var received [][]byte
+
+	input := NewFileInput(f.Name(), &FileInputConfig{})
+
+	buf := make([]byte, 1000)
+	for {
+		n, err := input.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		received = append(received, append([]byte{}, buf[:n]...))
+	}
+
+	if len(received) != len(payloads) {
+		t.Fatal("Should read all payloads:", len(received))
+	}
+
+	for i := range payloads {
+		if !bytes.Equal(received[i], payloads[i]) {
+			t.Errorf("Payload with separator inside body should not be corrupted: %q", received[i])
+		}
+	}
+}
+
+func TestInputFileBinaryFormatTimeWindow(t *testing.T) {
+	payloads := testCapturePayloads()
+
+	f := writeTestCapture(t, payloads, true)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	config := &FileInputConfig{}
+	config.start.Set(time.Unix(0, 150).Format(time.RFC3339Nano))
+
+	input := NewFileInput(f.Name(), config)
+
+	var received [][]byte
+	buf := make([]byte, 1000)
+	for {
+		n, err := input.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		received = append(received, append([]byte{}, buf[:n]...))
+	}
+
+	if len(received) != 2 || !bytes.Equal(received[0], payloads[1]) {
+		t.Errorf("Should seek to the window start using index: %q", received)
+	}
+}
+
+func TestInputFileBinaryFormatSkip(t *testing.T) {
+	payloads := testCapturePayloads()
+
+	f := writeTestCapture(t, payloads, true)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	for _, skip := range []int{2, 5} {
+		input := NewFileInput(f.Name(), &FileInputConfig{skip: skip})
+
+		input.mu.Lock()
+		skipped := input.skipped
+		input.mu.Unlock()
+
+		if skipped != min(skip, len(payloads)) {
+			t.Error("Should skip payloads using index:", skip, skipped)
+		}
+
+		var received [][]byte
+		buf := make([]byte, 1000)
+		for {
+			n, err := input.Read(buf)
+			if err == io.EOF {
+				break
+			}
+			received = append(received, append([]byte{}, buf[:n]...))
+		}
+
+		if skip < len(payloads) && (len(received) != 1 || !bytes.Equal(received[0], payloads[2])) || skip >= len(payloads) && len(received) != 0 {
+			t.Errorf("Wrong payloads after skipping %d: %q", skip, received)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfig reads YAML or JSON configuration file and applies it to the flags of given set.
+// Keys are option names without leading dashes, values are scalars, or lists for the options which can be repeated:
+//
+//	input-raw: ":80"
+//	output-http:
+//	  - http://staging.com
+//	output-http-timeout: 30s
+//	http-allow-url: ["^/api"]
+//
+// Options explicitly set on the command line take priority, and file values for them are ignored.
+//
+// Optional `pipelines` section defines named pipelines, each with own inputs, filters, modifiers,
+// middleware, rate limit and outputs. Pipelines are stored in settings `pipelines` field.
+func loadConfig(fs *flag.FlagSet, s *AppSettings, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read config file: %v", err)
+	}
+
+	values := make(map[string]interface{})
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+
+	if err != nil {
+		return fmt.Errorf("can't parse config file %s: %v", path, err)
+	}
+
+	pipelines, hasPipelines := values["pipelines"]
+	delete(values, "pipelines")
+
+	if err := applyConfig(fs, values, path); err != nil {
+		return err
+	}
+
+	if hasPipelines {
+		s.pipelines, err = loadPipelines(pipelines, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyConfig sets flags of given set from config values
+func applyConfig(fs *flag.FlagSet, values map[string]interface{}, path string) error {
+	setOnCLI := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setOnCLI[f.Name] = true
+	})
+
+	// Apply in stable order, so errors are reproducible
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := strings.TrimLeft(key, "-")
+
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown option %q in config file %s", key, path)
+		}
+
+		options, err := configValues(values[key])
+		if err != nil {
+			return fmt.Errorf("wrong value of %q in config file %s: %v", key, path, err)
+		}
+
+		if setOnCLI[name] {
+			continue
+		}
+
+		for _, o := range options {
+			if err := fs.Set(name, o); err != nil {
+				return fmt.Errorf("wrong value of %q in config file %s: %v", key, path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Options which affect whole process and can't be set per pipeline
+var processOptions = []string{"config", "verbose", "debug", "stats", "exit-after", "shutdown-timeout", "http-pprof", "http-admin"}
+
+// loadPipelines parses `pipelines` section of config file:
+//
+//	pipelines:
+//	  v1:
+//	    http-allow-url: ^/api/v1
+//	    http-rewrite-url: /api/v1/(.*):/v1/$1
+//	    output-http: http://staging-v1.com
+//	  v2:
+//	    http-allow-url: ^/api/v2
+//	    limit: 10%
+//	    output-http: http://staging-v2.com
+//
+// Each pipeline gets own flag set, so any option except process wide ones can be used.
+// `limit` key sets pipeline rate limit, same as `|N` or `|N%` plugin suffix.
+// Pipelines returned sorted by name.
+func loadPipelines(section interface{}, path string) ([]pipelineSettings, error) {
+	pipelines, err := configMap(section)
+	if err != nil {
+		return nil, fmt.Errorf("wrong value of \"pipelines\" in config file %s: %v", path, err)
+	}
+
+	names := make([]string, 0, len(pipelines))
+	for name := range pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]pipelineSettings, 0, len(names))
+
+	for _, name := range names {
+		values, err := configMap(pipelines[name])
+		if err != nil {
+			return nil, fmt.Errorf("wrong value of pipeline %q in config file %s: %v", name, path, err)
+		}
+
+		p := pipelineSettings{name: name, settings: new(AppSettings)}
+
+		if limit, ok := values["limit"]; ok {
+			if p.limit, err = configScalar(limit); err != nil {
+				return nil, fmt.Errorf("wrong value of \"limit\" in pipeline %q in config file %s: %v", name, path, err)
+			}
+			delete(values, "limit")
+		}
+
+		for key := range values {
+			for _, o := range processOptions {
+				if strings.TrimLeft(key, "-") == o {
+					return nil, fmt.Errorf("option %q can't be set per pipeline in config file %s", key, path)
+				}
+			}
+		}
+
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		registerFlags(fs, p.settings)
+
+		if err := applyConfig(fs, values, path); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %v", name, err)
+		}
+
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+// configMap converts YAML or JSON object to map with string keys
+func configMap(value interface{}) (map[string]interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = val
+		}
+		return m, nil
+	case nil:
+		return map[string]interface{}{}, nil
+	default:
+		return nil, fmt.Errorf("expected map, got %T", value)
+	}
+}
+
+// configValues converts config value to the list of flag values
+func configValues(value interface{}) ([]string, error) {
+	if list, ok := value.([]interface{}); ok {
+		values := make([]string, 0, len(list))
+
+		for _, v := range list {
+			s, err := configScalar(v)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+
+		return values, nil
+	}
+
+	s, err := configScalar(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{s}, nil
+}
+
+func configScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", fmt.Errorf("empty value")
+	default:
+		return "", fmt.Errorf("expected string, number, boolean or list of them, got %T", value)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, ext, data string) string {
+	f, err := ioutil.TempFile("", "gor_config*"+ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(data)
+	f.Close()
+
+	return f.Name()
+}
+
+func testConfigFlags() (*flag.FlagSet, *AppSettings) {
+	s := new(AppSettings)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&s.config, "config", "", "")
+	fs.Var(&s.inputRAW, "input-raw", "")
+	fs.Var(&s.outputHTTP, "output-http", "")
+	fs.BoolVar(&s.inputRAWTrackResponse, "input-raw-track-response", false, "")
+	fs.DurationVar(&s.outputHTTPConfig.Timeout, "output-http-timeout", 5*time.Second, "")
+	fs.IntVar(&s.outputHTTPConfig.workersMax, "output-http-workers", 0, "")
+	fs.Var(&s.modifierConfig.urlRegexp, "http-allow-url", "")
+	fs.Var(&s.modifierConfig.headers, "http-set-header", "")
+
+	return fs, s
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := writeTestConfig(t, ".yaml", `
+input-raw: ":80"
+input-raw-track-response: true
+output-http:
+  - http://staging.com
+  - http://dev.com
+output-http-timeout: 30s
+output-http-workers: 10
+http-allow-url: ["^/api"]
+http-set-header:
+  - "User-Agent: Gor"
+`)
+	defer os.Remove(path)
+
+	fs, s := testConfigFlags()
+	fs.Parse([]string{"--output-http-workers", "5"})
+
+	if err := loadConfig(fs, s, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.inputRAW) != 1 || s.inputRAW[0] != ":80" || !s.inputRAWTrackResponse {
+		t.Error("Wrong input settings:", s.inputRAW, s.inputRAWTrackResponse)
+	}
+
+	if len(s.outputHTTP) != 2 || s.outputHTTP[1] != "http://dev.com" || s.outputHTTPConfig.Timeout != 30*time.Second {
+		t.Error("Wrong output settings:", s.outputHTTP, s.outputHTTPConfig.Timeout)
+	}
+
+	if s.outputHTTPConfig.workersMax != 5 {
+		t.Error("Command line option should take priority:", s.outputHTTPConfig.workersMax)
+	}
+
+	if len(s.modifierConfig.urlRegexp) != 1 || len(s.modifierConfig.headers) != 1 || s.modifierConfig.headers[0].Name != "User-Agent" {
+		t.Error("Wrong modifier settings:", s.modifierConfig)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := writeTestConfig(t, ".json", `{"input-raw": [":80", ":81"], "output-http-workers": 3, "input-raw-track-response": false}`)
+	defer os.Remove(path)
+
+	fs, s := testConfigFlags()
+
+	if err := loadConfig(fs, s, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.inputRAW) != 2 || s.outputHTTPConfig.workersMax != 3 {
+		t.Error("Wrong settings:", s.inputRAW, s.outputHTTPConfig.workersMax)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	var tests = []struct {
+		config string
+		err    string
+	}{
+		{"input-raww: :80", `unknown option "input-raww"`},
+		{"config: other.yaml", `unknown option "config"`},
+		{"output-http-timeout: 30", `wrong value of "output-http-timeout"`},
+		{"http-allow-url: {a: b}", `wrong value of "http-allow-url"`},
+		{"input-raw: [", "can't parse config file"},
+	}
+
+	for _, c := range tests {
+		path := writeTestConfig(t, ".yml", c.config)
+
+		fs, s := testConfigFlags()
+		err := loadConfig(fs, s, path)
+
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q should fail with %q, got: %v", c.config, c.err, err)
+		}
+
+		os.Remove(path)
+	}
+}
+
+func TestLoadConfigPipelines(t *testing.T) {
+	path := writeTestConfig(t, ".yaml", `
+input-raw: ":80"
+pipelines:
+  v2:
+    http-allow-url: ^/api/v2
+    limit: 10%
+    output-http: http://staging-v2.com
+  v1:
+    http-allow-url: ^/api/v1
+    http-rewrite-url: /api/v1/(.*):/v1/$1
+    output-http: [http://staging-v1.com]
+`)
+	defer os.Remove(path)
+
+	fs, s := testConfigFlags()
+
+	if err := loadConfig(fs, s, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.inputRAW) != 1 || len(s.pipelines) != 2 {
+		t.Fatal("Wrong settings:", s.inputRAW, s.pipelines)
+	}
+
+	v1, v2 := s.pipelines[0], s.pipelines[1]
+
+	if v1.name != "v1" || len(v1.settings.outputHTTP) != 1 || len(v1.settings.modifierConfig.urlRewrite) != 1 || v1.limit != "" {
+		t.Error("Wrong v1 pipeline:", v1.name, v1.settings.outputHTTP, v1.limit)
+	}
+
+	if v2.name != "v2" || v2.settings.outputHTTP[0] != "http://staging-v2.com" || v2.limit != "10%" {
+		t.Error("Wrong v2 pipeline:", v2.name, v2.settings.outputHTTP, v2.limit)
+	}
+
+	if v1.settings.copyBufferSize != 5*1024*1024 {
+		t.Error("Pipeline should get default option values:", v1.settings.copyBufferSize)
+	}
+
+	var tests = []struct {
+		config string
+		err    string
+	}{
+		{"pipelines: [a]", `wrong value of "pipelines"`},
+		{"pipelines: {a: {verbose: true}}", `option "verbose" can't be set per pipeline`},
+		{"pipelines: {a: {input-raww: ':80'}}", `pipeline "a": unknown option "input-raww"`},
+	}
+
+	for _, c := range tests {
+		path := writeTestConfig(t, ".yml", c.config)
+
+		fs, s := testConfigFlags()
+		err := loadConfig(fs, s, path)
+
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q should fail with %q, got: %v", c.config, c.err, err)
+		}
+
+		os.Remove(path)
+	}
+}
@@ -2,83 +2,200 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Start initialize loop for sending data from inputs to outputs
-func Start(stop chan int) {
-	if Settings.middleware != "" {
-		middleware := NewMiddleware(Settings.middleware)
+// Start initialize loop for sending data from inputs to outputs.
+// It returns when stop channel closed, all inputs reached the end, or plugin failed and its restart policy is abort,
+// after ordered shutdown: inputs stopped, middleware and outputs drained, and all plugins closed.
+func Start(stop chan int) error {
+	e := newEmitter()
 
-		for _, in := range Plugins.Inputs {
-			middleware.ReadFrom(in)
+	if err := e.startPipeline(&Settings, Plugins, nil); err != nil {
+		e.shutdown(Settings.shutdownTimeout)
+		return err
+	}
+
+	for _, p := range Pipelines {
+		Debug("[PIPELINE] Starting", p)
+		if err := e.startPipeline(p.Settings, p.Plugins, p.limiter); err != nil {
+			e.shutdown(Settings.shutdownTimeout)
+			return fmt.Errorf("%s: %v", p, err)
+		}
+	}
+
+	inputsDone := make(chan bool)
+	go func() {
+		e.inputs.Wait()
+		close(inputsDone)
+	}()
+
+	var err error
+
+	for err == nil {
+		select {
+		case <-stop:
+		case <-inputsDone:
+			Debug("[EMITTER] All inputs reached the end")
+		case err = <-e.errors:
+			log.Println("Error during copy:", err)
+			continue
+		case pe := <-Errors:
+			if handlePluginError(pe) {
+				err = pe
+				log.Println("Plugin failure:", err)
+			}
+			continue
+		}
+
+		break
+	}
+
+	if drainErr := e.shutdown(Settings.shutdownTimeout); err == nil {
+		err = drainErr
+	}
+
+	return err
+}
+
+// emitter runs copy loops of all pipelines and coordinates their shutdown
+type emitter struct {
+	// Payloads which were read, but not yet written to outputs
+	inFlight int64
+
+	inputsStopped  int32
+	outputsStopped int32
+
+	// Copy loops reading from inputs, done when input reaches the end or emitter stops
+	inputs sync.WaitGroup
+	// Copy loops reading from middleware
+	middlewareLoops sync.WaitGroup
+
+	middlewares []*Middleware
+	plugins     []InOutPlugins
+
+	errors chan error
+}
+
+func newEmitter() *emitter {
+	return &emitter{errors: make(chan error, 100)}
+}
+
+// startPipeline starts copying from inputs to outputs using modifier and middleware of given settings
+func (e *emitter) startPipeline(s *AppSettings, plugins *InOutPlugins, limiter *Limiter) error {
+	// Plugins list can be changed after start, e.g. by tests
+	e.plugins = append(e.plugins, *plugins)
+	outputs := append([]io.Writer{}, plugins.Outputs...)
+
+	if s.middleware != "" {
+		middleware, err := NewMiddleware(s.middleware)
+		if err != nil {
+			return err
+		}
+		e.middlewares = append(e.middlewares, middleware)
+
+		for _, in := range plugins.Inputs {
+			e.inputs.Add(1)
+			middleware.ReadFrom(&eofReader{Reader: in, done: e.inputs.Done})
 		}
 
 		// We are going only to read responses, so using same ReadFrom method
-		for _, out := range Plugins.Outputs {
+		for _, out := range outputs {
 			if r, ok := out.(io.Reader); ok {
 				middleware.ReadFrom(r)
 			}
 		}
 
+		e.middlewareLoops.Add(1)
 		go func() {
-			if err := CopyMulty(middleware, Plugins.Outputs...); err != nil {
-				log.Println("Error during copy: ", err)
-				close(stop)
-			}
+			defer e.middlewareLoops.Done()
+			e.copy(s, limiter, nil, middleware, outputs)
 		}()
 	} else {
-		for _, in := range Plugins.Inputs {
+		for _, in := range plugins.Inputs {
+			e.inputs.Add(1)
 			go func(in io.Reader) {
-				if err := CopyMulty(in, Plugins.Outputs...); err != nil {
-					log.Println("Error during copy: ", err)
-					close(stop)
-				}
+				defer e.inputs.Done()
+				e.copy(s, limiter, &e.inputsStopped, in, outputs)
 			}(in)
 		}
 
-		for _, out := range Plugins.Outputs {
+		for _, out := range outputs {
 			if r, ok := out.(io.Reader); ok {
-				go func(r io.Reader) {
-					if err := CopyMulty(r, Plugins.Outputs...); err != nil {
-						log.Println("Error during copy: ", err)
-						close(stop)
-					}
-				}(r)
+				go e.copy(s, limiter, &e.outputsStopped, r, outputs)
 			}
 		}
 	}
 
-	for {
+	return nil
+}
+
+func (e *emitter) copy(s *AppSettings, limiter *Limiter, stopped *int32, src io.Reader, writers []io.Writer) {
+	if err := e.copyMulty(s, limiter, stopped, src, writers...); err != nil {
 		select {
-		case <-stop:
-			finalize()
-			return
-		case <-time.After(100 * time.Millisecond):
+		case e.errors <- err:
+		default:
 		}
 	}
 }
 
 // CopyMulty copies from 1 reader to multiple writers
 func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
-	buf := make([]byte, Settings.copyBufferSize)
+	return newEmitter().copyMulty(&Settings, nil, nil, src, writers...)
+}
+
+// copyMulty copies from 1 reader to multiple writers using given settings.
+// If limiter specified, requests over the limit dropped together with their responses.
+// Loop ends when stopped flag set, payloads read after that are dropped.
+func (e *emitter) copyMulty(s *AppSettings, limiter *Limiter, stopped *int32, src io.Reader, writers ...io.Writer) error {
+	processing := false
+	defer func() {
+		if processing {
+			atomic.AddInt64(&e.inFlight, -1)
+		}
+	}()
+
+	buf := make([]byte, s.copyBufferSize)
 	wIndex := 0
-	modifier := NewHTTPModifier(&Settings.modifierConfig)
+	modifierConfig := s.currentModifierConfig()
+	modifier := NewHTTPModifier(modifierConfig)
 	filteredRequests := make(map[string]time.Time)
 	filteredRequestsLastCleanTime := time.Now()
 
+	srcMetrics := metricsFor(src)
+	writerMetrics := make([]*PluginMetrics, len(writers))
+	for i, w := range writers {
+		writerMetrics[i] = metricsFor(w)
+	}
+
 	i := 0
 
 	for {
+		if processing {
+			atomic.AddInt64(&e.inFlight, -1)
+			processing = false
+		}
+
 		nr, er := src.Read(buf)
 
+		// Counted before the check, so shutdown either waits for this payload or we see the flag
+		atomic.AddInt64(&e.inFlight, 1)
+		processing = true
+
+		if stopped != nil && atomic.LoadInt32(stopped) == 1 {
+			return nil
+		}
+
 		if er == io.EOF {
 			return nil
 		}
 		if er != nil {
-			return err
+			return pluginError(src, er)
 		}
 
 		_maxN := nr
@@ -86,10 +203,12 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 			_maxN = 500
 		}
 		if nr > 0 && len(buf) > nr {
+			srcMetrics.read(nr)
+
 			payload := buf[:nr]
 			meta := payloadMeta(payload)
 			if len(meta) < 3 {
-				if Settings.debug {
+				if s.debug {
 					Debug("[EMITTER] Found malformed record", string(payload[0:_maxN]), nr, "from:", src)
 				}
 				continue
@@ -100,49 +219,69 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 				log.Println("INFO: Large packet... We received ", len(payload), " bytes from ", src)
 			}
 
-			if Settings.debug {
+			if s.debug {
 				Debug("[EMITTER] input:", string(payload[0:_maxN]), nr, "from:", src)
 			}
 
-			if modifier != nil {
+			// Pick up filters and rewrite rules changed by reload
+			if c := s.currentModifierConfig(); c != modifierConfig {
+				modifierConfig = c
+				modifier = NewHTTPModifier(c)
+			}
+
+			if modifier != nil || limiter != nil || len(filteredRequests) > 0 {
 				if isRequestPayload(payload) {
-					headSize := bytes.IndexByte(payload, '\n') + 1
-					body := payload[headSize:]
-					originalBodyLen := len(body)
-					body = modifier.Rewrite(body)
+					if modifier != nil {
+						headSize := bytes.IndexByte(payload, '\n') + 1
+						body := payload[headSize:]
+						originalBodyLen := len(body)
+						body = modifier.RewriteMessage(payloadParser(payload), body)
 
-					// If modifier tells to skip request
-					if len(body) == 0 {
-						filteredRequests[requestID] = time.Now()
-						continue
-					}
+						// If modifier tells to skip request
+						if len(body) == 0 {
+							filteredRequests[requestID] = time.Now()
+							atomic.AddInt64(&srcMetrics.filtered, 1)
+							continue
+						}
+
+						if originalBodyLen != len(body) {
+							payload = append(payload[:headSize], body...)
+						}
 
-					if originalBodyLen != len(body) {
-						payload = append(payload[:headSize], body...)
+						if s.debug {
+							Debug("[EMITTER] Rewritten input:", len(payload), "First 500 bytes:", string(payload[0:_maxN]))
+						}
 					}
 
-					if Settings.debug {
-						Debug("[EMITTER] Rewritten input:", len(payload), "First 500 bytes:", string(payload[0:_maxN]))
+					if limiter != nil && limiter.isLimited() {
+						filteredRequests[requestID] = time.Now()
+						atomic.AddInt64(&srcMetrics.limited, 1)
+						continue
 					}
 				} else {
 					if _, ok := filteredRequests[requestID]; ok {
 						delete(filteredRequests, requestID)
+						atomic.AddInt64(&srcMetrics.filtered, 1)
 						continue
 					}
 				}
 			}
 
-			if Settings.prettifyHTTP {
+			if s.prettifyHTTP {
 				payload = prettifyHTTP(payload)
 				if len(payload) == 0 {
 					continue
 				}
 			}
 
-			if Settings.splitOutput {
+			if s.splitOutput && len(writers) > 0 {
 				// Simple round robin
-				if _, err := writers[wIndex].Write(payload); err != nil {
-					return err
+				n, err := writers[wIndex].Write(payload)
+				if err != nil {
+					return pluginError(writers[wIndex], err)
+				}
+				if n > 0 {
+					writerMetrics[wIndex].written(n)
 				}
 
 				wIndex++
@@ -151,9 +290,13 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 					wIndex = 0
 				}
 			} else {
-				for _, dst := range writers {
-					if _, err := dst.Write(payload); err != nil {
-						return err
+				for i, dst := range writers {
+					n, err := dst.Write(payload)
+					if err != nil {
+						return pluginError(dst, err)
+					}
+					if n > 0 {
+						writerMetrics[i].written(n)
 					}
 				}
 			}
@@ -177,6 +320,4 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 
 		i++
 	}
-
-	return err
 }
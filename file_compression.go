@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// Compression of capture files is chosen by file extension
+var compressionExtensions = []string{".gz", ".zst", ".lz4"}
+
+// compressionExt returns compression extension of the file, or empty string if file is not compressed
+func compressionExt(path string) string {
+	ext := filepath.Ext(path)
+
+	for _, e := range compressionExtensions {
+		if ext == e {
+			return ext
+		}
+	}
+
+	return ""
+}
+
+// checkCompressionLevel returns error if level is out of range of compression chosen by file extension:
+// 1-9 for gzip and 1-22 for zstd, any positive level for lz4. Level 0 means default level of codec.
+func checkCompressionLevel(path string, level int) error {
+	if level < 0 {
+		return fmt.Errorf("compression level of %q should not be negative, got %d", path, level)
+	}
+
+	max := 0
+	switch compressionExt(path) {
+	case ".gz":
+		max = gzip.BestCompression
+	case ".zst":
+		max = 22
+	}
+
+	if max != 0 && level > max {
+		return fmt.Errorf("compression level of %q should be between 1 and %d, got %d", path, max, level)
+	}
+
+	return nil
+}
+
+// encoder is implemented by gzip, zstd and lz4 writers
+type encoder interface {
+	io.Writer
+	Close() error
+	Reset(io.Writer)
+}
+
+// compressWriter writes compressed stream as sequence of independent frames:
+// every Flush ends current frame and starts a new one, so everything flushed to the file
+// can be decompressed, even if file was not closed properly.
+type compressWriter struct {
+	w   io.Writer
+	buf *bufio.Writer
+	enc encoder
+	// Used to restore encoder options after reset
+	reset func()
+	// Set if frame has data, so empty frames are not written
+	dirty bool
+}
+
+// newCompressWriter returns writer compressing data according to file extension.
+// Level is codec specific, 0 means default level of codec.
+func newCompressWriter(w io.Writer, ext string, level int) (*compressWriter, error) {
+	c := &compressWriter{w: w}
+
+	switch ext {
+	case ".gz":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		enc, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		c.enc = enc
+	case ".zst":
+		zlevel := zstd.SpeedDefault
+		if level != 0 {
+			zlevel = zstd.EncoderLevelFromZstd(level)
+		}
+
+		enc, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zlevel), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.enc = enc
+	case ".lz4":
+		// lz4 writer makes a block from every write, so writes are buffered
+		enc := lz4.NewWriter(w)
+		c.reset = func() {
+			enc.Header.BlockMaxSize = 256 << 10
+			enc.Header.HighCompression = level > 0
+		}
+		c.reset()
+		c.enc = enc
+	}
+
+	c.buf = bufio.NewWriterSize(c.enc, 256<<10)
+
+	return c, nil
+}
+
+func (c *compressWriter) Write(data []byte) (int, error) {
+	c.dirty = true
+	return c.buf.Write(data)
+}
+
+// Flush completes current frame
+func (c *compressWriter) Flush() error {
+	if !c.dirty {
+		return nil
+	}
+
+	if err := c.buf.Flush(); err != nil {
+		return err
+	}
+
+	if err := c.enc.Close(); err != nil {
+		return err
+	}
+
+	c.enc.Reset(c.w)
+	if c.reset != nil {
+		c.reset()
+	}
+	c.buf.Reset(c.enc)
+	c.dirty = false
+
+	return nil
+}
+
+// Close completes current frame, it does not close underlying writer
+func (c *compressWriter) Close() error {
+	err := c.Flush()
+
+	if zenc, ok := c.enc.(*zstd.Encoder); ok {
+		// Release encoder resources
+		zenc.Reset(nil)
+	}
+
+	return err
+}
+
+// newDecompressReader returns reader decompressing file according to its extension.
+// Concatenated frames are read as one stream.
+func newDecompressReader(r io.Reader, ext string) (io.ReadCloser, error) {
+	switch ext {
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".zst":
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case ".lz4":
+		return io.NopCloser(lz4.NewReader(r)), nil
+	}
+
+	return io.NopCloser(r), nil
+}
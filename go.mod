@@ -1,9 +1,19 @@
 module github.com/buger/goreplay
 
-go 1.12
+go 1.22
 
 require (
 	github.com/Shopify/sarama v1.11.0
+	github.com/google/gopacket v0.0.0-20190211013929-f86faeb88894
+	github.com/klauspost/compress v1.18.0
+	github.com/pierrec/lz4 v0.0.0-20161206202305-5c9560bfa9ac
+	github.com/mattbaird/elastigo v0.0.0-20170123220020-2fe47fd29e4b
+	golang.org/x/crypto v0.14.0
+	golang.org/x/net v0.17.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
 	github.com/araddon/gou v0.0.0-20190110011759-c797efecbb61 // indirect
 	github.com/bitly/go-hostpool v0.0.0-20171023180738-a3a6125de932 // indirect
 	github.com/davecgh/go-spew v1.1.0 // indirect
@@ -11,13 +21,18 @@ require (
 	github.com/eapache/go-xerial-snappy v0.0.0-20160609142408-bb955e01b934 // indirect
 	github.com/eapache/queue v1.1.0 // indirect
 	github.com/golang/snappy v0.0.0-20160529050041-d9eb7a3d35ec // indirect
-	github.com/google/gopacket v0.0.0-20190211013929-f86faeb88894
 	github.com/klauspost/crc32 v0.0.0-20161016154125-cb6bfca970f6 // indirect
 	github.com/kr/pretty v0.0.0-20130510082846-bc9499caa0f4 // indirect
 	github.com/kr/text v0.0.0-20130911015532-6807e777504f // indirect
-	github.com/mattbaird/elastigo v0.0.0-20170123220020-2fe47fd29e4b
-	github.com/pierrec/lz4 v0.0.0-20161206202305-5c9560bfa9ac // indirect
 	github.com/pierrec/xxHash v0.0.0-20160112165351-5a004441f897 // indirect
 	github.com/rcrowley/go-metrics v0.0.0-20161128210544-1f30fe9094a5 // indirect
-	golang.org/x/sys v0.0.0-20170201051245-7a6e5648d140 // indirect
+	github.com/yuin/goldmark v1.4.13 // indirect
+	golang.org/x/mod v0.8.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/term v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	golang.org/x/tools v0.6.0 // indirect
+	golang.org/x/xerrors v0.0.0-20190717185122-a985d3407aa7 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
 )
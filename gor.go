@@ -5,7 +5,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
@@ -15,6 +14,7 @@ import (
 	"runtime"
 	_ "runtime/debug"
 	"runtime/pprof"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -61,12 +61,22 @@ func main() {
 		log.Fatal(http.ListenAndServe(args[1], loggingMiddleware(http.FileServer(http.Dir(dir)))))
 	} else {
 		flag.Parse()
-		InitPlugins()
+		reloadArgs = args
+
+		if Settings.config != "" {
+			if err := loadConfig(flag.CommandLine, &Settings, Settings.config); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err := InitPlugins(); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	fmt.Println("Version:", VERSION)
 
-	if len(Plugins.Inputs) == 0 || len(Plugins.Outputs) == 0 {
+	if len(Pipelines) == 0 && (len(Plugins.Inputs) == 0 || len(Plugins.Outputs) == 0) {
 		log.Fatal("Required at least 1 input and 1 output")
 	}
 
@@ -84,12 +94,34 @@ func main() {
 		}()
 	}
 
-	c := make(chan os.Signal, 1)
+	if Settings.admin != "" {
+		startAdminServer(Settings.admin)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadConfig(reloadArgs); err != nil {
+				log.Println("Can't reload config:", err)
+			}
+		}
+	}()
+
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() { close(closeCh) })
+	}
+
+	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		finalize()
-		os.Exit(1)
+		log.Println("Stopping gor, waiting up to", Settings.shutdownTimeout, "to deliver buffered payloads. Send signal again to exit immediately")
+		stop()
+
+		<-c
+		os.Exit(exitInterrupted)
 	}()
 
 	if Settings.exitAfter > 0 {
@@ -97,21 +129,30 @@ func main() {
 
 		time.AfterFunc(Settings.exitAfter, func() {
 			log.Println("Stopping gor after", Settings.exitAfter)
-			close(closeCh)
+			stop()
 		})
 	}
 
-	Start(closeCh)
-}
+	if err := Start(closeCh); err != nil {
+		log.Println(err)
 
-func finalize() {
-	for _, p := range Plugins.All {
-		if cp, ok := p.(io.Closer); ok {
-			cp.Close()
+		if _, ok := err.(*DrainError); ok {
+			os.Exit(exitDrainError)
 		}
+		os.Exit(exitCopyError)
 	}
 }
 
+// Exit codes
+const (
+	// Input or output failed
+	exitCopyError = 1
+	// Shutdown timed out, and some buffered payloads were lost
+	exitDrainError = 2
+	// Second interrupt signal received during shutdown
+	exitInterrupted = 130
+)
+
 func profileCPU(cpuprofile string) {
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/goreplay/proto"
+)
+
+// HAR 1.2 format, as described in http://www.softwareishard.com/blog/har-12-spec/
+// Only fields used by Gor are declared, required fields are always written.
+
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string       `json:"startedDateTime"`
+	Time            float64      `json:"time"`
+	Request         harRequest   `json:"request"`
+	Response        harResponse  `json:"response"`
+	Cache           struct{}     `json:"cache"`
+	Timings         harTimings   `json:"timings"`
+	Comment         string       `json:"comment,omitempty"`
+	ReplayedTime    float64      `json:"_replayedTime,omitempty"`
+	Replayed        *harResponse `json:"_replayedResponse,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Not in the spec, but used by some tools for binary bodies
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harRequestPayload converts HAR request into HTTP/1.1 request, ready to be replayed
+func harRequestPayload(r *harRequest) (payload []byte, err error) {
+	var body []byte
+	if r.PostData != nil {
+		if body, err = decodeText(r.PostData.Text, r.PostData.Encoding); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make([]JSONHeader, 0, len(r.Headers)+1)
+	for _, h := range r.Headers {
+		headers = append(headers, JSONHeader{h.Name, h.Value})
+	}
+
+	if r.PostData != nil && r.PostData.MimeType != "" && harHeaderValue(r.Headers, "Content-Type") == "" {
+		headers = append(headers, JSONHeader{"Content-Type", r.PostData.MimeType})
+	}
+
+	return buildRequestPayload(r.Method, r.URL, r.HTTPVersion, headers, body)
+}
+
+// harHeaders returns headers of HTTP payload, in order of appearance
+func harHeaders(payload []byte) []harNameValue {
+	headers := []harNameValue{}
+
+	start := proto.MIMEHeadersStartPos(payload)
+	if start < 2 {
+		return headers
+	}
+
+	// Request or status line skipped, as absolute URL contains colon
+	proto.ParseHeaders([][]byte{payload[start:]}, func(header, value []byte) bool {
+		headers = append(headers, harNameValue{string(header), string(value)})
+		return true
+	})
+
+	return headers
+}
+
+func harHeaderValue(headers []harNameValue, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+
+	return ""
+}
+
+// harFirstLine returns parts of request or status line
+func harFirstLine(payload []byte) []string {
+	end := bytes.IndexByte(payload, '\n')
+	if end == -1 {
+		end = len(payload)
+	}
+
+	return strings.SplitN(strings.TrimRight(string(payload[:end]), "\r"), " ", 3)
+}
+
+// harDecode returns HTTP message of payload, with decoded chunked and gzip body
+func harDecode(payload []byte) []byte {
+	if p := prettifyHTTP(payload); len(p) > 0 {
+		return payloadBody(p)
+	}
+
+	return payloadBody(payload)
+}
+
+// harPayloadRequest converts HTTP request into HAR request. Body should be already decoded.
+func harPayloadRequest(payload []byte) harRequest {
+	line := harFirstLine(payload)
+	for len(line) < 3 {
+		line = append(line, "")
+	}
+
+	r := harRequest{
+		Method:      line[0],
+		HTTPVersion: line[2],
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(payload),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+	}
+
+	u, _ := url.Parse(line[1])
+	if u == nil {
+		u = &url.URL{Path: line[1]}
+	}
+	if u.Host == "" {
+		u.Scheme = "http"
+		u.Host = harHeaderValue(r.Headers, "Host")
+	}
+	r.URL = u.String()
+
+	for name, values := range u.Query() {
+		for _, v := range values {
+			r.QueryString = append(r.QueryString, harNameValue{name, v})
+		}
+	}
+
+	body := proto.Body(payload)
+	r.BodySize = len(body)
+
+	if len(body) > 0 {
+		r.PostData = &harPostData{MimeType: harHeaderValue(r.Headers, "Content-Type")}
+		r.PostData.Text, r.PostData.Encoding = encodeText(body)
+	}
+
+	return r
+}
+
+// harPayloadResponse converts HTTP response into HAR response. Body should be already decoded.
+func harPayloadResponse(payload []byte) *harResponse {
+	line := harFirstLine(payload)
+	for len(line) < 3 {
+		line = append(line, "")
+	}
+
+	status, _ := strconv.Atoi(line[1])
+	body := proto.Body(payload)
+
+	r := &harResponse{
+		Status:      status,
+		StatusText:  line[2],
+		HTTPVersion: line[0],
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(payload),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+
+	r.RedirectURL = harHeaderValue(r.Headers, "Location")
+	r.Content = harContent{Size: len(body), MimeType: harHeaderValue(r.Headers, "Content-Type")}
+	r.Content.Text, r.Content.Encoding = encodeText(body)
+
+	return r
+}
+
+// harTime formats time in UTC, so entries can be ordered as strings
+func harTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// harDuration converts nanoseconds into milliseconds
+func harDuration(ns int64) float64 {
+	return float64(ns) / float64(time.Millisecond)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testHAR = `{"log": {"version": "1.2", "creator": {"name": "Firefox", "version": "60"}, "entries": [
+	{"startedDateTime": "2016-05-01T14:00:01.500+02:00", "time": 10,
+		"request": {"method": "POST", "url": "https://example.com/api?q=1", "httpVersion": "HTTP/2.0",
+			"headers": [{"name": ":authority", "value": "example.com"}, {"name": "Content-Length", "value": "100"}],
+			"postData": {"mimeType": "application/json", "text": "{\"a\":1}"}}},
+	{"startedDateTime": "2016-05-01T12:00:00.000Z", "time": 10,
+		"request": {"method": "GET", "url": "http://example.com/", "httpVersion": "HTTP/1.1",
+			"headers": [{"name": "Host", "value": "example.com"}, {"name": "Accept", "value": "*/*"}]}},
+	{"startedDateTime": "wrong", "request": {"method": "GET", "url": "http://example.com/skipped"}}
+]}}`
+
+func TestHARInput(t *testing.T) {
+	f, _ := ioutil.TempFile("", "gor_har*.har")
+	f.WriteString(testHAR)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	input, err := NewHARInput(f.Name(), &FileInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	expected := []struct {
+		ts      time.Time
+		request string
+	}{
+		{time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC), "GET / HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n"},
+		{time.Date(2016, 5, 1, 12, 0, 1, 500000000, time.UTC), "POST /api?q=1 HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 7\r\n\r\n{\"a\":1}"},
+	}
+
+	buf := make([]byte, 1000)
+	for i, e := range expected {
+		n, err := input.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := buf[:n]
+		if !isRequestPayload(payload) || string(payloadMeta(payload)[2]) != strconv.FormatInt(e.ts.UnixNano(), 10) {
+			t.Errorf("%d Wrong payload header: %q", i, payload)
+		}
+
+		if string(payloadBody(payload)) != e.request {
+			t.Errorf("%d Wrong request: %q", i, payloadBody(payload))
+		}
+	}
+}
+
+func TestHARInputWrongFile(t *testing.T) {
+	f, _ := ioutil.TempFile("", "gor_har*.har")
+	f.WriteString("<html>")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, err := NewHARInput(f.Name(), &FileInputConfig{}); err == nil {
+		t.Error("Should fail on wrong HAR file")
+	}
+}
+
+func TestHAROutput(t *testing.T) {
+	f, _ := ioutil.TempFile("", "gor_har*.har")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	output, err := NewHAROutput(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	request := append(payloadHeader(RequestPayload, []byte("1"), start.UnixNano(), -1), []byte("POST /api?q=1 HTTP/1.1\r\nHost: example.com\r\nContent-Length: 2\r\n\r\n{}")...)
+	response := append(payloadHeader(ResponsePayload, []byte("1"), start.UnixNano(), int64(12*time.Millisecond)), []byte("HTTP/1.1 201 Created\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n")...)
+	replayed := append(payloadHeader(ReplayedResponsePayload, []byte("1"), start.UnixNano(), int64(30*time.Millisecond)), []byte("HTTP/1.1 500 Internal Server Error\r\nContent-Length: 3\r\n\r\n\xff\xfe\xfd")...)
+
+	// Request without responses, and response without request
+	earlier := append(payloadHeader(RequestPayload, []byte("2"), start.Add(-time.Second).UnixNano(), -1), []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")...)
+	orphan := append(payloadHeader(ResponsePayload, []byte("3"), start.UnixNano(), 1), []byte("HTTP/1.1 200 OK\r\n\r\n")...)
+
+	for _, p := range [][]byte{replayed, request, earlier, response, orphan} {
+		output.Write(p)
+	}
+	output.Close()
+
+	data, _ := ioutil.ReadFile(f.Name())
+	var har harLog
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatal("Should write valid JSON:", err, string(data))
+	}
+
+	entries := har.Log.Entries
+	if har.Log.Version != "1.2" || len(entries) != 2 {
+		t.Fatal("Wrong HAR:", string(data))
+	}
+
+	if entries[0].Request.URL != "http://example.com/" || entries[0].Comment == "" || entries[0].Response.Status != 0 {
+		t.Error("Entries should be ordered by time, and keep requests without response:", entries[0])
+	}
+
+	e := entries[1]
+	if e.StartedDateTime != "2016-05-01T12:00:00.000Z" || e.Time != 12 || e.Timings.Wait != 12 || e.Timings.Connect != -1 {
+		t.Error("Wrong timings:", e.StartedDateTime, e.Time, e.Timings)
+	}
+
+	if e.Request.Method != "POST" || e.Request.URL != "http://example.com/api?q=1" || e.Request.PostData.Text != "{}" || len(e.Request.QueryString) != 1 {
+		t.Error("Wrong request:", e.Request)
+	}
+
+	if e.Response.Status != 201 || e.Response.StatusText != "Created" || e.Response.Content.Text != "ok" || e.Response.Content.MimeType != "text/plain" {
+		t.Error("Response body should be decoded:", e.Response)
+	}
+
+	if e.Replayed == nil || e.Replayed.Status != 500 || e.ReplayedTime != 30 || e.Replayed.Content.Encoding != "base64" {
+		t.Error("Wrong replayed response:", e.Replayed, e.ReplayedTime)
+	}
+
+	// Converted back, request is the same
+	payload, _ := harRequestPayload(&e.Request)
+	if !bytes.Equal(payload, payloadBody(request)) {
+		t.Errorf("Request should be converted back: %q", payload)
+	}
+}
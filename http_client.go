@@ -3,9 +3,11 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -19,6 +21,8 @@ import (
 	"time"
 
 	"github.com/buger/goreplay/proto"
+
+	"golang.org/x/net/http2"
 )
 
 var httpMu sync.Mutex
@@ -43,6 +47,12 @@ type HTTPClientConfig struct {
 	Timeout            time.Duration
 	ResponseBufferSize int
 	CompatibilityMode  bool
+	HTTP2              bool
+	// HTTP/2 connection without frames during this period is checked with ping, 0 disables checks
+	HTTP2PingInterval time.Duration
+
+	// Shared between clients of the same output, so HTTP/2 streams get multiplexed over same connections
+	HTTP2Transport *http2.Transport
 }
 
 type HTTPClient struct {
@@ -90,6 +100,23 @@ func NewHTTPClient(baseURL string, config *HTTPClientConfig) *HTTPClient {
 		}
 	}
 
+	if config.HTTP2 {
+		if config.HTTP2Transport == nil {
+			config.HTTP2Transport = NewHTTP2Transport(u.Scheme, config)
+		}
+
+		client.goClient = &http.Client{
+			Transport: config.HTTP2Transport,
+			Timeout:   config.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > config.FollowRedirects {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		}
+	}
+
 	if u.User != nil {
 		client.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.String()))
 	}
@@ -233,6 +260,111 @@ func (c *HTTPClient) SendGoClient(data []byte) ([]byte, error) {
 	return httputil.DumpResponse(resp, true)
 }
 
+// NewHTTP2Transport creates HTTP/2 transport which multiplexes requests over pooled connections.
+// For `https` scheme protocol negotiated using TLS ALPN, for `http` scheme h2c with prior knowledge is used.
+func NewHTTP2Transport(scheme string, config *HTTPClientConfig) *http2.Transport {
+	t := &http2.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		ReadIdleTimeout: config.HTTP2PingInterval,
+		PingTimeout:     config.Timeout,
+	}
+
+	dialer := &net.Dialer{Timeout: config.Timeout}
+
+	if scheme != "https" {
+		t.AllowHTTP = true
+		// h2c: plain TCP connection, HTTP/2 preface sent right away
+		t.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return t
+}
+
+// Hop-by-hop headers which are not allowed in HTTP/2 requests
+var http2ForbiddenHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Http2-Settings"}
+
+// SendHTTP2 converts HTTP/1.x payload to HTTP/2 request, and returns response in HTTP/1.1 wire format
+func (c *HTTPClient) SendHTTP2(data []byte) (response []byte, err error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.config.OriginalHost {
+		req.Host = c.host
+	}
+
+	if c.auth != "" {
+		req.Header.Set("Authorization", c.auth)
+	}
+
+	for _, h := range http2ForbiddenHeaders {
+		req.Header.Del(h)
+	}
+
+	// Only `TE: trailers` allowed, used by gRPC
+	if te := req.Header.Get("Te"); te != "" && te != "trailers" {
+		req.Header.Del("Te")
+	}
+
+	req.URL, err = url.ParseRequestURI(c.scheme + "://" + c.host + req.RequestURI)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = ""
+
+	if c.config.Debug {
+		Debug("[HTTPClient] Sending HTTP/2:", string(data))
+	}
+
+	resp, err := c.goClient.Do(req)
+	if err != nil {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return errorPayload(HTTP_TIMEOUT), err
+		}
+
+		return errorPayload(HTTP_CONNECTION_ERROR), err
+	}
+	defer resp.Body.Close()
+
+	// Like HTTP/1.x responses, all data after buffer size is discarded
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(c.config.ResponseBufferSize)))
+	if err != nil {
+		return errorPayload(HTTP_TIMEOUT), err
+	}
+
+	response = http2ResponsePayload(resp, body)
+	if len(response) > c.config.ResponseBufferSize {
+		response = response[:c.config.ResponseBufferSize]
+	}
+
+	if c.config.Debug {
+		Debug("[HTTPClient] Received:", string(response))
+	}
+
+	return response, nil
+}
+
+// http2ResponsePayload dumps response in HTTP/1.1 format.
+// Trailers (like gRPC status) do not have dedicated place in HTTP/1.1 payload, so they appended to the headers.
+func http2ResponsePayload(resp *http.Response, body []byte) []byte {
+	var b bytes.Buffer
+
+	b.WriteString("HTTP/1.1 " + strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode) + "\r\n")
+
+	resp.Header.Del("Transfer-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Write(&b)
+	resp.Trailer.Write(&b)
+
+	b.Write(proto.CLRF)
+	b.Write(body)
+
+	return b.Bytes()
+}
+
 func (c *HTTPClient) Send(data []byte) (response []byte, err error) {
 	// Don't exit on panic
 	defer func() {
@@ -251,6 +383,10 @@ func (c *HTTPClient) Send(data []byte) (response []byte, err error) {
 		return c.SendGoClient(data)
 	}
 
+	if c.config.HTTP2 {
+		return c.SendHTTP2(data)
+	}
+
 	var readBytes int
 	if c.conn == nil || !c.isAlive(&readBytes) {
 		Debug("[HTTPClient] Connecting:", c.baseURL)
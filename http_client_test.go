@@ -16,6 +16,9 @@ import (
 	"time"
 
 	"github.com/buger/goreplay/proto"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestHTTPClientURLPort(t *testing.T) {
@@ -531,3 +534,93 @@ func TestHTTPClientErrors(t *testing.T) {
 		t.Error("Should throw error")
 	}
 }
+
+func TestHTTPClientHTTP2Cleartext(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Error("Should use HTTP/2:", r.Proto)
+		}
+
+		if r.Host != "www.w3.org" {
+			t.Error("Should keep original host:", r.Host)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "a=1&b=2" {
+			t.Error("Wrong POST body:", string(body))
+		}
+
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write([]byte("ok"))
+		w.Header().Set("Grpc-Status", "0")
+	})
+
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer server.Close()
+
+	client := NewHTTPClient(server.URL, &HTTPClientConfig{HTTP2: true, OriginalHost: true})
+
+	resp, err := client.Send([]byte("POST /post HTTP/1.1\r\nHost: www.w3.org\r\nConnection: keep-alive\r\nContent-Length: 7\r\n\r\na=1&b=2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(resp, []byte("HTTP/1.1 200 OK\r\n")) {
+		t.Error("Response should be converted to HTTP/1.1:", string(resp))
+	}
+
+	if string(proto.Header(resp, []byte("Grpc-Status"))) != "0" {
+		t.Error("Should include trailers:", string(resp))
+	}
+
+	if string(proto.Body(resp)) != "ok" {
+		t.Error("Wrong body:", string(resp))
+	}
+}
+
+func TestHTTPClientHTTP2TLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Error("Should negotiate HTTP/2:", r.Proto)
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	client := NewHTTPClient(server.URL, &HTTPClientConfig{HTTP2: true})
+
+	resp, err := client.Send([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(proto.Status(resp), []byte("200")) {
+		t.Error("Wrong response:", string(resp))
+	}
+}
+
+func TestHTTPClientHTTP2ResponseBuffer(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), 1000))
+	})
+
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer server.Close()
+
+	client := NewHTTPClient(server.URL, &HTTPClientConfig{HTTP2: true, ResponseBufferSize: 100, HTTP2PingInterval: time.Minute})
+
+	resp, err := client.Send([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp) != 100 || !bytes.HasPrefix(resp, []byte("HTTP/1.1 200 OK\r\n")) {
+		t.Errorf("Response should be truncated to buffer size: %d %q", len(resp), resp)
+	}
+
+	if client.config.HTTP2Transport.ReadIdleTimeout != time.Minute {
+		t.Error("Should use configured ping interval:", client.config.HTTP2Transport.ReadIdleTimeout)
+	}
+}
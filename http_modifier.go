@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/buger/goreplay/proto"
+	"github.com/buger/goreplay/protocol"
 )
 
 type HTTPModifier struct {
@@ -24,6 +25,10 @@ func NewHTTPModifier(config *HTTPModifierConfig) *HTTPModifier {
 		len(config.headerBasicAuthFilters) == 0 &&
 		len(config.headerHashFilters) == 0 &&
 		len(config.paramHashFilters) == 0 &&
+		len(config.grpcMethods) == 0 &&
+		len(config.grpcNegativeMethods) == 0 &&
+		len(config.keyRegexp) == 0 &&
+		len(config.keyNegativeRegexp) == 0 &&
 		len(config.params) == 0 &&
 		len(config.headers) == 0 &&
 		len(config.methods) == 0 {
@@ -33,6 +38,44 @@ func NewHTTPModifier(config *HTTPModifierConfig) *HTTPModifier {
 	return &HTTPModifier{config: config}
 }
 
+// RewriteMessage filters request of any protocol by its key, HTTP requests are modified by Rewrite as well.
+// Parser is nil if protocol of payload is unknown, such requests have no key.
+func (m *HTTPModifier) RewriteMessage(parser protocol.Parser, payload []byte) []byte {
+	if len(m.config.keyRegexp) > 0 || len(m.config.keyNegativeRegexp) > 0 {
+		var key []byte
+		if parser != nil {
+			key = parser.Key(payload)
+		}
+
+		if len(m.config.keyRegexp) > 0 {
+			matched := false
+
+			for _, f := range m.config.keyRegexp {
+				if f.regexp.Match(key) {
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return nil
+			}
+		}
+
+		for _, f := range m.config.keyNegativeRegexp {
+			if f.regexp.Match(key) {
+				return nil
+			}
+		}
+	}
+
+	if parser == nil || parser.Name() != "http" {
+		return payload
+	}
+
+	return m.Rewrite(payload)
+}
+
 func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 	if !proto.IsHTTPPayload(payload) {
 		return payload
@@ -94,6 +137,37 @@ func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 		}
 	}
 
+	if len(m.config.grpcMethods) > 0 {
+		if !proto.IsGRPC(payload) {
+			return
+		}
+
+		method, _, _ := proto.GRPCMethod(payload)
+
+		matched := false
+
+		for _, f := range m.config.grpcMethods {
+			if f.regexp.Match(method) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return
+		}
+	}
+
+	if len(m.config.grpcNegativeMethods) > 0 && proto.IsGRPC(payload) {
+		method, _, _ := proto.GRPCMethod(payload)
+
+		for _, f := range m.config.grpcNegativeMethods {
+			if f.regexp.Match(method) {
+				return
+			}
+		}
+	}
+
 	if len(m.config.headerFilters) > 0 {
 		for _, f := range m.config.headerFilters {
 			value := proto.Header(payload, f.name)
@@ -20,6 +20,13 @@ type HTTPModifierConfig struct {
 	headerHashFilters      HTTPHashFilters
 	paramHashFilters       HTTPHashFilters
 
+	grpcMethods         HTTPUrlRegexp
+	grpcNegativeMethods HTTPUrlRegexp
+
+	// Match key of request, given by protocol parser
+	keyRegexp         HTTPUrlRegexp
+	keyNegativeRegexp HTTPUrlRegexp
+
 	params  HTTPParams
 	headers HTTPHeaders
 	methods HTTPMethods
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/buger/goreplay/proto"
+	"github.com/buger/goreplay/protocol"
 )
 
 func TestHTTPModifierWithoutConfig(t *testing.T) {
@@ -287,6 +288,39 @@ func TestHTTPModifierURLNegativeRegexp(t *testing.T) {
 	}
 }
 
+func TestHTTPModifierGRPCMethods(t *testing.T) {
+	allow := HTTPUrlRegexp{}
+	allow.Set("^/helloworld.Greeter/")
+
+	disallow := HTTPUrlRegexp{}
+	disallow.Set("/SayGoodbye$")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		grpcMethods:         allow,
+		grpcNegativeMethods: disallow,
+	})
+
+	payload := func(url, contentType string) []byte {
+		return []byte("POST " + url + " HTTP/1.1\r\nContent-Type: " + contentType + "\r\nHost: www.w3.org\r\n\r\n")
+	}
+
+	if len(modifier.Rewrite(payload("/helloworld.Greeter/SayHello", "application/grpc"))) == 0 {
+		t.Error("Should pass allowed method")
+	}
+
+	if len(modifier.Rewrite(payload("/helloworld.Greeter/SayGoodbye", "application/grpc+proto"))) > 0 {
+		t.Error("Should not pass disallowed method")
+	}
+
+	if len(modifier.Rewrite(payload("/routeguide.RouteGuide/GetFeature", "application/grpc"))) > 0 {
+		t.Error("Should not pass other services")
+	}
+
+	if len(modifier.Rewrite(payload("/helloworld.Greeter/SayHello", "application/json"))) > 0 {
+		t.Error("Should not pass non-gRPC requests")
+	}
+}
+
 func TestHTTPModifierSetHeader(t *testing.T) {
 	filters := HTTPHeaders{}
 	filters.Set("User-Agent:Gor")
@@ -318,3 +352,40 @@ func TestHTTPModifierSetParam(t *testing.T) {
 		t.Error("Should override param", string(payload))
 	}
 }
+
+func TestHTTPModifierKeyRegexp(t *testing.T) {
+	allow := HTTPUrlRegexp{}
+	allow.Set("^(GET|HGET) ")
+	disallow := HTTPUrlRegexp{}
+	disallow.Set("secret")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		keyRegexp:         allow,
+		keyNegativeRegexp: disallow,
+	})
+
+	parser, _ := protocol.New("redis")
+
+	if len(modifier.RewriteMessage(parser, []byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))) == 0 {
+		t.Error("Should pass key")
+	}
+
+	if len(modifier.RewriteMessage(parser, []byte("DEL a\r\n"))) > 0 {
+		t.Error("Should not pass key")
+	}
+
+	if len(modifier.RewriteMessage(parser, []byte("GET secret:1\r\n"))) > 0 {
+		t.Error("Should not pass disallowed key")
+	}
+
+	// HTTP key is method and path
+	parser, _ = protocol.New("http")
+	if len(modifier.RewriteMessage(parser, []byte("GET / HTTP/1.1\r\n\r\n"))) == 0 {
+		t.Error("Should pass HTTP request")
+	}
+
+	// Protocol without parser has no key
+	if len(modifier.RewriteMessage(nil, []byte("GET a\r\n"))) > 0 {
+		t.Error("Should not pass message without key")
+	}
+}
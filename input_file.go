@@ -3,7 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
+	"container/heap"
 	"errors"
 	"io"
 	"log"
@@ -13,33 +13,107 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/buger/goreplay/proto"
 )
 
+// fileInputReader reads payloads from a single file. Next payload is kept in memory,
+// so file can be closed when there are too many open files, and reopened later at the same position.
 type fileInputReader struct {
-	reader    *bufio.Reader
+	path string
+	// Name of the file relative to input pattern, used to tag payloads
+	source string
+
+	file         io.ReadCloser
+	decompressor io.Closer
+	reader       *bufio.Reader
+	capture      *captureReader
+	// File in JSON Lines format
+	json bool
+
 	data      []byte
-	file      *os.File
 	timestamp int64
+
+	// Position in uncompressed stream after the next payload
+	offset int64
+	eof    bool
+
+	// Position in the merge heap, and in the list of matched files
+	index int
+	order int
+}
+
+// open opens file and moves to the current position.
+// Compressed files, and files which can't seek are read again from the beginning up to the position.
+func (f *fileInputReader) open(openFile func(string) (io.ReadCloser, error)) (err error) {
+	if f.file, err = openFile(f.path); err != nil {
+		return
+	}
+
+	seeker, seekable := f.file.(io.Seeker)
+
+	ext := compressionExt(f.path)
+	compressed := ext != ""
+
+	var r io.Reader = f.file
+	if compressed {
+		var dr io.ReadCloser
+		if dr, err = newDecompressReader(f.file, ext); err != nil {
+			f.Close()
+			return
+		}
+		r, f.decompressor = dr, dr
+	}
+	f.reader = bufio.NewReader(r)
+
+	// Files in indexed capture format detected by header, compressed files can be read only sequentially
+	if isCaptureFormat(f.reader) {
+		var src io.Reader = f.reader
+		if seekable && !compressed {
+			src = f.file
+		}
+
+		if f.capture, err = newCaptureReader(src); err == nil && f.offset > 0 {
+			err = f.capture.seek(f.offset)
+		}
+	} else if f.json = isJSONFormat(f.reader); f.offset > 0 {
+		if compressed || !seekable {
+			_, err = f.reader.Discard(int(f.offset))
+		} else if _, err = seeker.Seek(f.offset, io.SeekStart); err == nil {
+			f.reader.Reset(f.file)
+		}
+	}
+
+	if err != nil {
+		f.Close()
+	}
+
+	return
 }
 
 func (f *fileInputReader) parseNext() error {
+	if f.capture != nil {
+		return f.parseNextRecord()
+	}
+
+	if f.json {
+		return f.parseNextJSON()
+	}
+
 	payloadSeparatorAsBytes := []byte(payloadSeparator)
 	var buffer bytes.Buffer
 
 	for {
 		line, err := f.reader.ReadBytes('\n')
+		f.offset += int64(len(line))
 
 		if err != nil {
 			if err != io.EOF {
 				log.Println(err)
-				return err
 			}
 
-			if err == io.EOF {
-				f.file.Close()
-				f.file = nil
-				return err
-			}
+			f.eof = true
+			return err
 		}
 
 		if bytes.Equal(payloadSeparatorAsBytes[1:], line) {
@@ -54,69 +128,238 @@ func (f *fileInputReader) parseNext() error {
 
 		buffer.Write(line)
 	}
+}
 
-	return nil
+// parseNextRecord reads payload from file in indexed capture format, skipping corrupted records
+func (f *fileInputReader) parseNextRecord() error {
+	for {
+		data, err := f.capture.Next()
+		f.offset = f.capture.offset
+
+		if err == ErrCaptureChecksum {
+			log.Println("Skipping corrupted record in", f.path)
+			continue
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+
+			f.eof = true
+			return err
+		}
+
+		meta := payloadMeta(data)
+		if len(meta) < 3 {
+			continue
+		}
+
+		f.timestamp, _ = strconv.ParseInt(string(meta[2]), 10, 64)
+		f.data = data
+
+		return nil
+	}
+}
+
+// parseNextJSON reads payload from file in JSON Lines format, skipping malformed lines
+func (f *fileInputReader) parseNextJSON() error {
+	for {
+		line, err := f.reader.ReadBytes('\n')
+		f.offset += int64(len(line))
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			data, jerr := decodeJSONPayload(line)
+			if meta := payloadMeta(data); jerr == nil && len(meta) >= 3 {
+				f.timestamp, _ = strconv.ParseInt(string(meta[2]), 10, 64)
+				f.data = data
+
+				return nil
+			}
+
+			log.Println("Skipping malformed JSON payload in", f.path, jerr)
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+
+			f.eof = true
+			return err
+		}
+	}
 }
 
-func (f *fileInputReader) ReadPayload() []byte {
-	defer f.parseNext()
+// seekTime skips payloads earlier than given time, using index of file in capture format.
+// Other files are filtered while reading.
+func (f *fileInputReader) seekTime(timestamp int64) {
+	if f.capture == nil || f.eof || f.timestamp >= timestamp {
+		return
+	}
 
-	return f.data
+	if ok, err := f.capture.SeekTime(timestamp); ok && err == nil {
+		f.parseNext()
+	}
 }
+
+// skipRecords skips first n payloads of file in capture format using index, without reading them.
+// Returns number of skipped payloads, 0 if file has no index.
+func (f *fileInputReader) skipRecords(n int) int {
+	if f.capture == nil || f.eof || n <= 0 {
+		return 0
+	}
+
+	count, ok := f.capture.Count()
+	if !ok {
+		return 0
+	}
+
+	if n > count {
+		n = count
+	}
+
+	if _, err := f.capture.SeekRecord(n); err != nil {
+		log.Println(err)
+		return 0
+	}
+	f.parseNext()
+
+	return n
+}
+
 func (f *fileInputReader) Close() error {
+	if f.decompressor != nil {
+		f.decompressor.Close()
+	}
+
 	if f.file != nil {
 		f.file.Close()
 	}
 
+	f.file, f.decompressor, f.reader, f.capture = nil, nil, nil, nil
+
 	return nil
 }
 
-func NewFileInputReader(path string) *fileInputReader {
-	file, err := os.Open(path)
+// readerHeap orders readers by timestamp of their next payloads
+type readerHeap []*fileInputReader
 
-	if err != nil {
-		log.Println(err)
-		return nil
-	}
+func (h readerHeap) Len() int {
+	return len(h)
+}
 
-	r := &fileInputReader{file: file}
-	if strings.HasSuffix(path, ".gz") {
-		gzReader, err := gzip.NewReader(file)
-		if err != nil {
-			log.Println(err)
-			return nil
-		}
-		r.reader = bufio.NewReader(gzReader)
-	} else {
-		r.reader = bufio.NewReader(file)
+func (h readerHeap) Less(i, j int) bool {
+	if h[i].timestamp == h[j].timestamp {
+		return h[i].order < h[j].order
 	}
 
-	r.parseNext()
+	return h[i].timestamp < h[j].timestamp
+}
+
+func (h readerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *readerHeap) Push(x interface{}) {
+	r := x.(*fileInputReader)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+
+func (h *readerHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
 
 	return r
 }
 
-// FileInput can read requests generated by FileOutput
+// fileSource returns path of the file relative to the static part of input pattern,
+// e.g. `host1/requests.gor` for `captures/*/requests.gor` pattern
+func fileSource(pattern, path string) string {
+	if i := strings.IndexAny(pattern, "*?["); i != -1 {
+		pattern = pattern[:i]
+	}
+
+	return strings.TrimPrefix(path, pattern[:strings.LastIndex(pattern, "/")+1])
+}
+
+// FileInputConfig holds options of FileInput
+type FileInputConfig struct {
+	loop bool
+
+	// Replay only payloads within time window
+	start timeVar
+	end   timeVar
+
+	// Skip first N payloads, and stop after N payloads
+	skip  int
+	limit int
+
+	// How many files can be open at once while merging, 0 means no limit
+	maxOpen int
+	// Header added to requests, with name of the file they read from
+	sourceHeader string
+}
+
+// FileInput can read requests generated by FileOutput.
+// Payloads of multiple files merged by timestamp.
 type FileInput struct {
 	mu          sync.Mutex
 	data        chan []byte
 	exit        chan bool
 	path        string
-	readers     []*fileInputReader
 	speedFactor float64
-	loop        bool
+	config      *FileInputConfig
+	closed      bool
+
+	// Maximum payloads per second, 0 means no limit
+	rate int
+
+	// All matched files
+	readers []*fileInputReader
+	// Readers which have payloads, ordered by timestamp
+	queue readerHeap
+	// Open readers, least recently used first
+	open []*fileInputReader
+	// Payloads skipped by init using index, see FileInputConfig.skip
+	skipped int
+
+	// Used to read files from other storages, like S3
+	listFiles func() ([]string, error)
+	openFile  func(string) (io.ReadCloser, error)
 }
 
 // NewFileInput constructor for FileInput. Accepts file path as argument.
-func NewFileInput(path string, loop bool) (i *FileInput) {
+func NewFileInput(path string, config *FileInputConfig) (i *FileInput) {
+	list := func() ([]string, error) {
+		return filepath.Glob(path)
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		return os.Open(name)
+	}
+
+	return newFileInput(path, config, list, open)
+}
+
+func newFileInput(path string, config *FileInputConfig, listFiles func() ([]string, error), openFile func(string) (io.ReadCloser, error)) (i *FileInput) {
 	i = new(FileInput)
 	i.data = make(chan []byte, 1000)
 	i.exit = make(chan bool, 1)
 	i.path = path
 	i.speedFactor = 1
-	i.loop = loop
+	i.config = config
+	i.listFiles = listFiles
+	i.openFile = openFile
 
-	if err := i.init(); err != nil {
+	i.mu.Lock()
+	err := i.init()
+	i.mu.Unlock()
+
+	if err != nil {
 		return
 	}
 
@@ -131,13 +374,11 @@ func (_ *NextFileNotFound) Error() string {
 	return "There is no new files"
 }
 
+// init opens matched files, and reads their first payloads. Should be called under lock.
 func (i *FileInput) init() (err error) {
-	defer i.mu.Unlock()
-	i.mu.Lock()
-
 	var matches []string
 
-	if matches, err = filepath.Glob(i.path); err != nil {
+	if matches, err = i.listFiles(); err != nil {
 		log.Println("Wrong file pattern", i.path, err)
 		return
 	}
@@ -147,17 +388,128 @@ func (i *FileInput) init() (err error) {
 		return errors.New("No matching files")
 	}
 
-	i.readers = make([]*fileInputReader, len(matches))
+	for _, r := range i.readers {
+		r.Close()
+	}
+	i.readers, i.queue, i.open = nil, nil, nil
+	i.skipped = 0
+
+	start, end := i.config.start.Time, i.config.end.Time
 
 	for idx, p := range matches {
-		i.readers[idx] = NewFileInputReader(p)
+		// Files named using date variables can be skipped without reading
+		if from, to, ok := fileNameTimeRange(p); ok {
+			if (!end.IsZero() && !from.Before(end)) || (!start.IsZero() && !to.After(start)) {
+				Debug("[INPUT-FILE] Skipping file outside of time window:", p)
+				continue
+			}
+		}
+
+		r := &fileInputReader{path: p, source: fileSource(i.path, p), order: idx}
+		if err := i.openReader(r); err != nil {
+			log.Println(err)
+			continue
+		}
+
+		i.readers = append(i.readers, r)
+
+		r.parseNext()
+		if !start.IsZero() {
+			r.seekTime(start.UnixNano())
+		} else if len(matches) == 1 {
+			// Payloads of single file are not merged, so they are skipped in order of writing
+			i.skipped = r.skipRecords(i.config.skip)
+		}
+
+		if r.eof {
+			i.closeReader(r)
+		} else {
+			heap.Push(&i.queue, r)
+		}
+	}
+
+	return nil
+}
+
+// openReader opens file of the reader if needed, closing least recently used file if limit reached
+func (i *FileInput) openReader(r *fileInputReader) error {
+	if r.file != nil {
+		for idx, o := range i.open {
+			if o == r {
+				i.open = append(append(i.open[:idx:idx], i.open[idx+1:]...), r)
+				break
+			}
+		}
+
+		return nil
+	}
+
+	if i.config.maxOpen > 0 && len(i.open) >= i.config.maxOpen {
+		i.open[0].Close()
+		i.open = i.open[1:]
+	}
+
+	if err := r.open(i.openFile); err != nil {
+		return err
 	}
 
+	i.open = append(i.open, r)
+
 	return nil
 }
 
+func (i *FileInput) closeReader(r *fileInputReader) {
+	r.Close()
+
+	for idx, o := range i.open {
+		if o == r {
+			i.open = append(i.open[:idx], i.open[idx+1:]...)
+			break
+		}
+	}
+}
+
+// readPayload returns the next payload of reader, and reads the following one
+func (i *FileInput) readPayload(r *fileInputReader) []byte {
+	payload := r.data
+
+	if err := i.openReader(r); err != nil {
+		log.Println(err)
+		r.eof = true
+	} else {
+		r.parseNext()
+	}
+
+	if r.eof {
+		heap.Remove(&i.queue, r.index)
+		i.closeReader(r)
+	} else {
+		heap.Fix(&i.queue, r.index)
+	}
+
+	if i.config.sourceHeader != "" && isRequestPayload(payload) {
+		headSize := bytes.IndexByte(payload, '\n') + 1
+		body := proto.SetHeader(payload[headSize:], []byte(i.config.sourceHeader), []byte(r.source))
+		payload = append(payload[:headSize:headSize], body...)
+	}
+
+	return payload
+}
+
+// fileInputPlugin implemented by FileInput, and inputs built on it, like S3Input
+type fileInputPlugin interface {
+	fileInput() *FileInput
+}
+
+func (i *FileInput) fileInput() *FileInput {
+	return i
+}
+
 func (i *FileInput) Read(data []byte) (int, error) {
-	buf := <-i.data
+	buf, ok := <-i.data
+	if !ok {
+		return 0, io.EOF
+	}
 	copy(data, buf)
 
 	return len(buf), nil
@@ -168,23 +520,30 @@ func (i *FileInput) String() string {
 }
 
 // Find reader with smallest timestamp e.g next payload in row
-func (i *FileInput) nextReader() (next *fileInputReader) {
-	for _, r := range i.readers {
-		if r == nil || r.file == nil {
-			continue
-		}
-
-		if next == nil || r.timestamp < next.timestamp {
-			next = r
-			continue
-		}
+func (i *FileInput) nextReader() *fileInputReader {
+	if len(i.queue) == 0 {
+		return nil
 	}
 
-	return
+	return i.queue[0]
 }
 
 func (i *FileInput) emit() {
-	var lastTime int64 = -1
+	var start, end int64 = -1, -1
+	if !i.config.start.IsZero() {
+		start = i.config.start.UnixNano()
+	}
+	if !i.config.end.IsZero() {
+		end = i.config.end.UnixNano()
+	}
+
+	// Replay timing is relative to window start, or to the first payload
+	lastTime := start
+	skipped, emitted := i.skipped, 0
+	var lastEmit time.Time
+
+	// Read returns io.EOF after all payloads are consumed
+	defer close(i.data)
 
 	for {
 		select {
@@ -193,21 +552,50 @@ func (i *FileInput) emit() {
 		default:
 		}
 
+		i.mu.Lock()
+		if i.closed {
+			i.mu.Unlock()
+			return
+		}
+
 		reader := i.nextReader()
 
-		if reader == nil {
-			if i.loop {
-				i.init()
-				lastTime = -1
+		// Readers ordered by time, so all other payloads are after the window end too
+		if reader == nil || (end != -1 && reader.timestamp >= end) || (i.config.limit > 0 && emitted >= i.config.limit) {
+			if i.config.loop && i.init() == nil && len(i.queue) > 0 {
+				i.mu.Unlock()
+				lastTime = start
+				skipped, emitted = i.skipped, 0
 				continue
-			} else {
-				break
 			}
+
+			i.mu.Unlock()
+			break
+		}
+
+		timestamp := reader.timestamp
+
+		if timestamp < start {
+			i.readPayload(reader)
+			i.mu.Unlock()
+			continue
+		}
+
+		if skipped < i.config.skip {
+			i.readPayload(reader)
+			i.mu.Unlock()
+			skipped++
+			// Replay starts with the first payload after skipped ones
+			lastTime = -1
+			continue
 		}
 
+		payload := i.readPayload(reader)
+		i.mu.Unlock()
+
 		if lastTime != -1 {
-			diff := reader.timestamp - lastTime
-			lastTime = reader.timestamp
+			diff := timestamp - lastTime
+			lastTime = timestamp
 
 			if i.speedFactor != 1 {
 				diff = int64(float64(diff) / i.speedFactor)
@@ -215,27 +603,35 @@ func (i *FileInput) emit() {
 
 			time.Sleep(time.Duration(diff))
 		} else {
-			lastTime = reader.timestamp
+			lastTime = timestamp
 		}
 
-		i.data <- reader.ReadPayload()
+		if i.rate > 0 {
+			time.Sleep(time.Until(lastEmit.Add(time.Second / time.Duration(i.rate))))
+			lastEmit = time.Now()
+		}
+
+		select {
+		case i.data <- payload:
+			emitted++
+		case <-i.exit:
+			return
+		}
 	}
 
 	log.Printf("FileInput: end of file '%s'\n", i.path)
-
-	// For now having fixed timeout is temporary solution
-	// Further should be modified, so outputs can report if their queue empty or not
-	time.Sleep(time.Second)
-	if closeCh != nil {
-		close(closeCh)
-	}
 }
 
 func (i *FileInput) Close() error {
 	defer i.mu.Unlock()
 	i.mu.Lock()
 
-	i.exit <- true
+	i.closed = true
+
+	select {
+	case i.exit <- true:
+	default:
+	}
 
 	for _, r := range i.readers {
 		r.Close()
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var timeVarLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// timeVar is a flag accepting date in RFC3339 or `2006-01-02 15:04:05` format, in local time zone if not specified,
+// or unix timestamp in seconds
+type timeVar struct {
+	time.Time
+}
+
+func (t timeVar) String() string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func (t *timeVar) Set(s string) error {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		t.Time = time.Unix(sec, 0)
+		return nil
+	}
+
+	for _, layout := range timeVarLayouts {
+		if v, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			t.Time = v
+			return nil
+		}
+	}
+
+	return fmt.Errorf("wrong time %q, use RFC3339, '2006-01-02 15:04:05' or unix timestamp", s)
+}
+
+var fileNameChunkSuffix = regexp.MustCompile(`_\d+$`)
+
+// fileNameTimeRange detects time period of file written using date variables, like `requests-%Y%m%d%H.gor`,
+// from digits in the file name, ignoring chunk index added by FileOutput.
+// Only names with at least year, month and day recognized, and names with words mixing letters and digits,
+// like request IDs, are not.
+func fileNameTimeRange(path string) (from, to time.Time, ok bool) {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, compressionExt(name))
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	if trimmed := fileNameChunkSuffix.ReplaceAllString(name, ""); strings.ContainsAny(trimmed, "0123456789") {
+		name = trimmed
+	}
+
+	var digits string
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, w := range words {
+		if strings.Trim(w, "0123456789") == "" {
+			digits += w
+		} else if strings.ContainsAny(w, "0123456789") {
+			return
+		}
+	}
+
+	layouts := map[int]string{
+		8:  "20060102",
+		10: "2006010215",
+		12: "200601021504",
+		14: "20060102150405",
+	}
+
+	layout, found := layouts[len(digits)]
+	if !found {
+		return
+	}
+
+	from, err := time.ParseInLocation(layout, digits, time.Local)
+	if err != nil {
+		return
+	}
+
+	switch len(digits) {
+	case 8:
+		to = from.AddDate(0, 0, 1)
+	case 10:
+		to = from.Add(time.Hour)
+	case 12:
+		to = from.Add(time.Minute)
+	case 14:
+		to = from.Add(time.Second)
+	}
+
+	return from, to, true
+}
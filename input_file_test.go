@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -9,10 +10,13 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
 	"sync"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/buger/goreplay/proto"
 )
 
 var _ = log.Println
@@ -114,7 +118,7 @@ func TestInputFileMultipleFilesWithRequestsOnly(t *testing.T) {
 	file2.Write([]byte(payloadSeparator))
 	file2.Close()
 
-	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), false)
+	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), &FileInputConfig{})
 	buf := make([]byte, 1000)
 
 	for i := '1'; i <= '4'; i++ {
@@ -128,6 +132,192 @@ func TestInputFileMultipleFilesWithRequestsOnly(t *testing.T) {
 	os.Remove(file2.Name())
 }
 
+func TestInputFileTimeWindow(t *testing.T) {
+	rnd := rand.Int63()
+
+	file, _ := os.OpenFile(fmt.Sprintf("/tmp/%d", rnd), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	for i := 1; i <= 9; i++ {
+		file.Write([]byte(fmt.Sprintf("1 %d %d\nrequest%d", i, i*1000000, i)))
+		file.Write([]byte(payloadSeparator))
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	config := &FileInputConfig{skip: 1, limit: 3}
+	config.start.Set(time.Unix(0, 3000000).Format(time.RFC3339Nano))
+	config.end.Set(time.Unix(0, 7000000).Format(time.RFC3339Nano))
+
+	input := NewFileInput(file.Name(), config)
+	buf := make([]byte, 1000)
+
+	var ids []string
+	for {
+		n, err := input.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		ids = append(ids, string(payloadMeta(buf[:n])[1]))
+	}
+
+	if fmt.Sprint(ids) != "[4 5 6]" {
+		t.Error("Should replay only payloads inside of time window, after skipped and before limit:", ids)
+	}
+
+	config = &FileInputConfig{}
+	config.end.Set(time.Unix(0, 3000000).Format(time.RFC3339Nano))
+
+	input = NewFileInput(file.Name(), config)
+	ids = nil
+	for {
+		n, err := input.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		ids = append(ids, string(payloadMeta(buf[:n])[1]))
+	}
+
+	if fmt.Sprint(ids) != "[1 2]" {
+		t.Error("Should stop at the end of time window:", ids)
+	}
+}
+
+func TestInputFileSkipFilesOutsideOfTimeWindow(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_window")
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"requests-2016050113_0.gor", "requests-2016050114_0.gor", "requests-2016050114_1.gor", "requests-2016050115_0.gor"} {
+		ioutil.WriteFile(dir+"/"+name, []byte("1 1 1\nrequest"+payloadSeparator), 0660)
+	}
+
+	config := &FileInputConfig{}
+	config.start.Set("2016-05-01 14:30")
+	config.end.Set("2016-05-01 15:00")
+
+	input := NewFileInput(dir+"/*", config)
+	defer input.Close()
+
+	input.mu.Lock()
+	n := len(input.readers)
+	input.mu.Unlock()
+
+	if n != 2 {
+		t.Error("Should read only files which names overlap time window:", n)
+	}
+}
+
+func TestFileNameTimeRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{"requests-2016050114_0.gor", "2016-05-01 14:00", "2016-05-01 15:00"},
+		{"/tmp/log-2016-05-01-14-30.gor.gz", "2016-05-01 14:30", "2016-05-01 14:31"},
+		{"log_20160501", "2016-05-01 00:00", "2016-05-02 00:00"},
+		{"requests_0.gor", "", ""},
+		{"log-2016-05-01-d7123dasd913jfd2.gor", "", ""},
+		{"log-20160501-1234567.gor", "", ""},
+	}
+
+	for _, tt := range tests {
+		from, to, ok := fileNameTimeRange(tt.name)
+
+		if tt.from == "" {
+			if ok {
+				t.Error("Should not detect time of", tt.name, from)
+			}
+			continue
+		}
+
+		var expectedFrom, expectedTo timeVar
+		expectedFrom.Set(tt.from)
+		expectedTo.Set(tt.to)
+
+		if !ok || !from.Equal(expectedFrom.Time) || !to.Equal(expectedTo.Time) {
+			t.Error("Wrong time range of", tt.name, from, to)
+		}
+	}
+}
+
+func TestInputFileMergeWithBoundedOpenFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_merge")
+	defer os.RemoveAll(dir)
+
+	// 5 hosts, host N has payloads with timestamps N, N+5, N+10, ...
+	for host := 0; host < 5; host++ {
+		var buf bytes.Buffer
+		for ts := host; ts < 50; ts += 5 {
+			buf.WriteString(fmt.Sprintf("1 %d %d\nrequest", ts, ts))
+			buf.WriteString(payloadSeparator)
+		}
+
+		name := fmt.Sprintf("%s/host%d.gor", dir, host)
+
+		// Compressed file is reopened, and decompressed again up to the position
+		if host == 2 {
+			f, _ := os.Create(name + ".gz")
+			gz := gzip.NewWriter(f)
+			gz.Write(buf.Bytes())
+			gz.Close()
+			f.Close()
+			continue
+		}
+
+		ioutil.WriteFile(name, buf.Bytes(), 0660)
+	}
+
+	input := NewFileInput(dir+"/*", &FileInputConfig{maxOpen: 2})
+	buf := make([]byte, 1000)
+
+	for i := 0; i < 50; i++ {
+		n, err := input.Read(buf)
+		if err != nil {
+			t.Fatal("Should read payloads of all files:", i, err)
+		}
+
+		if id := string(payloadMeta(buf[:n])[1]); id != strconv.Itoa(i) {
+			t.Fatal("Should merge payloads by time:", i, id)
+		}
+
+		input.mu.Lock()
+		open := 0
+		for _, r := range input.readers {
+			if r.file != nil {
+				open++
+			}
+		}
+		input.mu.Unlock()
+
+		if open > 2 {
+			t.Fatal("Should not open more than 2 files, including compressed:", open)
+		}
+	}
+
+	if _, err := input.Read(buf); err != io.EOF {
+		t.Error("Should stop at the end of all files:", err)
+	}
+}
+
+func TestInputFileSourceHeader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_source")
+	defer os.RemoveAll(dir)
+
+	for i, host := range []string{"host1", "host2"} {
+		os.Mkdir(dir+"/"+host, 0770)
+		ioutil.WriteFile(dir+"/"+host+"/requests.gor", []byte(fmt.Sprintf("1 %d %d\nGET / HTTP/1.1\r\n\r\n", i, i)+payloadSeparator), 0660)
+	}
+
+	input := NewFileInput(dir+"/*/requests.gor", &FileInputConfig{sourceHeader: "X-Gor-Source"})
+	buf := make([]byte, 1000)
+
+	for _, expected := range []string{"host1/requests.gor", "host2/requests.gor"} {
+		n, _ := input.Read(buf)
+
+		if source := proto.Header(payloadBody(buf[:n]), []byte("X-Gor-Source")); string(source) != expected {
+			t.Errorf("Request should be tagged with its file: %q", buf[:n])
+		}
+	}
+}
+
 func TestInputFileRequestsWithLatency(t *testing.T) {
 	rnd := rand.Int63()
 
@@ -141,7 +331,7 @@ func TestInputFileRequestsWithLatency(t *testing.T) {
 	file.Write([]byte("1 3 250000000\nrequest3"))
 	file.Write([]byte(payloadSeparator))
 
-	input := NewFileInput(fmt.Sprintf("/tmp/%d", rnd), false)
+	input := NewFileInput(fmt.Sprintf("/tmp/%d", rnd), &FileInputConfig{})
 	buf := make([]byte, 1000)
 
 	start := time.Now().UnixNano()
@@ -187,7 +377,7 @@ func TestInputFileMultipleFilesWithRequestsAndResponses(t *testing.T) {
 	file2.Write([]byte(payloadSeparator))
 	file2.Close()
 
-	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), false)
+	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), &FileInputConfig{})
 	buf := make([]byte, 1000)
 
 	for i := '1'; i <= '4'; i++ {
@@ -216,7 +406,7 @@ func TestInputFileLoop(t *testing.T) {
 	file.Write([]byte(payloadSeparator))
 	file.Close()
 
-	input := NewFileInput(fmt.Sprintf("/tmp/%d", rnd), true)
+	input := NewFileInput(fmt.Sprintf("/tmp/%d", rnd), &FileInputConfig{loop: true})
 	buf := make([]byte, 1000)
 
 	// Even if we have just 2 requests in file, it should indifinitly loop
@@ -245,7 +435,7 @@ func TestInputFileCompressed(t *testing.T) {
 	name2 := output2.file.Name()
 	output2.Close()
 
-	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), false)
+	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), &FileInputConfig{})
 	buf := make([]byte, 1000)
 	for i := 0; i < 2000; i++ {
 		input.Read(buf)
@@ -344,7 +534,7 @@ func ReadFromCaptureFile(captureFile *os.File, count int, callback writeCallback
 	quit := make(chan int)
 	wg := new(sync.WaitGroup)
 
-	input := NewFileInput(captureFile.Name(), false)
+	input := NewFileInput(captureFile.Name(), &FileInputConfig{})
 	output := NewTestOutput(func(data []byte) {
 		callback(data)
 		wg.Done()
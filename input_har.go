@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HARInput replays requests from HAR files, exported by browsers and HTTP tools.
+// Files are converted to capture format in memory, and replayed by FileInput, so all --input-file-* options are supported.
+type HARInput struct {
+	*FileInput
+}
+
+// NewHARInput constructor for HARInput, accepts file path or pattern
+func NewHARInput(path string, config *FileInputConfig) (*HARInput, error) {
+	list := func() ([]string, error) {
+		return filepath.Glob(path)
+	}
+
+	// Fail early on wrong file, instead of replaying nothing
+	matches, _ := list()
+	for _, m := range matches {
+		if _, err := readHARFile(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return &HARInput{newFileInput(path, config, list, openHARFile)}, nil
+}
+
+func readHARFile(path string) (*harLog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	har := new(harLog)
+	if err := json.Unmarshal(data, har); err != nil {
+		return nil, fmt.Errorf("can't parse HAR file %s: %v", path, err)
+	}
+
+	return har, nil
+}
+
+// openHARFile converts HAR entries into request payloads, ordered by time
+func openHARFile(path string) (io.ReadCloser, error) {
+	har, err := readHARFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	type harPayload struct {
+		started time.Time
+		data    []byte
+	}
+
+	var payloads []harPayload
+
+	for i := range har.Log.Entries {
+		e := &har.Log.Entries[i]
+
+		started, err := time.Parse(time.RFC3339Nano, e.StartedDateTime)
+		if err != nil {
+			log.Printf("[INPUT-HAR] Skipping entry %d of %s, wrong startedDateTime: %v", i, path, err)
+			continue
+		}
+
+		request, err := harRequestPayload(&e.Request)
+		if err != nil {
+			log.Printf("[INPUT-HAR] Skipping entry %d of %s: %v", i, path, err)
+			continue
+		}
+
+		header := payloadHeader(RequestPayload, uuid(), started.UnixNano(), -1)
+		payloads = append(payloads, harPayload{started, append(header, request...)})
+	}
+
+	sort.SliceStable(payloads, func(i, j int) bool {
+		return payloads[i].started.Before(payloads[j].started)
+	})
+
+	records := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		records[i] = p.data
+	}
+
+	return newCaptureStream(records), nil
+}
+
+func (i *HARInput) String() string {
+	return "HAR input: " + i.path
+}
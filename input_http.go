@@ -1,76 +1,401 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// HTTPInput used for sending requests to Gor via http
+// HTTPInputPayloadsPath accepts batches of already captured payloads, all other paths record request itself
+const HTTPInputPayloadsPath = "/gor/payloads"
+
+// HTTPInputTokenHeader carries token of the sender, it is removed from recorded requests
+const HTTPInputTokenHeader = "X-Gor-Token"
+
+// Time to read request headers, slow clients are disconnected
+const httpInputReadHeaderTimeout = 10 * time.Second
+
+// HTTPInputConfig contains settings of HTTP input
+type HTTPInputConfig struct {
+	tokens          MultiOption
+	certificatePath string
+	keyPath         string
+	clientCAPath    string
+	queueSize       int
+	// Maximum size of request body, after decompression too
+	maxBodySize unitSizeVar
+	// Time to read whole request, including body
+	readTimeout time.Duration
+}
+
+// HTTPInput used for sending requests to Gor via http.
+// Application can send copy of its requests, or batches of payloads in Gor or JSON Lines format to /gor/payloads.
 type HTTPInput struct {
-	data     chan []byte
-	address  string
-	listener net.Listener
+	// Keep first for 64bit alignment of atomic operations on 32bit machines
+	accepted int64
+	dropped  int64
+
+	data        chan []byte
+	address     string
+	config      *HTTPInputConfig
+	listener    net.Listener
+	server      *http.Server
+	maxBodySize int64
+
+	// Batch is either queued as whole, or rejected
+	mu sync.Mutex
+
+	quit      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewHTTPInput constructor for HTTPInput. Accepts address with port which he will listen on.
-func NewHTTPInput(address string) (i *HTTPInput) {
+func NewHTTPInput(address string, config *HTTPInputConfig) (i *HTTPInput, err error) {
 	i = new(HTTPInput)
-	i.data = make(chan []byte, 10000)
 	i.address = address
+	i.config = config
+	i.quit = make(chan struct{})
+
+	queueSize := config.queueSize
+	if queueSize <= 0 {
+		queueSize = 10000
+	}
+	i.data = make(chan []byte, queueSize)
 
-	i.listen(address)
+	i.maxBodySize = int64(config.maxBodySize)
+	if i.maxBodySize <= 0 {
+		i.maxBodySize = 32 << 20
+	}
+
+	if err = i.listen(address); err != nil {
+		return nil, err
+	}
 
 	return
 }
 
 func (i *HTTPInput) Read(data []byte) (int, error) {
-	buf := <-i.data
+	var buf []byte
 
-	header := payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1)
+	select {
+	case buf = <-i.data:
+	case <-i.quit:
+		// Deliver already accepted payloads before stopping
+		select {
+		case buf = <-i.data:
+		default:
+			return 0, io.EOF
+		}
+	}
 
-	copy(data[0:len(header)], header)
-	copy(data[len(header):], buf)
+	copy(data, buf)
 
-	return len(buf) + len(header), nil
+	return len(buf), nil
 }
 
 func (i *HTTPInput) handler(w http.ResponseWriter, r *http.Request) {
-	r.URL.Scheme = "http"
-	r.URL.Host = i.listener.Addr().String()
+	if !i.authorized(r) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
 
-	buf, _ := httputil.DumpRequestOut(r, true)
-	http.Error(w, http.StatusText(200), 200)
+	r.Body = http.MaxBytesReader(w, r.Body, i.maxBodySize)
 
-	select {
-	case i.data <- buf:
-	default:
-		Debug("[INPUT-HTTP] Dropping requests because output can't process them fast enough")
+	var payloads [][]byte
+	if r.URL.Path == HTTPInputPayloadsPath {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var err error
+		if payloads, err = i.readPayloads(r); err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+	} else {
+		r.Header.Del(HTTPInputTokenHeader)
+
+		// Unlike DumpRequestOut, keeps request line and headers as received
+		buf, err := httputil.DumpRequest(r, true)
+		if err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+
+		header := payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1)
+		payloads = [][]byte{append(header, buf...)}
+	}
+
+	if len(payloads) > cap(i.data) {
+		atomic.AddInt64(&i.dropped, int64(len(payloads)))
+		http.Error(w, fmt.Sprintf("batch of %d payloads is larger than queue size %d", len(payloads), cap(i.data)), http.StatusRequestEntityTooLarge)
+		return
 	}
+
+	if !i.enqueue(payloads) {
+		atomic.AddInt64(&i.dropped, int64(len(payloads)))
+		Debug("[INPUT-HTTP] Rejecting payloads because output can't process them fast enough")
+
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	atomic.AddInt64(&i.accepted, int64(len(payloads)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"accepted": len(payloads)})
+}
+
+// enqueue queues all payloads, or none of them if there is not enough space
+func (i *HTTPInput) enqueue(payloads [][]byte) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// Only Read takes from the queue, so free space can't shrink while locked
+	if cap(i.data)-len(i.data) < len(payloads) {
+		return false
+	}
+
+	for _, p := range payloads {
+		i.data <- p
+	}
+
+	return true
 }
 
-func (i *HTTPInput) listen(address string) {
-	var err error
+func (i *HTTPInput) authorized(r *http.Request) bool {
+	if len(i.config.tokens) == 0 {
+		return true
+	}
 
+	token := []byte(r.Header.Get(HTTPInputTokenHeader))
+	for _, t := range i.config.tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readPayloads parses batch of payloads in Gor text format, or JSON Lines format.
+// Batch with any malformed payload is rejected.
+func (i *HTTPInput) readPayloads(r *http.Request) ([][]byte, error) {
+	body := io.Reader(r.Body)
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		ext, ok := map[string]string{"gzip": ".gz", "zstd": ".zst", "lz4": ".lz4"}[enc]
+		if !ok {
+			return nil, fmt.Errorf("unsupported Content-Encoding: %s", enc)
+		}
+
+		dr, err := newDecompressReader(r.Body, ext)
+		if err != nil {
+			return nil, err
+		}
+		defer dr.Close()
+		// Small compressed body can be huge after decompression
+		body = &decompressedBodyReader{r: dr, n: i.maxBodySize, limit: i.maxBodySize}
+	}
+
+	reader := bufio.NewReader(body)
+
+	var payloads [][]byte
+	if isJSONFormat(reader) {
+		for n := 1; ; n++ {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				payload, perr := decodeJSONPayload(line)
+				if perr == nil {
+					perr = validatePayload(payload)
+				}
+				if perr != nil {
+					return nil, fmt.Errorf("line %d: %v", n, perr)
+				}
+				payloads = append(payloads, payload)
+			}
+
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		// Separator after the last payload is optional
+		data = bytes.TrimSuffix(data, []byte(payloadSeparator))
+		data = bytes.TrimSuffix(data, []byte(payloadSeparator[:len(payloadSeparator)-1]))
+
+		if len(data) > 0 {
+			for n, payload := range bytes.Split(data, []byte(payloadSeparator)) {
+				if err := validatePayload(payload); err != nil {
+					return nil, fmt.Errorf("payload %d: %v", n+1, err)
+				}
+				payloads = append(payloads, payload)
+			}
+		}
+	}
+
+	if len(payloads) == 0 {
+		return nil, errors.New("no payloads in request body")
+	}
+
+	return payloads, nil
+}
+
+// decompressedBodyReader limits size of decompressed body, like http.MaxBytesReader limits received one
+type decompressedBodyReader struct {
+	r io.Reader
+	// Bytes left before limit
+	n     int64
+	limit int64
+}
+
+func (l *decompressedBodyReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// Body of exactly limit size is allowed
+		if n, _ := l.r.Read(make([]byte, 1)); n > 0 {
+			return 0, &http.MaxBytesError{Limit: l.limit}
+		}
+
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	return n, err
+}
+
+// bodyErrorStatus returns status of response to request which body can't be read
+func bodyErrorStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// validatePayload checks that payload has correct header
+func validatePayload(payload []byte) error {
+	meta := payloadMeta(payload)
+	if len(meta) < 3 || len(meta[0]) != 1 {
+		return errors.New("wrong payload header")
+	}
+
+	switch meta[0][0] {
+	case RequestPayload, ResponsePayload, ReplayedResponsePayload:
+		return nil
+	}
+
+	return fmt.Errorf("unknown payload type: %q", meta[0])
+}
+
+func (i *HTTPInput) listen(address string) (err error) {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", i.handler)
 
 	i.listener, err = net.Listen("tcp", address)
 	if err != nil {
-		log.Fatal("HTTP input listener failure:", err)
+		return fmt.Errorf("HTTP input listener failure: %v", err)
 	}
 
-	go func() {
-		err = http.Serve(i.listener, mux)
+	if i.config.certificatePath != "" {
+		cer, err := tls.LoadX509KeyPair(i.config.certificatePath, i.config.keyPath)
 		if err != nil {
-			log.Fatal("HTTP input serve failure:", err)
+			i.listener.Close()
+			return fmt.Errorf("Error while loading --input-http certificate: %v", err)
+		}
+
+		config := &tls.Config{Certificates: []tls.Certificate{cer}}
+
+		// Mutual TLS, only clients with certificate signed by given CA are accepted
+		if i.config.clientCAPath != "" {
+			pem, err := ioutil.ReadFile(i.config.clientCAPath)
+			if err != nil {
+				i.listener.Close()
+				return fmt.Errorf("Error while loading --input-http client CA: %v", err)
+			}
+
+			config.ClientCAs = x509.NewCertPool()
+			if !config.ClientCAs.AppendCertsFromPEM(pem) {
+				i.listener.Close()
+				return fmt.Errorf("Error while loading --input-http client CA: no certificates found in %s", i.config.clientCAPath)
+			}
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		i.listener = tls.NewListener(i.listener, config)
+	} else if i.config.clientCAPath != "" {
+		i.listener.Close()
+		return errors.New("--input-http-client-ca requires --input-http-certificate")
+	}
+
+	readTimeout := i.config.readTimeout
+	if readTimeout <= 0 {
+		readTimeout = time.Minute
+	}
+
+	i.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: httpInputReadHeaderTimeout,
+		ReadTimeout:       readTimeout,
+	}
+
+	go func() {
+		if err := i.server.Serve(i.listener); err != nil && err != http.ErrServerClosed {
+			reportError(i, fmt.Errorf("HTTP input serve failure: %v", err))
 		}
 	}()
+
+	return nil
+}
+
+func (i *HTTPInput) gauges() map[string]int64 {
+	return map[string]int64{
+		"accepted_payloads": atomic.LoadInt64(&i.accepted),
+		"dropped_payloads":  atomic.LoadInt64(&i.dropped),
+		"queue_length":      int64(len(i.data)),
+	}
 }
 
 func (i *HTTPInput) String() string {
 	return "HTTP input: " + i.address
 }
+
+// Close stops accepting requests, payloads already accepted still can be read
+func (i *HTTPInput) Close() error {
+	i.closeOnce.Do(func() {
+		i.server.Close()
+		close(i.quit)
+	})
+
+	return nil
+}
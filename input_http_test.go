@@ -1,10 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -16,7 +24,10 @@ func TestHTTPInput(t *testing.T) {
 	wg := new(sync.WaitGroup)
 	quit := make(chan int)
 
-	input := NewHTTPInput("127.0.0.1:0")
+	input, err := NewHTTPInput("127.0.0.1:0", &HTTPInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	output := NewTestOutput(func(data []byte) {
 		wg.Done()
 	})
@@ -48,7 +59,10 @@ func TestInputHTTPLargePayload(t *testing.T) {
 		log.Fatal("dd error:", err)
 	}
 
-	input := NewHTTPInput("127.0.0.1:0")
+	input, err := NewHTTPInput("127.0.0.1:0", &HTTPInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	output := NewTestOutput(func(data []byte) {
 		if len(proto.Body(payloadBody(data))) != 4000000 {
 			t.Error("Should receive full file")
@@ -71,3 +85,186 @@ func TestInputHTTPLargePayload(t *testing.T) {
 	wg.Wait()
 	close(quit)
 }
+
+func TestHTTPInputPayloads(t *testing.T) {
+	input, err := NewHTTPInput("127.0.0.1:0", &HTTPInputConfig{tokens: MultiOption{"old", "new"}, queueSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	address := "http://" + input.listener.Addr().String()
+
+	post := func(path, token, body string) *http.Response {
+		req, _ := http.NewRequest("POST", address+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(HTTPInputTokenHeader, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	read := func() string {
+		buf := make([]byte, 1000)
+		n, _ := input.Read(buf)
+		return string(buf[:n])
+	}
+
+	text := "1 a1 100\nGET / HTTP/1.1\r\n\r\n" + payloadSeparator + "2 a1 200 5\nHTTP/1.1 200 OK\r\n\r\n" + payloadSeparator
+	if resp := post(HTTPInputPayloadsPath, "new", text); resp.StatusCode != 200 {
+		t.Fatal("Should accept payloads:", resp.Status)
+	}
+
+	if p := read(); p != "1 a1 100\nGET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("Wrong payload: %q", p)
+	}
+	if p := read(); p != "2 a1 200 5\nHTTP/1.1 200 OK\r\n\r\n" {
+		t.Errorf("Wrong payload: %q", p)
+	}
+
+	jsonl := `{"type":"request","id":"a2","timestamp":300,"method":"POST","url":"/","proto":"HTTP/1.1","headers":[{"name":"Content-Length","value":"2"}],"body":"{}"}` + "\n"
+	if resp := post(HTTPInputPayloadsPath, "old", jsonl); resp.StatusCode != 200 {
+		t.Fatal("Should accept JSON Lines:", resp.Status)
+	}
+	if p := read(); p != "1 a2 300\nPOST / HTTP/1.1\r\nContent-Length: 2\r\n\r\n{}" {
+		t.Errorf("Wrong payload: %q", p)
+	}
+
+	if resp := post(HTTPInputPayloadsPath, "wrong", text); resp.StatusCode != 401 {
+		t.Error("Should check token:", resp.Status)
+	}
+
+	if resp := post(HTTPInputPayloadsPath, "new", text+"GET / HTTP/1.1\r\n\r\n"); resp.StatusCode != 400 {
+		t.Error("Should reject malformed batch:", resp.Status)
+	}
+
+	// Requests sent by application are recorded without token
+	if resp := post("/users?page=1", "new", "body"); resp.StatusCode != 200 {
+		t.Fatal("Should accept request:", resp.Status)
+	}
+	if p := read(); !isRequestPayload([]byte(p)) || !strings.HasPrefix(string(payloadBody([]byte(p))), "POST /users?page=1 HTTP/1.1\r\n") || strings.Contains(p, HTTPInputTokenHeader) || !strings.HasSuffix(p, "\r\n\r\nbody") {
+		t.Errorf("Wrong payload: %q", p)
+	}
+
+	// Queue of 3 payloads: first batch fits, second is rejected as whole
+	if resp := post(HTTPInputPayloadsPath, "new", text); resp.StatusCode != 200 {
+		t.Fatal("Should accept payloads:", resp.Status)
+	}
+	if resp := post(HTTPInputPayloadsPath, "new", text); resp.StatusCode != 429 || resp.Header.Get("Retry-After") == "" {
+		t.Error("Should reject payloads when queue is full:", resp.Status)
+	}
+
+	if g := input.gauges(); g["accepted_payloads"] != 6 || g["dropped_payloads"] != 2 || g["queue_length"] != 2 {
+		t.Error("Wrong counts:", g)
+	}
+
+	input.Close()
+	read()
+	read()
+	if _, err := input.Read(make([]byte, 1000)); err != io.EOF {
+		t.Error("Should stop after accepted payloads are read:", err)
+	}
+}
+
+func TestHTTPInputMutualTLS(t *testing.T) {
+	serverCert, serverKey := genCertificate(&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}})
+	clientCert, clientKey := genCertificate(&x509.Certificate{})
+
+	dir, _ := ioutil.TempDir("", "gor_http_tls")
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{"server.crt": serverCert, "server.key": serverKey, "client.crt": clientCert}
+	for name, data := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+	}
+
+	input, err := NewHTTPInput("127.0.0.1:0", &HTTPInputConfig{
+		certificatePath: filepath.Join(dir, "server.crt"),
+		keyPath:         filepath.Join(dir, "server.key"),
+		clientCAPath:    filepath.Join(dir, "client.crt"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCert)
+
+	address := "https://" + input.listener.Addr().String() + "/"
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if _, err := client.Get(address); err == nil {
+		t.Error("Should require client certificate")
+	}
+
+	cert, _ := tls.X509KeyPair(clientCert, clientKey)
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}}}}
+	resp, err := client.Get(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || input.gauges()["queue_length"] != 1 {
+		t.Error("Should accept client with certificate:", resp.Status)
+	}
+}
+
+func TestHTTPInputBodyLimit(t *testing.T) {
+	input, err := NewHTTPInput("127.0.0.1:0", &HTTPInputConfig{maxBodySize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	post := func(path string, body []byte, encoding string) int {
+		req, _ := http.NewRequest("POST", "http://"+input.listener.Addr().String()+path, bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	large := []byte("1 a1 100\nPOST / HTTP/1.1\r\n\r\n" + strings.Repeat("a", 2000))
+
+	if status := post("/", large, ""); status != 413 {
+		t.Error("Should reject large request:", status)
+	}
+
+	if status := post(HTTPInputPayloadsPath, large, ""); status != 413 {
+		t.Error("Should reject large batch:", status)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(large)
+	gz.Close()
+
+	if compressed.Len() > 1000 {
+		t.Fatal("Compressed batch should fit limit:", compressed.Len())
+	}
+
+	if status := post(HTTPInputPayloadsPath, compressed.Bytes(), "gzip"); status != 413 {
+		t.Error("Should limit decompressed batch:", status)
+	}
+
+	if status := post(HTTPInputPayloadsPath, large[:1000], ""); status != 200 {
+		t.Error("Should accept batch of limit size:", status)
+	}
+
+	if input.server.ReadHeaderTimeout == 0 || input.server.ReadTimeout == 0 {
+		t.Error("Slow clients should be disconnected")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"strings"
 
@@ -18,7 +19,7 @@ type KafkaInput struct {
 }
 
 // NewKafkaInput creates instance of kafka consumer client.
-func NewKafkaInput(address string, config *KafkaConfig) *KafkaInput {
+func NewKafkaInput(address string, config *KafkaConfig) (*KafkaInput, error) {
 	c := sarama.NewConfig()
 	// Configuration options go here
 
@@ -32,13 +33,13 @@ func NewKafkaInput(address string, config *KafkaConfig) *KafkaInput {
 		con, err = sarama.NewConsumer(strings.Split(config.host, ","), c)
 
 		if err != nil {
-			log.Fatalln("Failed to start Sarama(Kafka) consumer:", err)
+			return nil, fmt.Errorf("Failed to start Sarama(Kafka) consumer: %v", err)
 		}
 	}
 
 	partitions, err := con.Partitions(config.topic)
 	if err != nil {
-		log.Fatalln("Failed to collect Sarama(Kafka) partitions:", err)
+		return nil, fmt.Errorf("Failed to collect Sarama(Kafka) partitions: %v", err)
 	}
 
 	i := &KafkaInput{
@@ -50,7 +51,7 @@ func NewKafkaInput(address string, config *KafkaConfig) *KafkaInput {
 	for index, partition := range partitions {
 		consumer, err := con.ConsumePartition(config.topic, partition, sarama.OffsetNewest)
 		if err != nil {
-			log.Fatalln("Failed to start Sarama(Kafka) partition consumer:", err)
+			return nil, fmt.Errorf("Failed to start Sarama(Kafka) partition consumer: %v", err)
 		}
 
 		go func(consumer sarama.PartitionConsumer) {
@@ -69,7 +70,7 @@ func NewKafkaInput(address string, config *KafkaConfig) *KafkaInput {
 		i.consumers[index] = consumer
 	}
 
-	return i
+	return i, nil
 }
 
 // ErrorHandler should receive errors
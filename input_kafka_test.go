@@ -16,11 +16,14 @@ func TestInputKafkaRAW(t *testing.T) {
 		map[string][]int32{"test": {0}},
 	)
 
-	input := NewKafkaInput("", &KafkaConfig{
+	input, err := NewKafkaInput("", &KafkaConfig{
 		consumer: consumer,
 		topic:    "test",
 		useJSON:  false,
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	buf := make([]byte, 1024)
 	n, err := input.Read(buf)
@@ -43,11 +46,14 @@ func TestInputKafkaJSON(t *testing.T) {
 		map[string][]int32{"test": {0}},
 	)
 
-	input := NewKafkaInput("", &KafkaConfig{
+	input, err := NewKafkaInput("", &KafkaConfig{
 		consumer: consumer,
 		topic:    "test",
 		useJSON:  true,
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	buf := make([]byte, 1024)
 	n, err := input.Read(buf)
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Nesting of generated objects and arrays, protects from infinite recursion of referenced schemas
+const openAPIMaxDepth = 5
+
+// OpenAPIInputConfig holds options of OpenAPIInput
+type OpenAPIInputConfig struct {
+	rps int
+	// Operation weights, in `operationId=weight` or `METHOD /path=weight` format
+	weights MultiOption
+	// Base URL, used instead of spec servers
+	server string
+}
+
+// OpenAPI 3 spec. Only fields used to generate requests are declared, both JSON and YAML specs supported.
+type openAPISpec struct {
+	Servers    []openAPIServer             `json:"servers" yaml:"servers"`
+	Paths      map[string]*openAPIPathItem `json:"paths" yaml:"paths"`
+	Components struct {
+		Schemas       map[string]*openAPISchema      `json:"schemas" yaml:"schemas"`
+		Parameters    map[string]*openAPIParameter   `json:"parameters" yaml:"parameters"`
+		RequestBodies map[string]*openAPIRequestBody `json:"requestBodies" yaml:"requestBodies"`
+	} `json:"components" yaml:"components"`
+}
+
+type openAPIServer struct {
+	URL       string `json:"url" yaml:"url"`
+	Variables map[string]struct {
+		Default string `json:"default" yaml:"default"`
+	} `json:"variables" yaml:"variables"`
+}
+
+type openAPIPathItem struct {
+	Parameters []*openAPIParameter `json:"parameters" yaml:"parameters"`
+	Get        *openAPIOperation   `json:"get" yaml:"get"`
+	Put        *openAPIOperation   `json:"put" yaml:"put"`
+	Post       *openAPIOperation   `json:"post" yaml:"post"`
+	Delete     *openAPIOperation   `json:"delete" yaml:"delete"`
+	Options    *openAPIOperation   `json:"options" yaml:"options"`
+	Head       *openAPIOperation   `json:"head" yaml:"head"`
+	Patch      *openAPIOperation   `json:"patch" yaml:"patch"`
+	Trace      *openAPIOperation   `json:"trace" yaml:"trace"`
+}
+
+type openAPIOperation struct {
+	OperationID string              `json:"operationId" yaml:"operationId"`
+	Parameters  []*openAPIParameter `json:"parameters" yaml:"parameters"`
+	RequestBody *openAPIRequestBody `json:"requestBody" yaml:"requestBody"`
+	// Weight of operation in generated traffic, if not set by option
+	Weight *float64 `json:"x-gor-weight" yaml:"x-gor-weight"`
+
+	// Set when spec loaded
+	method string
+	path   string
+	params []*openAPIParameter
+	weight float64
+}
+
+type openAPIParameter struct {
+	Ref      string         `json:"$ref" yaml:"$ref"`
+	Name     string         `json:"name" yaml:"name"`
+	In       string         `json:"in" yaml:"in"`
+	Required bool           `json:"required" yaml:"required"`
+	Schema   *openAPISchema `json:"schema" yaml:"schema"`
+	Example  interface{}    `json:"example" yaml:"example"`
+}
+
+type openAPIRequestBody struct {
+	Ref     string                       `json:"$ref" yaml:"$ref"`
+	Content map[string]*openAPIMediaType `json:"content" yaml:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema  *openAPISchema `json:"schema" yaml:"schema"`
+	Example interface{}    `json:"example" yaml:"example"`
+}
+
+type openAPISchema struct {
+	Ref string `json:"$ref" yaml:"$ref"`
+	// String, or list of types in OpenAPI 3.1
+	Type       interface{}               `json:"type" yaml:"type"`
+	Format     string                    `json:"format" yaml:"format"`
+	Enum       []interface{}             `json:"enum" yaml:"enum"`
+	Example    interface{}               `json:"example" yaml:"example"`
+	Properties map[string]*openAPISchema `json:"properties" yaml:"properties"`
+	Required   []string                  `json:"required" yaml:"required"`
+	ReadOnly   bool                      `json:"readOnly" yaml:"readOnly"`
+	Items      *openAPISchema            `json:"items" yaml:"items"`
+	AllOf      []*openAPISchema          `json:"allOf" yaml:"allOf"`
+	OneOf      []*openAPISchema          `json:"oneOf" yaml:"oneOf"`
+	AnyOf      []*openAPISchema          `json:"anyOf" yaml:"anyOf"`
+
+	Minimum   *float64 `json:"minimum" yaml:"minimum"`
+	Maximum   *float64 `json:"maximum" yaml:"maximum"`
+	MinLength *int     `json:"minLength" yaml:"minLength"`
+	MaxLength *int     `json:"maxLength" yaml:"maxLength"`
+	MinItems  *int     `json:"minItems" yaml:"minItems"`
+	MaxItems  *int     `json:"maxItems" yaml:"maxItems"`
+}
+
+// OpenAPIInput generates synthetic requests for operations of OpenAPI 3 spec, at configured rate.
+// Operations are chosen randomly according to their weights, and parameters and bodies generated from schemas,
+// using examples and enums where available.
+type OpenAPIInput struct {
+	data   chan []byte
+	quit   chan struct{}
+	path   string
+	config *OpenAPIInputConfig
+
+	spec        *openAPISpec
+	server      *url.URL
+	ops         []*openAPIOperation
+	totalWeight float64
+	rand        *rand.Rand
+
+	closeOnce sync.Once
+}
+
+// NewOpenAPIInput constructor for OpenAPIInput, accepts path to JSON or YAML spec
+func NewOpenAPIInput(path string, config *OpenAPIInputConfig) (*OpenAPIInput, error) {
+	i := &OpenAPIInput{
+		data:   make(chan []byte, 1000),
+		quit:   make(chan struct{}),
+		path:   path,
+		config: config,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if config.rps <= 0 {
+		return nil, errors.New("requests per second should be positive")
+	}
+
+	if err := i.load(); err != nil {
+		return nil, err
+	}
+
+	go i.emit()
+
+	return i, nil
+}
+
+// load parses spec, and collects operations with their weights
+func (i *OpenAPIInput) load() error {
+	data, err := ioutil.ReadFile(i.path)
+	if err != nil {
+		return err
+	}
+
+	i.spec = new(openAPISpec)
+	if strings.ToLower(filepath.Ext(i.path)) == ".json" {
+		err = json.Unmarshal(data, i.spec)
+	} else {
+		err = yaml.Unmarshal(data, i.spec)
+	}
+
+	if err != nil {
+		return fmt.Errorf("can't parse OpenAPI spec: %v", err)
+	}
+
+	server := i.config.server
+	if server == "" && len(i.spec.Servers) > 0 {
+		server = i.spec.Servers[0].URL
+		for name, v := range i.spec.Servers[0].Variables {
+			server = strings.Replace(server, "{"+name+"}", v.Default, -1)
+		}
+	}
+
+	if i.server, err = url.Parse(server); err != nil || i.server.Host == "" {
+		return fmt.Errorf("spec has no absolute server URL, set it with --input-openapi-server")
+	}
+
+	weights := make(map[string]float64)
+	for _, w := range i.config.weights {
+		eq := strings.LastIndexByte(w, '=')
+		if eq == -1 {
+			return fmt.Errorf("weight should be in operation=weight format, got %q", w)
+		}
+
+		weight, err := strconv.ParseFloat(w[eq+1:], 64)
+		if err != nil || weight < 0 {
+			return fmt.Errorf("wrong weight of %q", w)
+		}
+		weights[strings.TrimSpace(w[:eq])] = weight
+	}
+
+	// Sorted, so order of operations does not depend on map iteration
+	paths := make([]string, 0, len(i.spec.Paths))
+	for p := range i.spec.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		item := i.spec.Paths[p]
+		if item == nil {
+			continue
+		}
+
+		for _, op := range []struct {
+			method string
+			op     *openAPIOperation
+		}{
+			{"GET", item.Get}, {"PUT", item.Put}, {"POST", item.Post}, {"DELETE", item.Delete},
+			{"OPTIONS", item.Options}, {"HEAD", item.Head}, {"PATCH", item.Patch}, {"TRACE", item.Trace},
+		} {
+			if op.op == nil {
+				continue
+			}
+
+			o := op.op
+			o.method, o.path = op.method, p
+
+			o.weight = 1
+			if o.Weight != nil {
+				o.weight = *o.Weight
+			}
+			if w, ok := weights[o.OperationID]; ok && o.OperationID != "" {
+				o.weight = w
+			}
+			if w, ok := weights[o.method+" "+o.path]; ok {
+				o.weight = w
+			}
+
+			if o.weight == 0 {
+				continue
+			}
+
+			// Operation parameters override parameters of the path with the same name and location
+			seen := make(map[string]bool)
+			for _, param := range append(append([]*openAPIParameter{}, o.Parameters...), item.Parameters...) {
+				if param = i.resolveParameter(param); param == nil || seen[param.In+" "+param.Name] {
+					continue
+				}
+				seen[param.In+" "+param.Name] = true
+				o.params = append(o.params, param)
+			}
+
+			i.ops = append(i.ops, o)
+			i.totalWeight += o.weight
+		}
+	}
+
+	if len(i.ops) == 0 {
+		return errors.New("spec has no operations to generate")
+	}
+
+	return nil
+}
+
+func (i *OpenAPIInput) resolveParameter(p *openAPIParameter) *openAPIParameter {
+	if p != nil && p.Ref != "" {
+		return i.spec.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+	}
+
+	return p
+}
+
+func (i *OpenAPIInput) resolveSchema(s *openAPISchema) *openAPISchema {
+	for n := 0; s != nil && s.Ref != "" && n < openAPIMaxDepth; n++ {
+		s = i.spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+
+	return s
+}
+
+func (i *OpenAPIInput) emit() {
+	ticker := time.NewTicker(time.Second / time.Duration(i.config.rps))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.quit:
+			return
+		case <-ticker.C:
+		}
+
+		request, err := i.generate(i.operation())
+		if err != nil {
+			reportError(i, err)
+			continue
+		}
+
+		select {
+		case i.data <- append(payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1), request...):
+		case <-i.quit:
+			return
+		}
+	}
+}
+
+// operation chooses random operation according to weights
+func (i *OpenAPIInput) operation() *openAPIOperation {
+	n := i.rand.Float64() * i.totalWeight
+	for _, op := range i.ops {
+		if n -= op.weight; n < 0 {
+			return op
+		}
+	}
+
+	return i.ops[len(i.ops)-1]
+}
+
+// generate builds request for operation, optional parameters are included randomly
+func (i *OpenAPIInput) generate(op *openAPIOperation) ([]byte, error) {
+	path := op.path
+	query := url.Values{}
+	var headers []JSONHeader
+	var cookies []string
+
+	for _, p := range op.params {
+		if !p.Required && p.In != "path" && i.rand.Intn(2) == 0 {
+			continue
+		}
+
+		var v interface{}
+		if p.Example != nil {
+			v = openAPIValue(p.Example)
+		} else {
+			v = i.value(p.Schema, 0)
+		}
+
+		var values []string
+		if items, ok := v.([]interface{}); ok {
+			for _, item := range items {
+				values = append(values, openAPIString(item))
+			}
+		} else {
+			values = []string{openAPIString(v)}
+		}
+
+		switch p.In {
+		case "path":
+			path = strings.Replace(path, "{"+p.Name+"}", url.PathEscape(strings.Join(values, ",")), -1)
+		case "query":
+			query[p.Name] = values
+		case "header":
+			headers = append(headers, JSONHeader{p.Name, strings.Join(values, ",")})
+		case "cookie":
+			cookies = append(cookies, p.Name+"="+strings.Join(values, ","))
+		}
+	}
+
+	if len(cookies) > 0 {
+		headers = append(headers, JSONHeader{"Cookie", strings.Join(cookies, "; ")})
+	}
+
+	var body []byte
+	if rb := op.RequestBody; rb != nil {
+		if rb.Ref != "" {
+			rb = i.spec.Components.RequestBodies[strings.TrimPrefix(rb.Ref, "#/components/requestBodies/")]
+		}
+
+		if rb != nil {
+			contentType, media := openAPIMediaTypeOf(rb.Content)
+			if media != nil {
+				var err error
+				if body, err = i.body(contentType, media); err != nil {
+					return nil, fmt.Errorf("%s %s: %v", op.method, op.path, err)
+				}
+				headers = append(headers, JSONHeader{"Content-Type", contentType})
+			}
+		}
+	}
+
+	// Path parameters are already escaped
+	target := strings.TrimSuffix(i.server.String(), "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	return buildRequestPayload(op.method, target, "HTTP/1.1", headers, body)
+}
+
+// openAPIMediaTypeOf chooses media type of request body, JSON preferred
+func openAPIMediaTypeOf(content map[string]*openAPIMediaType) (string, *openAPIMediaType) {
+	types := make([]string, 0, len(content))
+	for t := range content {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	rank := func(t string) int {
+		switch {
+		case t == "application/json":
+			return 0
+		case strings.HasSuffix(t, "+json"):
+			return 1
+		case t == "application/x-www-form-urlencoded":
+			return 2
+		case strings.HasPrefix(t, "text/"):
+			return 3
+		}
+		return 4
+	}
+	sort.SliceStable(types, func(i, j int) bool {
+		return rank(types[i]) < rank(types[j])
+	})
+
+	if len(types) == 0 {
+		return "", nil
+	}
+
+	return types[0], content[types[0]]
+}
+
+func (i *OpenAPIInput) body(contentType string, media *openAPIMediaType) ([]byte, error) {
+	var v interface{}
+	if media.Example != nil {
+		v = openAPIValue(media.Example)
+	} else {
+		v = i.value(media.Schema, 0)
+	}
+
+	switch {
+	case strings.HasSuffix(contentType, "json"):
+		return json.Marshal(v)
+	case contentType == "application/x-www-form-urlencoded":
+		form := url.Values{}
+		if fields, ok := v.(map[string]interface{}); ok {
+			for name, value := range fields {
+				form.Set(name, openAPIString(value))
+			}
+		}
+		return []byte(form.Encode()), nil
+	default:
+		return []byte(openAPIString(v)), nil
+	}
+}
+
+// value generates random value matching the schema.
+// Every reference and composition step increases depth, so recursive schemas end with empty values.
+func (i *OpenAPIInput) value(s *openAPISchema, depth int) interface{} {
+	if s != nil && s.Ref != "" {
+		depth++
+	}
+
+	if depth > openAPIMaxDepth {
+		return nil
+	}
+
+	if s = i.resolveSchema(s); s == nil {
+		return i.word(1, 10)
+	}
+
+	switch {
+	case s.Example != nil:
+		return openAPIValue(s.Example)
+	case len(s.Enum) > 0:
+		return openAPIValue(s.Enum[i.rand.Intn(len(s.Enum))])
+	case len(s.OneOf) > 0:
+		return i.value(s.OneOf[i.rand.Intn(len(s.OneOf))], depth+1)
+	case len(s.AnyOf) > 0:
+		return i.value(s.AnyOf[i.rand.Intn(len(s.AnyOf))], depth+1)
+	case len(s.AllOf) > 0:
+		// Objects are merged, for other types the last schema wins
+		var result interface{}
+		for _, part := range s.AllOf {
+			v := i.value(part, depth+1)
+			obj, isObj := v.(map[string]interface{})
+			if merged, ok := result.(map[string]interface{}); ok && isObj {
+				for k, field := range obj {
+					merged[k] = field
+				}
+				continue
+			}
+			result = v
+		}
+		return result
+	}
+
+	switch schemaType(s) {
+	case "object":
+		obj := make(map[string]interface{})
+
+		required := make(map[string]bool)
+		for _, name := range s.Required {
+			required[name] = true
+		}
+
+		for name, prop := range s.Properties {
+			// Required properties are skipped too, otherwise recursive schema never ends
+			if depth >= openAPIMaxDepth || !required[name] && i.rand.Intn(2) == 0 {
+				continue
+			}
+
+			// Read only properties are set by server
+			if p := i.resolveSchema(prop); p != nil && p.ReadOnly && !required[name] {
+				continue
+			}
+
+			obj[name] = i.value(prop, depth+1)
+		}
+
+		return obj
+	case "array":
+		min, max := openAPIIntRange(s.MinItems, s.MaxItems, 1, 3)
+		if depth >= openAPIMaxDepth {
+			min, max = 0, 0
+		}
+
+		items := make([]interface{}, min+i.rand.Intn(max-min+1))
+		for n := range items {
+			items[n] = i.value(s.Items, depth+1)
+		}
+
+		return items
+	case "integer":
+		min, max := openAPIRange(s.Minimum, s.Maximum)
+		lo, hi := int64(math.Ceil(min)), int64(math.Floor(max))
+		if hi <= lo {
+			return lo
+		}
+		return lo + i.rand.Int63n(hi-lo+1)
+	case "number":
+		min, max := openAPIRange(s.Minimum, s.Maximum)
+		return math.Round((min+i.rand.Float64()*(max-min))*100) / 100
+	case "boolean":
+		return i.rand.Intn(2) == 1
+	}
+
+	return i.stringValue(s)
+}
+
+func (i *OpenAPIInput) stringValue(s *openAPISchema) string {
+	switch s.Format {
+	case "date-time":
+		return time.Now().UTC().Add(-time.Duration(i.rand.Intn(30*24)) * time.Hour).Format(time.RFC3339)
+	case "date":
+		return time.Now().UTC().AddDate(0, 0, -i.rand.Intn(365)).Format("2006-01-02")
+	case "uuid":
+		b := make([]byte, 16)
+		i.rand.Read(b)
+		b[6], b[8] = b[6]&0x0f|0x40, b[8]&0x3f|0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	case "email":
+		return i.word(3, 10) + "@example.com"
+	case "uri", "url":
+		return "https://example.com/" + i.word(3, 10)
+	case "hostname":
+		return i.word(3, 10) + ".example.com"
+	case "ipv4":
+		return fmt.Sprintf("192.0.2.%d", 1+i.rand.Intn(254))
+	case "byte":
+		return base64.StdEncoding.EncodeToString([]byte(i.word(3, 10)))
+	}
+
+	min, max := openAPIIntRange(s.MinLength, s.MaxLength, 1, 10)
+	return i.word(min, max)
+}
+
+func (i *OpenAPIInput) word(min, max int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+
+	b := make([]byte, min+i.rand.Intn(max-min+1))
+	for n := range b {
+		b[n] = letters[i.rand.Intn(len(letters))]
+	}
+
+	return string(b)
+}
+
+// schemaType returns type of schema, for OpenAPI 3.1 list of types the first non-null one
+func schemaType(s *openAPISchema) string {
+	switch t := s.Type.(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, v := range t {
+			if name, ok := v.(string); ok && name != "null" {
+				return name
+			}
+		}
+	}
+
+	if len(s.Properties) > 0 {
+		return "object"
+	}
+
+	return "string"
+}
+
+func openAPIRange(min, max *float64) (float64, float64) {
+	lo, hi := 0.0, 1000.0
+	if min != nil {
+		lo = *min
+	}
+	if max != nil {
+		hi = *max
+	}
+	if min != nil && max == nil {
+		hi = lo + 1000
+	}
+	if hi < lo {
+		hi = lo
+	}
+
+	return lo, hi
+}
+
+func openAPIIntRange(min, max *int, defaultMin, defaultMax int) (int, int) {
+	lo, hi := defaultMin, defaultMax
+	if min != nil {
+		lo = *min
+	}
+	if max != nil {
+		hi = *max
+	}
+	if hi < lo {
+		hi = lo
+	}
+
+	return lo, hi
+}
+
+// openAPIValue converts values decoded from YAML, which maps have interface{} keys, to be encoded to JSON
+func openAPIValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[fmt.Sprint(k)] = openAPIValue(value)
+		}
+		return m
+	case []interface{}:
+		for n := range v {
+			v[n] = openAPIValue(v[n])
+		}
+	}
+
+	return v
+}
+
+// openAPIString formats value of parameter or form field
+func openAPIString(v interface{}) string {
+	switch v := openAPIValue(v).(type) {
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (i *OpenAPIInput) Read(data []byte) (int, error) {
+	select {
+	case buf := <-i.data:
+		copy(data, buf)
+		return len(buf), nil
+	case <-i.quit:
+		return 0, io.EOF
+	}
+}
+
+func (i *OpenAPIInput) String() string {
+	return "OpenAPI input: " + i.path
+}
+
+// Close stops generating requests
+func (i *OpenAPIInput) Close() error {
+	i.closeOnce.Do(func() {
+		close(i.quit)
+	})
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOpenAPISpec = `
+openapi: 3.0.3
+info:
+  title: Users
+  version: "1.0"
+servers:
+  - url: http://{host}/api/v1
+    variables:
+      host:
+        default: staging.com
+paths:
+  /users:
+    get:
+      operationId: listUsers
+      x-gor-weight: 3
+      parameters:
+        - name: status
+          in: query
+          required: true
+          schema:
+            type: string
+            enum: [active, blocked]
+        - name: X-Tenant
+          in: header
+          required: true
+          example: acme
+    post:
+      operationId: createUser
+      requestBody:
+        $ref: '#/components/requestBodies/User'
+  /users/{id}:
+    parameters:
+      - $ref: '#/components/parameters/UserID'
+    delete:
+      operationId: deleteUser
+components:
+  parameters:
+    UserID:
+      name: id
+      in: path
+      required: true
+      schema:
+        type: integer
+        minimum: 1
+        maximum: 100
+  requestBodies:
+    User:
+      content:
+        text/plain:
+          schema:
+            type: string
+        application/json:
+          schema:
+            allOf:
+              - $ref: '#/components/schemas/Entity'
+              - type: object
+                required: [name, email, tags]
+                properties:
+                  name:
+                    type: string
+                    minLength: 3
+                    maxLength: 5
+                  email:
+                    type: string
+                    format: email
+                  tags:
+                    type: array
+                    minItems: 2
+                    maxItems: 2
+                    items:
+                      type: string
+                      enum: [a, b]
+  schemas:
+    Entity:
+      type: object
+      required: [created]
+      properties:
+        id:
+          type: integer
+          readOnly: true
+        created:
+          type: string
+          format: date-time
+`
+
+func writeTestOpenAPISpec(t *testing.T) string {
+	f, _ := ioutil.TempFile("", "gor_openapi*.yaml")
+	f.WriteString(testOpenAPISpec)
+	f.Close()
+
+	t.Cleanup(func() {
+		os.Remove(f.Name())
+	})
+
+	return f.Name()
+}
+
+func TestOpenAPIInputGenerate(t *testing.T) {
+	input, err := NewOpenAPIInput(writeTestOpenAPISpec(t), &OpenAPIInputConfig{rps: 1, weights: MultiOption{"DELETE /users/{id}=1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.Close()
+
+	if len(input.ops) != 3 || input.totalWeight != 5 {
+		t.Fatal("Wrong operations:", len(input.ops), input.totalWeight)
+	}
+
+	for _, op := range input.ops {
+		for n := 0; n < 20; n++ {
+			payload, err := input.generate(op)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(payload)))
+			if err != nil {
+				t.Fatal("Should generate valid request:", err, string(payload))
+			}
+
+			if req.Host != "staging.com" || !strings.HasPrefix(req.URL.Path, "/api/v1/users") {
+				t.Fatal("Should use server of spec:", req.Host, req.URL)
+			}
+
+			switch op.OperationID {
+			case "listUsers":
+				if s := req.URL.Query().Get("status"); s != "active" && s != "blocked" {
+					t.Error("Wrong enum value:", s)
+				}
+
+				if req.Header.Get("X-Tenant") != "acme" {
+					t.Error("Should use example value:", req.Header)
+				}
+			case "deleteUser":
+				if req.Method != "DELETE" || !strings.HasPrefix(req.URL.Path, "/api/v1/users/") || strings.Contains(req.URL.Path, "{") {
+					t.Error("Should set path parameter:", req.URL.Path)
+				}
+			case "createUser":
+				if req.Header.Get("Content-Type") != "application/json" {
+					t.Fatal("JSON body should be preferred:", req.Header)
+				}
+
+				var user struct {
+					ID      *int
+					Name    string
+					Email   string
+					Tags    []string
+					Created time.Time
+				}
+				body, _ := ioutil.ReadAll(req.Body)
+				if err := json.Unmarshal(body, &user); err != nil {
+					t.Fatal(err, string(body))
+				}
+
+				if user.ID != nil || len(user.Name) < 3 || len(user.Name) > 5 || !strings.HasSuffix(user.Email, "@example.com") || len(user.Tags) != 2 || user.Created.IsZero() {
+					t.Error("Body should match schema:", string(body))
+				}
+			}
+		}
+	}
+}
+
+func TestOpenAPIInputWeights(t *testing.T) {
+	input, err := NewOpenAPIInput(writeTestOpenAPISpec(t), &OpenAPIInputConfig{rps: 1, weights: MultiOption{"createUser=0", "deleteUser=1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.Close()
+
+	counts := make(map[string]int)
+	for n := 0; n < 4000; n++ {
+		counts[input.operation().OperationID]++
+	}
+
+	if counts["createUser"] != 0 || counts["listUsers"] < 2700 || counts["listUsers"] > 3300 {
+		t.Error("Operations should be chosen by weight:", counts)
+	}
+}
+
+func TestOpenAPIInputRead(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_openapi")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "spec.json")
+	ioutil.WriteFile(path, []byte(`{"openapi": "3.1.0", "paths": {"/ping": {"get": {}}}}`), 0600)
+
+	if _, err := NewOpenAPIInput(path, &OpenAPIInputConfig{rps: 100}); err == nil {
+		t.Error("Should require server URL")
+	}
+
+	input, err := NewOpenAPIInput(path, &OpenAPIInputConfig{rps: 100, server: "http://localhost:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1000)
+	for n := 0; n < 3; n++ {
+		l, err := input.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := "GET /ping HTTP/1.1\r\nHost: localhost:8080\r\n\r\n"; !isRequestPayload(buf[:l]) || string(payloadBody(buf[:l])) != expected {
+			t.Errorf("Wrong payload: %q", buf[:l])
+		}
+	}
+
+	input.Close()
+	if _, err := input.Read(buf); err == nil {
+		t.Error("Should stop after close")
+	}
+}
+
+func TestOpenAPIInputRecursiveSchema(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_openapi")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "spec.json")
+	ioutil.WriteFile(path, []byte(`{"openapi": "3.0.0",
+		"paths": {"/nodes": {"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Node"}}}}}}},
+		"components": {"schemas": {
+			"Node": {"type": "object", "required": ["child", "union"], "properties": {
+				"child": {"$ref": "#/components/schemas/Node"},
+				"union": {"$ref": "#/components/schemas/Union"}
+			}},
+			"Union": {"oneOf": [{"$ref": "#/components/schemas/Union"}, {"$ref": "#/components/schemas/Node"}]}
+		}}}`), 0600)
+
+	input, err := NewOpenAPIInput(path, &OpenAPIInputConfig{rps: 1, server: "http://localhost:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.Close()
+
+	payload, err := input.generate(input.ops[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var node map[string]interface{}
+	body, _ := ioutil.ReadAll(req.Body)
+	if err := json.Unmarshal(body, &node); err != nil || node["child"] == nil {
+		t.Error("Required property should be generated until max depth:", err, string(body))
+	}
+}
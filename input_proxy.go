@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buger/goreplay/proto"
+)
+
+// ProxyInputConfig contains settings of proxy input
+type ProxyInputConfig struct {
+	upstream  string
+	queueSize int
+	// Add client and server addresses to payload header
+	connection bool
+}
+
+// ProxyInput runs reverse proxy in front of application, and captures requests and responses passing through it.
+// Unlike RAWInput it does not need packet capture, so works without root access.
+type ProxyInput struct {
+	// Keep first for 64bit alignment of atomic operations on 32bit machines
+	captured int64
+	dropped  int64
+
+	data     chan []byte
+	address  string
+	config   *ProxyInputConfig
+	upstream *url.URL
+	listener net.Listener
+	server   *http.Server
+	proxy    *httputil.ReverseProxy
+
+	realIPHeader []byte
+	bodyLimit    int
+
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewProxyInput constructor for ProxyInput. Accepts address with port to listen on.
+// Bodies are captured up to bodyLimit bytes, rest of them still proxied.
+func NewProxyInput(address string, config *ProxyInputConfig, realIPHeader string, bodyLimit int) (i *ProxyInput, err error) {
+	i = new(ProxyInput)
+	i.address = address
+	i.config = config
+	i.realIPHeader = []byte(realIPHeader)
+	i.bodyLimit = bodyLimit
+	i.quit = make(chan struct{})
+
+	if config.upstream == "" {
+		return nil, errors.New("--input-proxy requires --input-proxy-upstream")
+	}
+
+	if i.upstream, err = url.Parse(config.upstream); err != nil || i.upstream.Host == "" {
+		return nil, fmt.Errorf("wrong --input-proxy-upstream address: %q", config.upstream)
+	}
+
+	queueSize := config.queueSize
+	if queueSize <= 0 {
+		queueSize = 10000
+	}
+	i.data = make(chan []byte, queueSize)
+
+	i.proxy = httputil.NewSingleHostReverseProxy(i.upstream)
+	i.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		Debug("[INPUT-PROXY] Upstream error:", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	i.listener, err = net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("proxy input listener failure: %v", err)
+	}
+
+	i.server = &http.Server{Handler: http.HandlerFunc(i.handler)}
+
+	go func() {
+		if err := i.server.Serve(i.listener); err != nil && err != http.ErrServerClosed {
+			reportError(i, fmt.Errorf("proxy input serve failure: %v", err))
+		}
+	}()
+
+	return
+}
+
+func (i *ProxyInput) Read(data []byte) (int, error) {
+	var buf []byte
+
+	select {
+	case buf = <-i.data:
+	case <-i.quit:
+		select {
+		case buf = <-i.data:
+		default:
+			return 0, io.EOF
+		}
+	}
+
+	copy(data, buf)
+
+	return len(buf), nil
+}
+
+func (i *ProxyInput) handler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	body := &proxyCaptureReader{ReadCloser: r.Body, limit: i.bodyLimit, end: start}
+	r.Body = body
+
+	rw := &proxyCaptureWriter{ResponseWriter: w, limit: i.bodyLimit, status: http.StatusOK}
+
+	i.proxy.ServeHTTP(rw, r)
+
+	end := time.Now()
+	if rw.start.IsZero() {
+		rw.start = end
+	}
+
+	// Request body may be not read by upstream, e.g. on error
+	requestEnd := body.end
+	if !body.eof {
+		requestEnd = rw.start
+	}
+
+	client, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	server, _ := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if client == nil {
+		client = &net.TCPAddr{}
+	}
+	if server == nil {
+		server = &net.TCPAddr{}
+	}
+
+	id := uuid()
+
+	request := i.requestPayload(r, body.buf.Bytes(), client)
+	header := payloadHeader(RequestPayload, id, start.UnixNano(), -1)
+	if i.config.connection {
+		header = appendPayloadConnection(header, client, server)
+	}
+	i.emit(append(header, request...))
+
+	response := rw.payload(r)
+	header = payloadHeader(ResponsePayload, id, rw.start.UnixNano(), end.UnixNano()-requestEnd.UnixNano())
+	if i.config.connection {
+		header = appendPayloadConnection(header, client, server)
+	}
+	i.emit(append(header, response...))
+}
+
+// requestPayload restores request as it was received
+func (i *ProxyInput) requestPayload(r *http.Request, body []byte, client *net.TCPAddr) []byte {
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	buf, err := httputil.DumpRequest(r, true)
+	if err != nil {
+		Debug("[INPUT-PROXY] Can't dump request:", err)
+	}
+
+	if len(i.realIPHeader) > 0 {
+		buf = proto.SetHeader(buf, i.realIPHeader, []byte(client.IP.String()))
+	}
+
+	return buf
+}
+
+// emit queues payload without blocking, so slow outputs do not delay proxied traffic
+func (i *ProxyInput) emit(payload []byte) {
+	select {
+	case i.data <- payload:
+		atomic.AddInt64(&i.captured, 1)
+	default:
+		atomic.AddInt64(&i.dropped, 1)
+		Debug("[INPUT-PROXY] Dropping payloads because output can't process them fast enough")
+	}
+}
+
+func (i *ProxyInput) gauges() map[string]int64 {
+	return map[string]int64{
+		"captured_payloads": atomic.LoadInt64(&i.captured),
+		"dropped_payloads":  atomic.LoadInt64(&i.dropped),
+		"queue_length":      int64(len(i.data)),
+	}
+}
+
+func (i *ProxyInput) String() string {
+	return "Proxy input: " + i.address + " to " + i.upstream.String()
+}
+
+// Close stops proxy, payloads already captured still can be read
+func (i *ProxyInput) Close() error {
+	i.closeOnce.Do(func() {
+		i.server.Close()
+		close(i.quit)
+	})
+
+	return nil
+}
+
+// proxyCaptureReader copies request body while upstream reads it
+type proxyCaptureReader struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int
+	eof   bool
+	end   time.Time
+}
+
+func (c *proxyCaptureReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if free := c.limit - c.buf.Len(); free > 0 {
+		if n < free {
+			free = n
+		}
+		c.buf.Write(p[:free])
+	}
+
+	c.end = time.Now()
+	if err == io.EOF {
+		c.eof = true
+	}
+
+	return n, err
+}
+
+// proxyCaptureWriter copies response while it is written to the client
+type proxyCaptureWriter struct {
+	http.ResponseWriter
+	header http.Header
+	status int
+	buf    bytes.Buffer
+	limit  int
+	start  time.Time
+}
+
+func (c *proxyCaptureWriter) WriteHeader(status int) {
+	if c.header == nil {
+		c.start = time.Now()
+		c.status = status
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *proxyCaptureWriter) Write(p []byte) (int, error) {
+	if c.header == nil {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if free := c.limit - c.buf.Len(); free > 0 {
+		if len(p) < free {
+			free = len(p)
+		}
+		c.buf.Write(p[:free])
+	}
+
+	return c.ResponseWriter.Write(p)
+}
+
+// Unwrap gives access to Flusher and Hijacker of the original writer, used for streaming and upgraded connections
+func (c *proxyCaptureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// payload builds response as it was sent to the client. Bodies are stored with Content-Length, instead of chunks.
+func (c *proxyCaptureWriter) payload(r *http.Request) []byte {
+	header := c.header
+	if header == nil {
+		header = c.ResponseWriter.Header().Clone()
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %d %s\r\n", r.Proto, c.status, http.StatusText(c.status))
+
+	bodyAllowed := r.Method != http.MethodHead && c.status >= 200 && c.status != http.StatusNoContent && c.status != http.StatusNotModified
+	if header.Get("Content-Length") == "" && bodyAllowed && c.status != http.StatusSwitchingProtocols {
+		header.Set("Content-Length", strconv.Itoa(c.buf.Len()))
+	}
+
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(c.buf.Bytes())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyInput(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		time.Sleep(50 * time.Millisecond)
+
+		// Streamed response, without Content-Length
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Write([]byte("echo:"))
+		w.(http.Flusher).Flush()
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	input, err := NewProxyInput("127.0.0.1:0", &ProxyInputConfig{upstream: upstream.URL, connection: true}, "X-Real-IP", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	address := "http://" + input.listener.Addr().String()
+
+	resp, err := http.Post(address+"/users?page=1", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "echo:hello" || resp.Header.Get("X-Path") != "/users" {
+		t.Fatal("Should proxy request to upstream:", string(body), resp.Header)
+	}
+
+	buf := make([]byte, 2000)
+	n, _ := input.Read(buf)
+	request := append([]byte{}, buf[:n]...)
+	n, _ = input.Read(buf)
+	response := append([]byte{}, buf[:n]...)
+
+	if !isRequestPayload(request) || response[0] != ResponsePayload || !bytes.Equal(payloadMeta(request)[1], payloadMeta(response)[1]) {
+		t.Fatalf("Should emit request and response with the same ID: %q %q", request, response)
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(payloadBody(request))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqBody, _ := ioutil.ReadAll(req.Body)
+	if req.Method != "POST" || req.RequestURI != "/users?page=1" || string(reqBody) != "hello" || req.Header.Get("X-Real-IP") != "127.0.0.1" || req.Header.Get("X-Forwarded-For") != "" {
+		t.Errorf("Request should be captured as received: %q", payloadBody(request))
+	}
+
+	if client, server, ok := payloadConnection(request); !ok || client.IP.String() != "127.0.0.1" || server.String() != input.listener.Addr().String() {
+		t.Error("Should store connection addresses:", client, server)
+	}
+
+	if latency := payloadLatency(response); latency < int64(50*time.Millisecond) || latency > int64(time.Second) {
+		t.Error("Wrong latency:", latency)
+	}
+
+	if res := string(payloadBody(response)); !strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n") || !strings.Contains(res, "Content-Length: 10\r\n") || !strings.HasSuffix(res, "\r\n\r\necho:hello") {
+		t.Errorf("Wrong response: %q", res)
+	}
+}
+
+func TestProxyInputUpstreamDown(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	input, err := NewProxyInput("127.0.0.1:0", &ProxyInputConfig{upstream: upstream.URL}, "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + input.listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Error("Should respond with 502:", resp.Status)
+	}
+
+	input.Close()
+
+	buf := make([]byte, 1000)
+	input.Read(buf)
+	if n, _ := input.Read(buf); !strings.HasPrefix(string(payloadBody(buf[:n])), "HTTP/1.1 502 Bad Gateway\r\n") {
+		t.Errorf("Should capture response sent to client: %q", buf[:n])
+	}
+
+	// Connection is added only if outputs need it
+	if _, _, ok := payloadConnection(buf); ok {
+		t.Error("Should not store connection addresses by default")
+	}
+
+	if _, err := NewProxyInput("127.0.0.1:0", &ProxyInputConfig{}, "", 1000); err == nil {
+		t.Error("Should require upstream")
+	}
+}
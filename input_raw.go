@@ -1,14 +1,28 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/buger/goreplay/proto"
+	"github.com/buger/goreplay/protocol"
 	raw "github.com/buger/goreplay/raw_socket_listener"
 )
 
+// RAWInputConfig contains advanced settings of raw input
+type RAWInputConfig struct {
+	overrideSnapLen bool
+	immediateMode   bool
+	tlsKeyLog       string
+	// Protocol of all ports, or of single port in `port=protocol` format
+	protocol MultiOption
+	tcpGap   time.Duration
+	// Add client and server addresses to payload header
+	connection bool
+}
+
 // RAWInput used for intercepting traffic for given address
 type RAWInput struct {
 	data          chan *raw.TCPMessage
@@ -22,6 +36,9 @@ type RAWInput struct {
 	bpfFilter     string
 	timestampType string
 	bufferSize    int
+	config        *RAWInputConfig
+	// Protocol written to payloads, empty for HTTP
+	protocol string
 }
 
 // Available engines for intercepting traffic
@@ -32,7 +49,7 @@ const (
 )
 
 // NewRAWInput constructor for RAWInput. Accepts address with port as argument.
-func NewRAWInput(address string, engine int, trackResponse bool, expire time.Duration, realIPHeader string, bpfFilter string, timestampType string, bufferSize int) (i *RAWInput) {
+func NewRAWInput(address string, engine int, trackResponse bool, expire time.Duration, realIPHeader string, bpfFilter string, timestampType string, bufferSize int, config *RAWInputConfig) (i *RAWInput, err error) {
 	i = new(RAWInput)
 	i.data = make(chan *raw.TCPMessage)
 	i.address = address
@@ -44,9 +61,34 @@ func NewRAWInput(address string, engine int, trackResponse bool, expire time.Dur
 	i.trackResponse = trackResponse
 	i.timestampType = timestampType
 	i.bufferSize = bufferSize
+	i.config = config
+
+	_, port, _ := net.SplitHostPort(address)
+
+	name := rawProtocol(config.protocol, port)
+	tcpConfig, err := rawTCPConfig(name, config.tcpGap)
+	if err != nil {
+		return nil, fmt.Errorf("input-raw: %v", err)
+	}
 
-	i.listen(address)
-	i.listener.IsReady()
+	if tcpConfig != nil {
+		i.protocol = "tcp"
+		if tcpConfig.Parser != nil {
+			i.protocol = tcpConfig.Parser.Name()
+		}
+
+		// Only HTTP has headers
+		i.realIPHeader = nil
+	}
+
+	if err = i.listen(address, tcpConfig); err != nil {
+		return nil, err
+	}
+
+	if err = i.listener.Ready(); err != nil {
+		i.Close()
+		return nil, err
+	}
 
 	return
 }
@@ -66,22 +108,78 @@ func (i *RAWInput) Read(data []byte) (int, error) {
 		header = payloadHeader(ResponsePayload, msg.UUID(), msg.Start.UnixNano(), msg.End.UnixNano()-msg.AssocMessage.End.UnixNano())
 	}
 
+	// Used to restore TCP connections, e.g. by --output-pcap
+	if i.config.connection {
+		client, server := msg.Connection()
+		header = appendPayloadConnection(header, client, server)
+
+		if msg.ConnectionID != 0 {
+			header = appendPayloadConnectionID(header, msg.ConnectionID)
+		}
+	}
+	if i.protocol != "" {
+		header = appendPayloadProtocol(header, i.protocol)
+	}
+
 	copy(data[0:len(header)], header)
 	copy(data[len(header):], buf)
 
 	return len(buf) + len(header), nil
 }
 
-func (i *RAWInput) listen(address string) {
+// rawProtocol returns protocol of the port from --input-raw-protocol options.
+// Option `name` sets protocol of all ports, and `port=name` of single port, which takes precedence.
+func rawProtocol(options []string, port string) string {
+	name := "http"
+
+	for _, o := range options {
+		if i := strings.IndexByte(o, '='); i == -1 {
+			name = o
+		}
+	}
+
+	for _, o := range options {
+		if i := strings.IndexByte(o, '='); i != -1 && o[:i] == port {
+			name = o[i+1:]
+		}
+	}
+
+	return name
+}
+
+// rawTCPConfig returns config of listener's protocol agnostic mode, where streams are split by protocol parser,
+// or by idle gap for `tcp`. HTTP returns nil: it is always captured by listener's own engine, which also handles
+// HTTP/2, TLS and `Expect: 100-continue`, so HTTP parser is used only by filters and outputs.
+func rawTCPConfig(name string, gap time.Duration) (*raw.TCPConfig, error) {
+	switch name {
+	case "http":
+		return nil, nil
+	case "tcp":
+		if gap <= 0 {
+			gap = 100 * time.Millisecond
+		}
+
+		return &raw.TCPConfig{Gap: gap}, nil
+	}
+
+	parser, err := protocol.New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &raw.TCPConfig{Parser: parser, Gap: gap}, nil
+}
+
+func (i *RAWInput) listen(address string, tcpConfig *raw.TCPConfig) error {
 	Debug("Listening for traffic on: " + address)
 
 	host, port, err := net.SplitHostPort(address)
 
 	if err != nil {
-		log.Fatal("input-raw: error while parsing address", err)
+		return fmt.Errorf("input-raw: error while parsing address: %v", err)
 	}
 
-	i.listener = raw.NewListener(host, port, i.engine, i.trackResponse, i.expire, i.bpfFilter, i.timestampType, i.bufferSize, Settings.inputRAWOverrideSnapLen, Settings.inputRAWImmediateMode)
+	i.listener = raw.NewListener(host, port, i.engine, i.trackResponse, i.expire, i.bpfFilter, i.timestampType, i.bufferSize, i.config.overrideSnapLen, i.config.immediateMode, i.config.tlsKeyLog, tcpConfig)
 
 	ch := i.listener.Receiver()
 
@@ -99,6 +197,25 @@ func (i *RAWInput) listen(address string) {
 			i.data <- m
 		}
 	}()
+
+	// Capture device can be lost after start
+	go func() {
+		select {
+		case err := <-i.listener.Errors():
+			reportError(i, err)
+		case <-i.quit:
+		}
+	}()
+
+	return nil
+}
+
+func (i *RAWInput) gauges() map[string]int64 {
+	return map[string]int64{
+		"pending_messages":     int64(i.listener.PendingMessages()),
+		"queue_length":         int64(i.listener.QueuedMessages()),
+		"packets_queue_length": int64(i.listener.QueuedPackets()),
+	}
 }
 
 func (i *RAWInput) String() string {
@@ -44,7 +44,10 @@ func TestRAWInputIPv4(t *testing.T) {
 
 	var respCounter, reqCounter int64
 
-	input := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "X-Real-IP", "", "", 0)
+	input, err := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "X-Real-IP", "", "", 0, &RAWInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer input.Close()
 
 	output := NewTestOutput(func(data []byte) {
@@ -106,7 +109,10 @@ func TestRAWInputNoKeepAlive(t *testing.T) {
 
 	originAddr := listener.Addr().String()
 
-	input := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0)
+	input, err := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0, &RAWInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer input.Close()
 
 	output := NewTestOutput(func(data []byte) {
@@ -152,7 +158,10 @@ func TestRAWInputIPv6(t *testing.T) {
 
 	var respCounter, reqCounter int64
 
-	input := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0)
+	input, err := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0, &RAWInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer input.Close()
 
 	output := NewTestOutput(func(data []byte) {
@@ -203,7 +212,10 @@ func TestInputRAW100Expect(t *testing.T) {
 
 	originAddr := strings.Replace(origin.Listener.Addr().String(), "[::]", "127.0.0.1", -1)
 
-	input := NewRAWInput(originAddr, EnginePcap, true, time.Second, "", "", "", 0)
+	input, err := NewRAWInput(originAddr, EnginePcap, true, time.Second, "", "", "", 0, &RAWInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer input.Close()
 
 	// We will use it to get content of raw HTTP request
@@ -242,7 +254,7 @@ func TestInputRAW100Expect(t *testing.T) {
 	// Origin + Response/Request Test Output + Request Http Output
 	wg.Add(4)
 	curl := exec.Command("curl", "http://"+originAddr, "--data-binary", "@COMM-LICENSE")
-	err := curl.Run()
+	err = curl.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -266,7 +278,10 @@ func TestInputRAWChunkedEncoding(t *testing.T) {
 	}))
 
 	originAddr := strings.Replace(origin.Listener.Addr().String(), "[::]", "127.0.0.1", -1)
-	input := NewRAWInput(originAddr, EnginePcap, true, time.Second, "", "", "", 0)
+	input, err := NewRAWInput(originAddr, EnginePcap, true, time.Second, "", "", "", 0, &RAWInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer input.Close()
 
 	replay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -292,7 +307,7 @@ func TestInputRAWChunkedEncoding(t *testing.T) {
 	wg.Add(2)
 
 	curl := exec.Command("curl", "http://"+originAddr, "--header", "Transfer-Encoding: chunked", "--header", "Expect:", "--data-binary", "@README.md")
-	err := curl.Run()
+	err = curl.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -330,7 +345,10 @@ func TestInputRAWLargePayload(t *testing.T) {
 	}))
 	originAddr := strings.Replace(origin.Listener.Addr().String(), "[::]", "127.0.0.1", -1)
 
-	input := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0)
+	input, err := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0, &RAWInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer input.Close()
 
 	replay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -381,7 +399,10 @@ func BenchmarkRAWInput(b *testing.B) {
 	defer origin.Close()
 	upstreamAddr := strings.Replace(upstream.Listener.Addr().String(), "[::]", "127.0.0.1", -1)
 
-	input := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0)
+	input, err := NewRAWInput(originAddr, EnginePcap, true, testRawExpire, "", "", "", 0, &RAWInputConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
 	defer input.Close()
 
 	output := NewTestOutput(func(data []byte) {
@@ -430,3 +451,39 @@ func BenchmarkRAWInput(b *testing.B) {
 
 	close(quit)
 }
+
+func TestRAWInputProtocol(t *testing.T) {
+	cases := []struct {
+		options  []string
+		port     string
+		protocol string
+	}{
+		{nil, "80", "http"},
+		{[]string{"redis"}, "6379", "redis"},
+		{[]string{"6379=redis"}, "80", "http"},
+		{[]string{"6379=redis", "line"}, "6379", "redis"},
+		{[]string{"6379=redis", "line"}, "7000", "line"},
+	}
+
+	for _, c := range cases {
+		if name := rawProtocol(c.options, c.port); name != c.protocol {
+			t.Errorf("%v %s: expected %s, got %s", c.options, c.port, c.protocol, name)
+		}
+	}
+
+	if config, err := rawTCPConfig("http", 0); config != nil || err != nil {
+		t.Error("HTTP should use listener engine", err)
+	}
+
+	if config, err := rawTCPConfig("tcp", time.Second); err != nil || config.Parser != nil || config.Gap != time.Second {
+		t.Error("Gap framing should have no parser", err)
+	}
+
+	if config, err := rawTCPConfig("length:2", 0); err != nil || config.Parser.Name() != "length:2" {
+		t.Error("Protocol should be used as parser", err)
+	}
+
+	if _, err := rawTCPConfig("unknown", 0); err == nil {
+		t.Error("Unknown protocol should fail")
+	}
+}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestsInputConfig holds options of RequestsInput
+type RequestsInputConfig struct {
+	// Variables in `name=value` format, override variables defined in files
+	vars MultiOption
+}
+
+// RequestsInput replays hand-crafted requests from files of curl commands, or of request blocks separated by `###`,
+// used by `.http` and `.rest` files of editor REST clients.
+//
+// Files are converted when opened, so dynamic variables, like `{{$uuid}}`, get new values on each loop.
+// Requests have no timing, so they are emitted at once, or at the rate set by `|N` limiter.
+type RequestsInput struct {
+	*FileInput
+	vars map[string]string
+}
+
+var (
+	// `{{name}}` or `{{$uuid}}`
+	requestVarRe = regexp.MustCompile(`\{\{\s*(\$?[\w.-]+)\s*\}\}`)
+	// `@name = value` line
+	requestVarDefRe = regexp.MustCompile(`^@([\w.-]+)\s*=\s*(.*?)\s*$`)
+)
+
+// fileRequest is request parsed from requests file
+type fileRequest struct {
+	method  string
+	url     string
+	version string
+	headers []JSONHeader
+	body    []byte
+}
+
+// NewRequestsInput constructor for RequestsInput, accepts file path or pattern
+func NewRequestsInput(path string, config *RequestsInputConfig, fileConfig *FileInputConfig) (*RequestsInput, error) {
+	i := &RequestsInput{vars: make(map[string]string)}
+
+	for _, v := range config.vars {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("variable should be in name=value format, got %q", v)
+		}
+		i.vars[strings.TrimSpace(kv[0])] = kv[1]
+	}
+
+	list := func() ([]string, error) {
+		return filepath.Glob(path)
+	}
+
+	// Fail early on wrong request, instead of skipping it on each loop
+	matches, _ := list()
+	for _, m := range matches {
+		if _, err := i.readRequests(m); err != nil {
+			return nil, err
+		}
+	}
+
+	i.FileInput = newFileInput(path, fileConfig, list, i.openRequests)
+
+	return i, nil
+}
+
+func (i *RequestsInput) openRequests(path string) (io.ReadCloser, error) {
+	requests, err := i.readRequests(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests of the same file keep their order
+	now := time.Now().UnixNano()
+
+	payloads := make([][]byte, len(requests))
+	for n, r := range requests {
+		payloads[n] = append(payloadHeader(RequestPayload, uuid(), now, -1), r...)
+	}
+
+	return newCaptureStream(payloads), nil
+}
+
+// readRequests parses file of curl commands or request blocks, and converts requests to HTTP/1.1
+func (i *RequestsInput) readRequests(path string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := i.expandVars(strings.Replace(string(data), "\r\n", "\n", -1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var requests []*fileRequest
+	if isCurlFile(text) {
+		requests, err = parseCurlFile(text, filepath.Dir(path))
+	} else {
+		requests, err = parseHTTPFile(text, filepath.Dir(path))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	payloads := make([][]byte, len(requests))
+	for n, r := range requests {
+		if payloads[n], err = buildRequestPayload(r.method, r.url, r.version, r.headers, r.body); err != nil {
+			return nil, fmt.Errorf("%s: request %d: %v", path, n+1, err)
+		}
+	}
+
+	return payloads, nil
+}
+
+// expandVars removes `@name = value` definitions, and replaces `{{name}}` with values of variables from options,
+// from file, or from environment. Definitions can use variables defined before them.
+func (i *RequestsInput) expandVars(text string) (string, error) {
+	var err error
+	vars := make(map[string]string)
+
+	expand := func(s string) string {
+		return requestVarRe.ReplaceAllStringFunc(s, func(m string) string {
+			name := requestVarRe.FindStringSubmatch(m)[1]
+
+			switch name {
+			case "$uuid":
+				return string(uuid())
+			case "$timestamp":
+				return strconv.FormatInt(time.Now().Unix(), 10)
+			}
+
+			if v, ok := i.vars[name]; ok {
+				return v
+			}
+			if v, ok := vars[name]; ok {
+				return v
+			}
+			if v, ok := os.LookupEnv(name); ok {
+				return v
+			}
+
+			if err == nil {
+				err = fmt.Errorf("unknown variable %s", m)
+			}
+			return m
+		})
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if m := requestVarDefRe.FindStringSubmatch(line); m != nil {
+			vars[m[1]] = expand(m[2])
+			continue
+		}
+
+		lines = append(lines, expand(line))
+	}
+
+	return strings.Join(lines, "\n"), err
+}
+
+// isCurlFile checks if the first command of file is curl
+func isCurlFile(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		return line == "curl" || strings.HasPrefix(line, "curl ")
+	}
+
+	return false
+}
+
+// parseHTTPFile parses request blocks separated by `###` lines. Each block has request line, like `POST /path HTTP/1.1`
+// or just URL for GET requests, headers, and body after empty line. Body can be read from file with `< ./path` line.
+// Lines starting with `#` or `//` before body are comments.
+func parseHTTPFile(text, dir string) (requests []*fileRequest, err error) {
+	var block []string
+
+	parseBlock := func() error {
+		r, err := parseHTTPBlock(block, dir)
+		if err != nil {
+			return fmt.Errorf("request %d: %v", len(requests)+1, err)
+		}
+
+		if r != nil {
+			requests = append(requests, r)
+		}
+		block = nil
+
+		return nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "###") {
+			if err := parseBlock(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		block = append(block, line)
+	}
+
+	if err := parseBlock(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+func isHTTPComment(line string) bool {
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//")
+}
+
+// parseHTTPBlock parses single request block, returns nil if block has only comments
+func parseHTTPBlock(lines []string, dir string) (*fileRequest, error) {
+	i := 0
+	for ; i < len(lines); i++ {
+		if line := strings.TrimSpace(lines[i]); line != "" && !isHTTPComment(line) {
+			break
+		}
+	}
+
+	if i == len(lines) {
+		return nil, nil
+	}
+
+	r := new(fileRequest)
+
+	switch fields := strings.Fields(lines[i]); len(fields) {
+	case 1:
+		r.method, r.url = "GET", fields[0]
+	case 2:
+		r.method, r.url = fields[0], fields[1]
+	case 3:
+		r.method, r.url, r.version = fields[0], fields[1], fields[2]
+	default:
+		return nil, fmt.Errorf("wrong request line: %q", lines[i])
+	}
+
+	for i++; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			i++
+			break
+		}
+
+		if isHTTPComment(line) {
+			continue
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 1 {
+			return nil, fmt.Errorf("malformed header: %q", line)
+		}
+
+		r.headers = append(r.headers, JSONHeader{strings.TrimSpace(line[:colon]), strings.TrimSpace(line[colon+1:])})
+	}
+
+	body := lines[i:]
+	for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+		body = body[:len(body)-1]
+	}
+
+	if len(body) == 1 && strings.HasPrefix(body[0], "< ") {
+		data, err := ioutil.ReadFile(requestFilePath(dir, strings.TrimSpace(body[0][2:])))
+		if err != nil {
+			return nil, err
+		}
+		r.body = data
+	} else {
+		r.body = []byte(strings.Join(body, "\n"))
+	}
+
+	return r, nil
+}
+
+// requestFilePath resolves path relative to the requests file
+func requestFilePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// parseCurlFile parses curl commands, written as in shell
+func parseCurlFile(text, dir string) ([]*fileRequest, error) {
+	commands, err := splitCommands(text)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]*fileRequest, 0, len(commands))
+	for _, args := range commands {
+		r, err := parseCurlCommand(args, dir)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %v", len(requests)+1, err)
+		}
+
+		requests = append(requests, r)
+	}
+
+	return requests, nil
+}
+
+// splitCommands splits shell script into commands and their arguments.
+// It supports quotes, including `$'...'` used by browsers in "Copy as cURL", escapes, line continuations and comments.
+func splitCommands(text string) (commands [][]string, err error) {
+	var args []string
+	var word bytes.Buffer
+	inWord := false
+
+	endWord := func() {
+		if inWord {
+			args = append(args, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	endCommand := func() {
+		endWord()
+		if len(args) > 0 {
+			commands = append(commands, args)
+			args = nil
+		}
+	}
+
+	unterminated := errors.New("unterminated quote")
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case c == '\\':
+			if i++; i < len(text) && text[i] != '\n' {
+				word.WriteByte(text[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(text[i+1:], '\'')
+			if end == -1 {
+				return nil, unterminated
+			}
+
+			word.WriteString(text[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '$' && i+1 < len(text) && text[i+1] == '\'':
+			for i += 2; i < len(text) && text[i] != '\''; i++ {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+					switch text[i] {
+					case 'n':
+						word.WriteByte('\n')
+					case 'r':
+						word.WriteByte('\r')
+					case 't':
+						word.WriteByte('\t')
+					default:
+						word.WriteByte(text[i])
+					}
+					continue
+				}
+
+				word.WriteByte(text[i])
+			}
+
+			if i >= len(text) {
+				return nil, unterminated
+			}
+			inWord = true
+		case c == '"':
+			for i++; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' && i+1 < len(text) && strings.IndexByte("\"\\$`\n", text[i+1]) != -1 {
+					if i++; text[i] == '\n' {
+						continue
+					}
+				}
+
+				word.WriteByte(text[i])
+			}
+
+			if i >= len(text) {
+				return nil, unterminated
+			}
+			inWord = true
+		case c == '#' && !inWord:
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+			endCommand()
+		case c == '\n' || c == ';':
+			endCommand()
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	endCommand()
+
+	return commands, nil
+}
+
+// Curl options which have value, but not used for request
+var curlIgnoredValueOptions = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true, "--retry": true,
+	"-w": true, "--write-out": true, "-x": true, "--proxy": true, "--cacert": true, "-E": true, "--cert": true,
+	"--key": true, "--resolve": true, "--connect-to": true, "-c": true, "--cookie-jar": true, "-r": true, "--range": true,
+}
+
+// Short curl options, which value can be written without space, like `-XPOST`
+var curlShortValueOptions = "XHdubAeoFmwxrEc"
+
+// parseCurlCommand converts curl arguments into request. Only options which change request are supported.
+func parseCurlCommand(args []string, dir string) (*fileRequest, error) {
+	if args[0] != "curl" {
+		return nil, fmt.Errorf("expected curl command, got %q", args[0])
+	}
+
+	r := new(fileRequest)
+
+	var data []string
+	var get, head, hasContentType bool
+
+	for i := 1; i < len(args); i++ {
+		name, value := args[i], ""
+
+		if len(name) > 2 && name[0] == '-' && name[1] != '-' && strings.IndexByte(curlShortValueOptions, name[1]) != -1 {
+			name, value = name[:2], name[2:]
+		} else if strings.HasPrefix(name, "-") && name != "-" && (curlOptionHasValue(name) || curlIgnoredValueOptions[name]) {
+			if i++; i == len(args) {
+				return nil, fmt.Errorf("option %s requires value", name)
+			}
+			value = args[i]
+		}
+
+		switch name {
+		case "-X", "--request":
+			r.method = value
+		case "-H", "--header":
+			colon := strings.IndexByte(value, ':')
+			if colon < 1 {
+				return nil, fmt.Errorf("malformed header: %q", value)
+			}
+
+			h := JSONHeader{strings.TrimSpace(value[:colon]), strings.TrimSpace(value[colon+1:])}
+			hasContentType = hasContentType || strings.EqualFold(h.Name, "Content-Type")
+			r.headers = append(r.headers, h)
+		case "-d", "--data", "--data-ascii", "--data-binary":
+			if strings.HasPrefix(value, "@") {
+				content, err := ioutil.ReadFile(requestFilePath(dir, value[1:]))
+				if err != nil {
+					return nil, err
+				}
+
+				value = string(content)
+				// Only binary data keeps new lines of the file
+				if name != "--data-binary" {
+					value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+				}
+			}
+			data = append(data, value)
+		case "--data-raw":
+			data = append(data, value)
+		case "--data-urlencode":
+			if eq := strings.IndexByte(value, '='); eq != -1 {
+				value = value[:eq+1] + url.QueryEscape(value[eq+1:])
+				value = strings.TrimPrefix(value, "=")
+			} else {
+				value = url.QueryEscape(value)
+			}
+			data = append(data, value)
+		case "--json":
+			data = append(data, value)
+			r.headers = append(r.headers, JSONHeader{"Content-Type", "application/json"}, JSONHeader{"Accept", "application/json"})
+			hasContentType = true
+		case "-u", "--user":
+			r.headers = append(r.headers, JSONHeader{"Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(value))})
+		case "-A", "--user-agent":
+			r.headers = append(r.headers, JSONHeader{"User-Agent", value})
+		case "-e", "--referer":
+			r.headers = append(r.headers, JSONHeader{"Referer", value})
+		case "-b", "--cookie":
+			// Without `=` value is name of cookies file
+			if strings.Contains(value, "=") {
+				r.headers = append(r.headers, JSONHeader{"Cookie", value})
+			}
+		case "--url":
+			r.url = value
+		case "-G", "--get":
+			get = true
+		case "-I", "--head":
+			head = true
+		case "-F", "--form":
+			return nil, errors.New("multipart forms are not supported")
+		default:
+			// Other options, like `-s` or `--compressed`, do not change request
+			if !strings.HasPrefix(name, "-") {
+				r.url = name
+			}
+		}
+	}
+
+	if r.url == "" {
+		return nil, errors.New("curl command without URL")
+	}
+
+	if !strings.Contains(r.url, "://") {
+		r.url = "http://" + r.url
+	}
+
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.User != nil {
+		// Credentials are decoded, User.String() would keep them URL encoded
+		password, _ := u.User.Password()
+		r.headers = append(r.headers, JSONHeader{"Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))})
+	}
+
+	body := strings.Join(data, "&")
+
+	switch {
+	case get && body != "":
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += body
+		body = ""
+	case body != "" && !hasContentType:
+		r.headers = append(r.headers, JSONHeader{"Content-Type", "application/x-www-form-urlencoded"})
+	}
+	r.url = u.String()
+
+	if r.method == "" {
+		switch {
+		case head:
+			r.method = "HEAD"
+		case body != "":
+			r.method = "POST"
+		default:
+			r.method = "GET"
+		}
+	}
+
+	r.body = []byte(body)
+
+	return r, nil
+}
+
+func curlOptionHasValue(name string) bool {
+	switch name {
+	case "-X", "--request", "-H", "--header", "-d", "--data", "--data-ascii", "--data-binary", "--data-raw", "--data-urlencode",
+		"--json", "-u", "--user", "-A", "--user-agent", "-e", "--referer", "-b", "--cookie", "--url", "-F", "--form":
+		return true
+	}
+
+	return false
+}
+
+// Headers computed from the body, or not valid for HTTP/1.1
+var requestSkipHeaders = []string{"Content-Length", "Transfer-Encoding", "Connection", "Keep-Alive"}
+
+// buildRequestPayload builds HTTP/1.1 request, ready to be replayed. URL should be absolute, or relative with Host header.
+// HTTP/2 requests, like `h2` or `HTTP/2.0` version, are converted to HTTP/1.1, and Content-Length computed from the body.
+func buildRequestPayload(method, rawURL, version string, headers []JSONHeader, body []byte) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	hasHost := false
+	for _, h := range headers {
+		hasHost = hasHost || strings.EqualFold(h.Name, "Host")
+	}
+
+	if method == "" || (u.Host == "" && !hasHost) {
+		return nil, errors.New("request should have method, and absolute URL or Host header")
+	}
+
+	if !strings.HasPrefix(version, "HTTP/1.") {
+		version = "HTTP/1.1"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(method + " " + u.RequestURI() + " " + version + "\r\n")
+
+	if !hasHost {
+		buf.WriteString("Host: " + u.Host + "\r\n")
+	}
+
+	for _, h := range headers {
+		// HTTP/2 pseudo headers, like `:authority`
+		if strings.HasPrefix(h.Name, ":") || skipRequestHeader(h.Name) {
+			continue
+		}
+
+		buf.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+
+	if len(body) > 0 {
+		buf.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	return buf.Bytes(), nil
+}
+
+func skipRequestHeader(name string) bool {
+	for _, h := range requestSkipHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (i *RequestsInput) String() string {
+	return "Requests input: " + i.path
+}
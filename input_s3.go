@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// S3Input reads objects uploaded by S3Output, or any other files in --input-file format.
+// All objects with given key prefix are streamed and merged by payload timestamps, like multiple files of FileInput.
+type S3Input struct {
+	*FileInput
+	bucket string
+	prefix string
+	client *s3Client
+
+	// Object key -> size, found by listing
+	sizes map[string]int64
+}
+
+// NewS3Input constructor for S3Input, accepts `bucket/prefix` path
+func NewS3Input(path string, s3config *S3Config, config *FileInputConfig) (*S3Input, error) {
+	bucket, prefix, err := parseS3Path(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &S3Input{
+		bucket: bucket,
+		prefix: prefix,
+		client: newS3Client(s3config),
+	}
+
+	// Fail early on wrong bucket or credentials
+	if _, err := i.listObjects(); err != nil {
+		return nil, err
+	}
+
+	i.FileInput = newFileInput(prefix, config, i.listObjects, i.openObject)
+
+	return i, nil
+}
+
+func (i *S3Input) listObjects() ([]string, error) {
+	objects, err := i.client.ListObjects(i.bucket, i.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(objects))
+	i.sizes = make(map[string]int64, len(objects))
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+		i.sizes[o.Key] = o.Size
+	}
+
+	// Chunks of the same file ordered by index, as they are written
+	sort.Sort(sortByFileIndex(keys))
+
+	return keys, nil
+}
+
+// openObject returns seekable reader of object, so input can close it to limit open files,
+// and continue from the same position later
+func (i *S3Input) openObject(key string) (io.ReadCloser, error) {
+	size, ok := i.sizes[key]
+	if !ok {
+		size = -1
+	}
+
+	return &s3ObjectReader{client: i.client, bucket: i.bucket, key: key, size: size}, nil
+}
+
+// s3ObjectReader downloads object lazily. Seeking closes current download,
+// and the next Read requests the rest of object from the new position with Range header.
+type s3ObjectReader struct {
+	client      *s3Client
+	bucket, key string
+	// Size of object, or -1 if not known
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3ObjectReader) Read(p []byte) (n int, err error) {
+	if r.body == nil {
+		// S3 rejects Range requests starting after the end of object
+		if r.size >= 0 && r.offset >= r.size {
+			return 0, io.EOF
+		}
+
+		if r.body, err = r.client.GetObject(r.bucket, r.key, r.offset); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = r.body.Read(p)
+	r.offset += int64(n)
+
+	return
+}
+
+func (r *s3ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		if r.size < 0 {
+			return 0, errors.New("s3: size of object is unknown")
+		}
+		offset += r.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+
+	return offset, nil
+}
+
+func (r *s3ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+
+	return err
+}
+
+func (i *S3Input) String() string {
+	return "S3 input: " + i.bucket + "/" + i.prefix
+}
@@ -26,13 +26,15 @@ type TCPInputConfig struct {
 }
 
 // NewTCPInput constructor for TCPInput, accepts address with port
-func NewTCPInput(address string, config *TCPInputConfig) (i *TCPInput) {
+func NewTCPInput(address string, config *TCPInputConfig) (i *TCPInput, err error) {
 	i = new(TCPInput)
 	i.data = make(chan []byte, 1000)
 	i.address = address
 	i.config = config
 
-	i.listen(address)
+	if err = i.listen(address); err != nil {
+		return nil, err
+	}
 
 	return
 }
@@ -44,23 +46,23 @@ func (i *TCPInput) Read(data []byte) (int, error) {
 	return len(buf), nil
 }
 
-func (i *TCPInput) listen(address string) {
+func (i *TCPInput) listen(address string) error {
 	if i.config.secure {
 		cer, err := tls.LoadX509KeyPair(i.config.certificatePath, i.config.keyPath)
 		if err != nil {
-			log.Fatal("Error while loading --input-file certificate:", err)
+			return fmt.Errorf("Error while loading --input-tcp certificate: %v", err)
 		}
 
 		config := &tls.Config{Certificates: []tls.Certificate{cer}}
 		listener, err := tls.Listen("tcp", address, config)
 		if err != nil {
-			log.Fatal("Can't start --input-tcp with secure connection:", err)
+			return fmt.Errorf("Can't start --input-tcp with secure connection: %v", err)
 		}
 		i.listener = listener
 	} else {
 		listener, err := net.Listen("tcp", address)
 		if err != nil {
-			log.Fatal("Can't start:", err)
+			return fmt.Errorf("Can't start --input-tcp: %v", err)
 		}
 
 		i.listener = listener
@@ -78,6 +80,8 @@ func (i *TCPInput) listen(address string) {
 			go i.handleConnection(conn)
 		}
 	}()
+
+	return nil
 }
 
 func (i *TCPInput) handleConnection(conn net.Conn) {
@@ -22,7 +22,10 @@ func TestTCPInput(t *testing.T) {
 	wg := new(sync.WaitGroup)
 	quit := make(chan int)
 
-	input := NewTCPInput("127.0.0.1:0", &TCPInputConfig{})
+	input, err := NewTCPInput("127.0.0.1:0", &TCPInputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	output := NewTestOutput(func(data []byte) {
 		wg.Done()
 	})
@@ -98,11 +101,14 @@ func TestTCPInputSecure(t *testing.T) {
 	wg := new(sync.WaitGroup)
 	quit := make(chan int)
 
-	input := NewTCPInput("127.0.0.1:0", &TCPInputConfig{
+	input, err := NewTCPInput("127.0.0.1:0", &TCPInputConfig{
 		secure:          true,
 		certificatePath: serverCertPemFile.Name(),
 		keyPath:         serverPrivPemFile.Name(),
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	output := NewTestOutput(func(data []byte) {
 		wg.Done()
 	})
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/buger/goreplay/proto"
+)
+
+// JSON Lines format stores each payload as JSON object on a separate line, so captures can be processed
+// with jq and other data tools. Format is lossless: payloads which can't be represented by fields,
+// like messages with malformed headers, are stored as is in `raw` field.
+
+var jsonPayloadTypes = map[byte]string{
+	RequestPayload:          "request",
+	ResponsePayload:         "response",
+	ReplayedResponsePayload: "replayed_response",
+}
+
+// JSONHeader is HTTP header, headers keep their order and duplicates
+type JSONHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// JSONPayload is payload in JSON Lines format.
+// Bodies are stored as on the wire, e.g. chunked or gzipped, in UTF-8 if possible, or base64 encoded otherwise.
+type JSONPayload struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Timestamp  int64  `json:"timestamp"`
+	Latency    *int64 `json:"latency,omitempty"`
+	Connection string `json:"connection,omitempty"`
+	// Other fields of payload header
+	Meta []string `json:"meta,omitempty"`
+
+	// Request line
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// Status line
+	Status     int    `json:"status,omitempty"`
+	StatusText string `json:"status_text,omitempty"`
+
+	Proto        string       `json:"proto,omitempty"`
+	Headers      []JSONHeader `json:"headers,omitempty"`
+	Body         string       `json:"body,omitempty"`
+	BodyEncoding string       `json:"body_encoding,omitempty"`
+
+	// Whole payload, if it can't be represented by fields above
+	Raw         string `json:"raw,omitempty"`
+	RawEncoding string `json:"raw_encoding,omitempty"`
+}
+
+// isJSONFormat checks if stream starts with JSON object, payloads of text format start with their type
+func isJSONFormat(r *bufio.Reader) bool {
+	b, err := r.Peek(1)
+	return err == nil && b[0] == '{'
+}
+
+// encodeJSONPayload converts payload to single line JSON, without trailing new line
+func encodeJSONPayload(payload []byte) ([]byte, error) {
+	p, err := newJSONPayload(payload)
+
+	// Check that payload can be restored, to not lose anything
+	if err == nil {
+		var restored []byte
+		if restored, err = p.Payload(); err == nil && !bytes.Equal(restored, payload) {
+			err = errors.New("payload can't be restored")
+		}
+	}
+
+	if err != nil {
+		p = &JSONPayload{}
+		if meta := payloadMeta(payload); len(meta) >= 3 {
+			p.Type = jsonPayloadTypes[payload[0]]
+			p.ID = string(meta[1])
+			p.Timestamp, _ = strconv.ParseInt(string(meta[2]), 10, 64)
+		}
+		p.Raw, p.RawEncoding = encodeText(payload)
+	}
+
+	return json.Marshal(p)
+}
+
+// decodeJSONPayload converts JSON line to payload
+func decodeJSONPayload(line []byte) ([]byte, error) {
+	p := new(JSONPayload)
+	if err := json.Unmarshal(line, p); err != nil {
+		return nil, err
+	}
+
+	return p.Payload()
+}
+
+func newJSONPayload(payload []byte) (*JSONPayload, error) {
+	meta := payloadMeta(payload)
+	if len(meta) < 3 {
+		return nil, errors.New("wrong payload header")
+	}
+
+	p := &JSONPayload{Type: jsonPayloadTypes[payload[0]], ID: string(meta[1])}
+	if p.Type == "" || len(meta[0]) != 1 {
+		return nil, fmt.Errorf("unknown payload type: %q", meta[0])
+	}
+
+	var err error
+	if p.Timestamp, err = strconv.ParseInt(string(meta[2]), 10, 64); err != nil {
+		return nil, err
+	}
+
+	for i, field := range meta[3:] {
+		if l, err := strconv.ParseInt(string(field), 10, 64); i == 0 && err == nil {
+			p.Latency = &l
+		} else if p.Connection == "" && bytes.IndexByte(field, '>') != -1 {
+			p.Connection = string(field)
+		} else {
+			p.Meta = append(p.Meta, string(field))
+		}
+	}
+
+	message := payloadBody(payload)
+
+	lineEnd := bytes.Index(message, proto.CLRF)
+	if lineEnd == -1 {
+		return nil, errors.New("incomplete HTTP message")
+	}
+
+	line := strings.SplitN(string(message[:lineEnd]), " ", 3)
+	if len(line) != 3 {
+		return nil, errors.New("wrong first line of HTTP message")
+	}
+
+	if payload[0] == RequestPayload {
+		p.Method, p.URL, p.Proto = line[0], line[1], line[2]
+	} else {
+		p.Proto, p.StatusText = line[0], line[2]
+		if p.Status, err = strconv.Atoi(line[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	rest := message[lineEnd+2:]
+	for !bytes.HasPrefix(rest, proto.CLRF) {
+		end := bytes.Index(rest, proto.CLRF)
+		if end == -1 {
+			return nil, errors.New("incomplete HTTP headers")
+		}
+
+		header := rest[:end]
+		colon := bytes.IndexByte(header, ':')
+		if colon == -1 {
+			return nil, errors.New("malformed HTTP header")
+		}
+
+		p.Headers = append(p.Headers, JSONHeader{string(header[:colon]), string(bytes.TrimLeft(header[colon+1:], " "))})
+		rest = rest[end+2:]
+	}
+
+	p.Body, p.BodyEncoding = encodeText(rest[2:])
+
+	return p, nil
+}
+
+// Payload converts JSON payload back to payload format
+func (p *JSONPayload) Payload() ([]byte, error) {
+	if p.Raw != "" {
+		return decodeText(p.Raw, p.RawEncoding)
+	}
+
+	var payloadType byte
+	for t, name := range jsonPayloadTypes {
+		if name == p.Type {
+			payloadType = t
+		}
+	}
+
+	if payloadType == 0 {
+		return nil, fmt.Errorf("unknown payload type: %q", p.Type)
+	}
+
+	body, err := decodeText(p.Body, p.BodyEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteByte(payloadType)
+	buf.WriteString(" " + p.ID + " " + strconv.FormatInt(p.Timestamp, 10))
+	if p.Latency != nil {
+		buf.WriteString(" " + strconv.FormatInt(*p.Latency, 10))
+	}
+	if p.Connection != "" {
+		buf.WriteString(" " + p.Connection)
+	}
+	for _, m := range p.Meta {
+		buf.WriteString(" " + m)
+	}
+	buf.WriteByte('\n')
+
+	if payloadType == RequestPayload {
+		buf.WriteString(p.Method + " " + p.URL + " " + p.Proto + "\r\n")
+	} else {
+		buf.WriteString(p.Proto + " " + strconv.Itoa(p.Status) + " " + p.StatusText + "\r\n")
+	}
+
+	for _, h := range p.Headers {
+		buf.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	return buf.Bytes(), nil
+}
+
+// encodeText returns data as text, or base64 encoded if it is not valid UTF-8
+func encodeText(data []byte) (text, encoding string) {
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+func decodeText(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(text)
+	default:
+		return nil, fmt.Errorf("unknown encoding: %q", encoding)
+	}
+}
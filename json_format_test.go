@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testJSONPayloads() [][]byte {
+	return [][]byte{
+		[]byte("1 a1 100\nGET /?q=1 HTTP/1.1\r\nHost: example.com\r\nCookie: a=1\r\nCookie: b=2\r\n\r\n"),
+		[]byte("1 a2 200 10.0.0.1:53422>10.0.0.2:80\nPOST / HTTP/1.1\r\nContent-Length: 3\r\n\r\n\xff\x00\xfe"),
+		[]byte("2 a2 210 0 10.0.0.1:53422>10.0.0.2:80\nHTTP/1.1 200 OK\r\nContent-Length: 15\r\n\r\nseparator" + payloadSeparator),
+		[]byte("3 a2 300 1000\nHTTP/1.1 500 \r\n\r\n"),
+		// Malformed messages are stored as is
+		[]byte("1 a3 400\nGET / HTTP/1.1\nHost: example.com\n\n"),
+		[]byte("2 a3 500 12\nHTTP/1.1 200 OK\r\nContent-Length: 10\r\n"),
+	}
+}
+
+func TestJSONPayload(t *testing.T) {
+	payloads := testJSONPayloads()
+
+	for _, p := range payloads {
+		line, err := encodeJSONPayload(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.IndexByte(line, '\n') != -1 {
+			t.Errorf("Payload should be encoded to single line: %s", line)
+		}
+
+		decoded, err := decodeJSONPayload(line)
+		if err != nil || !bytes.Equal(decoded, p) {
+			t.Errorf("Payload should be restored: %q %v", decoded, err)
+		}
+	}
+
+	var request, response, replayed, malformed JSONPayload
+	for i, v := range []*JSONPayload{&request, &response, &replayed, &malformed} {
+		line, _ := encodeJSONPayload(payloads[[]int{0, 2, 3, 4}[i]])
+		json.Unmarshal(line, v)
+	}
+
+	if request.Type != "request" || request.ID != "a1" || request.Timestamp != 100 || request.Latency != nil ||
+		request.Method != "GET" || request.URL != "/?q=1" || request.Proto != "HTTP/1.1" || len(request.Headers) != 3 || request.Headers[2].Value != "b=2" {
+		t.Errorf("Wrong request: %+v", request)
+	}
+
+	if response.Type != "response" || *response.Latency != 0 || response.Connection != "10.0.0.1:53422>10.0.0.2:80" ||
+		response.Status != 200 || response.StatusText != "OK" || !strings.HasPrefix(response.Body, "separator\n") {
+		t.Errorf("Wrong response: %+v", response)
+	}
+
+	if replayed.Type != "replayed_response" || *replayed.Latency != 1000 || replayed.Status != 500 {
+		t.Errorf("Wrong replayed response: %+v", replayed)
+	}
+
+	if malformed.Type != "request" || malformed.ID != "a3" || malformed.Raw == "" || malformed.Method != "" {
+		t.Errorf("Malformed payload should be stored as is: %+v", malformed)
+	}
+}
+
+func TestFileOutputJSONFormat(t *testing.T) {
+	f, _ := ioutil.TempFile("", "gor_json*.jsonl")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	payloads := testJSONPayloads()
+
+	output := NewFileOutput(f.Name(), &FileOutputConfig{flushInterval: time.Minute, append: true, format: CaptureFormatJSON})
+	for _, p := range payloads {
+		output.Write(p)
+	}
+	output.Close()
+
+	data, _ := ioutil.ReadFile(f.Name())
+	if n := bytes.Count(data, []byte("\n")); n != len(payloads) {
+		t.Error("Should write payload per line:", n)
+	}
+
+	input := NewFileInput(f.Name(), &FileInputConfig{})
+
+	buf := make([]byte, 1000)
+	for i := 0; ; i++ {
+		n, err := input.Read(buf)
+		if err == io.EOF {
+			if i != len(payloads) {
+				t.Error("Should read all payloads:", i)
+			}
+			break
+		}
+
+		if !bytes.Equal(buf[:n], payloads[i]) {
+			t.Errorf("Wrong payload: %q", buf[:n])
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Plugins start their work in constructors, and on shutdown emitter stops them in order:
+// inputs closed first, then middleware and outputs drained, and finally all plugins closed.
+
+// Drainer implemented by plugins which buffer payloads, like output queues.
+// Drain blocks until all buffered payloads are delivered, or deadline passed,
+// and returns error if some of them left undelivered.
+type Drainer interface {
+	Drain(deadline time.Time) error
+}
+
+// DrainError returned when shutdown could not deliver all buffered payloads
+type DrainError struct {
+	Errors []string
+}
+
+func (e *DrainError) Error() string {
+	return "shutdown is not clean: " + strings.Join(e.Errors, "; ")
+}
+
+// eofReader calls done once, when reader reaches the end
+type eofReader struct {
+	io.Reader
+	once sync.Once
+	done func()
+}
+
+func (r *eofReader) Read(data []byte) (n int, err error) {
+	n, err = r.Reader.Read(data)
+	if err == io.EOF {
+		r.once.Do(r.done)
+	}
+
+	return
+}
+
+func (r *eofReader) String() string {
+	return fmt.Sprint(r.Reader)
+}
+
+// shutdown stops inputs, drains middleware and outputs, and closes all plugins.
+// Each step waits until given timeout since the start of shutdown.
+func (e *emitter) shutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	closed := make(map[interface{}]bool)
+	var errors []string
+
+	closePlugin := func(p interface{}) {
+		if c, ok := p.(io.Closer); ok && !closed[p] {
+			closed[p] = true
+			c.Close()
+		}
+	}
+
+	// Stop inputs, and wait for the payloads which already read from them
+	atomic.StoreInt32(&e.inputsStopped, 1)
+	for _, plugins := range e.plugins {
+		for _, p := range plugins.All {
+			if _, isW := p.(io.Writer); !isW {
+				closePlugin(p)
+			}
+		}
+	}
+
+	if !e.waitInFlight(deadline) {
+		errors = append(errors, "payloads read from inputs are not processed in time")
+	}
+
+	// Middleware should process everything it received
+	for _, m := range e.middlewares {
+		if err := m.Drain(deadline); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	if len(e.middlewares) > 0 && !waitGroupDeadline(&e.middlewareLoops, deadline) {
+		errors = append(errors, "middleware output is not processed in time")
+	}
+
+	// Outputs which return responses drained first, so responses still can be written to other outputs
+	drain := func(readers bool) {
+		for _, plugins := range e.plugins {
+			for _, p := range plugins.All {
+				_, isR := p.(io.Reader)
+				if d, ok := p.(Drainer); ok && isR == readers {
+					if err := d.Drain(deadline); err != nil {
+						errors = append(errors, fmt.Sprintf("%s: %v", p, err))
+					}
+				}
+			}
+		}
+	}
+
+	drain(true)
+
+	atomic.StoreInt32(&e.outputsStopped, 1)
+	if !e.waitInFlight(deadline) {
+		errors = append(errors, "responses are not processed in time")
+	}
+
+	drain(false)
+
+	for _, plugins := range e.plugins {
+		for _, p := range plugins.All {
+			closePlugin(p)
+		}
+	}
+
+	if len(errors) > 0 {
+		return &DrainError{errors}
+	}
+
+	return nil
+}
+
+// waitInFlight waits until all payloads read by copy loops are written to outputs
+func (e *emitter) waitInFlight(deadline time.Time) bool {
+	return waitUntil(deadline, func() bool {
+		return atomic.LoadInt64(&e.inFlight) == 0
+	})
+}
+
+func waitGroupDeadline(wg *sync.WaitGroup, deadline time.Time) bool {
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// waitUntil polls condition until it is true, or deadline passed
+func waitUntil(deadline time.Time, condition func() bool) bool {
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return true
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsOutputs(t *testing.T) {
+	quit := make(chan int)
+
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&received, 1)
+	}))
+	defer server.Close()
+
+	input := NewTestInput()
+	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{workersMin: 1, workersMax: 1, queueLen: 100, Timeout: time.Second})
+
+	Plugins = &InOutPlugins{
+		Inputs:  []io.Reader{input},
+		Outputs: []io.Writer{output},
+		All:     []interface{}{input, output},
+	}
+
+	for i := 0; i < 10; i++ {
+		input.EmitGET()
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Start(quit)
+	}()
+
+	// Wait until emitter reads all requests, but replay is still in progress
+	waitUntil(time.Now().Add(time.Second), func() bool { return len(input.data) == 0 })
+	close(quit)
+
+	if err := <-done; err != nil {
+		t.Error("Shutdown should be clean:", err)
+	}
+
+	if n := atomic.LoadInt32(&received); n != 10 {
+		t.Error("All queued requests should be sent before exit:", n)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	quit := make(chan int)
+
+	block := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	input := NewTestInput()
+	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{workersMin: 1, workersMax: 1, queueLen: 100, Timeout: 5 * time.Second})
+
+	Plugins = &InOutPlugins{
+		Inputs:  []io.Reader{input},
+		Outputs: []io.Writer{output},
+		All:     []interface{}{input, output},
+	}
+
+	timeout := Settings.shutdownTimeout
+	Settings.shutdownTimeout = 100 * time.Millisecond
+	defer func() { Settings.shutdownTimeout = timeout }()
+
+	input.EmitGET()
+	input.EmitGET()
+
+	done := make(chan error)
+	go func() {
+		done <- Start(quit)
+	}()
+
+	waitUntil(time.Now().Add(time.Second), func() bool { return len(input.data) == 0 })
+	close(quit)
+
+	err := <-done
+	if _, ok := err.(*DrainError); !ok {
+		t.Error("Should report undelivered requests:", err)
+	}
+}
+
+func TestShutdownOnInputEnd(t *testing.T) {
+	f, _ := ioutil.TempFile("", "gor_shutdown")
+	defer os.Remove(f.Name())
+
+	for i := 0; i < 3; i++ {
+		f.Write(payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1))
+		f.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		f.Write([]byte(payloadSeparator))
+	}
+	f.Close()
+
+	var received int32
+	input := NewFileInput(f.Name(), &FileInputConfig{})
+	output := NewTestOutput(func(data []byte) {
+		atomic.AddInt32(&received, 1)
+	})
+
+	Plugins = &InOutPlugins{
+		Inputs:  []io.Reader{input},
+		Outputs: []io.Writer{output},
+		All:     []interface{}{input, output},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Start(make(chan int))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Should stop when all inputs reached the end")
+	}
+
+	if n := atomic.LoadInt32(&received); n != 3 {
+		t.Error("Should replay all payloads from file:", n)
+	}
+}
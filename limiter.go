@@ -6,17 +6,23 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Limiter is a wrapper for input or output plugin which adds rate limiting
 type Limiter struct {
+	mu sync.Mutex
+
 	plugin    interface{}
 	limit     int
 	isPercent bool
 
 	currentRPS  int
 	currentTime int64
+
+	metrics *PluginMetrics
 }
 
 func parseLimitOptions(options string) (limit int, isPercent bool) {
@@ -38,10 +44,16 @@ func NewLimiter(plugin interface{}, options string) io.ReadWriter {
 	l.limit, l.isPercent = parseLimitOptions(options)
 	l.plugin = plugin
 	l.currentTime = time.Now().UnixNano()
+	l.metrics = metricsFor(l)
 
 	// FileInput have its own rate limiting. Unlike other inputs we not just dropping requests, we can slow down or speed up request emittion.
-	if fi, ok := l.plugin.(*FileInput); ok && l.isPercent {
-		fi.speedFactor = float64(l.limit) / float64(100)
+	if fi, ok := l.plugin.(fileInputPlugin); ok && l.isPercent {
+		fi.fileInput().speedFactor = float64(l.limit) / float64(100)
+	}
+
+	// Requests files have no timing, so absolute limit sets their rate instead of dropping requests
+	if ri, ok := l.plugin.(*RequestsInput); ok && !l.isPercent {
+		ri.rate = l.limit
 	}
 
 	return l
@@ -49,7 +61,11 @@ func NewLimiter(plugin interface{}, options string) io.ReadWriter {
 
 func (l *Limiter) isLimited() bool {
 	// File input have its own limiting algorithm
-	if _, ok := l.plugin.(*FileInput); ok && l.isPercent {
+	if _, ok := l.plugin.(fileInputPlugin); ok && l.isPercent {
+		return false
+	}
+
+	if _, ok := l.plugin.(*RequestsInput); ok {
 		return false
 	}
 
@@ -57,6 +73,10 @@ func (l *Limiter) isLimited() bool {
 		return l.limit <= rand.Intn(100)
 	}
 
+	// Pipeline limiter shared between multiple inputs
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if (time.Now().UnixNano() - l.currentTime) > time.Second.Nanoseconds() {
 		l.currentTime = time.Now().UnixNano()
 		l.currentRPS = 0
@@ -73,6 +93,7 @@ func (l *Limiter) isLimited() bool {
 
 func (l *Limiter) Write(data []byte) (n int, err error) {
 	if l.isLimited() {
+		atomic.AddInt64(&l.metrics.limited, 1)
 		return 0, nil
 	}
 
@@ -89,6 +110,7 @@ func (l *Limiter) Read(data []byte) (n int, err error) {
 	}
 
 	if l.isLimited() {
+		atomic.AddInt64(&l.metrics.limited, 1)
 		return 0, nil
 	}
 
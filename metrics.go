@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// PluginMetrics holds counters of payloads which went through the plugin.
+// Read and written counters are updated by emitter, dropped counters by filters and limiters.
+type PluginMetrics struct {
+	readPayloads    int64
+	readBytes       int64
+	writtenPayloads int64
+	writtenBytes    int64
+	filtered        int64
+	limited         int64
+
+	latency *latencyHistogram
+}
+
+type latencyHistogram struct {
+	mu      sync.Mutex
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+var metricsMu sync.Mutex
+var pluginMetrics = make(map[interface{}]*PluginMetrics)
+
+// Plugin -> `plugin` label of its metrics
+var pluginLabels = make(map[interface{}]string)
+
+// setPluginLabel sets `plugin` label of plugin metrics, usually option name and address.
+// Label should not change while plugin works, otherwise every scrape creates new series.
+func setPluginLabel(plugin interface{}, label string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	pluginLabels[plugin] = label
+}
+
+// pluginLabel returns label set by setPluginLabel, or type name of plugin
+func pluginLabel(plugin interface{}) string {
+	metricsMu.Lock()
+	label, ok := pluginLabels[plugin]
+	metricsMu.Unlock()
+
+	if !ok {
+		label = pluginType(plugin)
+	}
+
+	return label
+}
+
+// metricsFor returns metrics of given plugin, creating them on first call
+func metricsFor(plugin interface{}) *PluginMetrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	m, ok := pluginMetrics[plugin]
+	if !ok {
+		m = new(PluginMetrics)
+		pluginMetrics[plugin] = m
+	}
+
+	return m
+}
+
+func (m *PluginMetrics) read(n int) {
+	atomic.AddInt64(&m.readPayloads, 1)
+	atomic.AddInt64(&m.readBytes, int64(n))
+}
+
+func (m *PluginMetrics) written(n int) {
+	atomic.AddInt64(&m.writtenPayloads, 1)
+	atomic.AddInt64(&m.writtenBytes, int64(n))
+}
+
+// observeLatency adds request round trip time to plugin latency histogram
+func (m *PluginMetrics) observeLatency(d time.Duration) {
+	metricsMu.Lock()
+	if m.latency == nil {
+		m.latency = &latencyHistogram{buckets: make([]int64, len(latencyBuckets))}
+	}
+	h := m.latency
+	metricsMu.Unlock()
+
+	seconds := d.Seconds()
+
+	h.mu.Lock()
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+	h.mu.Unlock()
+}
+
+// pluginGauges implemented by plugins which have internal queues, workers or buffers.
+// Keys are metric names without `gor_plugin_` prefix.
+type pluginGauges interface {
+	gauges() map[string]int64
+}
+
+// writeMetrics writes metrics of all pipelines and their plugins in Prometheus text format
+func writeMetrics(w io.Writer) {
+	type pipelinePlugins struct {
+		name    string
+		plugins *InOutPlugins
+	}
+
+	pipelines := []pipelinePlugins{{"", Plugins}}
+	for _, p := range Pipelines {
+		pipelines = append(pipelines, pipelinePlugins{p.Name, p.Plugins})
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(m *PluginMetrics) *int64
+	}{
+		{"gor_plugin_read_payloads_total", "Payloads read from plugin.", func(m *PluginMetrics) *int64 { return &m.readPayloads }},
+		{"gor_plugin_read_bytes_total", "Bytes read from plugin.", func(m *PluginMetrics) *int64 { return &m.readBytes }},
+		{"gor_plugin_written_payloads_total", "Payloads written to plugin.", func(m *PluginMetrics) *int64 { return &m.writtenPayloads }},
+		{"gor_plugin_written_bytes_total", "Bytes written to plugin.", func(m *PluginMetrics) *int64 { return &m.writtenBytes }},
+		{"gor_plugin_filtered_payloads_total", "Payloads read from plugin and dropped by filters.", func(m *PluginMetrics) *int64 { return &m.filtered }},
+		{"gor_plugin_limited_payloads_total", "Payloads dropped by rate limits.", func(m *PluginMetrics) *int64 { return &m.limited }},
+	}
+
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+		for _, p := range pipelines {
+			for _, plugin := range emitterPlugins(p.plugins) {
+				fmt.Fprintf(w, "%s%s %d\n", c.name, metricLabels(p.name, plugin), atomic.LoadInt64(c.value(metricsFor(plugin))))
+			}
+		}
+	}
+
+	// Gauges reported by plugins itself
+	gauges := make(map[string][]string)
+	for _, p := range pipelines {
+		for _, plugin := range p.plugins.All {
+			g, ok := plugin.(pluginGauges)
+			if !ok {
+				continue
+			}
+
+			for name, value := range g.gauges() {
+				gauges[name] = append(gauges[name], metricLabels(p.name, plugin)+" "+strconv.FormatInt(value, 10))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE gor_plugin_%s gauge\n", name)
+		for _, v := range gauges[name] {
+			fmt.Fprintf(w, "gor_plugin_%s%s\n", name, v)
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP gor_plugin_latency_seconds Round trip time of replayed requests.\n# TYPE gor_plugin_latency_seconds histogram\n")
+	for _, p := range pipelines {
+		for _, plugin := range p.plugins.All {
+			writeLatency(w, p.name, plugin)
+		}
+	}
+
+	fmt.Fprintf(w, "# TYPE gor_goroutines gauge\ngor_goroutines %d\n", runtime.NumGoroutine())
+}
+
+// emitterPlugins returns inputs and outputs, as emitter sees them, including limiter wrappers
+func emitterPlugins(plugins *InOutPlugins) []interface{} {
+	result := make([]interface{}, 0, len(plugins.Inputs)+len(plugins.Outputs))
+	for _, in := range plugins.Inputs {
+		result = append(result, in)
+	}
+	for _, out := range plugins.Outputs {
+		result = append(result, out)
+	}
+
+	return result
+}
+
+func writeLatency(w io.Writer, pipeline string, plugin interface{}) {
+	m := metricsFor(plugin)
+
+	metricsMu.Lock()
+	h := m.latency
+	metricsMu.Unlock()
+
+	if h == nil {
+		return
+	}
+
+	labels := metricLabels(pipeline, plugin)
+	labels = labels[:len(labels)-1]
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "gor_plugin_latency_seconds_bucket%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(le, 'f', -1, 64), h.buckets[i])
+	}
+	fmt.Fprintf(w, "gor_plugin_latency_seconds_bucket%s,le=\"+Inf\"} %d\n", labels, h.count)
+	fmt.Fprintf(w, "gor_plugin_latency_seconds_sum%s} %s\n", labels, strconv.FormatFloat(h.sum, 'f', -1, 64))
+	fmt.Fprintf(w, "gor_plugin_latency_seconds_count%s} %d\n", labels, h.count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func metricLabels(pipeline string, plugin interface{}) string {
+	labels := `{plugin="` + labelEscaper.Replace(pluginLabel(plugin)) + `"`
+	if pipeline != "" {
+		labels += `,pipeline="` + labelEscaper.Replace(pipeline) + `"`
+	}
+
+	return labels + "}"
+}
@@ -5,11 +5,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Middleware struct {
@@ -18,15 +18,20 @@ type Middleware struct {
 	data chan []byte
 
 	mu sync.Mutex
+	// Set when draining, so no more payloads are sent to middleware
+	closed bool
+	// Closed when middleware stdout reaches the end
+	done chan bool
 
-	Stdin  io.Writer
+	Stdin  io.WriteCloser
 	Stdout io.Reader
 }
 
-func NewMiddleware(command string) *Middleware {
+func NewMiddleware(command string) (*Middleware, error) {
 	m := new(Middleware)
 	m.command = command
 	m.data = make(chan []byte, 1000)
+	m.done = make(chan bool)
 
 	commands := strings.Split(command, " ")
 	cmd := exec.Command(commands[0], commands[1:]...)
@@ -36,23 +41,22 @@ func NewMiddleware(command string) *Middleware {
 
 	cmd.Stderr = os.Stderr
 
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Can't start middleware %q: %v", command, err)
+	}
+
 	go m.read(m.Stdout)
 
 	go func() {
-		err := cmd.Start()
-
-		if err != nil {
-			log.Fatal(err)
-		}
+		// Wait closes stdout, so all output should be read before
+		<-m.done
 
-		err = cmd.Wait()
-
-		if err != nil {
-			log.Fatal(err)
+		if err := cmd.Wait(); err != nil {
+			reportError(m, fmt.Errorf("middleware exited: %v", err))
 		}
 	}()
 
-	return m
+	return m, nil
 }
 
 func (m *Middleware) ReadFrom(plugin io.Reader) {
@@ -65,7 +69,10 @@ func (m *Middleware) copy(to io.Writer, from io.Reader) {
 	dst := make([]byte, len(buf)*4)
 
 	for {
-		nr, _ := from.Read(buf)
+		nr, err := from.Read(buf)
+		if err == io.EOF {
+			return
+		}
 		if nr == 0 || nr > len(buf) {
 			continue
 		}
@@ -90,7 +97,9 @@ func (m *Middleware) copy(to io.Writer, from io.Reader) {
 		dst[nr*2] = '\n'
 
 		m.mu.Lock()
-		to.Write(dst[0 : nr*2+1])
+		if !m.closed {
+			to.Write(dst[0 : nr*2+1])
+		}
 		m.mu.Unlock()
 
 		if Settings.debug {
@@ -100,17 +109,16 @@ func (m *Middleware) copy(to io.Writer, from io.Reader) {
 }
 
 func (m *Middleware) read(from io.Reader) {
+	defer close(m.done)
+	defer close(m.data)
+
 	reader := bufio.NewReader(from)
 	var line []byte
 	var e error
 
 	for {
 		if line, e = reader.ReadBytes('\n'); e != nil {
-			if e == io.EOF {
-				continue
-			} else {
-				break
-			}
+			break
 		}
 
 		buf := make([]byte, len(line)/2)
@@ -129,12 +137,33 @@ func (m *Middleware) read(from io.Reader) {
 }
 
 func (m *Middleware) Read(data []byte) (int, error) {
-	buf := <-m.data
+	buf, ok := <-m.data
+	if !ok {
+		return 0, io.EOF
+	}
 	copy(data, buf)
 
 	return len(buf), nil
 }
 
+// Drain stops sending payloads to middleware, and closes its stdin.
+// Well behaving middleware should process remaining payloads and exit, which closes its stdout.
+func (m *Middleware) Drain(deadline time.Time) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		m.Stdin.Close()
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-m.done:
+		return nil
+	case <-time.After(time.Until(deadline)):
+		return fmt.Errorf("middleware '%s' did not exit in time", m.command)
+	}
+}
+
 func (m *Middleware) String() string {
 	return fmt.Sprintf("Modifying traffic using '%s' command", m.command)
 }
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/goreplay/proto"
+)
+
+// Maximum number of body differences reported for a single pair of responses
+const diffMaxBodyPaths = 100
+
+// DiffOutputConfig holds configuration of the responses comparator
+type DiffOutputConfig struct {
+	ignoreHeaders MultiOption
+	maskFields    MultiOption
+	timeout       time.Duration
+}
+
+type diffPair struct {
+	request  []byte
+	original []byte
+	replayed []byte
+	seen     time.Time
+}
+
+// DiffReport describes differences between original and replayed response of the same request
+type DiffReport struct {
+	ID         string       `json:"id"`
+	Request    string       `json:"request,omitempty"`
+	Timestamp  int64        `json:"timestamp"`
+	Status     *DiffStatus  `json:"status,omitempty"`
+	GRPCStatus *DiffStatus  `json:"grpc_status,omitempty"`
+	Headers    []DiffHeader `json:"headers,omitempty"`
+	Body       *DiffBody    `json:"body,omitempty"`
+	Latency    *DiffLatency `json:"latency,omitempty"`
+}
+
+// DiffStatus holds mismatching response status codes
+type DiffStatus struct {
+	Original int `json:"original"`
+	Replayed int `json:"replayed"`
+}
+
+// DiffHeader holds mismatching header values, missing header reported as empty value
+type DiffHeader struct {
+	Name     string   `json:"name"`
+	Original []string `json:"original,omitempty"`
+	Replayed []string `json:"replayed,omitempty"`
+}
+
+// DiffBody holds list of mismatching fields (for JSON bodies), mismatching messages (for gRPC) or first mismatching byte offset
+type DiffBody struct {
+	OriginalSize int      `json:"original_size"`
+	ReplayedSize int      `json:"replayed_size"`
+	Paths        []string `json:"paths,omitempty"`
+	Offset       int      `json:"offset,omitempty"`
+}
+
+// DiffLatency holds response times in nanoseconds, reported together with other differences
+type DiffLatency struct {
+	Original int64 `json:"original"`
+	Replayed int64 `json:"replayed"`
+}
+
+// DiffOutput joins original (input-raw --input-raw-track-response) and replayed
+// (--output-http-track-response) responses by request ID and reports differences between them.
+type DiffOutput struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	pending map[string]*diffPair
+	config  *DiffOutputConfig
+
+	ignoreHeaders map[string]bool
+	maskFields    [][]string
+
+	lastCleanup time.Time
+
+	compared   int
+	mismatched int
+	expired    int
+}
+
+// NewDiffOutput constructor for DiffOutput, accepts file path or `-` for stdout
+func NewDiffOutput(path string, config *DiffOutputConfig) (*DiffOutput, error) {
+	o := new(DiffOutput)
+	o.path = path
+	o.config = config
+	o.pending = make(map[string]*diffPair)
+	o.lastCleanup = time.Now()
+
+	if o.config.timeout == 0 {
+		o.config.timeout = 60 * time.Second
+	}
+
+	o.ignoreHeaders = make(map[string]bool)
+	for _, h := range config.ignoreHeaders {
+		o.ignoreHeaders[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+
+	for _, f := range config.maskFields {
+		o.maskFields = append(o.maskFields, strings.Split(f, "."))
+	}
+
+	if path == "" || path == "-" {
+		o.writer = bufio.NewWriter(os.Stdout)
+	} else {
+		var err error
+		o.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+		if err != nil {
+			return nil, fmt.Errorf("Can't open diff output file: %v", err)
+		}
+		o.writer = bufio.NewWriter(o.file)
+	}
+
+	return o, nil
+}
+
+func (o *DiffOutput) Write(data []byte) (n int, err error) {
+	meta := payloadMeta(data)
+	if len(meta) < 3 {
+		return len(data), nil
+	}
+
+	id := string(meta[1])
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pair, ok := o.pending[id]
+	if !ok {
+		pair = &diffPair{seen: time.Now()}
+		o.pending[id] = pair
+	}
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	switch data[0] {
+	case RequestPayload:
+		pair.request = buf
+	case ResponsePayload:
+		pair.original = buf
+	case ReplayedResponsePayload:
+		pair.replayed = buf
+	}
+
+	if pair.original != nil && pair.replayed != nil {
+		delete(o.pending, id)
+		o.compared++
+
+		if report := o.compare(id, pair); report != nil {
+			o.mismatched++
+			err = o.writeReport(report)
+		}
+	}
+
+	if time.Since(o.lastCleanup) > o.config.timeout {
+		o.cleanup()
+	}
+
+	return len(data), err
+}
+
+// Remove pairs for which we did not receive one of the responses
+func (o *DiffOutput) cleanup() {
+	now := time.Now()
+	for id, p := range o.pending {
+		if now.Sub(p.seen) > o.config.timeout {
+			delete(o.pending, id)
+			o.expired++
+		}
+	}
+	o.lastCleanup = now
+}
+
+func (o *DiffOutput) writeReport(r *DiffReport) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	o.writer.Write(b)
+	o.writer.WriteByte('\n')
+
+	return o.writer.Flush()
+}
+
+// compare returns nil if responses are equal
+func (o *DiffOutput) compare(id string, pair *diffPair) *DiffReport {
+	r := &DiffReport{ID: id, Timestamp: time.Now().UnixNano()}
+	changed := false
+
+	if pair.request != nil {
+		req := payloadBody(pair.request)
+		if proto.IsHTTPPayload(req) {
+			r.Request = string(proto.Method(req)) + " " + string(proto.Path(req))
+		}
+	}
+
+	original := payloadBody(prettifyHTTP(pair.original))
+	replayed := payloadBody(prettifyHTTP(pair.replayed))
+
+	origStatus, _ := strconv.Atoi(string(proto.Status(original)))
+	replStatus, _ := strconv.Atoi(string(proto.Status(replayed)))
+
+	if origStatus != replStatus {
+		r.Status = &DiffStatus{origStatus, replStatus}
+		changed = true
+	}
+
+	isGRPC := proto.IsGRPC(original) && proto.IsGRPC(replayed)
+
+	if isGRPC {
+		origGRPCStatus, _ := strconv.Atoi(string(proto.GRPCStatus(original)))
+		replGRPCStatus, _ := strconv.Atoi(string(proto.GRPCStatus(replayed)))
+
+		if origGRPCStatus != replGRPCStatus {
+			r.GRPCStatus = &DiffStatus{origGRPCStatus, replGRPCStatus}
+			changed = true
+		}
+	}
+
+	if r.Headers = o.compareHeaders(original, replayed, isGRPC); len(r.Headers) > 0 {
+		changed = true
+	}
+
+	if isGRPC {
+		r.Body = compareGRPCBodies(original, replayed)
+	} else {
+		r.Body = o.compareBodies(original, replayed)
+	}
+
+	if r.Body != nil {
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	r.Latency = &DiffLatency{payloadLatency(pair.original), payloadLatency(pair.replayed)}
+
+	return r
+}
+
+func payloadLatency(payload []byte) int64 {
+	meta := payloadMeta(payload)
+	if len(meta) < 4 {
+		return 0
+	}
+
+	l, _ := strconv.ParseInt(string(meta[3]), 10, 64)
+	return l
+}
+
+func responseHeaders(payload []byte) map[string][]string {
+	headers := make(map[string][]string)
+
+	start := proto.MIMEHeadersStartPos(payload)
+	end := proto.MIMEHeadersEndPos(payload)
+	if start < 2 || end < start {
+		return headers
+	}
+
+	proto.ParseHeaders([][]byte{payload[start:end]}, func(header, value []byte) bool {
+		name := strings.ToLower(string(header))
+		headers[name] = append(headers[name], string(value))
+		return true
+	})
+
+	return headers
+}
+
+func (o *DiffOutput) compareHeaders(original, replayed []byte, isGRPC bool) (diff []DiffHeader) {
+	origHeaders := responseHeaders(original)
+	replHeaders := responseHeaders(replayed)
+
+	names := make(map[string]bool)
+	for name := range origHeaders {
+		names[name] = true
+	}
+	for name := range replHeaders {
+		names[name] = true
+	}
+
+	for name := range names {
+		if o.ignoreHeaders[name] {
+			continue
+		}
+
+		// Reported separately
+		if isGRPC && name == "grpc-status" {
+			continue
+		}
+
+		ov, rv := origHeaders[name], replHeaders[name]
+
+		if strings.Join(ov, "\n") != strings.Join(rv, "\n") {
+			diff = append(diff, DiffHeader{Name: name, Original: ov, Replayed: rv})
+		}
+	}
+
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Name < diff[j].Name })
+
+	return
+}
+
+func isJSONBody(payload, body []byte) bool {
+	if bytes.Contains(bytes.ToLower(proto.Header(payload, []byte("Content-Type"))), []byte("json")) {
+		return true
+	}
+
+	body = bytes.TrimSpace(body)
+
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[')
+}
+
+func (o *DiffOutput) compareBodies(original, replayed []byte) *DiffBody {
+	ob, rb := proto.Body(original), proto.Body(replayed)
+
+	if bytes.Equal(ob, rb) {
+		return nil
+	}
+
+	diff := &DiffBody{OriginalSize: len(ob), ReplayedSize: len(rb)}
+
+	if isJSONBody(original, ob) && isJSONBody(replayed, rb) {
+		var ov, rv interface{}
+
+		if json.Unmarshal(ob, &ov) == nil && json.Unmarshal(rb, &rv) == nil {
+			o.compareJSON(nil, ov, rv, &diff.Paths)
+
+			if len(diff.Paths) == 0 {
+				return nil
+			}
+
+			return diff
+		}
+	}
+
+	for diff.Offset < len(ob) && diff.Offset < len(rb) && ob[diff.Offset] == rb[diff.Offset] {
+		diff.Offset++
+	}
+
+	return diff
+}
+
+// compareGRPCBodies compares length-prefixed gRPC messages one by one, mismatching messages reported as `messages.N`
+func compareGRPCBodies(original, replayed []byte) *DiffBody {
+	ob, rb := proto.Body(original), proto.Body(replayed)
+
+	if bytes.Equal(ob, rb) {
+		return nil
+	}
+
+	diff := &DiffBody{OriginalSize: len(ob), ReplayedSize: len(rb)}
+
+	om, rm := proto.GRPCMessages(original), proto.GRPCMessages(replayed)
+
+	for i := 0; (i < len(om) || i < len(rm)) && len(diff.Paths) < diffMaxBodyPaths; i++ {
+		if i < len(om) && i < len(rm) && bytes.Equal(om[i].Data, rm[i].Data) {
+			continue
+		}
+
+		diff.Paths = append(diff.Paths, "messages."+strconv.Itoa(i))
+	}
+
+	return diff
+}
+
+// isMasked checks if JSON path matches one of the --output-diff-mask-field patterns.
+// Pattern segments separated by dot, `*` matches any object key or array index.
+func (o *DiffOutput) isMasked(path []string) bool {
+	for _, mask := range o.maskFields {
+		if len(mask) != len(path) {
+			continue
+		}
+
+		matched := true
+		for i, segment := range mask {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func jsonPath(path []string) string {
+	if len(path) == 0 {
+		return "."
+	}
+
+	return strings.Join(path, ".")
+}
+
+func (o *DiffOutput) compareJSON(path []string, original, replayed interface{}, paths *[]string) {
+	if len(*paths) >= diffMaxBodyPaths {
+		return
+	}
+
+	if len(path) > 0 && o.isMasked(path) {
+		return
+	}
+
+	switch ov := original.(type) {
+	case map[string]interface{}:
+		rv, ok := replayed.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(ov)+len(rv))
+		for k := range ov {
+			keys = append(keys, k)
+		}
+		for k := range rv {
+			if _, ok := ov[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			o.compareJSON(append(path[:len(path):len(path)], k), ov[k], rv[k], paths)
+		}
+
+		return
+	case []interface{}:
+		rv, ok := replayed.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(ov) || i < len(rv); i++ {
+			var oi, ri interface{}
+			if i < len(ov) {
+				oi = ov[i]
+			}
+			if i < len(rv) {
+				ri = rv[i]
+			}
+
+			o.compareJSON(append(path[:len(path):len(path)], strconv.Itoa(i)), oi, ri, paths)
+		}
+
+		return
+	default:
+		if original == replayed {
+			return
+		}
+	}
+
+	*paths = append(*paths, jsonPath(path))
+}
+
+func (o *DiffOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return fmt.Sprintf("Diff output: %s, compared: %d, mismatched: %d, expired: %d", o.path, o.compared, o.mismatched, o.expired)
+}
+
+// Close flushes pending reports
+func (o *DiffOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.writer.Flush()
+
+	if o.file != nil {
+		return o.file.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func readDiffReports(t *testing.T, path string) (reports []DiffReport) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r DiffReport
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal("Wrong report format:", err, scanner.Text())
+		}
+		reports = append(reports, r)
+	}
+
+	return
+}
+
+func newTestDiffOutput(t *testing.T, config *DiffOutputConfig) (*DiffOutput, string) {
+	f, _ := ioutil.TempFile("", "diff")
+	f.Close()
+
+	o, err := NewDiffOutput(f.Name(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return o, f.Name()
+}
+
+func TestDiffOutputStatusAndHeaders(t *testing.T) {
+	config := &DiffOutputConfig{}
+	config.ignoreHeaders.Set("Date")
+
+	output, path := newTestDiffOutput(t, config)
+	defer os.Remove(path)
+
+	output.Write([]byte("1 1 1\nGET /users HTTP/1.1\r\nHost: www.w3.org\r\n\r\n"))
+	output.Write([]byte("2 1 2 10\nHTTP/1.1 200 OK\r\nDate: Mon, 17 Aug 2015 14:10:11 GMT\r\nX-Version: 1\r\nContent-Length: 2\r\n\r\nok"))
+	output.Write([]byte("3 1 2 20\nHTTP/1.1 500 Internal Server Error\r\nDate: Mon, 17 Aug 2015 14:10:12 GMT\r\nX-Version: 2\r\nContent-Length: 2\r\n\r\nok"))
+
+	// Same responses should not be reported
+	output.Write([]byte("2 2 2 10\nHTTP/1.1 200 OK\r\nDate: Mon, 17 Aug 2015 14:10:11 GMT\r\nContent-Length: 2\r\n\r\nok"))
+	output.Write([]byte("3 2 2 20\nHTTP/1.1 200 OK\r\nDate: Mon, 17 Aug 2015 14:10:12 GMT\r\nContent-Length: 2\r\n\r\nok"))
+	output.Close()
+
+	reports := readDiffReports(t, path)
+
+	if len(reports) != 1 {
+		t.Fatal("Should report only 1 pair:", len(reports))
+	}
+
+	r := reports[0]
+
+	if r.ID != "1" || r.Request != "GET /users" {
+		t.Error("Wrong request info:", r.ID, r.Request)
+	}
+
+	if r.Status == nil || r.Status.Original != 200 || r.Status.Replayed != 500 {
+		t.Error("Should report status mismatch:", r.Status)
+	}
+
+	if len(r.Headers) != 1 || r.Headers[0].Name != "x-version" || r.Headers[0].Original[0] != "1" || r.Headers[0].Replayed[0] != "2" {
+		t.Error("Should report only X-Version header:", r.Headers)
+	}
+
+	if r.Body != nil {
+		t.Error("Bodies are equal:", r.Body)
+	}
+
+	if r.Latency == nil || r.Latency.Original != 10 || r.Latency.Replayed != 20 {
+		t.Error("Should report latencies:", r.Latency)
+	}
+}
+
+func TestDiffOutputJSONBody(t *testing.T) {
+	config := &DiffOutputConfig{}
+	config.maskFields.Set("request_id")
+	config.maskFields.Set("items.*.updated_at")
+
+	output, path := newTestDiffOutput(t, config)
+	defer os.Remove(path)
+
+	original := `{"request_id": 1, "items": [{"id": 1, "updated_at": 1}, {"id": 2, "updated_at": 2}], "total": 2}`
+	replayed := `{"total":3,"request_id":2,"items":[{"id":1,"updated_at":3},{"id":3,"updated_at":4}],"next":"b"}`
+
+	output.Write([]byte("2 1 2 10\nHTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" + original))
+	output.Write([]byte("3 1 2 20\nHTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" + replayed))
+
+	// Only masked fields and formatting differs
+	output.Write([]byte("2 2 2 10\nHTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" + `{"a": 1, "request_id": 1}`))
+	output.Write([]byte("3 2 2 20\nHTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" + `{"request_id":5,"a":1}`))
+	output.Close()
+
+	reports := readDiffReports(t, path)
+
+	if len(reports) != 1 {
+		t.Fatal("Should report only 1 pair:", len(reports))
+	}
+
+	body := reports[0].Body
+	if body == nil {
+		t.Fatal("Should report body differences")
+	}
+
+	expected := []string{"items.1.id", "next", "total"}
+	if len(body.Paths) != len(expected) {
+		t.Fatal("Wrong paths:", body.Paths)
+	}
+
+	for i, p := range expected {
+		if body.Paths[i] != p {
+			t.Error("Wrong path", i, body.Paths[i], p)
+		}
+	}
+}
+
+func TestDiffOutputRawBody(t *testing.T) {
+	output, path := newTestDiffOutput(t, &DiffOutputConfig{})
+	defer os.Remove(path)
+
+	output.Write([]byte("2 1 2 10\nHTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"))
+	output.Write([]byte("3 1 2 20\nHTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhelpo"))
+	output.Close()
+
+	reports := readDiffReports(t, path)
+
+	if len(reports) != 1 || reports[0].Body == nil {
+		t.Fatal("Should report body difference")
+	}
+
+	if reports[0].Body.Offset != 3 {
+		t.Error("Wrong mismatch offset:", reports[0].Body.Offset)
+	}
+}
+
+func TestDiffOutputChunkedBody(t *testing.T) {
+	output, path := newTestDiffOutput(t, &DiffOutputConfig{})
+	defer os.Remove(path)
+
+	// Same body with different transfer encoding should be treated as equal
+	output.Write([]byte("2 1 2 10\nHTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nWiki\r\n5\r\npedia\r\n0\r\n\r\n"))
+	output.Write([]byte("3 1 2 20\nHTTP/1.1 200 OK\r\nContent-Length: 9\r\n\r\nWikipedia"))
+	output.Close()
+
+	if reports := readDiffReports(t, path); len(reports) != 0 {
+		t.Error("Should not report anything:", reports)
+	}
+}
+
+func TestDiffOutputGRPC(t *testing.T) {
+	output, path := newTestDiffOutput(t, &DiffOutputConfig{})
+	defer os.Remove(path)
+
+	original := "HTTP/1.1 200 OK\r\nContent-Type: application/grpc\r\nGrpc-Status: 0\r\nContent-Length: 14\r\n\r\n\x00\x00\x00\x00\x02hi\x00\x00\x00\x00\x02ok"
+	replayed := "HTTP/1.1 200 OK\r\nContent-Type: application/grpc\r\nGrpc-Status: 5\r\nContent-Length: 14\r\n\r\n\x00\x00\x00\x00\x02hi\x00\x00\x00\x00\x02no"
+
+	output.Write([]byte("1 1 1\nPOST /helloworld.Greeter/SayHello HTTP/1.1\r\nContent-Type: application/grpc\r\n\r\n"))
+	output.Write([]byte("2 1 2 10\n" + original))
+	output.Write([]byte("3 1 2 20\n" + replayed))
+	output.Close()
+
+	reports := readDiffReports(t, path)
+
+	if len(reports) != 1 {
+		t.Fatal("Should report 1 pair:", len(reports))
+	}
+
+	r := reports[0]
+
+	if r.GRPCStatus == nil || r.GRPCStatus.Original != 0 || r.GRPCStatus.Replayed != 5 {
+		t.Error("Should report gRPC status mismatch:", r.GRPCStatus)
+	}
+
+	if len(r.Headers) != 0 {
+		t.Error("gRPC status should not be reported as header:", r.Headers)
+	}
+
+	if r.Body == nil || len(r.Body.Paths) != 1 || r.Body.Paths[0] != "messages.1" {
+		t.Error("Should report second message:", r.Body)
+	}
+}
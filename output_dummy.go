@@ -6,6 +6,7 @@ import (
 
 // DummyOutput used for debugging, prints all incoming requests
 type DummyOutput struct {
+	json bool
 }
 
 // NewDummyOutput constructor for DummyOutput
@@ -15,8 +16,25 @@ func NewDummyOutput() (di *DummyOutput) {
 	return
 }
 
+// NewStdoutOutput constructor for DummyOutput, which prints payloads in `text` or `json` format
+func NewStdoutOutput(format string) (*DummyOutput, error) {
+	if format != "" && format != CaptureFormatText && format != CaptureFormatJSON {
+		return nil, fmt.Errorf("format should be %s or %s, got %q", CaptureFormatText, CaptureFormatJSON, format)
+	}
+
+	return &DummyOutput{json: format == CaptureFormatJSON}, nil
+}
+
 func (i *DummyOutput) Write(data []byte) (int, error) {
-	fmt.Println(string(data))
+	if i.json {
+		line, err := encodeJSONPayload(data)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Println(string(line))
+	} else {
+		fmt.Println(string(data))
+	}
 
 	return len(data), nil
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -35,6 +34,11 @@ type FileOutputConfig struct {
 	outputFileMaxSize unitSizeVar
 	queueLimit        int
 	append            bool
+	format            string
+	compressionLevel  int
+
+	// Called when chunk file is complete and closed
+	onClose func(path string)
 }
 
 // FileOutput output plugin
@@ -46,6 +50,7 @@ type FileOutput struct {
 	queueLength    int
 	chunkSize      int
 	writer         io.Writer
+	capture        *captureWriter
 	requestPerFile bool
 	currentID      []byte
 	payloadType    []byte
@@ -184,6 +189,10 @@ func (o *FileOutput) updateName() {
 }
 
 func (o *FileOutput) Write(data []byte) (n int, err error) {
+	if o.closed {
+		return 0, errors.New("File output is closed")
+	}
+
 	if o.requestPerFile {
 		meta := payloadMeta(data)
 		o.currentID = meta[1]
@@ -194,32 +203,70 @@ func (o *FileOutput) Write(data []byte) (n int, err error) {
 
 	if o.file == nil || o.currentName != o.file.Name() {
 		o.mu.Lock()
-		o.Close()
+		o.closeFile()
+
+		// Date variables can be used in directory names too
+		os.MkdirAll(filepath.Dir(o.currentName), 0770)
 
 		o.file, err = os.OpenFile(o.currentName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+		if err != nil {
+			o.file = nil
+			o.mu.Unlock()
+			return 0, fmt.Errorf("Cannot open file %q. Error: %s", o.currentName, err)
+		}
 		o.file.Sync()
 
-		if strings.HasSuffix(o.currentName, ".gz") {
-			o.writer = gzip.NewWriter(o.file)
+		// Compression detected by extension: .gz, .zst or .lz4
+		if ext := compressionExt(o.currentName); ext != "" {
+			o.writer, err = newCompressWriter(o.file, ext, o.config.compressionLevel)
+			if err != nil {
+				o.file.Close()
+				o.file = nil
+				o.mu.Unlock()
+				return 0, err
+			}
 		} else {
 			o.writer = bufio.NewWriter(o.file)
 		}
 
-		if err != nil {
-			log.Fatal(o, "Cannot open file %q. Error: %s", o.currentName, err)
+		if o.config.format == CaptureFormatBinary {
+			o.capture, err = newCaptureWriter(o.writer)
+			if err != nil {
+				o.mu.Unlock()
+				return 0, err
+			}
 		}
 
 		o.queueLength = 0
 		o.mu.Unlock()
 	}
 
-	o.writer.Write(data)
-	o.writer.Write([]byte(payloadSeparator))
+	if o.capture != nil {
+		n, err := o.capture.WriteRecord(data)
+		if err != nil {
+			return 0, err
+		}
+
+		o.totalFileSize += int64(n)
+	} else if o.config.format == CaptureFormatJSON {
+		line, err := encodeJSONPayload(data)
+		if err != nil {
+			return 0, err
+		}
+
+		o.writer.Write(line)
+		o.writer.Write([]byte{'\n'})
 
-	o.totalFileSize += int64(len(data) + len(payloadSeparator))
+		o.totalFileSize += int64(len(line) + 1)
+	} else {
+		o.writer.Write(data)
+		o.writer.Write([]byte(payloadSeparator))
+
+		o.totalFileSize += int64(len(data) + len(payloadSeparator))
+	}
 	o.queueLength++
 
-	if Settings.outputFileConfig.outputFileMaxSize > 0 && o.totalFileSize >= int64(Settings.outputFileConfig.outputFileMaxSize) {
+	if o.config.outputFileMaxSize > 0 && o.totalFileSize >= int64(o.config.outputFileMaxSize) {
 		return len(data), errors.New("File output reached size limit")
 	}
 
@@ -237,9 +284,9 @@ func (o *FileOutput) flush() {
 	defer o.mu.Unlock()
 	o.mu.Lock()
 
-	if o.file != nil {
-		if strings.HasSuffix(o.currentName, ".gz") {
-			o.writer.(*gzip.Writer).Flush()
+	if o.writer != nil {
+		if w, ok := o.writer.(*compressWriter); ok {
+			w.Flush()
 		} else {
 			o.writer.(*bufio.Writer).Flush()
 		}
@@ -253,18 +300,34 @@ func (o *FileOutput) flush() {
 }
 
 func (o *FileOutput) String() string {
-	return "File output: " + o.file.Name()
+	return "File output: " + o.pathTemplate
 }
 
-func (o *FileOutput) Close() error {
-	if o.file != nil {
-		if strings.HasSuffix(o.currentName, ".gz") {
-			o.writer.(*gzip.Writer).Close()
+// closeFile completes current chunk
+func (o *FileOutput) closeFile() {
+	if o.writer != nil {
+		if o.capture != nil {
+			o.capture.Close()
+			o.capture = nil
+		}
+
+		if w, ok := o.writer.(*compressWriter); ok {
+			w.Close()
 		} else {
 			o.writer.(*bufio.Writer).Flush()
 		}
 		o.file.Close()
+
+		if o.config.onClose != nil {
+			o.config.onClose(o.file.Name())
+		}
+
+		o.writer = nil
 	}
+}
+
+func (o *FileOutput) Close() error {
+	o.closeFile()
 
 	o.closed = true
 	return nil
@@ -38,7 +38,7 @@ func TestFileOutput(t *testing.T) {
 	quit = make(chan int)
 
 	var counter int64
-	input2 := NewFileInput("/tmp/test_requests.gor", false)
+	input2 := NewFileInput("/tmp/test_requests.gor", &FileInputConfig{})
 	output2 := NewTestOutput(func(data []byte) {
 		atomic.AddInt64(&counter, 1)
 		wg.Done()
@@ -157,6 +157,52 @@ func TestFileOutputCompression(t *testing.T) {
 	os.Remove(name)
 }
 
+func TestFileOutputCompressionFormats(t *testing.T) {
+	for _, ext := range []string{".gz", ".zst", ".lz4"} {
+		for _, level := range []int{0, 3} {
+			name := fmt.Sprintf("/tmp/gor_compression_%d%s", level, ext)
+
+			output := NewFileOutput(name, &FileOutputConfig{append: true, flushInterval: time.Minute, compressionLevel: level})
+			for i := 0; i < 100; i++ {
+				output.Write([]byte(fmt.Sprintf("1 %d %d\nGET / HTTP/1.1\r\n\r\n", i, i)))
+
+				// Every flush starts a new frame
+				if i%30 == 0 {
+					output.flush()
+				}
+			}
+
+			// Flushed frames can be read before file is closed
+			output.flush()
+			if n := readTestFile(t, name); n != 100 {
+				t.Errorf("%s: should read all flushed payloads: %d", ext, n)
+			}
+
+			output.Write([]byte("1 100 100\nGET / HTTP/1.1\r\n\r\n"))
+			output.Close()
+
+			if n := readTestFile(t, name); n != 101 {
+				t.Errorf("%s: should read all payloads: %d", ext, n)
+			}
+
+			os.Remove(name)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, path string) (n int) {
+	input := NewFileInput(path, &FileInputConfig{})
+	defer input.Close()
+
+	buf := make([]byte, 1000)
+	for {
+		if _, err := input.Read(buf); err != nil {
+			return
+		}
+		n++
+	}
+}
+
 func TestParseDataUnit(t *testing.T) {
 	var tests = []struct {
 		value string
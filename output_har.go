@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long entry waits for missing responses, before it is written
+const harEntryTimeout = 5 * time.Second
+
+type harPending struct {
+	request  []byte
+	response []byte
+	replayed []byte
+	seen     time.Time
+}
+
+// HAROutput writes requests, with original and replayed responses, as HAR 1.2 file, which can be opened in browser developer tools.
+// Records are paired by request ID, and `time` and `timings.wait` of entry taken from response latency.
+// Replayed response is written to non-standard `_replayedResponse` field, and its latency to `_replayedTime`.
+type HAROutput struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	pending map[string]*harPending
+	written int
+	closed  bool
+}
+
+// NewHAROutput constructor for HAROutput, accepts file path
+func NewHAROutput(path string) (*HAROutput, error) {
+	o := &HAROutput{
+		path:    path,
+		pending: make(map[string]*harPending),
+	}
+
+	var err error
+	if o.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660); err != nil {
+		return nil, err
+	}
+	o.writer = bufio.NewWriter(o.file)
+
+	// Entries are streamed, so document is valid only after output is closed
+	creator, _ := json.Marshal(harCreator{"GoReplay", VERSION})
+	o.writer.WriteString(`{"log":{"version":"1.2","creator":` + string(creator) + `,"entries":[`)
+
+	go o.flushLoop()
+
+	return o, nil
+}
+
+func (o *HAROutput) Write(data []byte) (int, error) {
+	meta := payloadMeta(data)
+	if len(meta) < 3 {
+		return 0, errors.New("wrong payload header")
+	}
+
+	id := string(meta[1])
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return 0, errors.New("HAR output is closed")
+	}
+
+	p, ok := o.pending[id]
+	if !ok {
+		p = new(harPending)
+		o.pending[id] = p
+	}
+	p.seen = time.Now()
+
+	switch data[0] {
+	case RequestPayload:
+		p.request = buf
+	case ResponsePayload:
+		p.response = buf
+	case ReplayedResponsePayload:
+		p.replayed = buf
+	}
+
+	return len(data), nil
+}
+
+// writeEntries writes entries which got all responses, or waited for them long enough
+func (o *HAROutput) writeEntries(all bool) error {
+	var entries []*harEntry
+
+	for id, p := range o.pending {
+		complete := p.request != nil && p.response != nil && p.replayed != nil
+		if !all && !complete && time.Since(p.seen) < harEntryTimeout {
+			continue
+		}
+		delete(o.pending, id)
+
+		// Responses without request can't be converted
+		if p.request == nil {
+			continue
+		}
+
+		entries = append(entries, harPendingEntry(p))
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime < entries[j].StartedDateTime
+	})
+
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		if o.written > 0 {
+			o.writer.WriteByte(',')
+		}
+		o.writer.WriteByte('\n')
+		o.writer.Write(b)
+		o.written++
+	}
+
+	return o.writer.Flush()
+}
+
+func harPendingEntry(p *harPending) *harEntry {
+	meta := payloadMeta(p.request)
+	ts, _ := strconv.ParseInt(string(meta[2]), 10, 64)
+
+	e := &harEntry{
+		StartedDateTime: harTime(time.Unix(0, ts)),
+		Request:         harPayloadRequest(harDecode(p.request)),
+		Response: harResponse{
+			HTTPVersion: "unknown",
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		// Only time to response is known, so the rest is not available or included into wait
+		Timings: harTimings{Blocked: -1, DNS: -1, Connect: -1},
+	}
+
+	if p.response != nil {
+		e.Response = *harPayloadResponse(harDecode(p.response))
+		e.Time = harDuration(payloadLatency(p.response))
+		e.Timings.Wait = e.Time
+	} else {
+		e.Comment = "response was not captured"
+	}
+
+	if p.replayed != nil {
+		e.Replayed = harPayloadResponse(harDecode(p.replayed))
+		e.ReplayedTime = harDuration(payloadLatency(p.replayed))
+	}
+
+	return e
+}
+
+func (o *HAROutput) flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		o.mu.Lock()
+		if o.closed {
+			o.mu.Unlock()
+			return
+		}
+		if err := o.writeEntries(false); err != nil {
+			reportError(o, err)
+		}
+		o.mu.Unlock()
+	}
+}
+
+func (o *HAROutput) String() string {
+	return "HAR output: " + o.path
+}
+
+// Close writes remaining entries, and completes HAR document
+func (o *HAROutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+
+	err := o.writeEntries(true)
+	if err == nil {
+		o.writer.WriteString("\n]}}\n")
+		err = o.writer.Flush()
+	}
+
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"log"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/buger/goreplay/proto"
+
+	"golang.org/x/net/http2"
 )
 
 const initialDynamicWorkers = 10
@@ -37,6 +41,10 @@ type HTTPOutputConfig struct {
 
 	CompatibilityMode bool
 
+	// Replay using HTTP/2: h2c for http:// addresses, TLS ALPN for https://
+	HTTP2             bool
+	HTTP2PingInterval time.Duration
+
 	Debug bool
 
 	TrackResponses bool
@@ -50,6 +58,8 @@ type HTTPOutput struct {
 	// alignment. atomic.* functions crash on 32bit machines if operand is not
 	// aligned at 64bit. See https://github.com/golang/go/issues/599
 	activeWorkers int64
+	// Requests written to the queue, but not yet sent
+	pending int64
 
 	address string
 	limit   int
@@ -64,6 +74,10 @@ type HTTPOutput struct {
 	queueStats *GorStat
 
 	elasticSearch *ESPlugin
+
+	http2Transport *http2.Transport
+
+	metrics *PluginMetrics
 }
 
 // NewHTTPOutput constructor for HTTPOutput
@@ -73,6 +87,7 @@ func NewHTTPOutput(address string, config *HTTPOutputConfig) io.Writer {
 
 	o.address = address
 	o.config = config
+	o.metrics = metricsFor(o)
 
 	if o.config.stats {
 		o.queueStats = NewGorStat("output_http", o.config.statsMs)
@@ -94,6 +109,15 @@ func NewHTTPOutput(address string, config *HTTPOutputConfig) io.Writer {
 		o.elasticSearch.Init(o.config.elasticSearch)
 	}
 
+	if o.config.HTTP2 {
+		scheme := "http"
+		if strings.HasPrefix(address, "https://") {
+			scheme = "https"
+		}
+
+		o.http2Transport = NewHTTP2Transport(scheme, &HTTPClientConfig{Timeout: o.config.Timeout, HTTP2PingInterval: o.config.HTTP2PingInterval})
+	}
+
 	go o.workerMaster()
 
 	return o
@@ -116,6 +140,9 @@ func (o *HTTPOutput) startWorker() {
 		Timeout:            o.config.Timeout,
 		ResponseBufferSize: o.config.BufferSize,
 		CompatibilityMode:  o.config.CompatibilityMode,
+		HTTP2:              o.config.HTTP2,
+		HTTP2PingInterval:  o.config.HTTP2PingInterval,
+		HTTP2Transport:     o.http2Transport,
 	})
 
 	deathCount := 0
@@ -126,6 +153,7 @@ func (o *HTTPOutput) startWorker() {
 		select {
 		case data := <-o.queue:
 			o.sendRequest(client, data)
+			atomic.AddInt64(&o.pending, -1)
 			deathCount = 0
 		case <-time.After(time.Millisecond * 100):
 			// When dynamic scaling enabled workers die after 2s of inactivity
@@ -155,6 +183,7 @@ func (o *HTTPOutput) Write(data []byte) (n int, err error) {
 	buf := make([]byte, len(data))
 	copy(buf, data)
 
+	atomic.AddInt64(&o.pending, 1)
 	o.queue <- buf
 
 	if o.config.stats {
@@ -205,8 +234,9 @@ func (o *HTTPOutput) sendRequest(client *HTTPClient, request []byte) {
 	}
 	uuid := meta[1]
 
+	// Payloads of other protocols are replayed by --output-tcp-raw
 	body := payloadBody(request)
-	if !proto.IsHTTPPayload(body) {
+	if payloadProtocol(request) != "http" || !proto.IsHTTPPayload(body) {
 		return
 	}
 
@@ -214,6 +244,8 @@ func (o *HTTPOutput) sendRequest(client *HTTPClient, request []byte) {
 	resp, err := client.Send(body)
 	stop := time.Now()
 
+	o.metrics.observeLatency(stop.Sub(start))
+
 	if err != nil {
 		log.Println("Error when sending ", err, time.Now())
 		Debug("Request error:", err)
@@ -228,6 +260,24 @@ func (o *HTTPOutput) sendRequest(client *HTTPClient, request []byte) {
 	}
 }
 
+// Drain waits until all queued requests are sent
+func (o *HTTPOutput) Drain(deadline time.Time) error {
+	if !waitUntil(deadline, func() bool { return atomic.LoadInt64(&o.pending) == 0 }) {
+		return fmt.Errorf("%d requests are not sent in time", atomic.LoadInt64(&o.pending))
+	}
+
+	return nil
+}
+
+func (o *HTTPOutput) gauges() map[string]int64 {
+	return map[string]int64{
+		"queue_length":           int64(len(o.queue)),
+		"queue_capacity":         int64(cap(o.queue)),
+		"workers":                atomic.LoadInt64(&o.activeWorkers),
+		"responses_queue_length": int64(len(o.responses)),
+	}
+}
+
 func (o *HTTPOutput) String() string {
 	return "HTTP output: " + o.address
 }
@@ -3,12 +3,17 @@ package main
 import (
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	_ "net/http/httputil"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestHTTPOutput(t *testing.T) {
@@ -129,6 +134,48 @@ func TestOutputHTTPSSL(t *testing.T) {
 	close(quit)
 }
 
+func TestHTTPOutputHTTP2(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	var connections int32
+
+	server := httptest.NewUnstartedServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Error("Should use HTTP/2:", r.Proto)
+		}
+		wg.Done()
+	}), &http2.Server{}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	input := NewTestInput()
+	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{HTTP2: true, workers: 10})
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = []io.Writer{output}
+
+	go Start(quit)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		input.EmitPOST()
+		input.EmitGET()
+	}
+
+	wg.Wait()
+	close(quit)
+
+	if c := atomic.LoadInt32(&connections); c != 1 {
+		t.Error("Requests should be multiplexed over single connection:", c)
+	}
+}
+
 func BenchmarkHTTPOutput(b *testing.B) {
 	wg := new(sync.WaitGroup)
 	quit := make(chan int)
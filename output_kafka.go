@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/buger/goreplay/proto"
@@ -17,13 +20,16 @@ import (
 type KafkaOutput struct {
 	config   *KafkaConfig
 	producer sarama.AsyncProducer
+
+	closeOnce sync.Once
+	closed    chan error
 }
 
 // KafkaOutputFrequency in milliseconds
 const KafkaOutputFrequency = 500
 
 // NewKafkaOutput creates instance of kafka producer client.
-func NewKafkaOutput(address string, config *KafkaConfig) io.Writer {
+func NewKafkaOutput(address string, config *KafkaConfig) (io.Writer, error) {
 	c := sarama.NewConfig()
 
 	var producer sarama.AsyncProducer
@@ -40,13 +46,14 @@ func NewKafkaOutput(address string, config *KafkaConfig) io.Writer {
 		var err error
 		producer, err = sarama.NewAsyncProducer(brokerList, c)
 		if err != nil {
-			log.Fatalln("Failed to start Sarama(Kafka) producer:", err)
+			return nil, fmt.Errorf("Failed to start Sarama(Kafka) producer: %v", err)
 		}
 	}
 
 	o := &KafkaOutput{
 		config:   config,
 		producer: producer,
+		closed:   make(chan error, 1),
 	}
 
 	if Settings.verbose {
@@ -54,7 +61,7 @@ func NewKafkaOutput(address string, config *KafkaConfig) io.Writer {
 		go o.ErrorHandler()
 	}
 
-	return o
+	return o, nil
 }
 
 // ErrorHandler should receive errors
@@ -64,6 +71,22 @@ func (o *KafkaOutput) ErrorHandler() {
 	}
 }
 
+// Drain flushes buffered messages and closes producer
+func (o *KafkaOutput) Drain(deadline time.Time) error {
+	o.closeOnce.Do(func() {
+		go func() {
+			o.closed <- o.producer.Close()
+		}()
+	})
+
+	select {
+	case err := <-o.closed:
+		return err
+	case <-time.After(time.Until(deadline)):
+		return errors.New("buffered messages are not flushed in time")
+	}
+}
+
 func (o *KafkaOutput) Write(data []byte) (n int, err error) {
 	var message sarama.StringEncoder
 
@@ -13,11 +13,14 @@ func TestOutputKafkaRAW(t *testing.T) {
 	producer := mocks.NewAsyncProducer(t, config)
 	producer.ExpectInputAndSucceed()
 
-	output := NewKafkaOutput("", &KafkaConfig{
+	output, err := NewKafkaOutput("", &KafkaConfig{
 		producer: producer,
 		topic:    "test",
 		useJSON:  false,
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	output.Write([]byte("1 2 3\nGET / HTTP1.1\r\nHeader: 1\r\n\r\n"))
 
@@ -36,11 +39,14 @@ func TestOutputKafkaJSON(t *testing.T) {
 	producer := mocks.NewAsyncProducer(t, config)
 	producer.ExpectInputAndSucceed()
 
-	output := NewKafkaOutput("", &KafkaConfig{
+	output, err := NewKafkaOutput("", &KafkaConfig{
 		producer: producer,
 		topic:    "test",
 		useJSON:  true,
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	output.Write([]byte("1 2 3\nGET / HTTP1.1\r\nHeader: 1\r\n\r\n"))
 
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// Maximum TCP payload of synthesized packets, to fit Ethernet MTU
+	pcapSegmentSize = 1448
+	// How many requests remembered to write their responses to the same connection
+	pcapMaxRequests = 10000
+	// How many connections kept open, oldest are forgotten and started again with new handshake
+	pcapMaxConns = 10000
+	pcapSnapLen  = 262144
+)
+
+// Addresses used when payload has no connection info, e.g. captured by other inputs or written by older versions.
+// They are from ranges reserved for documentation.
+var (
+	pcapClientIP   = net.IPv4(192, 0, 2, 1)
+	pcapServerIP   = net.IPv4(192, 0, 2, 2)
+	pcapClientIPv6 = net.ParseIP("2001:db8::1")
+	pcapServerIPv6 = net.ParseIP("2001:db8::2")
+
+	pcapClientMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	pcapServerMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// pcapConn is synthesized TCP connection, keeping sequence numbers between requests
+type pcapConn struct {
+	client, server       *net.TCPAddr
+	clientSeq, serverSeq uint32
+}
+
+// PcapOutput writes requests and responses as TCP/IP packets to file in pcapng format,
+// or in pcap format if file has .pcap extension, to analyze traffic in Wireshark or tcpdump.
+// Payload timestamps, and client and server addresses captured by --input-raw are kept.
+type PcapOutput struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	pcapng bool
+	closed bool
+
+	// Client and server addresses -> connection
+	conns     map[string]*pcapConn
+	connOrder []string
+	// Request ID -> connection, to write responses to the same connection
+	requests     map[string]*pcapConn
+	requestOrder []string
+
+	// Used for connections without captured addresses
+	nextPort int
+}
+
+// NewPcapOutput constructor for PcapOutput, accepts file path
+func NewPcapOutput(path string) (*PcapOutput, error) {
+	o := &PcapOutput{
+		path:     path,
+		pcapng:   filepath.Ext(path) != ".pcap",
+		conns:    make(map[string]*pcapConn),
+		requests: make(map[string]*pcapConn),
+		nextPort: 1024,
+	}
+
+	var err error
+	if o.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660); err != nil {
+		return nil, err
+	}
+	o.writer = bufio.NewWriter(o.file)
+
+	if o.pcapng {
+		err = o.writePcapngHeader()
+	} else {
+		err = o.writePcapHeader()
+	}
+
+	if err != nil {
+		o.file.Close()
+		return nil, err
+	}
+
+	go o.flushLoop()
+
+	return o, nil
+}
+
+func (o *PcapOutput) writePcapHeader() error {
+	header := make([]byte, 24)
+	// Magic of nanosecond resolution
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b23c4d)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:24], uint32(layers.LinkTypeEthernet))
+
+	_, err := o.writer.Write(header)
+	return err
+}
+
+func (o *PcapOutput) writePcapngHeader() error {
+	// Section header block
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], 0x1A2B3C4D)
+	binary.LittleEndian.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint16(shb[6:8], 0)
+	// Section length is not specified
+	binary.LittleEndian.PutUint64(shb[8:16], 0xFFFFFFFFFFFFFFFF)
+
+	if err := o.writePcapngBlock(0x0A0D0D0A, shb); err != nil {
+		return err
+	}
+
+	// Interface description block, with if_tsresol option set to nanoseconds
+	idb := make([]byte, 8, 20)
+	binary.LittleEndian.PutUint16(idb[0:2], uint16(layers.LinkTypeEthernet))
+	binary.LittleEndian.PutUint32(idb[4:8], pcapSnapLen)
+	idb = append(idb, 9, 0, 1, 0, 9, 0, 0, 0)
+	idb = append(idb, 0, 0, 0, 0)
+
+	return o.writePcapngBlock(1, idb)
+}
+
+func (o *PcapOutput) writePcapngBlock(blockType uint32, body []byte) error {
+	padding := (4 - len(body)%4) % 4
+	length := uint32(12 + len(body) + padding)
+
+	var num [4]byte
+	binary.LittleEndian.PutUint32(num[:], blockType)
+	o.writer.Write(num[:])
+	binary.LittleEndian.PutUint32(num[:], length)
+	o.writer.Write(num[:])
+	o.writer.Write(body)
+	o.writer.Write(make([]byte, padding))
+
+	_, err := o.writer.Write(num[:])
+	return err
+}
+
+func (o *PcapOutput) writePacket(data []byte, t time.Time) error {
+	ts := uint64(t.UnixNano())
+
+	if o.pcapng {
+		epb := make([]byte, 20, 20+len(data))
+		binary.LittleEndian.PutUint32(epb[4:8], uint32(ts>>32))
+		binary.LittleEndian.PutUint32(epb[8:12], uint32(ts))
+		binary.LittleEndian.PutUint32(epb[12:16], uint32(len(data)))
+		binary.LittleEndian.PutUint32(epb[16:20], uint32(len(data)))
+
+		return o.writePcapngBlock(6, append(epb, data...))
+	}
+
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(ts/uint64(time.Second)))
+	binary.LittleEndian.PutUint32(header[4:8], uint32(ts%uint64(time.Second)))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[12:16], uint32(len(data)))
+
+	o.writer.Write(header)
+	_, err := o.writer.Write(data)
+	return err
+}
+
+// connection returns connection of payload, starting new one if needed
+func (o *PcapOutput) connection(data []byte, id string, t time.Time) (*pcapConn, error) {
+	client, server, ok := payloadConnection(data)
+
+	if !ok {
+		if conn, found := o.requests[id]; found && !isRequestPayload(data) {
+			return conn, nil
+		}
+
+		// Every request without address gets its own connection
+		client = &net.TCPAddr{IP: pcapClientIP, Port: o.nextPort}
+		server = &net.TCPAddr{IP: pcapServerIP, Port: 80}
+
+		if o.nextPort++; o.nextPort > 65535 {
+			o.nextPort = 1024
+		}
+	}
+
+	if len(server.IP) == 0 {
+		if client.IP.To4() != nil {
+			server.IP = pcapServerIP
+		} else {
+			server.IP = pcapServerIPv6
+		}
+	}
+
+	if len(client.IP) == 0 {
+		client.IP = pcapClientIPv6
+		if server.IP.To4() != nil {
+			client.IP = pcapClientIP
+		}
+	}
+
+	key := client.String() + ">" + server.String()
+	if conn, found := o.conns[key]; found {
+		return conn, nil
+	}
+
+	conn := &pcapConn{client: client, server: server}
+	conn.clientSeq = crc32.ChecksumIEEE([]byte(key))
+	conn.serverSeq = crc32.ChecksumIEEE([]byte(id))
+
+	// Handshake makes Wireshark follow the stream from the beginning
+	if err := o.writeSegment(conn, true, &layers.TCP{SYN: true}, nil, t); err != nil {
+		return nil, err
+	}
+	conn.clientSeq++
+	if err := o.writeSegment(conn, false, &layers.TCP{SYN: true, ACK: true}, nil, t); err != nil {
+		return nil, err
+	}
+	conn.serverSeq++
+	if err := o.writeSegment(conn, true, &layers.TCP{ACK: true}, nil, t); err != nil {
+		return nil, err
+	}
+
+	o.conns[key] = conn
+	o.connOrder = append(o.connOrder, key)
+
+	if len(o.connOrder) > pcapMaxConns {
+		delete(o.conns, o.connOrder[0])
+		o.connOrder = o.connOrder[1:]
+	}
+
+	return conn, nil
+}
+
+// writeSegment writes TCP segment from client or from server, sequence numbers are not changed
+func (o *PcapOutput) writeSegment(conn *pcapConn, fromClient bool, tcp *layers.TCP, payload []byte, t time.Time) error {
+	src, dst := conn.client, conn.server
+	srcMAC, dstMAC := pcapClientMAC, pcapServerMAC
+	tcp.Seq, tcp.Ack = conn.clientSeq, conn.serverSeq
+
+	if !fromClient {
+		src, dst = dst, src
+		srcMAC, dstMAC = dstMAC, srcMAC
+		tcp.Seq, tcp.Ack = conn.serverSeq, conn.clientSeq
+	}
+
+	// Ack number is set in all segments except the first SYN
+	if !tcp.SYN || tcp.ACK {
+		tcp.ACK = true
+	} else {
+		tcp.Ack = 0
+	}
+
+	tcp.SrcPort = layers.TCPPort(src.Port)
+	tcp.DstPort = layers.TCPPort(dst.Port)
+	tcp.Window = 65535
+
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dstMAC}
+
+	var network gopacket.SerializableLayer
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src.IP.To4(), DstIP: dst.IP.To4()}
+		tcp.SetNetworkLayerForChecksum(ip)
+		network = ip
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: src.IP.To16(), DstIP: dst.IP.To16()}
+		tcp.SetNetworkLayerForChecksum(ip)
+		network = ip
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, network, tcp, gopacket.Payload(payload)); err != nil {
+		return err
+	}
+
+	return o.writePacket(buf.Bytes(), t)
+}
+
+func (o *PcapOutput) Write(data []byte) (int, error) {
+	meta := payloadMeta(data)
+	if len(meta) < 3 {
+		return 0, errors.New("wrong payload header")
+	}
+
+	id := string(meta[1])
+	ts, _ := strconv.ParseInt(string(meta[2]), 10, 64)
+	t := time.Unix(0, ts)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return 0, errors.New("pcap output is closed")
+	}
+
+	conn, err := o.connection(data, id, t)
+	if err != nil {
+		return 0, err
+	}
+
+	fromClient := isRequestPayload(data)
+	if fromClient {
+		o.rememberRequest(id, conn)
+	}
+
+	body := payloadBody(data)
+	for len(body) > 0 {
+		n := len(body)
+		if n > pcapSegmentSize {
+			n = pcapSegmentSize
+		}
+
+		if err := o.writeSegment(conn, fromClient, &layers.TCP{PSH: n == len(body)}, body[:n], t); err != nil {
+			return 0, err
+		}
+
+		if fromClient {
+			conn.clientSeq += uint32(n)
+		} else {
+			conn.serverSeq += uint32(n)
+		}
+
+		body = body[n:]
+	}
+
+	return len(data), nil
+}
+
+func (o *PcapOutput) rememberRequest(id string, conn *pcapConn) {
+	if _, found := o.requests[id]; !found {
+		o.requestOrder = append(o.requestOrder, id)
+	}
+	o.requests[id] = conn
+
+	if len(o.requestOrder) > pcapMaxRequests {
+		delete(o.requests, o.requestOrder[0])
+		o.requestOrder = o.requestOrder[1:]
+	}
+}
+
+func (o *PcapOutput) flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		o.mu.Lock()
+		if o.closed {
+			o.mu.Unlock()
+			return
+		}
+		o.writer.Flush()
+		o.mu.Unlock()
+	}
+}
+
+func (o *PcapOutput) String() string {
+	return "Pcap output: " + o.path
+}
+
+func (o *PcapOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+
+	if err := o.writer.Flush(); err != nil {
+		o.file.Close()
+		return err
+	}
+
+	return o.file.Close()
+}
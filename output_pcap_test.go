@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type testPcapPacket struct {
+	timestamp time.Time
+	packet    gopacket.Packet
+}
+
+// readTestPcap parses packets of pcap or pcapng file written by PcapOutput
+func readTestPcap(t *testing.T, path string) (packets []testPcapPacket) {
+	data, _ := ioutil.ReadFile(path)
+	le := binary.LittleEndian
+
+	if le.Uint32(data[0:4]) == 0xa1b23c4d {
+		for data = data[24:]; len(data) > 0; {
+			ts := time.Unix(int64(le.Uint32(data[0:4])), int64(le.Uint32(data[4:8])))
+			size := int(le.Uint32(data[8:12]))
+
+			packets = append(packets, testPcapPacket{ts, gopacket.NewPacket(data[16:16+size], layers.LayerTypeEthernet, gopacket.Default)})
+			data = data[16+size:]
+		}
+
+		return
+	}
+
+	for len(data) > 0 {
+		blockType, length := le.Uint32(data[0:4]), int(le.Uint32(data[4:8]))
+		if le.Uint32(data[length-4:length]) != uint32(length) {
+			t.Fatal("Wrong pcapng block length")
+		}
+
+		if blockType == 6 {
+			ts := int64(le.Uint32(data[12:16]))<<32 | int64(le.Uint32(data[16:20]))
+			size := int(le.Uint32(data[20:24]))
+
+			packets = append(packets, testPcapPacket{time.Unix(0, ts), gopacket.NewPacket(data[28:28+size], layers.LayerTypeEthernet, gopacket.Default)})
+		}
+
+		data = data[length:]
+	}
+
+	return
+}
+
+func TestPayloadConnection(t *testing.T) {
+	header := payloadHeader(ResponsePayload, []byte("1"), 1, 10)
+	header = appendPayloadConnection(header, &net.TCPAddr{IP: net.ParseIP("::1"), Port: 5000}, &net.TCPAddr{Port: 80})
+
+	if string(header) != "2 1 1 10 [::1]:5000>:80\n" {
+		t.Errorf("Wrong header: %q", header)
+	}
+
+	client, server, ok := payloadConnection(header)
+	if !ok || client.String() != "[::1]:5000" || server.String() != ":80" {
+		t.Error("Should parse connection:", client, server, ok)
+	}
+
+	if payloadLatency(header) != 10 {
+		t.Error("Connection should not break latency")
+	}
+
+	if _, _, ok := payloadConnection(payloadHeader(RequestPayload, []byte("1"), 1, -1)); ok {
+		t.Error("Payload without connection")
+	}
+}
+
+func TestPcapOutput(t *testing.T) {
+	for _, ext := range []string{".pcapng", ".pcap"} {
+		f, _ := ioutil.TempFile("", "gor_pcap*"+ext)
+		f.Close()
+		defer os.Remove(f.Name())
+
+		output, err := NewPcapOutput(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Date(2016, 5, 1, 14, 0, 0, 123456789, time.UTC)
+		client := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53422}
+		server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 8080}
+
+		body := bytes.Repeat([]byte("a"), 2000)
+		request := appendPayloadConnection(payloadHeader(RequestPayload, []byte("1"), start.UnixNano(), -1), client, server)
+		request = append(request, append([]byte("POST / HTTP/1.1\r\nContent-Length: 2000\r\n\r\n"), body...)...)
+
+		response := appendPayloadConnection(payloadHeader(ResponsePayload, []byte("1"), start.Add(time.Millisecond).UnixNano(), 1000), client, server)
+		response = append(response, []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")...)
+
+		// Replayed response has no connection, and written to connection of request
+		replayed := append(payloadHeader(ReplayedResponsePayload, []byte("1"), start.Add(time.Second).UnixNano(), 1000), []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")...)
+
+		output.Write(request)
+		output.Write(response)
+		output.Write(replayed)
+		output.Close()
+
+		packets := readTestPcap(t, f.Name())
+
+		// Handshake, request in 2 segments, and 2 responses
+		if len(packets) != 7 {
+			t.Fatal(ext, "Wrong number of packets:", len(packets))
+		}
+
+		var reqData, respData []byte
+		for i, p := range packets {
+			if err := p.packet.ErrorLayer(); err != nil {
+				t.Fatal(ext, "Packet should be valid:", err.Error())
+			}
+
+			ip := p.packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+			tcp := p.packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+
+			if ip.SrcIP.Equal(client.IP) && int(tcp.SrcPort) == client.Port && ip.DstIP.Equal(server.IP) && int(tcp.DstPort) == server.Port {
+				reqData = append(reqData, tcp.Payload...)
+			} else if ip.SrcIP.Equal(server.IP) && int(tcp.SrcPort) == server.Port {
+				respData = append(respData, tcp.Payload...)
+			} else {
+				t.Error(ext, "Packet should keep addresses:", ip.SrcIP, tcp.SrcPort, ip.DstIP, tcp.DstPort)
+			}
+
+			if i == 0 && (!tcp.SYN || tcp.ACK) {
+				t.Error(ext, "Connection should start with handshake")
+			}
+
+			if i < 5 && !p.timestamp.Equal(start) {
+				t.Error(ext, "Should keep request timestamp:", p.timestamp)
+			}
+		}
+
+		if !bytes.Equal(reqData, payloadBody(request)) {
+			t.Error(ext, "Request should be split into segments")
+		}
+
+		if len(respData) != 2*len(payloadBody(response)) {
+			t.Error(ext, "Responses should be written to request connection:", len(respData))
+		}
+
+		if !packets[6].timestamp.Equal(start.Add(time.Second)) {
+			t.Error(ext, "Wrong timestamp of replayed response:", packets[6].timestamp)
+		}
+	}
+}
+
+func TestPcapOutputConnsLimit(t *testing.T) {
+	f, _ := ioutil.TempFile("", "gor_pcap*.pcap")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	output, err := NewPcapOutput(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	// Every request without captured addresses gets own connection
+	for i := 0; i < pcapMaxConns+10; i++ {
+		output.Write(append(payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1), []byte("GET / HTTP/1.1\r\n\r\n")...))
+	}
+
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	if len(output.conns) != pcapMaxConns || len(output.connOrder) != pcapMaxConns {
+		t.Error("Old connections should be forgotten:", len(output.conns), len(output.connOrder))
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How many times chunk upload is tried before reporting error
+const s3UploadAttempts = 3
+
+// S3Output writes payloads to local chunks using FileOutput, and uploads completed chunks to S3 in background.
+// Key template supports the same date variables as --output-file, e.g. `bucket/%Y%m%d/requests-%H.gor.gz`.
+type S3Output struct {
+	// Keep first for 64bit alignment of atomic operations on 32bit machines
+	// Chunks completed, but not uploaded yet
+	pending int64
+	failed  int64
+
+	bucket string
+	path   string
+	dir    string
+
+	client *s3Client
+	output *FileOutput
+
+	// Delay before the second upload attempt, doubled on each next one
+	backoff time.Duration
+
+	// Chunks waiting for upload. Queue is not limited, so writing is not blocked by slow uploads,
+	// chunks are already on disk.
+	mu      sync.Mutex
+	uploads []string
+	closed  bool
+	wakeup  chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewS3Output constructor for S3Output, accepts `bucket/key-template` path
+func NewS3Output(path string, s3config *S3Config, config *FileOutputConfig) (*S3Output, error) {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &S3Output{
+		bucket:  bucket,
+		path:    path,
+		client:  newS3Client(s3config),
+		backoff: time.Second,
+		wakeup:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	if o.dir, err = ioutil.TempDir("", "gor_s3"); err != nil {
+		return nil, err
+	}
+
+	fileConfig := *config
+	fileConfig.onClose = o.chunkClosed
+
+	o.output = NewFileOutput(filepath.Join(o.dir, filepath.FromSlash(key)), &fileConfig)
+
+	go o.uploader()
+
+	return o, nil
+}
+
+func (o *S3Output) chunkClosed(path string) {
+	atomic.AddInt64(&o.pending, 1)
+
+	o.mu.Lock()
+	o.uploads = append(o.uploads, path)
+	o.mu.Unlock()
+
+	o.wake()
+}
+
+func (o *S3Output) wake() {
+	select {
+	case o.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// nextUpload returns the oldest chunk waiting for upload, or false if output is closed and all chunks are taken
+func (o *S3Output) nextUpload() (string, bool) {
+	for {
+		o.mu.Lock()
+		if len(o.uploads) > 0 {
+			path := o.uploads[0]
+			o.uploads = o.uploads[1:]
+			o.mu.Unlock()
+
+			return path, true
+		}
+		closed := o.closed
+		o.mu.Unlock()
+
+		if closed {
+			return "", false
+		}
+
+		<-o.wakeup
+	}
+}
+
+func (o *S3Output) uploader() {
+	defer close(o.done)
+
+	for {
+		path, ok := o.nextUpload()
+		if !ok {
+			return
+		}
+
+		if err := o.upload(path); err != nil {
+			atomic.AddInt64(&o.failed, 1)
+			reportError(o, fmt.Errorf("chunk left in %s: %v", path, err))
+		} else {
+			os.Remove(path)
+		}
+
+		atomic.AddInt64(&o.pending, -1)
+	}
+}
+
+func (o *S3Output) upload(path string) (err error) {
+	rel, err := filepath.Rel(o.dir, path)
+	if err != nil {
+		return err
+	}
+	key := filepath.ToSlash(rel)
+
+	backoff := o.backoff
+	for attempt := 1; ; attempt++ {
+		if err = o.putFile(key, path); err == nil {
+			Debug("[OUTPUT-S3] Uploaded", path, "to", o.bucket+"/"+key)
+			return nil
+		}
+
+		if attempt == s3UploadAttempts {
+			return err
+		}
+
+		log.Println("[OUTPUT-S3] Upload failed, retrying:", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (o *S3Output) putFile(key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return o.client.PutObject(o.bucket, key, f, stat.Size())
+}
+
+func (o *S3Output) Write(data []byte) (int, error) {
+	return o.output.Write(data)
+}
+
+// closeOutput completes the last chunk, and stops uploader after it is uploaded
+func (o *S3Output) closeOutput() {
+	o.closeOnce.Do(func() {
+		o.output.Close()
+
+		o.mu.Lock()
+		o.closed = true
+		o.mu.Unlock()
+
+		o.wake()
+	})
+}
+
+// Drain completes current chunk and waits until all chunks are uploaded
+func (o *S3Output) Drain(deadline time.Time) error {
+	o.closeOutput()
+
+	if !waitUntil(deadline, func() bool { return atomic.LoadInt64(&o.pending) == 0 }) {
+		return fmt.Errorf("%d chunks are not uploaded in time, they are left in %s", atomic.LoadInt64(&o.pending), o.dir)
+	}
+
+	return nil
+}
+
+func (o *S3Output) gauges() map[string]int64 {
+	return map[string]int64{
+		"pending_uploads": atomic.LoadInt64(&o.pending),
+		"failed_uploads":  atomic.LoadInt64(&o.failed),
+	}
+}
+
+func (o *S3Output) String() string {
+	return "S3 output: " + o.path
+}
+
+func (o *S3Output) Close() error {
+	o.closeOutput()
+	<-o.done
+
+	// Chunks which failed to upload are kept
+	if atomic.LoadInt64(&o.failed) == 0 {
+		os.RemoveAll(o.dir)
+	}
+
+	return nil
+}
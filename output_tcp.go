@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,6 +14,10 @@ import (
 // Currently used for internal communication between listener and replay server
 // Can be used for transfering binary payloads like protocol buffers
 type TCPOutput struct {
+	// Keep first for 64bit alignment of atomic operations on 32bit machines
+	// Payloads written to the buffer, but not yet sent
+	pending int64
+
 	address  string
 	limit    int
 	buf      chan []byte
@@ -76,6 +81,8 @@ func (o *TCPOutput) worker() {
 			go o.worker()
 			break
 		}
+
+		atomic.AddInt64(&o.pending, -1)
 	}
 }
 
@@ -88,6 +95,7 @@ func (o *TCPOutput) Write(data []byte) (n int, err error) {
 	newBuf := make([]byte, len(data))
 	copy(newBuf, data)
 
+	atomic.AddInt64(&o.pending, 1)
 	o.buf <- newBuf
 
 	if Settings.outputTCPStats {
@@ -107,6 +115,22 @@ func (o *TCPOutput) connect(address string) (conn net.Conn, err error) {
 	return
 }
 
+// Drain waits until all buffered payloads are sent
+func (o *TCPOutput) Drain(deadline time.Time) error {
+	if !waitUntil(deadline, func() bool { return atomic.LoadInt64(&o.pending) == 0 }) {
+		return fmt.Errorf("%d payloads are not sent in time", atomic.LoadInt64(&o.pending))
+	}
+
+	return nil
+}
+
+func (o *TCPOutput) gauges() map[string]int64 {
+	return map[string]int64{
+		"queue_length":   int64(len(o.buf)),
+		"queue_capacity": int64(cap(o.buf)),
+	}
+}
+
 func (o *TCPOutput) String() string {
 	return fmt.Sprintf("TCP output %s, limit: %d", o.address, o.limit)
 }
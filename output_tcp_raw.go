@@ -0,0 +1,444 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buger/goreplay/protocol"
+)
+
+const (
+	// Messages of single captured connection waiting to be sent
+	tcpRawQueueSize = 100
+	// Sent requests waiting for responses per connection
+	tcpRawMaxInflight = 1000
+	// Silence after which response of protocol without parser is complete
+	tcpRawResponseGap = 100 * time.Millisecond
+)
+
+// TCPRawOutputConfig contains settings of raw TCP output
+type TCPRawOutputConfig struct {
+	timeout        time.Duration
+	trackResponses bool
+}
+
+// tcpRawMessage is request waiting to be sent
+type tcpRawMessage struct {
+	id   []byte
+	data []byte
+}
+
+// tcpRawRequest is sent request waiting for response
+type tcpRawRequest struct {
+	id        []byte
+	messageID []byte
+	start     time.Time
+}
+
+// tcpRawConn is socket which replays messages of one captured client connection
+type tcpRawConn struct {
+	key   string
+	queue chan tcpRawMessage
+	// Messages queued, but not taken by worker yet, guarded by TCPRawOutput.mu
+	queued int
+
+	// ID of captured connection, see payloadConnectionID
+	connID string
+	// Closed when captured client opened new connection from the same address,
+	// socket is closed after already queued messages are sent
+	stop chan struct{}
+
+	// Protocol of captured connection, parser is nil if protocol has no parser
+	protocol string
+	parser   protocol.Parser
+
+	mu       sync.Mutex
+	inflight []tcpRawRequest
+	byID     map[string]tcpRawRequest
+}
+
+// TCPRawOutput replays requests of non-HTTP protocols, captured by --input-raw-protocol.
+// Each captured client connection gets own socket, so protocol state like selected database or
+// authentication is kept, and messages are sent in the same order as captured.
+// Responses are split by protocol parser, and emitted as replayed responses if tracking is enabled.
+type TCPRawOutput struct {
+	// Keep first for 64bit alignment of atomic operations on 32bit machines
+	// Payloads written to output, but not yet sent
+	pending int64
+	dropped int64
+
+	address string
+	config  *TCPRawOutputConfig
+	timeout time.Duration
+
+	mu     sync.Mutex
+	conns  map[string]*tcpRawConn
+	closed bool
+
+	responses chan []byte
+	quit      chan struct{}
+}
+
+// NewTCPRawOutput constructor for TCPRawOutput, accepts address of replayed service
+func NewTCPRawOutput(address string, config *TCPRawOutputConfig) (*TCPRawOutput, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("output-tcp-raw: wrong address %q: %v", address, err)
+	}
+
+	o := &TCPRawOutput{
+		address:   address,
+		config:    config,
+		conns:     make(map[string]*tcpRawConn),
+		responses: make(chan []byte, 1000),
+		quit:      make(chan struct{}),
+	}
+
+	o.timeout = config.timeout
+	if o.timeout <= 0 {
+		o.timeout = time.Minute
+	}
+
+	return o, nil
+}
+
+func (o *TCPRawOutput) Write(data []byte) (int, error) {
+	// HTTP is replayed by --output-http
+	if !isRequestPayload(data) || payloadProtocol(data) == "http" {
+		return len(data), nil
+	}
+
+	meta := payloadMeta(data)
+	if len(meta) < 2 {
+		return len(data), nil
+	}
+
+	// All payloads without captured connection share one socket
+	var key string
+	if client, _, ok := payloadConnection(data); ok {
+		key = client.String()
+	}
+	connID := string(payloadConnectionID(data))
+
+	body := payloadBody(data)
+	if len(body) == 0 {
+		return len(data), nil
+	}
+
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return len(data), nil
+	}
+
+	conn, ok := o.conns[key]
+	if ok && conn.connID != connID {
+		// New captured connection gets new socket
+		close(conn.stop)
+		ok = false
+	}
+
+	if !ok {
+		conn = &tcpRawConn{
+			key:      key,
+			queue:    make(chan tcpRawMessage, tcpRawQueueSize),
+			connID:   connID,
+			stop:     make(chan struct{}),
+			protocol: payloadProtocol(data),
+			parser:   payloadParser(data),
+		}
+		o.conns[key] = conn
+		go o.worker(conn)
+	}
+	conn.queued++
+	o.mu.Unlock()
+
+	atomic.AddInt64(&o.pending, 1)
+
+	// Copy, because buffer is reused by emitter
+	msg := tcpRawMessage{
+		id:   append([]byte(nil), meta[1]...),
+		data: append([]byte(nil), body...),
+	}
+
+	select {
+	case conn.queue <- msg:
+	case <-o.quit:
+		atomic.AddInt64(&o.pending, -1)
+	}
+
+	return len(data), nil
+}
+
+// Read returns responses of replayed requests, if --output-tcp-raw-track-response is set
+func (o *TCPRawOutput) Read(data []byte) (int, error) {
+	var buf []byte
+
+	select {
+	case buf = <-o.responses:
+	case <-o.quit:
+		return 0, io.EOF
+	}
+
+	copy(data, buf)
+
+	return len(buf), nil
+}
+
+// worker sends messages of captured connection, and closes socket when connection is idle,
+// or when it is replaced by new connection of the same client
+func (o *TCPRawOutput) worker(c *tcpRawConn) {
+	var conn net.Conn
+
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	idle := time.NewTimer(o.timeout)
+	defer idle.Stop()
+
+	stop := c.stop
+	stopped := false
+
+	for {
+		select {
+		case msg := <-c.queue:
+			o.mu.Lock()
+			c.queued--
+			done := stopped && c.queued == 0
+			o.mu.Unlock()
+
+			conn = o.send(c, conn, msg)
+			atomic.AddInt64(&o.pending, -1)
+
+			if done {
+				return
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(o.timeout)
+		case <-stop:
+			// Connection is already removed from the map, so queued messages can't be added
+			stop, stopped = nil, true
+
+			o.mu.Lock()
+			done := c.queued == 0
+			o.mu.Unlock()
+
+			if done {
+				return
+			}
+		case <-idle.C:
+			o.mu.Lock()
+			if c.queued == 0 {
+				if !stopped {
+					delete(o.conns, c.key)
+				}
+				o.mu.Unlock()
+				return
+			}
+			o.mu.Unlock()
+
+			idle.Reset(o.timeout)
+		case <-o.quit:
+			return
+		}
+	}
+}
+
+// send writes message to the socket, connecting if needed. Returns socket to use for next messages.
+func (o *TCPRawOutput) send(c *tcpRawConn, conn net.Conn, msg tcpRawMessage) net.Conn {
+	var err error
+
+	if conn == nil {
+		if conn, err = net.DialTimeout("tcp", o.address, 5*time.Second); err != nil {
+			Debug("[OUTPUT-TCP-RAW] Can't connect:", err)
+			atomic.AddInt64(&o.dropped, 1)
+			return nil
+		}
+
+		if o.config.trackResponses {
+			c.mu.Lock()
+			c.inflight, c.byID = nil, nil
+			c.mu.Unlock()
+
+			go o.readResponses(c, conn)
+		} else {
+			// Responses should be read, so server is not blocked
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}
+
+	if o.config.trackResponses {
+		c.track(msg)
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(o.timeout))
+	if _, err = conn.Write(msg.data); err != nil {
+		Debug("[OUTPUT-TCP-RAW] Write failure, reconnecting:", err)
+		atomic.AddInt64(&o.dropped, 1)
+		conn.Close()
+		return nil
+	}
+
+	return conn
+}
+
+// track remembers sent request, to match it with response
+func (c *tcpRawConn) track(msg tcpRawMessage) {
+	req := tcpRawRequest{id: msg.id, start: time.Now()}
+	if c.parser != nil {
+		req.messageID = c.parser.MessageID(msg.data, true)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.messageID != nil {
+		if c.byID == nil || len(c.byID) >= tcpRawMaxInflight {
+			c.byID = make(map[string]tcpRawRequest)
+		}
+		c.byID[string(req.messageID)] = req
+		return
+	}
+
+	if len(c.inflight) >= tcpRawMaxInflight {
+		c.inflight = c.inflight[1:]
+	}
+	c.inflight = append(c.inflight, req)
+}
+
+// request returns sent request of the response, by message ID or in order
+func (c *tcpRawConn) request(resp []byte) (req tcpRawRequest, ok bool) {
+	var messageID []byte
+	if c.parser != nil {
+		messageID = c.parser.MessageID(resp, false)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if messageID != nil {
+		req, ok = c.byID[string(messageID)]
+		delete(c.byID, string(messageID))
+		return
+	}
+
+	if len(c.inflight) == 0 {
+		return
+	}
+
+	req = c.inflight[0]
+	c.inflight = c.inflight[1:]
+
+	return req, true
+}
+
+// readResponses splits data received from replayed service to responses, until socket is closed
+func (o *TCPRawOutput) readResponses(c *tcpRawConn, conn net.Conn) {
+	var buf []byte
+	chunk := make([]byte, 64*1024)
+
+	for {
+		// Protocol without parser: response is complete after short silence
+		if c.parser == nil && len(buf) > 0 {
+			conn.SetReadDeadline(time.Now().Add(tcpRawResponseGap))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && c.parser == nil {
+				o.emitResponse(c, buf)
+				buf = nil
+				continue
+			}
+
+			return
+		}
+
+		for c.parser != nil && len(buf) > 0 {
+			size, err := c.parser.Frame(buf, false)
+			if err != nil {
+				Debug("[OUTPUT-TCP-RAW] Can't parse response:", err)
+				io.Copy(ioutil.Discard, conn)
+				return
+			}
+
+			if size == 0 {
+				break
+			}
+
+			o.emitResponse(c, buf[:size])
+			buf = buf[size:]
+		}
+	}
+}
+
+// emitResponse sends response of tracked request to outputs
+func (o *TCPRawOutput) emitResponse(c *tcpRawConn, resp []byte) {
+	req, ok := c.request(resp)
+	if !ok {
+		return
+	}
+
+	header := payloadHeader(ReplayedResponsePayload, req.id, req.start.UnixNano(), time.Since(req.start).Nanoseconds())
+	if c.protocol != "http" {
+		header = appendPayloadProtocol(header, c.protocol)
+	}
+
+	select {
+	case o.responses <- append(header, resp...):
+	case <-o.quit:
+	}
+}
+
+// Drain waits until all written payloads are sent
+func (o *TCPRawOutput) Drain(deadline time.Time) error {
+	if !waitUntil(deadline, func() bool { return atomic.LoadInt64(&o.pending) == 0 }) {
+		return fmt.Errorf("%d payloads are not sent in time", atomic.LoadInt64(&o.pending))
+	}
+
+	return nil
+}
+
+func (o *TCPRawOutput) gauges() map[string]int64 {
+	o.mu.Lock()
+	conns := len(o.conns)
+	o.mu.Unlock()
+
+	return map[string]int64{
+		"connections":            int64(conns),
+		"pending_payloads":       atomic.LoadInt64(&o.pending),
+		"dropped_payloads":       atomic.LoadInt64(&o.dropped),
+		"responses_queue_length": int64(len(o.responses)),
+	}
+}
+
+func (o *TCPRawOutput) String() string {
+	return "Raw TCP output: " + o.address
+}
+
+// Close closes all sockets, payloads not sent yet are dropped
+func (o *TCPRawOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.closed {
+		o.closed = true
+		close(o.quit)
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTCPRawOutput(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	// Lines received by each socket
+	received := make(map[string][]string)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					mu.Lock()
+					received[conn.RemoteAddr().String()] = append(received[conn.RemoteAddr().String()], scanner.Text())
+					mu.Unlock()
+
+					conn.Write([]byte("+OK\r\n"))
+					wg.Done()
+				}
+			}()
+		}
+	}()
+
+	output, err := NewTCPRawOutput(listener.Addr().String(), &TCPRawOutputConfig{timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6379}
+	clients := []*net.TCPAddr{{IP: net.IPv4(10, 0, 0, 2), Port: 50000}, {IP: net.IPv4(10, 0, 0, 3), Port: 50000}}
+
+	for i := 0; i < 20; i++ {
+		client := clients[i%2]
+		id := uuid()
+
+		wg.Add(1)
+		header := appendPayloadProtocol(appendPayloadConnection(payloadHeader(RequestPayload, id, time.Now().UnixNano(), -1), client, server), "redis")
+		output.Write(append(header, []byte("SET "+client.IP.String()+" "+string(rune('a'+i))+"\r\n")...))
+
+		// Responses are not replayed
+		header = appendPayloadConnection(payloadHeader(ResponsePayload, id, time.Now().UnixNano(), 1), client, server)
+		output.Write(append(header, []byte("+OK\r\n")...))
+	}
+
+	// HTTP is replayed by --output-http
+	output.Write(append(payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1), []byte("GET / HTTP/1.1\r\n\r\n")...))
+
+	wg.Wait()
+
+	if err := output.Drain(time.Now().Add(time.Second)); err != nil {
+		t.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received) != 2 {
+		t.Fatalf("Each captured connection should have own socket: %v", received)
+	}
+
+	for _, lines := range received {
+		if len(lines) != 10 {
+			t.Errorf("Wrong number of messages: %v", lines)
+			continue
+		}
+
+		// Same client, and captured order
+		for i, line := range lines {
+			fields := strings.Fields(line)
+			if fields[1] != strings.Fields(lines[0])[1] || (i > 0 && fields[2] <= strings.Fields(lines[i-1])[2]) {
+				t.Errorf("Wrong order of messages: %v", lines)
+				break
+			}
+		}
+	}
+
+	if g := output.gauges(); g["connections"] != 2 || g["dropped_payloads"] != 0 {
+		t.Error("Wrong gauges:", g)
+	}
+}
+
+func TestTCPRawOutputIdle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	output, _ := NewTCPRawOutput(listener.Addr().String(), &TCPRawOutputConfig{timeout: 50 * time.Millisecond})
+	defer output.Close()
+
+	// Without captured connection
+	output.Write(append(appendPayloadProtocol(payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1), "redis"), []byte("PING\r\n")...))
+
+	conn := <-accepted
+	defer conn.Close()
+
+	buf := make([]byte, 6)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(buf); err != nil || string(buf) != "PING\r\n" {
+		t.Fatalf("Wrong message: %q %v", buf, err)
+	}
+
+	// Idle socket is closed
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("Socket should be closed")
+	}
+
+	if g := output.gauges(); g["connections"] != 0 {
+		t.Error("Idle connection should be removed", g)
+	}
+}
+
+func TestTCPRawOutputTrackResponses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			// Response is split between packets
+			conn.Write([]byte("$5\r\nval"))
+			time.Sleep(10 * time.Millisecond)
+			conn.Write([]byte(strings.Fields(scanner.Text())[1] + "\r\n"))
+		}
+	}()
+
+	output, _ := NewTCPRawOutput(listener.Addr().String(), &TCPRawOutputConfig{timeout: time.Minute, trackResponses: true})
+	defer output.Close()
+
+	client := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 50000}
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6379}
+
+	var ids []string
+	for _, key := range []string{"a1", "b2"} {
+		id := uuid()
+		ids = append(ids, string(id))
+
+		header := appendPayloadConnection(payloadHeader(RequestPayload, id, time.Now().UnixNano(), -1), client, server)
+		header = appendPayloadProtocol(header, "redis")
+		output.Write(append(header, []byte("GET "+key+"\r\n")...))
+	}
+
+	buf := make([]byte, 1024)
+	for i, expected := range []string{"$5\r\nvala1\r\n", "$5\r\nvalb2\r\n"} {
+		n, err := output.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := buf[:n]
+
+		if payload[0] != ReplayedResponsePayload || string(payloadMeta(payload)[1]) != ids[i] {
+			t.Errorf("Response should match request %s: %q", ids[i], payload)
+		}
+
+		if payloadProtocol(payload) != "redis" || string(payloadBody(payload)) != expected {
+			t.Errorf("Wrong response: %q", payload)
+		}
+	}
+
+	output.Close()
+	if _, err := output.Read(buf); err != io.EOF {
+		t.Error("Closed output should return EOF", err)
+	}
+}
+
+func TestTCPRawOutputReusedClientPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	output, _ := NewTCPRawOutput(listener.Addr().String(), &TCPRawOutputConfig{timeout: time.Minute})
+	defer output.Close()
+
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6379}
+	client := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 50000}
+
+	write := func(connID uint64, body string) {
+		header := appendPayloadConnection(payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1), client, server)
+		header = appendPayloadConnectionID(header, connID)
+		output.Write(append(appendPayloadProtocol(header, "redis"), body...))
+	}
+
+	// Payload without ID is ignored
+	output.Write([]byte("1\nPING\r\n"))
+
+	write(1, "SELECT 1\r\n")
+	first := <-accepted
+	defer first.Close()
+
+	// The same client address, but new captured connection
+	write(2, "PING\r\n")
+	second := <-accepted
+	defer second.Close()
+
+	buf := make([]byte, 10)
+	first.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := io.ReadFull(first, buf); err != nil || string(buf[:n]) != "SELECT 1\r\n" {
+		t.Fatalf("Wrong message: %q %v", buf[:n], err)
+	}
+
+	// Socket of previous connection is closed without waiting for timeout
+	if _, err := first.Read(buf); err != io.EOF {
+		t.Error("Previous socket should be closed:", err)
+	}
+
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := io.ReadFull(second, buf[:6]); err != nil || string(buf[:n]) != "PING\r\n" {
+		t.Errorf("Wrong message: %q %v", buf[:n], err)
+	}
+
+	if g := output.gauges(); g["connections"] != 1 {
+		t.Error("Only the new connection should be kept", g)
+	}
+}
+
+func TestPayloadParser(t *testing.T) {
+	header := payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1)
+
+	if p := payloadParser(appendPayloadProtocol(header, "redis")); p == nil || p.Name() != "redis" {
+		t.Error("Should create parser of payload protocol", p)
+	}
+
+	for i := 0; i < 2; i++ {
+		if p := payloadParser(appendPayloadProtocol(header, "tcp")); p != nil {
+			t.Error("Gap framing has no parser", p)
+		}
+	}
+
+	if p, ok := payloadParsers.Load("tcp"); !ok || p != nil {
+		t.Error("Protocol without parser should be cached as nil", p)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// pipelineSettings holds options of named pipeline, loaded from `pipelines` section of config file
+type pipelineSettings struct {
+	name     string
+	limit    string
+	settings *AppSettings
+}
+
+// Pipeline is a named set of inputs, filters, modifiers, middleware and outputs,
+// running side by side with the main one. Each payload routed by pipeline own modifier,
+// so same traffic can be filtered and rewritten differently for different outputs.
+//
+// Pipeline without own inputs receives copy of every payload read from main inputs.
+type Pipeline struct {
+	Name     string
+	Settings *AppSettings
+	Plugins  *InOutPlugins
+
+	limiter *Limiter
+}
+
+func (p *Pipeline) String() string {
+	return "Pipeline: " + p.Name
+}
+
+// Pipelines holds all the named pipelines
+var Pipelines []*Pipeline
+
+// How many payloads can wait in pipeline queue, when it is full new payloads are dropped,
+// so slow pipeline does not block main inputs and other pipelines
+const pipelineQueueSize = 1000
+
+// initPipelines initialize plugins of named pipelines, and connects pipelines without own inputs to main ones
+func initPipelines() error {
+	Pipelines = nil
+
+	for _, ps := range Settings.pipelines {
+		p := &Pipeline{
+			Name:     ps.name,
+			Settings: ps.settings,
+			Plugins:  new(InOutPlugins),
+		}
+
+		if err := p.Plugins.initPlugins(ps.settings); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+
+		if ps.limit != "" {
+			p.limiter = NewLimiter(nil, ps.limit).(*Limiter)
+		}
+
+		if len(p.Plugins.Outputs) == 0 {
+			return fmt.Errorf("Pipeline %s requires at least 1 output", p.Name)
+		}
+
+		Pipelines = append(Pipelines, p)
+	}
+
+	return routePipelines(Plugins, Pipelines)
+}
+
+// routePipelines makes main inputs to send copy of each payload to the pipelines which do not have own inputs
+func routePipelines(main *InOutPlugins, pipelines []*Pipeline) error {
+	var queues []*pipelineQueue
+
+	for _, p := range pipelines {
+		if len(p.Plugins.Inputs) == 0 {
+			q := newPipelineQueue()
+			p.Plugins.Inputs = append(p.Plugins.Inputs, q)
+			// Reports gauges
+			p.Plugins.All = append(p.Plugins.All, q)
+			setPluginLabel(q, "pipeline-queue")
+			queues = append(queues, q)
+
+			if len(main.Inputs) == 0 {
+				return fmt.Errorf("Pipeline %s has no inputs, and there are no main inputs to share", p.Name)
+			}
+		}
+	}
+
+	if len(queues) == 0 {
+		return nil
+	}
+
+	for _, q := range queues {
+		q.sources = len(main.Inputs)
+	}
+
+	for i, in := range main.Inputs {
+		main.Inputs[i] = &inputTee{Reader: in, queues: queues}
+	}
+
+	return nil
+}
+
+// inputTee sends copy of each payload read from input to pipeline queues
+type inputTee struct {
+	io.Reader
+	queues []*pipelineQueue
+	done   bool
+}
+
+func (t *inputTee) Read(data []byte) (n int, err error) {
+	n, err = t.Reader.Read(data)
+
+	if n > 0 {
+		for _, q := range t.queues {
+			q.push(data[:n])
+		}
+	}
+
+	if err == io.EOF && !t.done {
+		t.done = true
+		for _, q := range t.queues {
+			q.sourceDone()
+		}
+	}
+
+	return
+}
+
+func (t *inputTee) String() string {
+	return fmt.Sprint(t.Reader)
+}
+
+// pipelineQueue is an input of pipeline, which receives payloads from main inputs
+type pipelineQueue struct {
+	// Keep first for 64bit alignment of atomic operations on 32bit machines
+	dropped int64
+
+	data chan []byte
+
+	mu      sync.Mutex
+	sources int
+	closed  bool
+}
+
+func newPipelineQueue() *pipelineQueue {
+	return &pipelineQueue{data: make(chan []byte, pipelineQueueSize)}
+}
+
+// push never blocks, payload is dropped if pipeline does not keep up
+func (q *pipelineQueue) push(payload []byte) {
+	buf := make([]byte, len(payload))
+	copy(buf, payload)
+
+	select {
+	case q.data <- buf:
+	default:
+		atomic.AddInt64(&q.dropped, 1)
+	}
+}
+
+// sourceDone closes queue when all the main inputs reached EOF
+func (q *pipelineQueue) sourceDone() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sources--
+	if q.sources <= 0 && !q.closed {
+		q.closed = true
+		close(q.data)
+	}
+}
+
+func (q *pipelineQueue) Read(data []byte) (int, error) {
+	buf, ok := <-q.data
+	if !ok {
+		return 0, io.EOF
+	}
+
+	return copy(data, buf), nil
+}
+
+func (q *pipelineQueue) gauges() map[string]int64 {
+	return map[string]int64{
+		"queue_length":     int64(len(q.data)),
+		"dropped_payloads": atomic.LoadInt64(&q.dropped),
+	}
+}
+
+func (q *pipelineQueue) String() string {
+	return "Pipeline queue"
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buger/goreplay/proto"
+)
+
+func TestPipelinesRouting(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	input := NewTestInput()
+	input.skipHeader = true
+
+	var mu sync.Mutex
+	var v1Paths, v2Paths []string
+
+	v1 := &Pipeline{Name: "v1", Settings: new(AppSettings), Plugins: new(InOutPlugins)}
+	v1.Settings.modifierConfig.urlRegexp.Set("^/api/v1")
+	v1.Settings.modifierConfig.urlRewrite.Set("/api/v1/(.*):/v1/$1")
+	v1.Plugins.Outputs = []io.Writer{NewTestOutput(func(data []byte) {
+		mu.Lock()
+		v1Paths = append(v1Paths, string(proto.Path(payloadBody(data))))
+		mu.Unlock()
+		wg.Done()
+	})}
+
+	v2 := &Pipeline{Name: "v2", Settings: new(AppSettings), Plugins: new(InOutPlugins)}
+	v2.Settings.modifierConfig.urlRegexp.Set("^/api/v2")
+	v2.Plugins.Outputs = []io.Writer{NewTestOutput(func(data []byte) {
+		mu.Lock()
+		v2Paths = append(v2Paths, string(proto.Path(payloadBody(data))))
+		mu.Unlock()
+		wg.Done()
+	})}
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = nil
+	Pipelines = []*Pipeline{v1, v2}
+
+	for _, p := range Pipelines {
+		p.Settings.copyBufferSize = Settings.copyBufferSize
+	}
+
+	if err := routePipelines(Plugins, Pipelines); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		Start(quit)
+		done <- true
+	}()
+
+	wg.Add(3)
+
+	for _, path := range []string{"/api/v1/users", "/api/v2/users", "/static/main.css", "/api/v2/orders"} {
+		req := payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1)
+		req = append(req, []byte("GET "+path+" HTTP/1.1\r\nHost: www.w3.org\r\n\r\n")...)
+		input.EmitBytes(req)
+	}
+
+	wg.Wait()
+	close(quit)
+	<-done
+
+	if len(v1Paths) != 1 || v1Paths[0] != "/v1/users" {
+		t.Error("Pipeline v1 should receive only rewritten v1 requests:", v1Paths)
+	}
+
+	if len(v2Paths) != 2 || v2Paths[0] != "/api/v2/users" || v2Paths[1] != "/api/v2/orders" {
+		t.Error("Pipeline v2 should receive unmodified v2 requests:", v2Paths)
+	}
+
+	Pipelines = nil
+}
+
+func TestPipelinesWithoutInputs(t *testing.T) {
+	p := &Pipeline{Name: "v1", Settings: new(AppSettings), Plugins: new(InOutPlugins)}
+	p.Plugins.Outputs = []io.Writer{NewTestOutput(func(data []byte) {})}
+
+	if err := routePipelines(new(InOutPlugins), []*Pipeline{p}); err == nil {
+		t.Error("Should fail when there are no inputs to share")
+	}
+}
+
+func TestPipelineQueueFull(t *testing.T) {
+	q := newPipelineQueue()
+	q.sources = 1
+
+	// Stalled pipeline does not block main inputs
+	for i := 0; i < pipelineQueueSize+10; i++ {
+		q.push([]byte("1 1 1\nGET / HTTP/1.1\r\n\r\n"))
+	}
+
+	if g := q.gauges(); g["queue_length"] != pipelineQueueSize || g["dropped_payloads"] != 10 {
+		t.Error("Payloads should be dropped when queue is full", g)
+	}
+}
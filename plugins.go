@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"reflect"
 	"strings"
@@ -12,6 +13,9 @@ type InOutPlugins struct {
 	Inputs  []io.Reader
 	Outputs []io.Writer
 	All     []interface{}
+
+	// Restart policies by plugin option name, `*` for default
+	policies map[string]RestartPolicy
 }
 
 var pluginMu sync.Mutex
@@ -31,10 +35,11 @@ func extractLimitOptions(options string) (string, string) {
 	return split[0], ""
 }
 
-// Automatically detects type of plugin and initialize it
+// Automatically detects type of plugin and initialize it.
+// Kind is the option name of plugin, like `output-http`, used in errors and for choosing restart policy.
 //
 // See this article if curious about relfect stuff below: http://blog.burntsushi.net/type-parametric-functions-golang
-func registerPlugin(constructor interface{}, options ...interface{}) {
+func (plugins *InOutPlugins) registerPlugin(kind string, constructor interface{}, options ...interface{}) error {
 	var path, limit string
 	vc := reflect.ValueOf(constructor)
 
@@ -52,101 +57,288 @@ func registerPlugin(constructor interface{}, options ...interface{}) {
 		vo[0] = reflect.ValueOf(path)
 	}
 
-	// Calling our constructor with list of given options
-	plugin := vc.Call(vo)[0].Interface()
-	pluginWrapper := plugin
+	create := func() (plugin, pluginWrapper interface{}, err error) {
+		// Calling our constructor with list of given options
+		out := vc.Call(vo)
 
-	if limit != "" {
-		pluginWrapper = NewLimiter(plugin, limit)
-	} else {
-		pluginWrapper = plugin
+		// Constructors which can fail return error as the last value
+		if len(out) > 1 && !out[1].IsNil() {
+			return nil, nil, out[1].Interface().(error)
+		}
+
+		plugin = out[0].Interface()
+
+		if limit != "" {
+			pluginWrapper = NewLimiter(plugin, limit)
+		} else {
+			pluginWrapper = plugin
+		}
+
+		return
+	}
+
+	plugin, pluginWrapper, err := create()
+	if err != nil {
+		if path != "" {
+			return fmt.Errorf("--%s %s: %v", kind, path, err)
+		}
+		return fmt.Errorf("--%s: %v", kind, err)
 	}
 
 	_, isR := plugin.(io.Reader)
 	_, isW := plugin.(io.Writer)
 
+	// Failed plugin can be disabled or restarted only by supervisor, which replaces its instance
+	if policy := plugins.restartPolicy(kind); policy.Action != RestartAbort {
+		s := newSupervisedPlugin(kind, policy, plugin, pluginWrapper, create)
+
+		switch {
+		case isR && isW:
+			plugin = supervisedReadWriter{s}
+		case isR:
+			plugin = supervisedInput{s}
+		default:
+			plugin = supervisedOutput{s}
+		}
+
+		pluginWrapper = plugin
+	}
+
 	// Some of the output can be Readers as well because return responses
 	if isR && !isW {
-		Plugins.Inputs = append(Plugins.Inputs, pluginWrapper.(io.Reader))
+		plugins.Inputs = append(plugins.Inputs, pluginWrapper.(io.Reader))
 	}
 
 	if isW {
-		Plugins.Outputs = append(Plugins.Outputs, pluginWrapper.(io.Writer))
+		plugins.Outputs = append(plugins.Outputs, pluginWrapper.(io.Writer))
+	}
+
+	plugins.All = append(plugins.All, plugin)
+
+	label := kind
+	if path != "" {
+		label += " " + path
+	}
+	setPluginLabel(plugin, label)
+	setPluginLabel(pluginWrapper, label)
+
+	return nil
+}
+
+// restartPolicy returns restart policy of plugin kind, abort by default
+func (plugins *InOutPlugins) restartPolicy(kind string) RestartPolicy {
+	if p, ok := plugins.policies[kind]; ok {
+		return p
 	}
 
-	Plugins.All = append(Plugins.All, plugin)
+	if p, ok := plugins.policies["*"]; ok {
+		return p
+	}
+
+	return RestartPolicy{Action: RestartAbort}
 }
 
-// InitPlugins specify and initialize all available plugins
-func InitPlugins() {
+// InitPlugins specify and initialize all available plugins, including plugins of named pipelines
+func InitPlugins() error {
 	pluginMu.Lock()
 	defer pluginMu.Unlock()
 
-	for _, options := range Settings.inputDummy {
-		registerPlugin(NewDummyInput, options)
+	// Pipelines without own inputs get payloads of main inputs
+	for _, ps := range Settings.pipelines {
+		if needsPayloadConnection(ps.settings) {
+			Settings.payloadConnection = true
+		}
+	}
+
+	if err := Plugins.initPlugins(&Settings); err != nil {
+		return err
+	}
+
+	return initPipelines()
+}
+
+// needsPayloadConnection checks if outputs restore captured connections, so inputs should add addresses to payload header
+func needsPayloadConnection(s *AppSettings) bool {
+	return s.payloadConnection || len(s.outputPcap) > 0 || len(s.outputTCPRaw) > 0
+}
+
+// initPlugins initialize plugins using given settings
+func (plugins *InOutPlugins) initPlugins(s *AppSettings) (err error) {
+	if plugins.policies, err = parseRestartPolicies(s.restartPolicy); err != nil {
+		return err
 	}
 
-	for range Settings.outputDummy {
-		registerPlugin(NewDummyOutput)
+	if needsPayloadConnection(s) {
+		s.payloadConnection = true
 	}
+	s.inputRAWConfig.connection = s.payloadConnection
+	s.inputProxyConfig.connection = s.payloadConnection
 
-	if Settings.outputStdout {
-		registerPlugin(NewDummyOutput)
+	for _, options := range s.inputDummy {
+		if err := plugins.registerPlugin("input-dummy", NewDummyInput, options); err != nil {
+			return err
+		}
+	}
+
+	for range s.outputDummy {
+		if err := plugins.registerPlugin("output-dummy", NewDummyOutput); err != nil {
+			return err
+		}
 	}
 
-	if Settings.outputNull {
-		registerPlugin(NewNullOutput)
+	if s.outputStdout {
+		if err := plugins.registerPlugin("output-stdout", NewStdoutOutput, s.outputStdoutFormat); err != nil {
+			return err
+		}
+	}
+
+	if s.outputNull {
+		if err := plugins.registerPlugin("output-null", NewNullOutput); err != nil {
+			return err
+		}
 	}
 
 	engine := EnginePcap
-	if Settings.inputRAWEngine == "raw_socket" {
+	if s.inputRAWEngine == "raw_socket" {
 		engine = EngineRawSocket
-	} else if Settings.inputRAWEngine == "pcap_file" {
+	} else if s.inputRAWEngine == "pcap_file" {
 		engine = EnginePcapFile
 	}
 
-	for _, options := range Settings.inputRAW {
-		registerPlugin(NewRAWInput, options, engine, Settings.inputRAWTrackResponse, Settings.inputRAWExpire, Settings.inputRAWRealIPHeader, Settings.inputRAWBpfFilter, Settings.inputRAWTimestampType, Settings.inputRawBufferSize)
+	for _, options := range s.inputRAW {
+		if err := plugins.registerPlugin("input-raw", NewRAWInput, options, engine, s.inputRAWTrackResponse, s.inputRAWExpire, s.inputRAWRealIPHeader, s.inputRAWBpfFilter, s.inputRAWTimestampType, s.inputRawBufferSize, &s.inputRAWConfig); err != nil {
+			return err
+		}
 	}
 
-	for _, options := range Settings.inputTCP {
-		registerPlugin(NewTCPInput, options, &Settings.inputTCPConfig)
+	for _, options := range s.inputTCP {
+		if err := plugins.registerPlugin("input-tcp", NewTCPInput, options, &s.inputTCPConfig); err != nil {
+			return err
+		}
 	}
 
-	for _, options := range Settings.outputTCP {
-		registerPlugin(NewTCPOutput, options, &Settings.outputTCPConfig)
+	for _, options := range s.outputTCP {
+		if err := plugins.registerPlugin("output-tcp", NewTCPOutput, options, &s.outputTCPConfig); err != nil {
+			return err
+		}
 	}
 
-	for _, options := range Settings.inputFile {
-		registerPlugin(NewFileInput, options, Settings.inputFileLoop)
+	for _, options := range s.outputTCPRaw {
+		if err := plugins.registerPlugin("output-tcp-raw", NewTCPRawOutput, options, &s.outputTCPRawConfig); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.inputFile {
+		if err := plugins.registerPlugin("input-file", NewFileInput, options, &s.inputFileConfig); err != nil {
+			return err
+		}
+	}
+
+	if f := s.outputFileConfig.format; f != "" && f != CaptureFormatText && f != CaptureFormatBinary && f != CaptureFormatJSON {
+		return fmt.Errorf("--output-file-format should be %s, %s or %s, got %q", CaptureFormatText, CaptureFormatBinary, CaptureFormatJSON, f)
+	}
+
+	for _, options := range s.outputFile {
+		if err := checkCompressionLevel(options, s.outputFileConfig.compressionLevel); err != nil {
+			return fmt.Errorf("--output-file-compression-level: %v", err)
+		}
+
+		if err := plugins.registerPlugin("output-file", NewFileOutput, options, &s.outputFileConfig); err != nil {
+			return err
+		}
 	}
 
-	for _, options := range Settings.outputFile {
-		registerPlugin(NewFileOutput, options, &Settings.outputFileConfig)
+	for _, options := range s.outputPcap {
+		if err := plugins.registerPlugin("output-pcap", NewPcapOutput, options); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.inputHAR {
+		if err := plugins.registerPlugin("input-har", NewHARInput, options, &s.inputFileConfig); err != nil {
+			return err
+		}
 	}
 
-	for _, options := range Settings.inputHTTP {
-		registerPlugin(NewHTTPInput, options)
+	for _, options := range s.inputRequests {
+		if err := plugins.registerPlugin("input-requests", NewRequestsInput, options, &s.inputRequestsConfig, &s.inputFileConfig); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.inputOpenAPI {
+		if err := plugins.registerPlugin("input-openapi", NewOpenAPIInput, options, &s.inputOpenAPIConfig); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.outputHAR {
+		if err := plugins.registerPlugin("output-har", NewHAROutput, options); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.inputS3 {
+		if err := plugins.registerPlugin("input-s3", NewS3Input, options, &s.s3Config, &s.inputFileConfig); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.outputS3 {
+		if err := checkCompressionLevel(options, s.outputFileConfig.compressionLevel); err != nil {
+			return fmt.Errorf("--output-file-compression-level: %v", err)
+		}
+
+		if err := plugins.registerPlugin("output-s3", NewS3Output, options, &s.s3Config, &s.outputFileConfig); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.inputProxy {
+		if err := plugins.registerPlugin("input-proxy", NewProxyInput, options, &s.inputProxyConfig, s.inputRAWRealIPHeader, s.copyBufferSize); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.inputHTTP {
+		if err := plugins.registerPlugin("input-http", NewHTTPInput, options, &s.inputHTTPConfig); err != nil {
+			return err
+		}
 	}
 
 	// If we explicitly set Host header http output should not rewrite it
 	// Fix: https://github.com/buger/gor/issues/174
-	for _, header := range Settings.modifierConfig.headers {
+	for _, header := range s.modifierConfig.headers {
 		if header.Name == "Host" {
-			Settings.outputHTTPConfig.OriginalHost = true
+			s.outputHTTPConfig.OriginalHost = true
 			break
 		}
 	}
 
-	for _, options := range Settings.outputHTTP {
-		registerPlugin(NewHTTPOutput, options, &Settings.outputHTTPConfig)
+	for _, options := range s.outputHTTP {
+		if err := plugins.registerPlugin("output-http", NewHTTPOutput, options, &s.outputHTTPConfig); err != nil {
+			return err
+		}
+	}
+
+	for _, options := range s.outputDiff {
+		if err := plugins.registerPlugin("output-diff", NewDiffOutput, options, &s.outputDiffConfig); err != nil {
+			return err
+		}
 	}
 
-	if Settings.outputKafkaConfig.host != "" && Settings.outputKafkaConfig.topic != "" {
-		registerPlugin(NewKafkaOutput, "", &Settings.outputKafkaConfig)
+	if s.outputKafkaConfig.host != "" && s.outputKafkaConfig.topic != "" {
+		if err := plugins.registerPlugin("output-kafka", NewKafkaOutput, "", &s.outputKafkaConfig); err != nil {
+			return err
+		}
 	}
 
-	if Settings.inputKafkaConfig.host != "" && Settings.inputKafkaConfig.topic != "" {
-		registerPlugin(NewKafkaInput, "", &Settings.inputKafkaConfig)
+	if s.inputKafkaConfig.host != "" && s.inputKafkaConfig.topic != "" {
+		if err := plugins.registerPlugin("input-kafka", NewKafkaInput, "", &s.inputKafkaConfig); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
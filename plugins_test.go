@@ -14,7 +14,9 @@ func TestPluginsRegistration(t *testing.T) {
 	Settings.outputHTTP = MultiOption{"www.example.com|10"}
 	Settings.inputFile = MultiOption{"/dev/null"}
 
-	InitPlugins()
+	if err := InitPlugins(); err != nil {
+		t.Fatal(err)
+	}
 
 	if len(Plugins.Inputs) != 2 {
 		t.Errorf("Should be 2 inputs %d", len(Plugins.Inputs))
@@ -45,3 +47,56 @@ func TestPluginsRegistration(t *testing.T) {
 	}
 
 }
+
+func TestPluginsPayloadConnection(t *testing.T) {
+	s := new(AppSettings)
+	if err := new(InOutPlugins).initPlugins(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.inputRAWConfig.connection || s.inputProxyConfig.connection {
+		t.Error("Connection should not be added to payloads by default")
+	}
+
+	s.outputTCPRaw = MultiOption{"127.0.0.1:6379"}
+	plugins := new(InOutPlugins)
+	if err := plugins.initPlugins(s); err != nil {
+		t.Fatal(err)
+	}
+	defer plugins.Outputs[0].(io.Closer).Close()
+
+	if !s.inputRAWConfig.connection || !s.inputProxyConfig.connection {
+		t.Error("Output restoring connections requires connection in payloads")
+	}
+}
+
+func TestPluginsCompressionLevel(t *testing.T) {
+	cases := []struct {
+		path  string
+		level int
+		valid bool
+	}{
+		{"/tmp/requests.gor.gz", 9, true},
+		{"/tmp/requests.gor.gz", 10, false},
+		{"/tmp/requests.gor.zst", 22, true},
+		{"/tmp/requests.gor.zst", 23, false},
+		{"/tmp/requests.gor.lz4", 30, true},
+		{"/tmp/requests.gor", -1, false},
+	}
+
+	for _, c := range cases {
+		s := new(AppSettings)
+		s.outputFile = MultiOption{c.path}
+		s.outputFileConfig.compressionLevel = c.level
+
+		plugins := new(InOutPlugins)
+		err := plugins.initPlugins(s)
+		if (err == nil) != c.valid {
+			t.Errorf("%s level %d: unexpected result %v", c.path, c.level, err)
+		}
+
+		for _, o := range plugins.Outputs {
+			o.(io.Closer).Close()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// gRPC runs on top of HTTP/2. Captured HTTP/2 streams converted to HTTP/1.1 format,
+// with trailers appended to the headers, so gRPC payload looks like:
+//
+//	POST /helloworld.Greeter/SayHello HTTP/1.1\r\n
+//	Content-Type: application/grpc\r\n
+//	\r\n
+//	<length-prefixed messages>
+//
+//	HTTP/1.1 200 OK\r\n
+//	Content-Type: application/grpc\r\n
+//	Grpc-Status: 0\r\n
+//	\r\n
+//	<length-prefixed messages>
+
+// Each gRPC message prefixed by 1 byte compression flag and 4 bytes message length
+const grpcMessageHeaderLen = 5
+
+var bContentType = []byte("Content-Type")
+var bGRPCContentType = []byte("application/grpc")
+var bGRPCStatus = []byte("Grpc-Status")
+var bGRPCMessage = []byte("Grpc-Message")
+
+// GRPCMessage is a single message from gRPC request or response body
+type GRPCMessage struct {
+	Compressed bool
+	Data       []byte
+}
+
+// IsGRPC checks if payload Content-Type is `application/grpc` (including `application/grpc+proto` and etc.)
+func IsGRPC(payload []byte) bool {
+	return bytes.HasPrefix(Header(payload, bContentType), bGRPCContentType)
+}
+
+// GRPCMethod returns full method name `/pkg.Service/Method` and its parts.
+// If path is not a gRPC method, all values will be blank.
+func GRPCMethod(payload []byte) (fullMethod, service, method []byte) {
+	path := Path(payload)
+
+	if len(path) < 4 || path[0] != '/' {
+		return
+	}
+
+	sep := bytes.IndexByte(path[1:], '/') + 1
+	if sep < 2 || sep == len(path)-1 || bytes.IndexByte(path[sep+1:], '/') != -1 {
+		return
+	}
+
+	return path, path[1:sep], path[sep+1:]
+}
+
+// GRPCMessages splits payload body to length-prefixed gRPC messages.
+// Truncated message at the end of the body is ignored.
+func GRPCMessages(payload []byte) (messages []GRPCMessage) {
+	body := Body(payload)
+
+	for len(body) >= grpcMessageHeaderLen {
+		length := int(binary.BigEndian.Uint32(body[1:grpcMessageHeaderLen]))
+
+		if len(body)-grpcMessageHeaderLen < length {
+			break
+		}
+
+		messages = append(messages, GRPCMessage{
+			Compressed: body[0] == 1,
+			Data:       body[grpcMessageHeaderLen : grpcMessageHeaderLen+length],
+		})
+
+		body = body[grpcMessageHeaderLen+length:]
+	}
+
+	return
+}
+
+// GRPCStatus returns status code of gRPC response, sent by server as trailer
+func GRPCStatus(payload []byte) []byte {
+	return Header(payload, bGRPCStatus)
+}
+
+// GRPCStatusMessage returns error message of gRPC response
+func GRPCStatusMessage(payload []byte) []byte {
+	return Header(payload, bGRPCMessage)
+}
@@ -0,0 +1,65 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGRPCMethod(t *testing.T) {
+	var tests = []struct {
+		path    string
+		service string
+		method  string
+	}{
+		{"/helloworld.Greeter/SayHello", "helloworld.Greeter", "SayHello"},
+		{"/Greeter/SayHello", "Greeter", "SayHello"},
+		{"/", "", ""},
+		{"/helloworld.Greeter/", "", ""},
+		{"//SayHello", "", ""},
+		{"/a/b/c", "", ""},
+	}
+
+	for _, c := range tests {
+		payload := []byte("POST " + c.path + " HTTP/1.1\r\nContent-Type: application/grpc\r\n\r\n")
+
+		full, service, method := GRPCMethod(payload)
+
+		if string(service) != c.service || string(method) != c.method {
+			t.Error(c.path, "should be", c.service, c.method, "instead", string(service), string(method))
+		}
+
+		if c.service != "" && string(full) != c.path {
+			t.Error("Wrong full method name:", string(full))
+		}
+	}
+}
+
+func TestGRPCMessages(t *testing.T) {
+	payload := []byte("HTTP/1.1 200 OK\r\nContent-Type: application/grpc+proto\r\nGrpc-Status: 0\r\n\r\n")
+	payload = append(payload, 0, 0, 0, 0, 2, 'h', 'i')
+	payload = append(payload, 1, 0, 0, 0, 0)
+	// Truncated message
+	payload = append(payload, 0, 0, 0, 0, 5, 'a')
+
+	if !IsGRPC(payload) {
+		t.Error("Should detect gRPC payload")
+	}
+
+	if !bytes.Equal(GRPCStatus(payload), []byte("0")) {
+		t.Error("Wrong status:", string(GRPCStatus(payload)))
+	}
+
+	messages := GRPCMessages(payload)
+
+	if len(messages) != 2 {
+		t.Fatal("Should find 2 messages:", messages)
+	}
+
+	if messages[0].Compressed || string(messages[0].Data) != "hi" {
+		t.Error("Wrong first message:", messages[0])
+	}
+
+	if !messages[1].Compressed || len(messages[1].Data) != 0 {
+		t.Error("Wrong second message:", messages[1])
+	}
+}
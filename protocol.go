@@ -4,7 +4,15 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"net"
 	"strconv"
+	"sync"
+
+	"github.com/buger/goreplay/protocol"
+
+	// Protocols of captured traffic, see --input-raw-protocol
+	_ "github.com/buger/goreplay/protocol/http1"
+	_ "github.com/buger/goreplay/protocol/redis"
 )
 
 const (
@@ -76,6 +84,132 @@ func payloadHeader(payloadType byte, uuid []byte, timing int64, latency int64) (
 	return header
 }
 
+// appendPayloadConnection adds client and server addresses to payload header, as `client>server` meta field.
+// Server IP can be empty if capture engine does not provide it.
+func appendPayloadConnection(header []byte, client, server *net.TCPAddr) []byte {
+	conn := connAddrString(client) + ">" + connAddrString(server)
+
+	header = append(header[:len(header)-1], ' ')
+	return append(header, conn+"\n"...)
+}
+
+func connAddrString(addr *net.TCPAddr) string {
+	var ip string
+	if len(addr.IP) > 0 {
+		ip = addr.IP.String()
+	}
+
+	return net.JoinHostPort(ip, strconv.Itoa(addr.Port))
+}
+
+// payloadConnection returns client and server addresses, if they were added to payload header
+func payloadConnection(payload []byte) (client, server *net.TCPAddr, ok bool) {
+	meta := payloadMeta(payload)
+	if len(meta) < 4 {
+		return
+	}
+
+	for _, field := range meta[3:] {
+		i := bytes.IndexByte(field, '>')
+		if i == -1 {
+			continue
+		}
+
+		client, cErr := parseConnAddr(string(field[:i]))
+		server, sErr := parseConnAddr(string(field[i+1:]))
+
+		return client, server, cErr == nil && sErr == nil
+	}
+
+	return
+}
+
+func parseConnAddr(s string) (*net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}, nil
+}
+
+var payloadConnectionIDPrefix = []byte("conn=")
+
+// appendPayloadConnectionID adds ID of captured connection to payload header, as `conn=id` meta field.
+// Connections of the same client address have different IDs, e.g. when client port is reused.
+func appendPayloadConnectionID(header []byte, id uint64) []byte {
+	header = append(header[:len(header)-1], ' ')
+	header = append(header, payloadConnectionIDPrefix...)
+	return append(header, strconv.FormatUint(id, 16)+"\n"...)
+}
+
+// payloadConnectionID returns ID of captured connection, or nil if payload has no ID
+func payloadConnectionID(payload []byte) []byte {
+	meta := payloadMeta(payload)
+	if len(meta) < 4 {
+		return nil
+	}
+
+	for _, field := range meta[3:] {
+		if bytes.HasPrefix(field, payloadConnectionIDPrefix) {
+			return field[len(payloadConnectionIDPrefix):]
+		}
+	}
+
+	return nil
+}
+
+var payloadProtocolPrefix = []byte("proto=")
+
+// appendPayloadProtocol adds protocol of non-HTTP payload to its header, as `proto=name` meta field
+func appendPayloadProtocol(header []byte, name string) []byte {
+	header = append(header[:len(header)-1], ' ')
+	header = append(header, payloadProtocolPrefix...)
+	return append(header, name+"\n"...)
+}
+
+// payloadProtocol returns protocol of payload, payloads without protocol field are HTTP
+func payloadProtocol(payload []byte) string {
+	meta := payloadMeta(payload)
+	if len(meta) < 4 {
+		return "http"
+	}
+
+	for _, field := range meta[3:] {
+		if bytes.HasPrefix(field, payloadProtocolPrefix) {
+			return string(field[len(payloadProtocolPrefix):])
+		}
+	}
+
+	return "http"
+}
+
+// Parsers of payload protocols, created on first use. Protocols without parser are stored as nil.
+var payloadParsers sync.Map
+
+// payloadParser returns parser of payload protocol, nil if protocol has no parser, e.g. `tcp` split by idle gap
+func payloadParser(payload []byte) protocol.Parser {
+	name := payloadProtocol(payload)
+
+	if p, ok := payloadParsers.Load(name); ok {
+		parser, _ := p.(protocol.Parser)
+		return parser
+	}
+
+	parser, err := protocol.New(name)
+	if err != nil {
+		parser = nil
+	}
+	payloadParsers.Store(name, parser)
+
+	return parser
+}
+
 func payloadBody(payload []byte) []byte {
 	headerSize := bytes.IndexByte(payload, '\n')
 	return payload[headerSize+1:]
@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Generic framings for protocols without own parser
+func init() {
+	Register("line", func(args []string) (Parser, error) {
+		if len(args) > 0 {
+			return nil, errors.New("line has no arguments")
+		}
+
+		return Line{}, nil
+	})
+
+	Register("length", newLengthPrefixed)
+}
+
+// Line is text protocol where each message is a line, e.g. Memcached meta commands or SMTP.
+type Line struct{}
+
+// Name implements Parser
+func (Line) Name() string {
+	return "line"
+}
+
+// Frame implements Parser
+func (Line) Frame(data []byte, isRequest bool) (int, error) {
+	return bytes.IndexByte(data, '\n') + 1, nil
+}
+
+// MessageID implements Parser, responses follow requests in order
+func (Line) MessageID(message []byte, isRequest bool) []byte {
+	return nil
+}
+
+// Key implements Parser, key is the line itself
+func (Line) Key(request []byte) []byte {
+	return bytes.TrimRight(request, "\r\n")
+}
+
+// LengthPrefixed is binary protocol where each message starts with its length, not including length itself.
+type LengthPrefixed struct {
+	Size  int
+	Order binary.ByteOrder
+}
+
+// newLengthPrefixed creates parser from `length:<bytes>[:le]` spec, length is big-endian by default
+func newLengthPrefixed(args []string) (Parser, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errors.New("expected length:<bytes>[:le]")
+	}
+
+	size, err := strconv.Atoi(args[0])
+	if err != nil || (size != 1 && size != 2 && size != 4 && size != 8) {
+		return nil, errors.New("length prefix should be 1, 2, 4 or 8 bytes")
+	}
+
+	p := LengthPrefixed{Size: size, Order: binary.BigEndian}
+	if len(args) == 2 {
+		switch args[1] {
+		case "le":
+			p.Order = binary.LittleEndian
+		case "be":
+		default:
+			return nil, fmt.Errorf("unknown byte order %q, should be be or le", args[1])
+		}
+	}
+
+	return p, nil
+}
+
+// Name implements Parser
+func (p LengthPrefixed) Name() string {
+	name := "length:" + strconv.Itoa(p.Size)
+	if p.Order == binary.LittleEndian {
+		name += ":le"
+	}
+
+	return name
+}
+
+// Frame implements Parser
+func (p LengthPrefixed) Frame(data []byte, isRequest bool) (int, error) {
+	if len(data) < p.Size {
+		return 0, nil
+	}
+
+	var length uint64
+	switch p.Size {
+	case 1:
+		length = uint64(data[0])
+	case 2:
+		length = uint64(p.Order.Uint16(data))
+	case 4:
+		length = uint64(p.Order.Uint32(data))
+	case 8:
+		length = p.Order.Uint64(data)
+	}
+
+	if length > uint64(len(data)-p.Size) {
+		return 0, nil
+	}
+
+	return p.Size + int(length), nil
+}
+
+// MessageID implements Parser, responses follow requests in order
+func (LengthPrefixed) MessageID(message []byte, isRequest bool) []byte {
+	return nil
+}
+
+// Key implements Parser, binary messages have no key
+func (LengthPrefixed) Key(request []byte) []byte {
+	return nil
+}
@@ -0,0 +1,153 @@
+// Package http1 implements protocol parser of HTTP/1.x messages.
+//
+// Listener always captures HTTP with own engine, which handles `Expect: 100-continue`, HTTP/2 and TLS,
+// and never passes HTTP streams to this parser: it is used only by emitter and outputs.
+package http1
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/buger/goreplay/proto"
+	"github.com/buger/goreplay/protocol"
+)
+
+func init() {
+	protocol.Register("http", func(args []string) (protocol.Parser, error) {
+		if len(args) > 0 {
+			return nil, errors.New("http has no arguments")
+		}
+
+		return Parser{}, nil
+	})
+}
+
+var errFrame = errors.New("malformed HTTP message")
+
+var bHTTP = []byte("HTTP/")
+var bContentLength = []byte("Content-Length")
+var bTransferEncoding = []byte("Transfer-Encoding")
+var bChunked = []byte("chunked")
+
+// Parser splits stream to HTTP/1.x requests and responses.
+// Responses without Content-Length and chunked encoding end with connection, and are not framed.
+type Parser struct{}
+
+// Name implements protocol.Parser
+func (Parser) Name() string {
+	return "http"
+}
+
+// Frame implements protocol.Parser
+func (Parser) Frame(data []byte, isRequest bool) (int, error) {
+	if len(data) >= 5 {
+		if isRequest && !proto.IsHTTPPayload(data) || !isRequest && !bytes.HasPrefix(data, bHTTP) {
+			return 0, errFrame
+		}
+	}
+
+	headersEnd := bytes.Index(data, proto.EmptyLine)
+	if headersEnd == -1 {
+		return 0, nil
+	}
+	headersEnd += len(proto.EmptyLine)
+
+	headers := data[:headersEnd]
+
+	if bytes.Contains(bytes.ToLower(proto.Header(headers, bTransferEncoding)), bChunked) {
+		n, err := chunkedLen(data[headersEnd:])
+		if n == 0 || err != nil {
+			return 0, err
+		}
+
+		return headersEnd + n, nil
+	}
+
+	if cl := proto.Header(headers, bContentLength); len(cl) > 0 {
+		length, err := strconv.Atoi(string(bytes.TrimSpace(cl)))
+		if err != nil || length < 0 {
+			return 0, errFrame
+		}
+
+		if len(data) < headersEnd+length {
+			return 0, nil
+		}
+
+		return headersEnd + length, nil
+	}
+
+	if isRequest || !responseHasBody(headers) {
+		return headersEnd, nil
+	}
+
+	return 0, nil
+}
+
+// MessageID implements protocol.Parser, HTTP/1.x answers in order of requests
+func (Parser) MessageID(message []byte, isRequest bool) []byte {
+	return nil
+}
+
+// Key implements protocol.Parser, key is request method and path, e.g. `GET /index.html`
+func (Parser) Key(request []byte) []byte {
+	if !proto.IsHTTPPayload(request) || bytes.IndexByte(request, ' ') == -1 {
+		return nil
+	}
+
+	key := append([]byte(nil), proto.Method(request)...)
+	return append(append(key, ' '), proto.Path(request)...)
+}
+
+// responseHasBody checks if response without length has body till the end of connection
+func responseHasBody(headers []byte) bool {
+	status, _ := strconv.Atoi(string(proto.Status(headers)))
+
+	return !(status >= 100 && status < 200 || status == 204 || status == 304)
+}
+
+// chunkedLen returns length of chunked body including trailers, 0 if body is incomplete
+func chunkedLen(body []byte) (int, error) {
+	n := 0
+
+	for {
+		end := bytes.Index(body[n:], proto.CLRF)
+		if end == -1 {
+			return 0, nil
+		}
+
+		sizeField := body[n : n+end]
+		if i := bytes.IndexByte(sizeField, ';'); i != -1 {
+			sizeField = sizeField[:i]
+		}
+
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(sizeField)), 16, 64)
+		if err != nil || size < 0 {
+			return 0, errFrame
+		}
+
+		n += end + 2
+
+		if size == 0 {
+			break
+		}
+
+		n += int(size) + 2
+		if n > len(body) {
+			return 0, nil
+		}
+	}
+
+	// Trailers end with empty line
+	for {
+		end := bytes.Index(body[n:], proto.CLRF)
+		if end == -1 {
+			return 0, nil
+		}
+
+		n += end + 2
+		if end == 0 {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package http1
+
+import (
+	"testing"
+
+	"github.com/buger/goreplay/protocol"
+)
+
+func TestFrame(t *testing.T) {
+	cases := []struct {
+		data      string
+		isRequest bool
+		length    int
+		err       bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\nGET /next", true, 27, false},
+		{"GET / HTTP/1.1\r\nHost: a\r\n", true, 0, false},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloPOST", true, 43, false},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhel", true, 0, false},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\nGET", true, 62, false},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nTrailer: 1\r\n\r\n", true, 80, false},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel", true, 0, false},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n", true, 0, false},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false, 40, false},
+		{"HTTP/1.1 204 No Content\r\n\r\nHTTP", false, 27, false},
+		{"HTTP/1.1 200 OK\r\n\r\nuntil close", false, 0, false},
+		{"\x00\x01binary", true, 0, true},
+		{"GET / HTTP/1.1\r\n", false, 0, true},
+		{"POST / HTTP/1.1\r\nContent-Length: x\r\n\r\n", true, 0, true},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n", true, 0, true},
+	}
+
+	for _, c := range cases {
+		n, err := Parser{}.Frame([]byte(c.data), c.isRequest)
+		if n != c.length || (err != nil) != c.err {
+			t.Errorf("%q: expected %d, got %d, %v", c.data, c.length, n, err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	cases := []struct {
+		request string
+		key     string
+	}{
+		{"GET /index.html?a=1 HTTP/1.1\r\n\r\n", "GET /index.html?a=1"},
+		{"POST /api HTTP/1.1\r\nContent-Length: 0\r\n\r\n", "POST /api"},
+		{"HTTP/1.1 200 OK\r\n\r\n", ""},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if key := (Parser{}).Key([]byte(c.request)); string(key) != c.key {
+			t.Errorf("%q: expected key %q, got %q", c.request, c.key, key)
+		}
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	if parser, err := protocol.New("http"); err != nil || parser.Name() != "http" {
+		t.Error("Parser should be registered", err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Package protocol describes application protocols of captured traffic.
+
+Listener uses Parser to split TCP streams of non-HTTP protocols to messages and to match responses with requests,
+emitter and outputs use the same Parser to filter requests by their key and to read replayed responses.
+
+HTTP is not captured through Parser: ports with `http` protocol are always handled by listener's own engine,
+which also handles HTTP/2, TLS and `Expect: 100-continue`. Registered `http` parser is used only by emitter and outputs.
+
+New protocol is added as separate package which registers its parser, usually in init function:
+
+	func init() {
+		protocol.Register("redis", func(args []string) (protocol.Parser, error) { return Parser{}, nil })
+	}
+
+and is enabled by importing this package into the main package.
+*/
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Parser finds messages of the protocol in TCP stream. Parser should not keep state, it is used concurrently.
+type Parser interface {
+	// Name returns protocol spec, which creates the same parser using New, e.g. `length:4:le`
+	Name() string
+
+	// Frame returns length of the first complete message at the start of data, 0 if more data needed.
+	// Error means that data does not belong to the protocol, and rest of the stream should be ignored.
+	Frame(data []byte, isRequest bool) (int, error)
+
+	// MessageID returns ID which matches request with its response, for protocols which can answer out of order,
+	// e.g. stream ID of multiplexed protocols. Nil means that responses follow requests in the same order.
+	MessageID(message []byte, isRequest bool) []byte
+
+	// Key returns short description of the request, like HTTP method and path, or Redis command and key.
+	// Used by filters and for logging, nil if protocol has no such notion.
+	Key(request []byte) []byte
+}
+
+// Factory creates parser from arguments of protocol spec, e.g. ["4", "le"] for `length:4:le`
+type Factory func(args []string) (Parser, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes protocol available by name. It panics if protocol with the same name is already registered.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic("protocol: " + name + " is registered twice")
+	}
+
+	factories[name] = factory
+}
+
+// New creates parser from protocol spec: name of the protocol optionally followed by colon separated arguments
+func New(spec string) (Parser, error) {
+	parts := strings.Split(spec, ":")
+
+	mu.RLock()
+	factory, ok := factories[parts[0]]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %q, available: %s", spec, strings.Join(Names(), ", "))
+	}
+
+	parser, err := factory(parts[1:])
+	if err != nil {
+		return nil, fmt.Errorf("wrong protocol %q: %v", spec, err)
+	}
+
+	return parser, nil
+}
+
+// Names returns sorted names of registered protocols
+func Names() (names []string) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return
+}
@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestFraming(t *testing.T) {
+	cases := []struct {
+		spec   string
+		data   string
+		length int
+		key    string
+	}{
+		{"line", "get key1\r\n", 10, "get key1"},
+		{"line", "get", 0, "get"},
+		{"length:2", "\x00\x03abcd", 5, ""},
+		{"length:2:be", "\x00\x03ab", 0, ""},
+		{"length:4:le", "\x02\x00\x00\x00ab", 6, ""},
+		{"length:8", "\x00\x00\x00\x00\x00\x00\x00\x01a", 9, ""},
+		{"length:1", "", 0, ""},
+	}
+
+	for _, c := range cases {
+		parser, err := New(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := parser.Frame([]byte(c.data), true)
+		if n != c.length || err != nil {
+			t.Errorf("%s %q: expected %d, got %d, %v", c.spec, c.data, c.length, n, err)
+		}
+
+		if key := parser.Key([]byte(c.data)); string(key) != c.key {
+			t.Errorf("%s %q: wrong key %q", c.spec, c.data, key)
+		}
+
+		if parser.MessageID([]byte(c.data), true) != nil {
+			t.Errorf("%s: responses should be matched in order", c.spec)
+		}
+
+		// Name can be used to create the same parser
+		if same, err := New(parser.Name()); err != nil || same != parser {
+			t.Errorf("%s: wrong name %q", c.spec, parser.Name())
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, spec := range []string{"unknown", "line:1", "length", "length:3", "length:4:me", "length:2:le:be"} {
+		if _, err := New(spec); err == nil {
+			t.Errorf("%s: should fail", spec)
+		}
+	}
+
+	Register("test", func(args []string) (Parser, error) { return Line{}, nil })
+
+	if names := Names(); len(names) != 3 || names[0] != "length" || names[2] != "test" {
+		t.Error("Wrong protocols:", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Should not register protocol twice")
+		}
+	}()
+	Register("test", nil)
+}
@@ -0,0 +1,167 @@
+// Package redis implements protocol parser of Redis serialization protocol (RESP).
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/buger/goreplay/protocol"
+)
+
+func init() {
+	protocol.Register("redis", func(args []string) (protocol.Parser, error) {
+		if len(args) > 0 {
+			return nil, errors.New("redis has no arguments")
+		}
+
+		return Parser{}, nil
+	})
+}
+
+var errFrame = errors.New("malformed RESP message")
+
+var crlf = []byte("\r\n")
+
+// Parser splits stream to RESP values, pipelined commands are separate messages.
+// Commands sent inline, e.g. by telnet, are supported as well.
+type Parser struct{}
+
+// Name implements protocol.Parser
+func (Parser) Name() string {
+	return "redis"
+}
+
+// Frame implements protocol.Parser
+func (Parser) Frame(data []byte, isRequest bool) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	switch data[0] {
+	case '+', '-', ':', '*', '$':
+		return valueLen(data)
+	}
+
+	// Inline command
+	return bytes.IndexByte(data, '\n') + 1, nil
+}
+
+// MessageID implements protocol.Parser, Redis answers in order of commands
+func (Parser) MessageID(message []byte, isRequest bool) []byte {
+	return nil
+}
+
+// Key implements protocol.Parser, key is upper-cased command name followed by its first argument, e.g. `GET user:1`
+func (Parser) Key(request []byte) []byte {
+	args := Command(request)
+	if len(args) == 0 {
+		return nil
+	}
+
+	key := bytes.ToUpper(args[0])
+	if len(args) > 1 {
+		key = append(append(key, ' '), args[1]...)
+	}
+
+	return key
+}
+
+// Command returns command name and arguments of request
+func Command(request []byte) (args [][]byte) {
+	if len(request) == 0 {
+		return nil
+	}
+
+	if request[0] != '*' {
+		return bytes.Fields(request)
+	}
+
+	end := bytes.Index(request, crlf)
+	if end == -1 {
+		return nil
+	}
+
+	count, err := strconv.Atoi(string(request[1:end]))
+	if err != nil {
+		return nil
+	}
+
+	request = request[end+2:]
+	for i := 0; i < count; i++ {
+		if len(request) == 0 || request[0] != '$' {
+			return
+		}
+
+		end = bytes.Index(request, crlf)
+		if end == -1 {
+			return
+		}
+
+		size, err := strconv.Atoi(string(request[1:end]))
+		if err != nil || size < 0 || len(request) < end+2+size {
+			return
+		}
+
+		args = append(args, request[end+2:end+2+size])
+
+		if len(request) < end+2+size+2 {
+			return
+		}
+		request = request[end+2+size+2:]
+	}
+
+	return
+}
+
+// valueLen returns length of RESP value at the start of data, or 0 if it is incomplete
+func valueLen(data []byte) (int, error) {
+	end := bytes.Index(data, crlf)
+	if end == -1 {
+		return 0, nil
+	}
+
+	switch data[0] {
+	case '+', '-', ':':
+		return end + 2, nil
+	case '$':
+		size, err := strconv.Atoi(string(data[1:end]))
+		if err != nil || size < -1 {
+			return 0, errFrame
+		}
+
+		// Null bulk string
+		if size == -1 {
+			return end + 2, nil
+		}
+
+		n := end + 2 + size + 2
+		if len(data) < n {
+			return 0, nil
+		}
+
+		return n, nil
+	case '*':
+		count, err := strconv.Atoi(string(data[1:end]))
+		if err != nil || count < -1 {
+			return 0, errFrame
+		}
+
+		n := end + 2
+		for i := 0; i < count; i++ {
+			if n >= len(data) {
+				return 0, nil
+			}
+
+			l, err := valueLen(data[n:])
+			if l == 0 || err != nil {
+				return 0, err
+			}
+			n += l
+		}
+
+		return n, nil
+	}
+
+	return 0, errFrame
+}
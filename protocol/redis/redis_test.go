@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/buger/goreplay/protocol"
+)
+
+func TestFrame(t *testing.T) {
+	cases := []struct {
+		data   string
+		length int
+		err    bool
+	}{
+		{"+OK\r\n:1\r\n", 5, false},
+		{"-ERR unknown\r\n", 14, false},
+		{"*1\r\n*2\r\n:1\r\n$2\r\nab\r\n", 20, false},
+		{"*1\r\n$2\r\nab", 0, false},
+		{"*2\r\n$3\r\nGET\r\n", 0, false},
+		{"*-1\r\n", 5, false},
+		{"$-1\r\n", 5, false},
+		{"$3\r\nabc\r\n$", 9, false},
+		{"PING\r\nPING", 6, false},
+		{"", 0, false},
+		{"$abc\r\n", 0, true},
+		{"*1\r\n!\r\n", 0, true},
+	}
+
+	for _, c := range cases {
+		n, err := Parser{}.Frame([]byte(c.data), true)
+		if n != c.length || (err != nil) != c.err {
+			t.Errorf("%q: expected %d, got %d, %v", c.data, c.length, n, err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	cases := []struct {
+		request string
+		key     string
+	}{
+		{"*2\r\n$3\r\nget\r\n$6\r\nuser:1\r\n", "GET user:1"},
+		{"*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$5\r\nhello\r\n", "SET b"},
+		{"*1\r\n$4\r\nPING\r\n", "PING"},
+		{"ping\r\n", "PING"},
+		{"hget h f\r\n", "HGET h"},
+		{"*2\r\n$3\r\nGET\r\n$6\r\nus", "GET"},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if key := (Parser{}).Key([]byte(c.request)); string(key) != c.key {
+			t.Errorf("%q: expected key %q, got %q", c.request, c.key, key)
+		}
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	parser, err := protocol.New("redis")
+	if err != nil || parser.Name() != "redis" {
+		t.Error("Parser should be registered", err)
+	}
+
+	if _, err := protocol.New("redis:1"); err == nil {
+		t.Error("Should not accept arguments")
+	}
+}
@@ -0,0 +1,495 @@
+package rawSocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP/2 (h2c) support
+//
+// HTTP/2 multiplexes many requests over single long-living connection, so the HTTP/1 logic (message per Ack) can't be applied.
+// Once client connection preface is detected, all packets of that connection are handled here:
+// TCP stream is re-assembled for each direction, split to frames, headers decoded using connection HPACK state,
+// and each stream is emitted as separate request/response pair, converted to HTTP/1.1 format.
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// HTTP/2 connections without any packets during this period are dropped
+const http2ConnExpire = 5 * time.Minute
+
+const http2FrameHeaderLen = 9
+
+// Frame types
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FrameContinuation = 0x9
+)
+
+// Frame flags
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+const http2SettingHeaderTableSize = 0x1
+
+var errHTTP2Frame = errors.New("malformed HTTP/2 frame")
+
+// http2Direction re-assembles one direction of TCP stream and keeps its HPACK state
+type http2Direction struct {
+	tcpStream
+
+	prefaceRead bool
+
+	decoder *hpack.Decoder
+
+	// Header block split to HEADERS/PUSH_PROMISE + CONTINUATION frames
+	headerBlock     []byte
+	headerStream    uint32
+	headerEndStream bool
+	headerPromise   bool
+}
+
+// http2Half is request or response part of the stream
+type http2Half struct {
+	headers  []hpack.HeaderField
+	trailers []hpack.HeaderField
+	body     []byte
+	start    time.Time
+	end      time.Time
+	done     bool
+}
+
+type http2Stream struct {
+	id       uint32
+	ack      uint32
+	lastSeen time.Time
+
+	request  http2Half
+	response http2Half
+
+	requestMsg *TCPMessage
+}
+
+type http2Conn struct {
+	clientAddr []byte
+	serverAddr []byte
+	clientPort uint16
+
+	client http2Direction
+	server http2Direction
+
+	streams  map[uint32]*http2Stream
+	lastSeen time.Time
+}
+
+func newHTTP2Conn(packet *TCPPacket) *http2Conn {
+	c := &http2Conn{
+		clientAddr: packet.Addr,
+		clientPort: packet.SrcPort,
+		streams:    make(map[uint32]*http2Stream),
+	}
+
+	c.client.decoder = hpack.NewDecoder(4096, nil)
+	c.server.decoder = hpack.NewDecoder(4096, nil)
+
+	return c
+}
+
+// processHTTP2Packet returns true if packet belongs to HTTP/2 connection and was consumed
+func (t *Listener) processHTTP2Packet(packet *TCPPacket) bool {
+	isIncoming := packet.DestPort == t.port
+
+	key := packet.clientID(isIncoming)
+
+	conn, ok := t.http2Conns[key]
+	if !ok {
+		if !isIncoming || !bytes.HasPrefix(packet.Data, http2Preface) {
+			return false
+		}
+
+		conn = newHTTP2Conn(packet)
+		t.http2Conns[key] = conn
+	}
+
+	// Wall clock used for expiration, same as for HTTP/1 messages, packet timestamps can be in the past when reading pcap file
+	conn.lastSeen = time.Now()
+
+	dir := &conn.client
+	if !isIncoming {
+		dir = &conn.server
+		if conn.serverAddr == nil {
+			conn.serverAddr = packet.Addr
+		}
+	}
+
+	if err := t.http2ReadPacket(conn, dir, packet, isIncoming); err != nil || packet.IsFIN {
+		delete(t.http2Conns, key)
+	}
+
+	return true
+}
+
+// http2ReadPacket puts packet data in sequence order and process all completely received frames
+func (t *Listener) http2ReadPacket(conn *http2Conn, dir *http2Direction, packet *TCPPacket, isIncoming bool) error {
+	if len(packet.Data) == 0 {
+		return nil
+	}
+
+	dir.write(packet.Seq, packet.Data)
+
+	// Client connection starts with the preface, not a frame
+	if isIncoming && !dir.prefaceRead {
+		if len(dir.buf) < len(http2Preface) {
+			return nil
+		}
+
+		if !bytes.HasPrefix(dir.buf, http2Preface) {
+			return errHTTP2Frame
+		}
+
+		dir.buf = dir.buf[len(http2Preface):]
+		dir.prefaceRead = true
+	}
+
+	for len(dir.buf) >= http2FrameHeaderLen {
+		length := int(dir.buf[0])<<16 | int(dir.buf[1])<<8 | int(dir.buf[2])
+
+		if len(dir.buf) < http2FrameHeaderLen+length {
+			break
+		}
+
+		frameType := dir.buf[3]
+		flags := dir.buf[4]
+		streamID := binary.BigEndian.Uint32(dir.buf[5:9]) & 0x7fffffff
+		payload := dir.buf[http2FrameHeaderLen : http2FrameHeaderLen+length]
+
+		if err := t.http2ProcessFrame(conn, dir, packet, isIncoming, frameType, flags, streamID, payload); err != nil {
+			return err
+		}
+
+		dir.buf = dir.buf[http2FrameHeaderLen+length:]
+	}
+
+	if len(dir.buf) == 0 {
+		dir.buf = nil
+	}
+
+	return nil
+}
+
+func http2StripPadding(flags byte, payload []byte) ([]byte, error) {
+	if flags&http2FlagPadded == 0 {
+		return payload, nil
+	}
+
+	if len(payload) < 1 || int(payload[0]) >= len(payload) {
+		return nil, errHTTP2Frame
+	}
+
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+func (t *Listener) http2ProcessFrame(conn *http2Conn, dir *http2Direction, packet *TCPPacket, isIncoming bool, frameType, flags byte, streamID uint32, payload []byte) (err error) {
+	switch frameType {
+	case http2FrameData:
+		if payload, err = http2StripPadding(flags, payload); err != nil {
+			return
+		}
+
+		stream, ok := conn.streams[streamID]
+		if !ok {
+			return
+		}
+		stream.lastSeen = time.Now()
+
+		half := &stream.request
+		if !isIncoming {
+			half = &stream.response
+		}
+		half.body = append(half.body, payload...)
+
+		if flags&http2FlagEndStream != 0 {
+			t.http2EndStream(conn, stream, half, packet.timestamp)
+		}
+	case http2FrameHeaders, http2FramePushPromise:
+		if payload, err = http2StripPadding(flags, payload); err != nil {
+			return
+		}
+
+		if frameType == http2FramePushPromise {
+			// Promised stream ID
+			if len(payload) < 4 {
+				return errHTTP2Frame
+			}
+			payload = payload[4:]
+		} else if flags&http2FlagPriority != 0 {
+			// Stream dependency and weight
+			if len(payload) < 5 {
+				return errHTTP2Frame
+			}
+			payload = payload[5:]
+		}
+
+		dir.headerBlock = append(dir.headerBlock[:0], payload...)
+		dir.headerStream = streamID
+		dir.headerEndStream = flags&http2FlagEndStream != 0
+		dir.headerPromise = frameType == http2FramePushPromise
+
+		if flags&http2FlagEndHeaders != 0 {
+			return t.http2ProcessHeaders(conn, dir, packet, isIncoming)
+		}
+	case http2FrameContinuation:
+		if streamID != dir.headerStream {
+			return errHTTP2Frame
+		}
+
+		dir.headerBlock = append(dir.headerBlock, payload...)
+
+		if flags&http2FlagEndHeaders != 0 {
+			return t.http2ProcessHeaders(conn, dir, packet, isIncoming)
+		}
+	case http2FrameSettings:
+		if flags&http2FlagAck != 0 {
+			return
+		}
+
+		for i := 0; i+6 <= len(payload); i += 6 {
+			if binary.BigEndian.Uint16(payload[i:i+2]) == http2SettingHeaderTableSize {
+				// Setting limits table of the peer encoder
+				peer := &conn.server
+				if !isIncoming {
+					peer = &conn.client
+				}
+				peer.decoder.SetAllowedMaxDynamicTableSize(binary.BigEndian.Uint32(payload[i+2 : i+6]))
+			}
+		}
+	case http2FrameRSTStream:
+		delete(conn.streams, streamID)
+	}
+
+	return
+}
+
+// http2ProcessHeaders decodes complete header block. Blocks have to be decoded even for unknown streams to keep HPACK table in sync.
+func (t *Listener) http2ProcessHeaders(conn *http2Conn, dir *http2Direction, packet *TCPPacket, isIncoming bool) error {
+	fields, err := dir.decoder.DecodeFull(dir.headerBlock)
+	if err != nil {
+		return err
+	}
+	dir.headerBlock = dir.headerBlock[:0]
+
+	if dir.headerPromise {
+		return nil
+	}
+
+	stream, ok := conn.streams[dir.headerStream]
+
+	if isIncoming {
+		if !ok {
+			stream = &http2Stream{id: dir.headerStream, ack: packet.Ack}
+			stream.request.start = packet.timestamp
+			conn.streams[stream.id] = stream
+		}
+	} else if !ok {
+		return nil
+	}
+
+	stream.lastSeen = time.Now()
+
+	half := &stream.request
+	if !isIncoming {
+		half = &stream.response
+
+		// Skip informational 1xx responses
+		if half.headers == nil && strings.HasPrefix(http2HeaderValue(fields, ":status"), "1") {
+			return nil
+		}
+
+		if half.headers == nil {
+			half.start = packet.timestamp
+		}
+	}
+
+	if half.headers == nil {
+		half.headers = fields
+	} else {
+		half.trailers = fields
+	}
+
+	if dir.headerEndStream {
+		t.http2EndStream(conn, stream, half, packet.timestamp)
+	}
+
+	return nil
+}
+
+// http2EndStream emits request once it fully received, and response only after its request
+func (t *Listener) http2EndStream(conn *http2Conn, stream *http2Stream, half *http2Half, timestamp time.Time) {
+	half.done = true
+	half.end = timestamp
+
+	if stream.request.done && stream.requestMsg == nil {
+		stream.requestMsg = t.http2Message(conn, stream, true)
+		t.messagesChan <- stream.requestMsg
+
+		if !t.trackResponse {
+			delete(conn.streams, stream.id)
+			return
+		}
+	}
+
+	if stream.requestMsg != nil && stream.response.done {
+		resp := t.http2Message(conn, stream, false)
+		resp.setAssocMessage(stream.requestMsg)
+		stream.requestMsg.setAssocMessage(resp)
+
+		t.messagesChan <- resp
+
+		delete(conn.streams, stream.id)
+	}
+}
+
+// http2Message synthesize TCPMessage with single packet containing HTTP/1.1 representation of the stream
+func (t *Listener) http2Message(conn *http2Conn, stream *http2Stream, isIncoming bool) *TCPMessage {
+	var data []byte
+	var half *http2Half
+	var srcPort, destPort uint16
+	var addr []byte
+
+	if isIncoming {
+		half = &stream.request
+		data = http2RequestPayload(half)
+		srcPort, destPort = conn.clientPort, t.port
+		addr = conn.clientAddr
+	} else {
+		half = &stream.response
+		data = http2ResponsePayload(half)
+		srcPort, destPort = t.port, conn.clientPort
+		addr = conn.serverAddr
+	}
+
+	// Stream ID makes message unique when multiple streams share same TCP packet
+	ack := stream.ack + stream.id
+
+	raw := make([]byte, 16+len(data))
+	binary.BigEndian.PutUint16(raw[0:2], srcPort)
+	binary.BigEndian.PutUint16(raw[2:4], destPort)
+	binary.BigEndian.PutUint32(raw[4:8], stream.id)
+	binary.BigEndian.PutUint32(raw[8:12], ack)
+	raw[12] = 64
+	copy(raw[16:], data)
+
+	msg := NewTCPMessage(stream.id, ack, isIncoming, half.start)
+	msg.packets = []*TCPPacket{ParseTCPPacket(addr, raw, half.start)}
+	msg.End = half.end
+	msg.headerPacket = 0
+	msg.methodType = httpMethodKnown
+	msg.bodyType = httpBodyContentLength
+	msg.contentLength = len(half.body)
+	msg.complete = true
+
+	return msg
+}
+
+// http2HeaderValue returns value of the first field with given name, including pseudo headers
+func http2HeaderValue(fields []hpack.HeaderField, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+
+	return ""
+}
+
+// http2WriteHeaders writes regular (non pseudo) headers in HTTP/1.1 format. Split cookies joined back to single header.
+func http2WriteHeaders(b *bytes.Buffer, fields []hpack.HeaderField) {
+	var cookies []string
+
+	for _, f := range fields {
+		switch {
+		case strings.HasPrefix(f.Name, ":"), f.Name == "content-length":
+			continue
+		case f.Name == "cookie":
+			cookies = append(cookies, f.Value)
+			continue
+		}
+
+		b.WriteString(textproto.CanonicalMIMEHeaderKey(f.Name) + ": " + f.Value + "\r\n")
+	}
+
+	if len(cookies) > 0 {
+		b.WriteString("Cookie: " + strings.Join(cookies, "; ") + "\r\n")
+	}
+}
+
+func http2RequestPayload(h *http2Half) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(http2HeaderValue(h.headers, ":method") + " " + http2HeaderValue(h.headers, ":path") + " HTTP/1.1\r\n")
+
+	if authority := http2HeaderValue(h.headers, ":authority"); authority != "" && http2HeaderValue(h.headers, "host") == "" {
+		b.WriteString("Host: " + authority + "\r\n")
+	}
+
+	http2WriteHeaders(&b, h.headers)
+	http2WriteHeaders(&b, h.trailers)
+
+	if len(h.body) > 0 || http2HeaderValue(h.headers, "content-length") != "" {
+		b.WriteString("Content-Length: " + strconv.Itoa(len(h.body)) + "\r\n")
+	}
+
+	b.WriteString("\r\n")
+	b.Write(h.body)
+
+	return b.Bytes()
+}
+
+// http2ResponsePayload converts response to HTTP/1.1 format, trailers (like gRPC status) appended to the headers
+func http2ResponsePayload(h *http2Half) []byte {
+	var b bytes.Buffer
+
+	status := http2HeaderValue(h.headers, ":status")
+	code, _ := strconv.Atoi(status)
+
+	b.WriteString("HTTP/1.1 " + status + " " + http.StatusText(code) + "\r\n")
+
+	http2WriteHeaders(&b, h.headers)
+	http2WriteHeaders(&b, h.trailers)
+
+	b.WriteString("Content-Length: " + strconv.Itoa(len(h.body)) + "\r\n\r\n")
+	b.Write(h.body)
+
+	return b.Bytes()
+}
+
+// http2Cleanup removes idle connections and streams which were not completed in time
+func (t *Listener) http2Cleanup(now time.Time) {
+	for key, conn := range t.http2Conns {
+		if now.Sub(conn.lastSeen) >= http2ConnExpire {
+			delete(t.http2Conns, key)
+			continue
+		}
+
+		for id, stream := range conn.streams {
+			if now.Sub(stream.lastSeen) >= t.messageExpire {
+				delete(conn.streams, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package rawSocket
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// http2Peer writes frames of one side of the connection and splits them to TCP packets
+type http2Peer struct {
+	incoming bool
+	seq      uint32
+	// Client IP, set as source of incoming packets and destination of outgoing
+	clientIP []byte
+	buf      bytes.Buffer
+	framer   *http2.Framer
+	hbuf     bytes.Buffer
+	encoder  *hpack.Encoder
+}
+
+func newHTTP2Peer(incoming bool, seq uint32) *http2Peer {
+	p := &http2Peer{incoming: incoming, seq: seq}
+	p.framer = http2.NewFramer(&p.buf, nil)
+	p.encoder = hpack.NewEncoder(&p.hbuf)
+
+	return p
+}
+
+func (p *http2Peer) headers(fields ...string) []byte {
+	p.hbuf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		p.encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+
+	return append([]byte(nil), p.hbuf.Bytes()...)
+}
+
+// packet returns all written frames as single TCP packet
+func (p *http2Peer) packet() *TCPPacket {
+	data := append([]byte(nil), p.buf.Bytes()...)
+	p.buf.Reset()
+
+	packet := buildPacket(p.incoming, 1, p.seq, data, time.Now())
+	p.seq += uint32(len(data))
+
+	if p.clientIP != nil {
+		if p.incoming {
+			packet.Addr = p.clientIP
+			packet.GenID()
+		} else {
+			packet.DstAddr = p.clientIP
+		}
+	}
+
+	return packet
+}
+
+func receiveMessage(t *testing.T, l *Listener) *TCPMessage {
+	select {
+	case m := <-l.messagesChan:
+		return m
+	case <-time.After(20 * time.Millisecond):
+		t.Fatal("Should return message")
+	}
+
+	return nil
+}
+
+func TestHTTP2RequestResponse(t *testing.T) {
+	listener := NewListener("", "0", EnginePcap, true, 10*time.Millisecond, "", "", 0, false, false, "", nil)
+	defer listener.Close()
+
+	client := newHTTP2Peer(true, 1)
+	server := newHTTP2Peer(false, 1000)
+
+	client.buf.Write(http2Preface)
+	client.framer.WriteSettings()
+	client.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: client.headers(":method", "POST", ":scheme", "http", ":path", "/helloworld.Greeter/SayHello", ":authority", "localhost:50051", "content-type", "application/grpc", "cookie", "a=1", "cookie", "b=2"),
+		EndHeaders:    true,
+	})
+	listener.processTCPPacket(client.packet())
+
+	client.framer.WriteData(1, true, []byte("hello"))
+	listener.processTCPPacket(client.packet())
+
+	server.framer.WriteSettings()
+	server.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: server.headers(":status", "200", "content-type", "application/grpc"),
+		EndHeaders:    true,
+	})
+	server.framer.WriteData(1, false, []byte("world"))
+	server.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: server.headers("grpc-status", "0"),
+		EndHeaders:    true,
+		EndStream:     true,
+	})
+	listener.processTCPPacket(server.packet())
+
+	req := receiveMessage(t, listener)
+	resp := receiveMessage(t, listener)
+
+	expected := "POST /helloworld.Greeter/SayHello HTTP/1.1\r\nHost: localhost:50051\r\nContent-Type: application/grpc\r\nCookie: a=1; b=2\r\nContent-Length: 5\r\n\r\nhello"
+	if !req.IsIncoming || string(req.Bytes()) != expected {
+		t.Errorf("Wrong request:\n%q\n%q", req.Bytes(), expected)
+	}
+
+	expected = "HTTP/1.1 200 OK\r\nContent-Type: application/grpc\r\nGrpc-Status: 0\r\nContent-Length: 5\r\n\r\nworld"
+	if resp.IsIncoming || string(resp.Bytes()) != expected {
+		t.Errorf("Wrong response:\n%q\n%q", resp.Bytes(), expected)
+	}
+
+	if resp.AssocMessage != req || !bytes.Equal(req.UUID(), resp.UUID()) {
+		t.Error("Response should be associated with request")
+	}
+}
+
+func TestHTTP2MultiplexedStreams(t *testing.T) {
+	listener := NewListener("", "0", EnginePcap, true, 10*time.Millisecond, "", "", 0, false, false, "", nil)
+	defer listener.Close()
+
+	client := newHTTP2Peer(true, 1)
+	server := newHTTP2Peer(false, 1000)
+
+	client.buf.Write(http2Preface)
+	client.framer.WriteSettings()
+	listener.processTCPPacket(client.packet())
+
+	// Same headers in both streams, second one encoded using HPACK dynamic table
+	for _, id := range []uint32{1, 3} {
+		block := client.headers(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "example.com", "x-custom-header", "value")
+		client.framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      id,
+			BlockFragment: block[:3],
+			EndStream:     true,
+		})
+		client.framer.WriteContinuation(id, true, block[3:])
+	}
+	listener.processTCPPacket(client.packet())
+
+	for i := 0; i < 2; i++ {
+		if req := receiveMessage(t, listener); string(req.Bytes()) != "GET / HTTP/1.1\r\nHost: example.com\r\nX-Custom-Header: value\r\n\r\n" {
+			t.Errorf("Wrong request %d: %q", i, req.Bytes())
+		}
+	}
+
+	server.framer.WriteSettings()
+	listener.processTCPPacket(server.packet())
+
+	// Stream 3 response comes first, and packets re-ordered
+	server.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      3,
+		BlockFragment: server.headers(":status", "404"),
+		EndHeaders:    true,
+		EndStream:     true,
+	})
+	first := server.packet()
+
+	server.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: server.headers(":status", "200"),
+		EndHeaders:    true,
+	})
+	server.framer.WriteData(1, true, []byte("ok"))
+	second := server.packet()
+
+	listener.processTCPPacket(second)
+
+	select {
+	case m := <-listener.messagesChan:
+		t.Fatal("Should wait for missing packet", string(m.Bytes()))
+	default:
+	}
+
+	listener.processTCPPacket(first)
+
+	if resp := receiveMessage(t, listener); string(resp.Bytes()) != "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n" {
+		t.Errorf("Wrong response: %q", resp.Bytes())
+	}
+
+	if resp := receiveMessage(t, listener); string(resp.Bytes()) != "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok" {
+		t.Errorf("Wrong response: %q", resp.Bytes())
+	}
+}
+
+func TestHTTP2SamePortClients(t *testing.T) {
+	listener := NewListener("", "0", EnginePcap, true, 10*time.Millisecond, "", "", 0, false, false, "", nil)
+	defer listener.Close()
+
+	// Different clients using the same port
+	var clients, servers []*http2Peer
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		client := newHTTP2Peer(true, 1)
+		client.clientIP = net.ParseIP(ip).To4()
+		client.buf.Write(http2Preface)
+		client.framer.WriteSettings()
+		listener.processTCPPacket(client.packet())
+
+		server := newHTTP2Peer(false, 1000)
+		server.clientIP = client.clientIP
+
+		clients, servers = append(clients, client), append(servers, server)
+	}
+
+	for i, client := range clients {
+		client.framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      1,
+			BlockFragment: client.headers(":method", "GET", ":scheme", "http", ":path", "/"+strconv.Itoa(i), ":authority", "example.com"),
+			EndHeaders:    true,
+			EndStream:     true,
+		})
+		listener.processTCPPacket(client.packet())
+	}
+
+	var requests []*TCPMessage
+	for i := range clients {
+		req := receiveMessage(t, listener)
+		if !bytes.HasPrefix(req.Bytes(), []byte("GET /"+strconv.Itoa(i)+" ")) {
+			t.Errorf("Wrong request %d: %q", i, req.Bytes())
+		}
+		requests = append(requests, req)
+	}
+
+	// Second client gets response first
+	for i := len(servers) - 1; i >= 0; i-- {
+		servers[i].framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      1,
+			BlockFragment: servers[i].headers(":status", "200"),
+			EndHeaders:    true,
+			EndStream:     true,
+		})
+		listener.processTCPPacket(servers[i].packet())
+
+		if resp := receiveMessage(t, listener); resp.AssocMessage != requests[i] {
+			t.Errorf("Response should be associated with request of client %d", i)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/buger/goreplay/proto"
@@ -37,12 +38,17 @@ var _ = fmt.Println
 
 type packet struct {
 	srcIP     []byte
+	dstIP     []byte
 	data      []byte
 	timestamp time.Time
 }
 
 // Listener handle traffic capture
 type Listener struct {
+	// Keep first for 64bit alignment of atomic operations on 32bit machines
+	// Number of TCPMessages waiting for more packets, updated by listen loop
+	pendingMessages int64
+
 	mu sync.Mutex
 	// buffer of TCPMessages waiting to be send
 	// ID -> TCPMessage
@@ -60,6 +66,19 @@ type Listener struct {
 	// Ack -> ID
 	respWithoutReq map[uint32]tcpID
 
+	// Client IP and port -> HTTP/2 connection
+	http2Conns map[connID]*http2Conn
+
+	// Secrets for decryption of TLS connections, nil if not set
+	tlsKeyLog *tlsKeyLog
+	// Client IP and port -> TLS connection
+	tlsConns map[connID]*tlsConn
+
+	// Protocol agnostic mode, nil if traffic is HTTP
+	tcpConfig *TCPConfig
+	// Client IP and port -> connection of protocol agnostic mode
+	tcpConns map[connID]*tcpConn
+
 	// Messages ready to be send to client
 	packetsChan chan *packet
 
@@ -84,6 +103,7 @@ type Listener struct {
 
 	quit    chan bool
 	readyCh chan bool
+	errCh   chan error
 }
 
 type request struct {
@@ -100,19 +120,30 @@ const (
 )
 
 // NewListener creates and initializes new Listener object
-func NewListener(addr string, port string, engine int, trackResponse bool, expire time.Duration, bpfFilter string, timestampType string, bufferSize int, overrideSnapLen bool, immediateMode bool) (l *Listener) {
+func NewListener(addr string, port string, engine int, trackResponse bool, expire time.Duration, bpfFilter string, timestampType string, bufferSize int, overrideSnapLen bool, immediateMode bool, tlsKeyLogFile string, tcpConfig *TCPConfig) (l *Listener) {
 	l = &Listener{}
 
 	l.packetsChan = make(chan *packet, 10000)
 	l.messagesChan = make(chan *TCPMessage, 10000)
 	l.quit = make(chan bool)
 	l.readyCh = make(chan bool, 1)
+	l.errCh = make(chan error, 1)
 
 	l.messages = make(map[tcpID]*TCPMessage)
 	l.ackAliases = make(map[uint32]uint32)
 	l.seqWithData = make(map[uint32]uint32)
 	l.respAliases = make(map[uint32]*TCPMessage)
 	l.respWithoutReq = make(map[uint32]tcpID)
+	l.http2Conns = make(map[connID]*http2Conn)
+	l.tlsConns = make(map[connID]*tlsConn)
+	if tlsKeyLogFile != "" {
+		l.tlsKeyLog = newTLSKeyLog(tlsKeyLogFile)
+	}
+	l.tcpConns = make(map[connID]*tcpConn)
+	l.tcpConfig = tcpConfig
+	if tcpConfig != nil && tcpConfig.Gap <= 0 {
+		l.tcpConfig = &TCPConfig{Parser: tcpConfig.Parser, Gap: 100 * time.Millisecond}
+	}
 	l.trackResponse = trackResponse
 	l.bpfFilter = bpfFilter
 	l.timestampType = timestampType
@@ -148,7 +179,12 @@ func NewListener(addr string, port string, engine int, trackResponse bool, expir
 }
 
 func (t *Listener) listen() {
-	gcTicker := time.Tick(t.messageExpire / 2)
+	gcInterval := t.messageExpire / 2
+	// Messages of protocol agnostic mode can be finished by short idle gap
+	if t.tcpConfig != nil && t.tcpConfig.Parser == nil && t.tcpConfig.Gap/2 < gcInterval {
+		gcInterval = t.tcpConfig.Gap / 2
+	}
+	gcTicker := time.Tick(gcInterval)
 
 	for {
 		select {
@@ -159,7 +195,10 @@ func (t *Listener) listen() {
 			return
 		case packet := <-t.packetsChan:
 			tcpPacket := ParseTCPPacket(packet.srcIP, packet.data, packet.timestamp)
+			tcpPacket.DstAddr = packet.dstIP
 			t.processTCPPacket(tcpPacket)
+
+			atomic.StoreInt64(&t.pendingMessages, int64(len(t.messages)))
 		case <-gcTicker:
 			now := time.Now()
 
@@ -169,6 +208,12 @@ func (t *Listener) listen() {
 					t.dispatchMessage(message)
 				}
 			}
+
+			t.http2Cleanup(now)
+			t.tlsCleanup(now)
+			t.tcpCleanup(now)
+
+			atomic.StoreInt64(&t.pendingMessages, int64(len(t.messages)))
 		}
 	}
 }
@@ -298,7 +343,7 @@ func listenAllInterfaces(addr string) bool {
 func findPcapDevices(addr string) (interfaces []pcap.Interface, err error) {
 	devices, err := pcap.FindAllDevs()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	for _, device := range devices {
@@ -325,7 +370,8 @@ func findPcapDevices(addr string) (interfaces []pcap.Interface, err error) {
 func (t *Listener) readPcap() {
 	devices, err := findPcapDevices(t.addr)
 	if err != nil {
-		log.Fatal(err)
+		t.fail(err)
+		return
 	}
 
 	bpfSupported := true
@@ -579,7 +625,7 @@ func (t *Listener) readPcap() {
 						}
 					}
 
-					t.packetsChan <- t.buildPacket(srcIP, data, packet.Metadata().Timestamp)
+					t.packetsChan <- t.buildPacket(srcIP, dstIP, data, packet.Metadata().Timestamp)
 				}
 			}
 		}(d)
@@ -591,7 +637,7 @@ func (t *Listener) readPcap() {
 
 func (t *Listener) readPcapFile() {
 	if handle, err := pcap.OpenOffline(t.addr); err != nil {
-		log.Fatal(err)
+		t.fail(err)
 	} else {
 		if t.bpfFilter != "" {
 			if err := handle.SetBPFFilter(t.bpfFilter); err != nil {
@@ -612,7 +658,7 @@ func (t *Listener) readPcapFile() {
 				continue
 			}
 
-			var addr, data []byte
+			var addr, dstAddr, data []byte
 
 			if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
 				tcp, _ := tcpLayer.(*layers.TCP)
@@ -631,10 +677,10 @@ func (t *Listener) readPcapFile() {
 
 			if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
 				ip, _ := ipLayer.(*layers.IPv4)
-				addr = ip.SrcIP
+				addr, dstAddr = ip.SrcIP, ip.DstIP
 			} else if ipLayer = packet.Layer(layers.LayerTypeIPv6); ipLayer != nil {
 				ip, _ := ipLayer.(*layers.IPv6)
-				addr = ip.SrcIP
+				addr, dstAddr = ip.SrcIP, ip.DstIP
 			} else {
 				// log.Println("Can't find IP layer", packet)
 				continue
@@ -649,7 +695,7 @@ func (t *Listener) readPcapFile() {
 				continue
 			}
 
-			t.packetsChan <- t.buildPacket(addr, data, packet.Metadata().Timestamp)
+			t.packetsChan <- t.buildPacket(addr, dstAddr, data, packet.Metadata().Timestamp)
 		}
 	}
 }
@@ -659,13 +705,17 @@ func (t *Listener) readRAWSocket() {
 	t.conn = conn
 
 	if e != nil {
-		log.Fatal(e)
+		t.fail(e)
+		return
 	}
 
 	defer t.conn.Close()
 
 	buf := make([]byte, 64*1024) // 64kb
 
+	// Raw socket does not provide destination address, so it is known only if listening on specific IP
+	dstIP := []byte(net.ParseIP(t.addr))
+
 	t.readyCh <- true
 
 	for {
@@ -682,15 +732,16 @@ func (t *Listener) readRAWSocket() {
 
 		if n > 0 {
 			if t.isValidPacket(buf[:n]) {
-				t.packetsChan <- t.buildPacket([]byte(addr.(*net.IPAddr).IP), buf[:n], time.Now())
+				t.packetsChan <- t.buildPacket([]byte(addr.(*net.IPAddr).IP), dstIP, buf[:n], time.Now())
 			}
 		}
 	}
 }
 
-func (t *Listener) buildPacket(packetSrcIP []byte, packetData []byte, timestamp time.Time) *packet {
+func (t *Listener) buildPacket(packetSrcIP []byte, packetDstIP []byte, packetData []byte, timestamp time.Time) *packet {
 	return &packet{
 		srcIP:     packetSrcIP,
+		dstIP:     packetDstIP,
 		data:      packetData,
 		timestamp: timestamp,
 	}
@@ -729,6 +780,19 @@ func (t *Listener) processTCPPacket(packet *TCPPacket) {
 		}
 	}()
 
+	if t.processTLSPacket(packet) {
+		return
+	}
+
+	if t.tcpConfig != nil {
+		t.processGenericPacket(packet)
+		return
+	}
+
+	if t.processHTTP2Packet(packet) {
+		return
+	}
+
 	var responseRequest *TCPMessage
 	var message *TCPMessage
 
@@ -880,6 +944,32 @@ func (t *Listener) processTCPPacket(packet *TCPPacket) {
 	}
 }
 
+// fail reports error which stopped traffic interception
+func (t *Listener) fail(err error) {
+	select {
+	case t.errCh <- err:
+	default:
+	}
+}
+
+// Errors returns channel which receives error if listener stopped capturing traffic
+func (t *Listener) Errors() <-chan error {
+	return t.errCh
+}
+
+// Ready waits until listener starts capturing traffic, and returns error if it failed to start.
+// Listener which is not ready in 5 seconds considered as started.
+func (t *Listener) Ready() error {
+	select {
+	case <-t.readyCh:
+		return nil
+	case err := <-t.errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
 func (t *Listener) IsReady() bool {
 	select {
 	case <-t.readyCh:
@@ -889,6 +979,21 @@ func (t *Listener) IsReady() bool {
 	}
 }
 
+// PendingMessages returns number of TCP messages which are not yet complete
+func (t *Listener) PendingMessages() int {
+	return int(atomic.LoadInt64(&t.pendingMessages))
+}
+
+// QueuedPackets returns number of captured packets waiting to be processed
+func (t *Listener) QueuedPackets() int {
+	return len(t.packetsChan)
+}
+
+// QueuedMessages returns number of complete TCP messages waiting to be received
+func (t *Listener) QueuedMessages() int {
+	return len(t.messagesChan)
+}
+
 // Receive TCP messages from the listener channel
 func (t *Listener) Receiver() chan *TCPMessage {
 	return t.messagesChan
@@ -0,0 +1,270 @@
+package rawSocket
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/buger/goreplay/protocol"
+)
+
+// Connections of protocol agnostic mode without any packets during this period are dropped
+const tcpConnExpire = 5 * time.Minute
+
+// Out of order packets kept per direction, if there are more, some packet is lost and stream restarted
+const tcpMaxPending = 1000
+
+// Requests waiting for responses per connection, e.g. sent by pipelining client
+const tcpMaxRequests = 1000
+
+// TCPConfig turns on protocol agnostic mode of the listener: streams are not parsed by HTTP engine,
+// but split to messages by Parser. Without Parser message ends when the other side starts sending,
+// or when there were no packets during Gap.
+type TCPConfig struct {
+	Parser protocol.Parser
+	Gap    time.Duration
+}
+
+// tcpConn is TCP connection of protocol without HTTP semantics, see TCPConfig
+type tcpConn struct {
+	// Capture time of the first packet, in nanoseconds
+	id uint64
+
+	clientAddr []byte
+	serverAddr []byte
+	clientPort uint16
+
+	client tcpDirection
+	server tcpDirection
+
+	// Requests without responses yet, responses matched in order
+	requests []*TCPMessage
+	// Requests without responses yet, for protocols with message IDs
+	requestsByID map[string]*TCPMessage
+	// Number of emitted messages, makes IDs of messages unique
+	count uint32
+
+	// Parser failed to parse stream, rest of connection ignored
+	failed bool
+
+	lastSeen time.Time
+}
+
+type tcpDirection struct {
+	tcpStream
+
+	// Capture timestamps of first and last packets of buffered data
+	start, end time.Time
+	// Wall clock of last packet, used to find idle gap
+	lastSeen time.Time
+	// Side closed its half of connection, the other side still can send
+	fin bool
+}
+
+// processGenericPacket splits connection streams to messages in protocol agnostic mode
+func (t *Listener) processGenericPacket(packet *TCPPacket) {
+	isIncoming := packet.DestPort == t.port
+
+	key := packet.clientID(isIncoming)
+
+	conn, ok := t.tcpConns[key]
+	if !ok {
+		// Connection is closed, or we see the end of connection started before capture
+		if len(packet.Data) == 0 {
+			return
+		}
+
+		clientPort := packet.SrcPort
+		if !isIncoming {
+			clientPort = packet.DestPort
+		}
+
+		conn = &tcpConn{id: uint64(packet.timestamp.UnixNano()), clientPort: clientPort}
+		t.tcpConns[key] = conn
+	}
+
+	conn.lastSeen = time.Now()
+
+	dir, other := &conn.client, &conn.server
+	if isIncoming {
+		if conn.clientAddr == nil {
+			conn.clientAddr, conn.serverAddr = packet.Addr, packet.DstAddr
+		}
+	} else {
+		dir, other = &conn.server, &conn.client
+		if conn.clientAddr == nil {
+			conn.clientAddr, conn.serverAddr = packet.DstAddr, packet.Addr
+		}
+	}
+
+	if len(packet.Data) > 0 && !conn.failed {
+		if t.tcpConfig.Parser == nil {
+			// The other side answers, so its message is complete
+			if len(other.buf) > 0 {
+				t.tcpFlush(conn, other, !isIncoming)
+			}
+
+			if len(dir.buf) > 0 && packet.timestamp.Sub(dir.end) >= t.tcpConfig.Gap {
+				t.tcpFlush(conn, dir, isIncoming)
+			}
+		}
+
+		// Missing packet is lost, continue from current one
+		if len(dir.pending) >= tcpMaxPending {
+			dir.tcpStream = tcpStream{}
+		}
+
+		if len(dir.buf) == 0 {
+			dir.start = packet.timestamp
+		}
+		dir.end = packet.timestamp
+		dir.lastSeen = conn.lastSeen
+
+		dir.write(packet.Seq, packet.Data)
+
+		if t.tcpConfig.Parser != nil {
+			t.tcpFrame(conn, dir, isIncoming)
+		}
+	}
+
+	// Unframed data is the last message of the side, e.g. HTTP response without length.
+	// After half-close the other side still can answer, so connection is kept until both sides are closed,
+	// or until client closes it if responses are not tracked.
+	if packet.IsFIN {
+		if !conn.failed {
+			t.tcpFlush(conn, dir, isIncoming)
+		}
+		dir.fin = true
+
+		if conn.client.fin && (conn.server.fin || !t.trackResponse) {
+			delete(t.tcpConns, key)
+		}
+	}
+}
+
+// tcpFrame emits all complete messages found by framer
+func (t *Listener) tcpFrame(conn *tcpConn, dir *tcpDirection, isIncoming bool) {
+	for len(dir.buf) > 0 {
+		n, err := t.tcpConfig.Parser.Frame(dir.buf, isIncoming)
+		if err != nil {
+			log.Println("Can't split TCP stream to messages:", err)
+
+			conn.failed = true
+			conn.client.buf, conn.server.buf = nil, nil
+			conn.requests, conn.requestsByID = nil, nil
+			return
+		}
+
+		if n == 0 {
+			return
+		}
+
+		t.tcpEmit(conn, dir.buf[:n], dir.start, dir.end, isIncoming)
+		dir.buf = dir.buf[n:]
+		dir.start = dir.end
+	}
+
+	// Reclaim memory of already emitted data
+	dir.buf = append([]byte(nil), dir.buf...)
+}
+
+// tcpFlush emits all buffered data of the direction as single message
+func (t *Listener) tcpFlush(conn *tcpConn, dir *tcpDirection, isIncoming bool) {
+	if len(dir.buf) == 0 {
+		return
+	}
+
+	t.tcpEmit(conn, dir.buf, dir.start, dir.end, isIncoming)
+	dir.buf = nil
+}
+
+// tcpEmit synthesize TCPMessage with single packet, responses are matched to requests by ID, or in order
+func (t *Listener) tcpEmit(conn *tcpConn, data []byte, start, end time.Time, isIncoming bool) {
+	var req *TCPMessage
+	var id []byte
+
+	if t.tcpConfig.Parser != nil && t.trackResponse {
+		id = t.tcpConfig.Parser.MessageID(data, isIncoming)
+	}
+
+	if !isIncoming {
+		if !t.trackResponse {
+			return
+		}
+
+		if id != nil {
+			req = conn.requestsByID[string(id)]
+			delete(conn.requestsByID, string(id))
+		} else if len(conn.requests) > 0 {
+			req = conn.requests[0]
+			conn.requests = conn.requests[1:]
+		}
+
+		// Response to request sent before capture, or message sent by server itself, e.g. greeting
+		if req == nil {
+			return
+		}
+	}
+
+	conn.count++
+
+	srcPort, destPort := conn.clientPort, t.port
+	addr, dstAddr := conn.clientAddr, conn.serverAddr
+	if !isIncoming {
+		srcPort, destPort = t.port, conn.clientPort
+		addr, dstAddr = conn.serverAddr, conn.clientAddr
+	}
+
+	raw := make([]byte, 16+len(data))
+	binary.BigEndian.PutUint16(raw[0:2], srcPort)
+	binary.BigEndian.PutUint16(raw[2:4], destPort)
+	binary.BigEndian.PutUint32(raw[4:8], conn.count)
+	binary.BigEndian.PutUint32(raw[8:12], conn.count)
+	raw[12] = 64
+	copy(raw[16:], data)
+
+	packet := ParseTCPPacket(addr, raw, start)
+	packet.DstAddr = dstAddr
+
+	msg := NewTCPMessage(conn.count, conn.count, isIncoming, start)
+	msg.packets = []*TCPPacket{packet}
+	msg.End = end
+	msg.complete = true
+	msg.AssocMessage = req
+	msg.ConnectionID = conn.id
+
+	if isIncoming && t.trackResponse {
+		if id != nil {
+			if conn.requestsByID == nil || len(conn.requestsByID) >= tcpMaxRequests {
+				conn.requestsByID = make(map[string]*TCPMessage)
+			}
+			conn.requestsByID[string(id)] = msg
+		} else {
+			if len(conn.requests) >= tcpMaxRequests {
+				conn.requests = conn.requests[1:]
+			}
+			conn.requests = append(conn.requests, msg)
+		}
+	}
+
+	t.messagesChan <- msg
+}
+
+// tcpCleanup emits messages finished by idle gap, and removes idle connections
+func (t *Listener) tcpCleanup(now time.Time) {
+	for key, conn := range t.tcpConns {
+		if t.tcpConfig.Parser == nil && !conn.failed {
+			if now.Sub(conn.client.lastSeen) >= t.tcpConfig.Gap {
+				t.tcpFlush(conn, &conn.client, true)
+			}
+
+			if now.Sub(conn.server.lastSeen) >= t.tcpConfig.Gap {
+				t.tcpFlush(conn, &conn.server, false)
+			}
+		}
+
+		if now.Sub(conn.lastSeen) >= tcpConnExpire {
+			delete(t.tcpConns, key)
+		}
+	}
+}
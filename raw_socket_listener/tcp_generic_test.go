@@ -0,0 +1,234 @@
+package rawSocket
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/buger/goreplay/protocol"
+	"github.com/buger/goreplay/protocol/redis"
+)
+
+func newTCPTestListener(t *testing.T, parser protocol.Parser) *Listener {
+	// Long gap, so idle connections are flushed only by test
+	return NewListener("", "0", EnginePcap, true, time.Hour, "", "", 0, false, false, "", &TCPConfig{Parser: parser, Gap: time.Hour})
+}
+
+func expectNoMessage(t *testing.T, l *Listener) {
+	select {
+	case m := <-l.messagesChan:
+		t.Errorf("Should not emit message: %q", m.Bytes())
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestTCPGapFraming(t *testing.T) {
+	listener := newTCPTestListener(t, nil)
+	defer listener.Close()
+
+	now := time.Now()
+
+	// Out of order packets of the request
+	listener.processTCPPacket(buildPacket(true, 1000, 1, []byte("get "), now))
+	listener.processTCPPacket(buildPacket(true, 1000, 9, []byte("\r\n"), now))
+	listener.processTCPPacket(buildPacket(true, 1000, 5, []byte("key1"), now))
+	expectNoMessage(t, listener)
+
+	// Response finishes the request
+	listener.processTCPPacket(buildPacket(false, 11, 1000, []byte("VALUE key1 0 1\r\n"), now.Add(time.Millisecond)))
+	req := receiveMessage(t, listener)
+	if !req.IsIncoming || string(req.Bytes()) != "get key1\r\n" {
+		t.Errorf("Wrong request: %q", req.Bytes())
+	}
+
+	listener.processTCPPacket(buildPacket(false, 11, 1016, []byte("a\r\nEND\r\n"), now.Add(2*time.Millisecond)))
+
+	// Next request finishes the response
+	listener.processTCPPacket(buildPacket(true, 1024, 11, []byte("get key2\r\n"), now.Add(3*time.Millisecond)))
+	resp := receiveMessage(t, listener)
+	if resp.IsIncoming || resp.AssocMessage != req || string(resp.Bytes()) != "VALUE key1 0 1\r\na\r\nEND\r\n" {
+		t.Errorf("Wrong response: %q", resp.Bytes())
+	}
+
+	if resp.End.Sub(req.End) != 2*time.Millisecond {
+		t.Error("Latency should be counted from the end of request", resp.End.Sub(req.End))
+	}
+
+	client, server := resp.Connection()
+	if reqClient, _ := req.Connection(); client.String() != reqClient.String() || client.Port != 1 || server.Port != 0 {
+		t.Error("Response should have connection of request", client, server)
+	}
+
+	// Silent client sends next message
+	listener.processTCPPacket(buildPacket(true, 1024, 21, []byte("get key3\r\n"), now.Add(2*time.Hour)))
+	next := receiveMessage(t, listener)
+	if string(next.Bytes()) != "get key2\r\n" {
+		t.Errorf("Wrong request: %q", next.Bytes())
+	}
+
+	if string(next.UUID()) == string(req.UUID()) {
+		t.Error("Requests should have different IDs")
+	}
+
+	// Idle connection
+	listener.tcpCleanup(time.Now().Add(2 * time.Hour))
+	if req := receiveMessage(t, listener); string(req.Bytes()) != "get key3\r\n" {
+		t.Errorf("Wrong request: %q", req.Bytes())
+	}
+
+	fin := buildPacket(true, 1024, 31, nil, now.Add(2*time.Hour))
+	fin.IsFIN = true
+	listener.processTCPPacket(fin)
+
+	if len(listener.tcpConns) != 0 {
+		t.Error("Closed connection should be removed")
+	}
+}
+
+func TestTCPHalfClose(t *testing.T) {
+	listener := newTCPTestListener(t, nil)
+	defer listener.Close()
+
+	now := time.Now()
+
+	// Client sends request and closes its side of connection
+	fin := buildPacket(true, 1000, 1, []byte("get key1\r\n"), now)
+	fin.IsFIN = true
+	listener.processTCPPacket(fin)
+
+	req := receiveMessage(t, listener)
+	if len(listener.tcpConns) != 1 {
+		t.Fatal("Half-closed connection should be kept")
+	}
+
+	// Server still answers
+	listener.processTCPPacket(buildPacket(false, 11, 1000, []byte("END\r\n"), now))
+	expectNoMessage(t, listener)
+
+	fin = buildPacket(false, 11, 1005, nil, now)
+	fin.IsFIN = true
+	listener.processTCPPacket(fin)
+
+	if resp := receiveMessage(t, listener); resp.AssocMessage != req || string(resp.Bytes()) != "END\r\n" {
+		t.Errorf("Wrong response: %q", resp.Bytes())
+	}
+
+	if len(listener.tcpConns) != 0 {
+		t.Error("Closed connection should be removed")
+	}
+
+	// Client port is reused by the next connection
+	fin = buildPacket(true, 5000, 1, []byte("get key2\r\n"), now.Add(time.Second))
+	fin.IsFIN = true
+	listener.processTCPPacket(fin)
+
+	if next := receiveMessage(t, listener); next.ConnectionID == 0 || next.ConnectionID == req.ConnectionID {
+		t.Error("New connection should have new ID", next.ConnectionID, req.ConnectionID)
+	}
+}
+
+func TestTCPSamePortClients(t *testing.T) {
+	listener := newTCPTestListener(t, redis.Parser{})
+	defer listener.Close()
+
+	now := time.Now()
+
+	// Different clients using the same port
+	var requests []*TCPMessage
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		packet := buildPacket(true, 1000, 1, []byte("PING\r\n"), now)
+		packet.Addr = net.ParseIP(ip).To4()
+		packet.GenID()
+		listener.processTCPPacket(packet)
+
+		requests = append(requests, receiveMessage(t, listener))
+	}
+
+	if client, _ := requests[1].Connection(); client.IP.String() != "10.0.0.2" || len(listener.tcpConns) != 2 {
+		t.Fatal("Each client should have own connection", client)
+	}
+
+	// Second client gets response first
+	for i := len(requests) - 1; i >= 0; i-- {
+		packet := buildPacket(false, 7, 1000, []byte("+PONG\r\n"), now)
+		packet.DstAddr = requests[i].IP()
+		listener.processTCPPacket(packet)
+
+		if resp := receiveMessage(t, listener); resp.AssocMessage != requests[i] {
+			t.Errorf("Response should be associated with request of client %d", i)
+		}
+	}
+}
+
+func TestTCPRedisFraming(t *testing.T) {
+	listener := newTCPTestListener(t, redis.Parser{})
+	defer listener.Close()
+
+	now := time.Now()
+
+	// Server greeting has no request
+	listener.processTCPPacket(buildPacket(false, 1, 1000, []byte("+HELLO\r\n"), now))
+
+	// Pipelined commands, second one split between packets
+	listener.processTCPPacket(buildPacket(true, 1008, 1, []byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n*3\r\n$3\r\nSET\r\n$1\r\nb"), now))
+	listener.processTCPPacket(buildPacket(true, 1008, 39, []byte("\r\n$5\r\nhello\r\n"), now))
+	listener.processTCPPacket(buildPacket(false, 52, 1008, []byte("$-1\r\n+OK\r\n"), now))
+
+	get, set := receiveMessage(t, listener), receiveMessage(t, listener)
+	if string(get.Bytes()) != "*2\r\n$3\r\nGET\r\n$1\r\na\r\n" || string(set.Bytes()) != "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$5\r\nhello\r\n" {
+		t.Errorf("Wrong requests: %q %q", get.Bytes(), set.Bytes())
+	}
+
+	getResp, setResp := receiveMessage(t, listener), receiveMessage(t, listener)
+	if getResp.AssocMessage != get || string(getResp.Bytes()) != "$-1\r\n" {
+		t.Errorf("Wrong response: %q", getResp.Bytes())
+	}
+	if setResp.AssocMessage != set || string(setResp.Bytes()) != "+OK\r\n" {
+		t.Errorf("Wrong response: %q", setResp.Bytes())
+	}
+
+	// Not a RESP stream
+	listener.processTCPPacket(buildPacket(true, 1018, 52, []byte("*x\r\n"), now))
+	listener.processTCPPacket(buildPacket(true, 1018, 56, []byte("PING\r\n"), now))
+	expectNoMessage(t, listener)
+}
+
+// idParser frames messages of 2 bytes: ID and payload
+type idParser struct{}
+
+func (idParser) Name() string { return "id" }
+
+func (idParser) Frame(data []byte, isRequest bool) (int, error) {
+	if len(data) < 2 {
+		return 0, nil
+	}
+	return 2, nil
+}
+
+func (idParser) MessageID(message []byte, isRequest bool) []byte { return message[:1] }
+
+func (idParser) Key(request []byte) []byte { return nil }
+
+func TestTCPMessageID(t *testing.T) {
+	listener := newTCPTestListener(t, idParser{})
+	defer listener.Close()
+
+	now := time.Now()
+
+	listener.processTCPPacket(buildPacket(true, 1000, 1, []byte("1a2b"), now))
+	first, second := receiveMessage(t, listener), receiveMessage(t, listener)
+
+	// Responses out of order
+	listener.processTCPPacket(buildPacket(false, 5, 1000, []byte("2B1A3C"), now))
+
+	if resp := receiveMessage(t, listener); resp.AssocMessage != second || string(resp.Bytes()) != "2B" {
+		t.Errorf("Wrong response: %q", resp.Bytes())
+	}
+
+	if resp := receiveMessage(t, listener); resp.AssocMessage != first || string(resp.Bytes()) != "1A" {
+		t.Errorf("Wrong response: %q", resp.Bytes())
+	}
+
+	// Response without request
+	expectNoMessage(t, listener)
+}
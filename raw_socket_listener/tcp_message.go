@@ -35,6 +35,10 @@ type TCPMessage struct {
 	End          time.Time
 	IsIncoming   bool
 
+	// Set in protocol agnostic mode: distinguishes connections of the same client address,
+	// e.g. when client port is reused
+	ConnectionID uint64
+
 	packets []*TCPPacket
 
 	delChan chan *TCPMessage
@@ -503,6 +507,18 @@ func (t *TCPMessage) IP() net.IP {
 	return net.IP(t.packets[0].Addr)
 }
 
+// Connection returns client and server addresses of the request, for responses addresses of associated request used
+func (t *TCPMessage) Connection() (client, server *net.TCPAddr) {
+	req := t
+	if !t.IsIncoming && t.AssocMessage != nil {
+		req = t.AssocMessage
+	}
+
+	p := req.packets[0]
+
+	return &net.TCPAddr{IP: net.IP(p.Addr), Port: int(p.SrcPort)}, &net.TCPAddr{IP: net.IP(p.DstAddr), Port: int(p.DestPort)}
+}
+
 func (t *TCPMessage) String() string {
 	return strings.Join([]string{
 		"Len packets: " + strconv.Itoa(len(t.packets)),
@@ -3,6 +3,7 @@ package rawSocket
 import (
 	"encoding/binary"
 	"log"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +26,9 @@ const (
 
 type tcpID [24]byte
 
+// connID identifies TCP connection by client IP and port
+type connID [18]byte
+
 // TCPPacket provides tcp packet parser
 // Packet structure: http://en.wikipedia.org/wiki/Transmission_Control_Protocol
 type TCPPacket struct {
@@ -39,8 +43,12 @@ type TCPPacket struct {
 	Raw       []byte
 	Data      []byte
 	Addr      []byte
+	DstAddr   []byte // Empty if capture engine does not provide destination IP
 	timestamp time.Time
 	ID        tcpID
+
+	// Plaintext of TLS records, made by listener
+	decrypted bool
 }
 
 // ParseTCPPacket takes address and tcp payload and returns parsed TCPPacket
@@ -61,6 +69,20 @@ func (p *TCPPacket) GenID() {
 	copy(p.ID[20:], p.Raw[8:12]) // Ack
 }
 
+// clientID returns ID of the packet connection: client IP and port, same for packets of both directions.
+// Client IP of outgoing packets is known only if capture engine provides destination IP.
+func (p *TCPPacket) clientID(isIncoming bool) (id connID) {
+	addr, port := p.Addr, p.SrcPort
+	if !isIncoming {
+		addr, port = p.DstAddr, p.DestPort
+	}
+
+	copy(id[:16], net.IP(addr).To16())
+	binary.BigEndian.PutUint16(id[16:], port)
+
+	return
+}
+
 func (p *TCPPacket) UpdateAck(ack uint32) {
 	p.OrigAck = p.Ack
 	p.Ack = ack
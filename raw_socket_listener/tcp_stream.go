@@ -0,0 +1,50 @@
+package rawSocket
+
+// tcpStream re-assembles one direction of TCP connection, for protocols which are not split to messages by Ack
+type tcpStream struct {
+	started bool
+
+	nextSeq uint32
+	pending map[uint32][]byte
+
+	buf []byte
+}
+
+// write adds packet data to the buffer, first packet defines start of the stream
+func (s *tcpStream) write(seq uint32, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	if !s.started {
+		s.started = true
+		s.nextSeq = seq
+		s.pending = make(map[uint32][]byte)
+	}
+
+	s.appendData(seq, data)
+}
+
+// appendData adds packet data to the buffer, out of order packets are kept until missing data arrives
+func (s *tcpStream) appendData(seq uint32, data []byte) {
+	// Re-transmission or old packet
+	if offset := s.nextSeq - seq; int32(offset) > 0 {
+		if int(offset) >= len(data) {
+			return
+		}
+		seq, data = s.nextSeq, data[offset:]
+	}
+
+	if seq != s.nextSeq {
+		s.pending[seq] = append([]byte(nil), data...)
+		return
+	}
+
+	s.buf = append(s.buf, data...)
+	s.nextSeq += uint32(len(data))
+
+	if next, ok := s.pending[s.nextSeq]; ok {
+		delete(s.pending, s.nextSeq)
+		s.appendData(s.nextSeq, next)
+	}
+}
@@ -0,0 +1,763 @@
+package rawSocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// TLS decryption
+//
+// Application can write secrets of its TLS sessions to key log file in SSLKEYLOGFILE format, e.g. using tls.Config.KeyLogWriter in Go.
+// Using these secrets HTTPS traffic is decrypted: once ClientHello is detected, TLS records of both directions are re-assembled,
+// handshake is followed to get client random and cipher suite, and application data is decrypted.
+// Plaintext is processed as packets of the same connection, by HTTP/1 or HTTP/2 logic.
+//
+// TLS 1.2 with AEAD cipher suites (AES-GCM and ChaCha20-Poly1305) and TLS 1.3 are supported.
+
+// TLS connections without any packets during this period are dropped
+const tlsConnExpire = 5 * time.Minute
+
+// Connection waiting for its keys is dropped if it buffers more data
+const tlsMaxPending = 1 << 20
+
+const tlsRecordHeaderLen = 5
+
+// Maximum length of TLS record payload, with expansion allowed for encryption
+const tlsMaxRecordLen = 16384 + 2048
+
+// Record content types
+const (
+	tlsRecordChangeCipherSpec = 20
+	tlsRecordAlert            = 21
+	tlsRecordHandshake        = 22
+	tlsRecordApplicationData  = 23
+)
+
+// Handshake message types
+const (
+	tlsClientHello = 1
+	tlsServerHello = 2
+	tlsFinished    = 20
+	tlsKeyUpdate   = 24
+)
+
+const (
+	tlsVersion12 = 0x0303
+	tlsVersion13 = 0x0304
+)
+
+const tlsExtensionSupportedVersions = 43
+
+// ServerHello with this random is HelloRetryRequest
+var tlsHelloRetryRandom = []byte{
+	0xCF, 0x21, 0xAD, 0x74, 0xE5, 0x9A, 0x61, 0x11, 0xBE, 0x1D, 0x8C, 0x02, 0x1E, 0x65, 0xB8, 0x91,
+	0xC2, 0xA2, 0x11, 0x16, 0x7A, 0xBB, 0x8C, 0x5E, 0x07, 0x9E, 0x09, 0xE2, 0xC8, 0xA8, 0x33, 0x9C,
+}
+
+var (
+	errTLSRecord      = errors.New("malformed TLS record")
+	errTLSUnsupported = errors.New("unsupported TLS version or cipher suite")
+)
+
+type tlsSuite struct {
+	hash   func() hash.Hash
+	keyLen int
+	// Length of IV derived from secrets, TLS 1.2 AES-GCM uses 4 bytes of it and 8 bytes sent with each record
+	ivLen  int
+	chacha bool
+}
+
+var tlsSuites = map[uint16]tlsSuite{
+	// TLS 1.3
+	0x1301: {sha256.New, 16, 12, false},
+	0x1302: {sha512.New384, 32, 12, false},
+	0x1303: {sha256.New, 32, 12, true},
+
+	// TLS 1.2
+	0x009c: {sha256.New, 16, 4, false},    // TLS_RSA_WITH_AES_128_GCM_SHA256
+	0x009d: {sha512.New384, 32, 4, false}, // TLS_RSA_WITH_AES_256_GCM_SHA384
+	0xc02b: {sha256.New, 16, 4, false},    // TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	0xc02c: {sha512.New384, 32, 4, false}, // TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384
+	0xc02f: {sha256.New, 16, 4, false},    // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	0xc030: {sha512.New384, 32, 4, false}, // TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+	0xcca8: {sha256.New, 32, 12, true},    // TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+	0xcca9: {sha256.New, 32, 12, true},    // TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256
+}
+
+// tlsKeyLog reads secrets from key log file. Application appends to it while running, so file is re-read when secret is not found.
+type tlsKeyLog struct {
+	path    string
+	offset  int64
+	partial []byte
+
+	// "LABEL client_random" -> secret
+	secrets map[string][]byte
+}
+
+func newTLSKeyLog(path string) *tlsKeyLog {
+	return &tlsKeyLog{path: path, secrets: make(map[string][]byte)}
+}
+
+func (k *tlsKeyLog) secret(label string, clientRandom []byte) []byte {
+	key := label + " " + hex.EncodeToString(clientRandom)
+
+	if s, ok := k.secrets[key]; ok {
+		return s
+	}
+
+	k.reload()
+
+	return k.secrets[key]
+}
+
+// forget removes secrets of finished connection
+func (k *tlsKeyLog) forget(clientRandom []byte) {
+	suffix := " " + hex.EncodeToString(clientRandom)
+
+	for key := range k.secrets {
+		if strings.HasSuffix(key, suffix) {
+			delete(k.secrets, key)
+		}
+	}
+}
+
+// reload reads lines appended since the last read
+func (k *tlsKeyLog) reload() {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() == k.offset {
+		return
+	}
+
+	// File was truncated or re-created
+	if info.Size() < k.offset {
+		k.offset, k.partial = 0, nil
+	}
+
+	if _, err = f.Seek(k.offset, io.SeekStart); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		k.offset += int64(len(line))
+
+		if err != nil {
+			// Line is not written completely yet
+			k.partial = append(k.partial, line...)
+			return
+		}
+
+		if len(k.partial) > 0 {
+			line = append(k.partial, line...)
+			k.partial = nil
+		}
+
+		// LABEL <client random> <secret>, lines starting with # are comments
+		fields := strings.Fields(string(line))
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if secret, err := hex.DecodeString(fields[2]); err == nil {
+			k.secrets[fields[0]+" "+strings.ToLower(fields[1])] = secret
+		}
+	}
+}
+
+// tlsDirection re-assembles TLS records of one direction, and decrypts them
+type tlsDirection struct {
+	tcpStream
+
+	// Handshake messages, split between records
+	handshake []byte
+
+	encrypted bool
+	cipher    *tlsCipher
+	recordSeq uint64
+
+	// TLS 1.3 secret of current keys, and label of the next secret
+	secret     []byte
+	nextLabel  string
+	nextSecret bool
+	keyUpdate  bool
+
+	// Plaintext is passed further as stream with own sequence numbers
+	plainStarted bool
+	plainSeq     uint32
+	last         *TCPPacket
+	fin          bool
+}
+
+type tlsConn struct {
+	client tlsDirection
+	server tlsDirection
+
+	clientRandom []byte
+	serverRandom []byte
+	version      uint16
+	suite        uint16
+
+	// Connection which can't be decrypted, its packets are dropped
+	failed bool
+
+	lastSeen time.Time
+}
+
+// isTLSClientHello checks if packet starts with handshake record containing ClientHello
+func isTLSClientHello(data []byte) bool {
+	return len(data) > tlsRecordHeaderLen && data[0] == tlsRecordHandshake && data[1] == 3 && data[tlsRecordHeaderLen] == tlsClientHello
+}
+
+// processTLSPacket returns true if packet belongs to TLS connection and was consumed
+func (t *Listener) processTLSPacket(packet *TCPPacket) bool {
+	if t.tlsKeyLog == nil || packet.decrypted {
+		return false
+	}
+
+	isIncoming := packet.DestPort == t.port
+
+	key := packet.clientID(isIncoming)
+
+	// Client port can be re-used by new connection
+	conn, ok := t.tlsConns[key]
+	if !ok || (isIncoming && (conn.client.fin || conn.failed) && isTLSClientHello(packet.Data)) {
+		if !isIncoming || !isTLSClientHello(packet.Data) {
+			return false
+		}
+
+		if ok {
+			t.tlsDelete(key, conn)
+		}
+
+		conn = &tlsConn{}
+		t.tlsConns[key] = conn
+	}
+
+	conn.lastSeen = time.Now()
+
+	dir := &conn.client
+	if !isIncoming {
+		dir = &conn.server
+	}
+
+	if !conn.failed {
+		dir.write(packet.Seq, packet.Data)
+		dir.last = packet
+
+		t.tlsProcess(conn, dir, isIncoming)
+	}
+
+	if packet.IsFIN {
+		dir.fin = true
+	}
+
+	if conn.client.fin && conn.server.fin {
+		t.tlsDelete(key, conn)
+	}
+
+	return true
+}
+
+func (t *Listener) tlsDelete(key connID, conn *tlsConn) {
+	delete(t.tlsConns, key)
+
+	if conn.clientRandom != nil {
+		t.tlsKeyLog.forget(conn.clientRandom)
+	}
+}
+
+// tlsProcess decrypts received records, connection which can't be decrypted is ignored further
+func (t *Listener) tlsProcess(conn *tlsConn, dir *tlsDirection, isIncoming bool) {
+	if err := t.tlsProcessRecords(conn, dir, isIncoming); err != nil {
+		log.Println("Can't decrypt TLS connection:", err)
+
+		conn.failed = true
+		conn.client.buf, conn.server.buf = nil, nil
+		conn.client.handshake, conn.server.handshake = nil, nil
+	}
+}
+
+// tlsProcessRecords processes all completely received records. Processing stops if keys are not yet written to the key log.
+func (t *Listener) tlsProcessRecords(conn *tlsConn, dir *tlsDirection, isIncoming bool) error {
+	for len(dir.buf) >= tlsRecordHeaderLen {
+		length := int(binary.BigEndian.Uint16(dir.buf[3:5]))
+		if length > tlsMaxRecordLen || dir.buf[1] != 3 {
+			return errTLSRecord
+		}
+
+		if len(dir.buf) < tlsRecordHeaderLen+length {
+			break
+		}
+
+		done, err := t.tlsProcessRecord(conn, dir, isIncoming, dir.buf[:tlsRecordHeaderLen+length])
+		if err != nil {
+			return err
+		}
+
+		if !done {
+			if len(dir.buf) > tlsMaxPending {
+				return errTLSUnsupported
+			}
+			break
+		}
+
+		dir.buf = dir.buf[tlsRecordHeaderLen+length:]
+	}
+
+	if len(dir.buf) == 0 {
+		dir.buf = nil
+	}
+
+	return nil
+}
+
+// tlsProcessRecord returns false if record can't be decrypted yet
+func (t *Listener) tlsProcessRecord(conn *tlsConn, dir *tlsDirection, isIncoming bool, record []byte) (bool, error) {
+	contentType := record[0]
+	fragment := record[tlsRecordHeaderLen:]
+
+	if contentType == tlsRecordChangeCipherSpec {
+		// In TLS 1.3 sent only for compatibility
+		if conn.version != tlsVersion13 {
+			dir.encrypted = true
+			dir.recordSeq = 0
+		}
+		return true, nil
+	}
+
+	if !dir.encrypted {
+		switch contentType {
+		case tlsRecordHandshake:
+			dir.handshake = append(dir.handshake, fragment...)
+			return true, t.tlsPlainHandshake(conn, dir)
+		case tlsRecordAlert:
+			return true, nil
+		}
+
+		// Early data, or traffic without handshake
+		return false, errTLSUnsupported
+	}
+
+	if dir.cipher == nil {
+		ok, err := t.tlsSetupCipher(conn, dir, isIncoming)
+		if !ok || err != nil {
+			return false, err
+		}
+	}
+
+	contentType, plaintext, err := dir.cipher.open(dir.recordSeq, record)
+	if err != nil {
+		return false, err
+	}
+	dir.recordSeq++
+
+	switch contentType {
+	case tlsRecordApplicationData:
+		t.tlsEmit(conn, dir, isIncoming, plaintext)
+	case tlsRecordHandshake:
+		if conn.version == tlsVersion13 {
+			dir.handshake = append(dir.handshake, plaintext...)
+			t.tlsEncryptedHandshake(dir)
+		}
+	}
+
+	// Keys change after the record with Finished or KeyUpdate message
+	if dir.nextSecret {
+		dir.nextSecret = false
+		dir.cipher, dir.secret = nil, nil
+		dir.recordSeq = 0
+	} else if dir.keyUpdate {
+		dir.keyUpdate = false
+		suite := tlsSuites[conn.suite]
+		dir.secret = tls13ExpandLabel(suite.hash, dir.secret, "traffic upd", suite.hash().Size())
+		dir.cipher, err = newTLS13Cipher(suite, dir.secret)
+		dir.recordSeq = 0
+	}
+
+	return true, err
+}
+
+// tlsPlainHandshake reads client and server random, and negotiated parameters from hello messages
+func (t *Listener) tlsPlainHandshake(conn *tlsConn, dir *tlsDirection) error {
+	for len(dir.handshake) >= 4 {
+		length := int(dir.handshake[1])<<16 | int(dir.handshake[2])<<8 | int(dir.handshake[3])
+		if len(dir.handshake) < 4+length {
+			break
+		}
+
+		msgType, body := dir.handshake[0], dir.handshake[4:4+length]
+		dir.handshake = dir.handshake[4+length:]
+
+		switch msgType {
+		case tlsClientHello:
+			if len(body) < 34 {
+				return errTLSRecord
+			}
+			conn.clientRandom = append([]byte(nil), body[2:34]...)
+		case tlsServerHello:
+			if err := t.tlsServerHello(conn, body); err != nil {
+				return err
+			}
+
+			// Rest of TLS 1.3 handshake is encrypted
+			if conn.version == tlsVersion13 {
+				conn.client.encrypted, conn.server.encrypted = true, true
+				conn.client.nextLabel, conn.server.nextLabel = "CLIENT_HANDSHAKE_TRAFFIC_SECRET", "SERVER_HANDSHAKE_TRAFFIC_SECRET"
+				dir.handshake = nil
+				return nil
+			}
+		}
+	}
+
+	if len(dir.handshake) == 0 {
+		dir.handshake = nil
+	}
+
+	return nil
+}
+
+func (t *Listener) tlsServerHello(conn *tlsConn, body []byte) error {
+	if len(body) < 35 || conn.clientRandom == nil {
+		return errTLSRecord
+	}
+
+	random := body[2:34]
+
+	// After HelloRetryRequest client sends new ClientHello
+	if bytes.Equal(random, tlsHelloRetryRandom) {
+		return nil
+	}
+
+	conn.serverRandom = append([]byte(nil), random...)
+	conn.version = binary.BigEndian.Uint16(body[0:2])
+
+	pos := 35 + int(body[34])
+	if len(body) < pos+3 {
+		return errTLSRecord
+	}
+	conn.suite = binary.BigEndian.Uint16(body[pos : pos+2])
+	pos += 3
+
+	// TLS 1.3 negotiated using extension
+	if len(body) >= pos+2 {
+		extensions := body[pos+2:]
+		for len(extensions) >= 4 {
+			extType := binary.BigEndian.Uint16(extensions[0:2])
+			extLen := int(binary.BigEndian.Uint16(extensions[2:4]))
+			if len(extensions) < 4+extLen {
+				return errTLSRecord
+			}
+
+			if extType == tlsExtensionSupportedVersions && extLen == 2 {
+				conn.version = binary.BigEndian.Uint16(extensions[4:6])
+			}
+			extensions = extensions[4+extLen:]
+		}
+	}
+
+	if _, ok := tlsSuites[conn.suite]; !ok || (conn.version != tlsVersion12 && conn.version != tlsVersion13) {
+		return errTLSUnsupported
+	}
+
+	// Suites of TLS 1.3 can't be used with TLS 1.2, and vice versa
+	if (conn.version == tlsVersion13) != (conn.suite>>8 == 0x13) {
+		return errTLSUnsupported
+	}
+
+	return nil
+}
+
+// tlsEncryptedHandshake looks for messages after which TLS 1.3 keys change
+func (t *Listener) tlsEncryptedHandshake(dir *tlsDirection) {
+	for len(dir.handshake) >= 4 {
+		length := int(dir.handshake[1])<<16 | int(dir.handshake[2])<<8 | int(dir.handshake[3])
+		if len(dir.handshake) < 4+length {
+			break
+		}
+
+		switch dir.handshake[0] {
+		case tlsFinished:
+			if dir.nextLabel != "" {
+				dir.nextSecret = true
+			}
+		case tlsKeyUpdate:
+			dir.keyUpdate = true
+		}
+
+		dir.handshake = dir.handshake[4+length:]
+	}
+
+	if len(dir.handshake) == 0 {
+		dir.handshake = nil
+	}
+}
+
+// tlsSetupCipher derives keys of the direction from the key log, returns false if secret is not found
+func (t *Listener) tlsSetupCipher(conn *tlsConn, dir *tlsDirection, isIncoming bool) (bool, error) {
+	suite := tlsSuites[conn.suite]
+
+	if conn.version == tlsVersion13 {
+		secret := t.tlsKeyLog.secret(dir.nextLabel, conn.clientRandom)
+		if secret == nil {
+			return false, nil
+		}
+
+		cipher, err := newTLS13Cipher(suite, secret)
+		if err != nil {
+			return false, err
+		}
+
+		dir.cipher, dir.secret = cipher, secret
+
+		// After handshake keys go application keys
+		if strings.HasSuffix(dir.nextLabel, "_HANDSHAKE_TRAFFIC_SECRET") {
+			dir.nextLabel = strings.TrimSuffix(dir.nextLabel, "_HANDSHAKE_TRAFFIC_SECRET") + "_TRAFFIC_SECRET_0"
+		} else {
+			dir.nextLabel = ""
+		}
+
+		return true, nil
+	}
+
+	master := t.tlsKeyLog.secret("CLIENT_RANDOM", conn.clientRandom)
+	if master == nil {
+		return false, nil
+	}
+
+	cipher, err := newTLS12Cipher(suite, master, conn.clientRandom, conn.serverRandom, isIncoming)
+	if err != nil {
+		return false, err
+	}
+	dir.cipher = cipher
+
+	return true, nil
+}
+
+// tlsEmit passes decrypted data as a packet of the same connection.
+// Sequence numbers count plaintext bytes, so requests and responses are matched the same way as for plain HTTP.
+func (t *Listener) tlsEmit(conn *tlsConn, dir *tlsDirection, isIncoming bool, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	other := &conn.server
+	if !isIncoming {
+		other = &conn.client
+	}
+
+	for _, d := range []*tlsDirection{dir, other} {
+		if !d.plainStarted {
+			d.plainStarted = true
+			d.plainSeq = d.nextSeq
+		}
+	}
+
+	src := dir.last
+
+	raw := make([]byte, 20+len(data))
+	copy(raw[0:4], src.Raw[0:4]) // Ports
+	binary.BigEndian.PutUint32(raw[4:8], dir.plainSeq)
+	binary.BigEndian.PutUint32(raw[8:12], other.plainSeq)
+	raw[12] = 5 << 4
+	copy(raw[20:], data)
+
+	packet := ParseTCPPacket(src.Addr, raw, src.timestamp)
+	packet.DstAddr = src.DstAddr
+	packet.decrypted = true
+
+	dir.plainSeq += uint32(len(data))
+
+	t.processTCPPacket(packet)
+}
+
+// tlsCleanup retries connections waiting for keys, and removes idle connections
+func (t *Listener) tlsCleanup(now time.Time) {
+	for key, conn := range t.tlsConns {
+		if now.Sub(conn.lastSeen) >= tlsConnExpire {
+			t.tlsDelete(key, conn)
+			continue
+		}
+
+		if conn.failed {
+			continue
+		}
+
+		for _, d := range []struct {
+			dir        *tlsDirection
+			isIncoming bool
+		}{{&conn.client, true}, {&conn.server, false}} {
+			if len(d.dir.buf) > 0 && d.dir.last != nil && !conn.failed {
+				t.tlsProcess(conn, d.dir, d.isIncoming)
+			}
+		}
+	}
+}
+
+// tlsCipher decrypts records of one direction
+type tlsCipher struct {
+	aead cipher.AEAD
+	iv   []byte
+
+	tls13 bool
+	// TLS 1.2 AES-GCM sends part of the nonce with each record
+	explicitNonce bool
+}
+
+func newTLSAEAD(suite tlsSuite, key []byte) (cipher.AEAD, error) {
+	if suite.chacha {
+		return chacha20poly1305.New(key)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func newTLS13Cipher(suite tlsSuite, secret []byte) (*tlsCipher, error) {
+	key := tls13ExpandLabel(suite.hash, secret, "key", suite.keyLen)
+	iv := tls13ExpandLabel(suite.hash, secret, "iv", suite.ivLen)
+
+	aead, err := newTLSAEAD(suite, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsCipher{aead: aead, iv: iv, tls13: true}, nil
+}
+
+func newTLS12Cipher(suite tlsSuite, master, clientRandom, serverRandom []byte, isClient bool) (*tlsCipher, error) {
+	// AEAD suites have no MAC keys: client key, server key, client IV, server IV
+	seed := append(append([]byte(nil), serverRandom...), clientRandom...)
+	keyBlock := tls12PRF(suite.hash, master, "key expansion", seed, 2*suite.keyLen+2*suite.ivLen)
+
+	key := keyBlock[suite.keyLen : 2*suite.keyLen]
+	iv := keyBlock[2*suite.keyLen+suite.ivLen:]
+	if isClient {
+		key = keyBlock[:suite.keyLen]
+		iv = keyBlock[2*suite.keyLen : 2*suite.keyLen+suite.ivLen]
+	}
+
+	aead, err := newTLSAEAD(suite, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsCipher{aead: aead, iv: iv, explicitNonce: !suite.chacha}, nil
+}
+
+// open decrypts record, and returns its content type and plaintext
+func (c *tlsCipher) open(seq uint64, record []byte) (byte, []byte, error) {
+	header, payload := record[:tlsRecordHeaderLen], record[tlsRecordHeaderLen:]
+
+	nonce := make([]byte, 12)
+	if c.explicitNonce {
+		if len(payload) < 8 {
+			return 0, nil, errTLSRecord
+		}
+		copy(nonce, c.iv)
+		copy(nonce[4:], payload[:8])
+		payload = payload[8:]
+	} else {
+		copy(nonce, c.iv)
+		for i := 0; i < 8; i++ {
+			nonce[4+i] ^= byte(seq >> uint(56-8*i))
+		}
+	}
+
+	if len(payload) < c.aead.Overhead() {
+		return 0, nil, errTLSRecord
+	}
+
+	aad := header
+	if !c.tls13 {
+		aad = make([]byte, 13)
+		binary.BigEndian.PutUint64(aad, seq)
+		copy(aad[8:11], header[:3])
+		binary.BigEndian.PutUint16(aad[11:], uint16(len(payload)-c.aead.Overhead()))
+	}
+
+	plaintext, err := c.aead.Open(nil, nonce, payload, aad)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !c.tls13 {
+		return header[0], plaintext, nil
+	}
+
+	// Real content type is the last non-zero byte, followed by padding
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, errTLSRecord
+	}
+
+	return plaintext[i], plaintext[:i], nil
+}
+
+// tls13ExpandLabel implements HKDF-Expand-Label from RFC 8446
+func tls13ExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+
+	info := make([]byte, 0, 4+len(label))
+	info = append(info, byte(length>>8), byte(length), byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0) // Empty context
+
+	out := make([]byte, length)
+	io.ReadFull(hkdf.Expand(h, secret, info), out)
+
+	return out
+}
+
+// tls12PRF implements pseudo random function from RFC 5246
+func tls12PRF(h func() hash.Hash, secret []byte, label string, seed []byte, length int) []byte {
+	labelSeed := append([]byte(label), seed...)
+
+	mac := hmac.New(h, secret)
+	mac.Write(labelSeed)
+	a := mac.Sum(nil)
+
+	var out []byte
+	for len(out) < length {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelSeed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+
+	return out[:length]
+}
@@ -0,0 +1,278 @@
+package rawSocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tlsRecorder stores data written by both sides of the connection, in order of writing
+type tlsRecorder struct {
+	mu      sync.Mutex
+	packets []*TCPPacket
+	seq     [2]uint32
+}
+
+type tlsRecordingConn struct {
+	net.Conn
+	incoming bool
+	rec      *tlsRecorder
+}
+
+func (c *tlsRecordingConn) Write(p []byte) (int, error) {
+	c.rec.mu.Lock()
+	dir, other := 0, 1
+	if !c.incoming {
+		dir, other = 1, 0
+	}
+	c.rec.packets = append(c.rec.packets, buildPacket(c.incoming, c.rec.seq[other], c.rec.seq[dir], append([]byte(nil), p...), time.Now()))
+	c.rec.seq[dir] += uint32(len(p))
+	c.rec.mu.Unlock()
+
+	return c.Conn.Write(p)
+}
+
+func tlsTestCertificate(t *testing.T) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsExchange makes requests over TLS connection, and returns captured packets
+func tlsExchange(t *testing.T, clientConfig *tls.Config, keyLog io.Writer, requests ...string) []*TCPPacket {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	rec := &tlsRecorder{seq: [2]uint32{1, 1000}}
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{tlsTestCertificate(t)}, KeyLogWriter: keyLog}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		server := tls.Server(&tlsRecordingConn{conn, false, rec}, serverConfig)
+		defer server.Close()
+
+		reader := bufio.NewReader(server)
+		for {
+			req, err := http.ReadRequest(reader)
+			if err != nil {
+				return
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+
+			resp := "echo:" + req.URL.Path + ":" + string(body)
+			server.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(resp)) + "\r\n\r\n" + resp))
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConfig.InsecureSkipVerify = true
+	client := tls.Client(&tlsRecordingConn{conn, true, rec}, clientConfig)
+
+	reader := bufio.NewReader(client)
+	for _, r := range requests {
+		if _, err := client.Write([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+	}
+
+	client.Close()
+	<-done
+
+	// Both sides closed connection
+	for i, incoming := range []bool{true, false} {
+		fin := buildPacket(incoming, rec.seq[1-i], rec.seq[i], nil, time.Now())
+		fin.IsFIN = true
+		rec.packets = append(rec.packets, fin)
+	}
+
+	return rec.packets
+}
+
+func TestTLSDecryption(t *testing.T) {
+	requests := []string{
+		"GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"POST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+	}
+
+	cases := []struct {
+		name   string
+		config *tls.Config
+	}{
+		{"TLS 1.3", &tls.Config{MinVersion: tls.VersionTLS13}},
+		{"TLS 1.2 AES-128-GCM", &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}},
+		{"TLS 1.2 AES-256-GCM", &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}}},
+		{"TLS 1.2 ChaCha20-Poly1305", &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keyLog, _ := ioutil.TempFile("", "gor_keylog")
+			defer os.Remove(keyLog.Name())
+
+			packets := tlsExchange(t, c.config, keyLog, requests...)
+			keyLog.Close()
+
+			listener := NewListener("", "0", EnginePcap, true, time.Hour, "", "", 0, false, false, keyLog.Name(), nil)
+			defer listener.Close()
+
+			for _, p := range packets {
+				listener.processTCPPacket(p)
+			}
+
+			for i, r := range requests {
+				req := receiveMessage(t, listener)
+				resp := receiveMessage(t, listener)
+
+				if !req.IsIncoming || string(req.Bytes()) != r {
+					t.Errorf("Wrong request %d: %q", i, req.Bytes())
+				}
+
+				if resp.IsIncoming || resp.AssocMessage != req || !bytes.HasPrefix(resp.Bytes(), []byte("HTTP/1.1 200 OK\r\n")) || !bytes.Contains(resp.Bytes(), []byte("echo:/")) {
+					t.Errorf("Wrong response %d: %q", i, resp.Bytes())
+				}
+			}
+
+			if len(listener.tlsConns) != 0 {
+				t.Error("Closed connection should be removed")
+			}
+		})
+	}
+}
+
+func TestTLSDecryptionDelayedKeys(t *testing.T) {
+	var keys bytes.Buffer
+	packets := tlsExchange(t, &tls.Config{}, &keys, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	keyLog, _ := ioutil.TempFile("", "gor_keylog")
+	defer os.Remove(keyLog.Name())
+	keyLog.Close()
+
+	listener := NewListener("", "0", EnginePcap, true, time.Hour, "", "", 0, false, false, keyLog.Name(), nil)
+	defer listener.Close()
+
+	// Without FIN connection waits for keys
+	for _, p := range packets {
+		p.IsFIN = false
+		listener.processTCPPacket(p)
+	}
+
+	select {
+	case m := <-listener.messagesChan:
+		t.Fatalf("Should not emit encrypted data: %q", m.Bytes())
+	default:
+	}
+
+	ioutil.WriteFile(keyLog.Name(), keys.Bytes(), 0600)
+	listener.tlsCleanup(time.Now())
+
+	if req := receiveMessage(t, listener); string(req.Bytes()) != "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n" {
+		t.Errorf("Wrong request: %q", req.Bytes())
+	}
+
+	if resp := receiveMessage(t, listener); !bytes.HasSuffix(resp.Bytes(), []byte("echo:/:")) {
+		t.Errorf("Wrong response: %q", resp.Bytes())
+	}
+}
+
+func TestTLSSamePortClients(t *testing.T) {
+	keyLog, _ := ioutil.TempFile("", "gor_keylog")
+	defer os.Remove(keyLog.Name())
+
+	var exchanges [][]*TCPPacket
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		packets := tlsExchange(t, &tls.Config{}, keyLog, "GET /"+strconv.Itoa(i)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		// Different clients using the same port, and own sequence numbers as real connections
+		for _, p := range packets {
+			binary.BigEndian.PutUint32(p.Raw[4:8], p.Seq+uint32(i)<<24)
+			binary.BigEndian.PutUint32(p.Raw[8:12], p.Ack+uint32(i)<<24)
+			fin := p.IsFIN
+			p.ParseBasic()
+			p.IsFIN = fin
+
+			if p.DestPort == 0 {
+				p.Addr = net.ParseIP(ip).To4()
+				p.GenID()
+			} else {
+				p.DstAddr = net.ParseIP(ip).To4()
+			}
+		}
+
+		exchanges = append(exchanges, packets)
+	}
+	keyLog.Close()
+
+	listener := NewListener("", "0", EnginePcap, true, time.Hour, "", "", 0, false, false, keyLog.Name(), nil)
+	defer listener.Close()
+
+	// Second connection starts while first is in progress
+	for i := 0; i < len(exchanges[0]) || i < len(exchanges[1]); i++ {
+		for _, packets := range exchanges {
+			if i < len(packets) {
+				listener.processTCPPacket(packets[i])
+			}
+		}
+	}
+
+	paths := make(map[string]bool)
+	for done := false; !done; {
+		select {
+		case m := <-listener.messagesChan:
+			if m.IsIncoming {
+				paths[string(bytes.Fields(m.Bytes())[1])] = true
+			}
+		case <-time.After(20 * time.Millisecond):
+			done = true
+		}
+	}
+
+	if !paths["/0"] || !paths["/1"] {
+		t.Error("Both connections should be decrypted:", paths)
+	}
+}
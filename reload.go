@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+)
+
+var reloadMu sync.Mutex
+
+// Command line arguments, parsed again on each reload
+var reloadArgs []string
+
+// currentModifierConfig returns modifier config, replaced by the last reload if any
+func (s *AppSettings) currentModifierConfig() *HTTPModifierConfig {
+	if c, ok := s.reloadedModifierConfig.Load().(*HTTPModifierConfig); ok {
+		return c
+	}
+
+	return &s.modifierConfig
+}
+
+// reloadConfig parses command line arguments and config file again, and atomically swaps
+// filters and rewrite rules of main and named pipelines. Inputs and outputs keep running,
+// so in-flight TCP messages and response tracking are not lost.
+//
+// Other options, and the list of pipelines, can't be changed without restart and are ignored.
+func reloadConfig(args []string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	s := new(AppSettings)
+
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	registerFlags(fs, s)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if s.config != "" {
+		if err := loadConfig(fs, s, s.config); err != nil {
+			return err
+		}
+	}
+
+	pipelines := make(map[string]*AppSettings)
+	for _, p := range s.pipelines {
+		pipelines[p.name] = p.settings
+	}
+
+	for _, p := range Pipelines {
+		if _, ok := pipelines[p.Name]; !ok {
+			return fmt.Errorf("pipeline %q is missing in reloaded config, pipelines can't be added or removed without restart", p.Name)
+		}
+	}
+
+	if len(pipelines) != len(Pipelines) {
+		return fmt.Errorf("new pipelines found in reloaded config, pipelines can't be added or removed without restart")
+	}
+
+	Settings.reloadedModifierConfig.Store(&s.modifierConfig)
+
+	for _, p := range Pipelines {
+		p.Settings.reloadedModifierConfig.Store(&pipelines[p.Name].modifierConfig)
+	}
+
+	log.Println("Reloaded filters and rewrite rules")
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/buger/goreplay/proto"
+)
+
+func TestReloadConfig(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	path := writeTestConfig(t, ".yaml", "http-allow-url: ^/a")
+	defer os.Remove(path)
+
+	reloadArgs = []string{"--config", path}
+	defer func() {
+		reloadArgs = nil
+		Settings.reloadedModifierConfig.Store(&Settings.modifierConfig)
+	}()
+
+	if err := reloadConfig(reloadArgs); err != nil {
+		t.Fatal(err)
+	}
+
+	input := NewTestInput()
+
+	var mu sync.Mutex
+	var paths []string
+
+	output := NewTestOutput(func(data []byte) {
+		mu.Lock()
+		paths = append(paths, string(proto.Path(payloadBody(data))))
+		mu.Unlock()
+		wg.Done()
+	})
+
+	Plugins = &InOutPlugins{
+		Inputs:  []io.Reader{input},
+		Outputs: []io.Writer{output},
+	}
+
+	go Start(quit)
+
+	wg.Add(1)
+	input.EmitBytes([]byte("GET /b HTTP/1.1\r\n\r\n"))
+	input.EmitBytes([]byte("GET /a HTTP/1.1\r\n\r\n"))
+	wg.Wait()
+
+	ioutil.WriteFile(path, []byte("http-allow-url: ^/b"), 0644)
+
+	admin := httptest.NewServer(NewAdminHandler())
+	defer admin.Close()
+
+	resp, err := http.Post(admin.URL+"/reload", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Reload failed:", resp.Status)
+	}
+
+	wg.Add(1)
+	input.EmitBytes([]byte("GET /a HTTP/1.1\r\n\r\n"))
+	input.EmitBytes([]byte("GET /b HTTP/1.1\r\n\r\n"))
+	wg.Wait()
+
+	close(quit)
+
+	if len(paths) != 2 || paths[0] != "/a" || paths[1] != "/b" {
+		t.Error("Filters should be reloaded:", paths)
+	}
+
+	// Broken config should keep previous rules
+	ioutil.WriteFile(path, []byte("http-allow-url: ["), 0644)
+
+	if err := reloadConfig(reloadArgs); err == nil {
+		t.Error("Should fail on broken config")
+	}
+
+	if c := Settings.currentModifierConfig(); len(c.urlRegexp) != 1 || c.urlRegexp[0].regexp.String() != "^/b" {
+		t.Error("Should keep previous rules:", c.urlRegexp)
+	}
+}
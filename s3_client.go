@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config holds settings of S3 compatible storage.
+// Credentials taken from standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+type S3Config struct {
+	// Custom endpoint, like http://localhost:9000 for MinIO. Path style URLs used with custom endpoint.
+	endpoint string
+	region   string
+}
+
+// Timeouts of S3 requests. Whole request time is not limited: objects are streamed, and can be large.
+const (
+	s3DialTimeout           = 10 * time.Second
+	s3ResponseHeaderTimeout = 30 * time.Second
+	s3IdleConnTimeout       = 90 * time.Second
+)
+
+// s3Client implements subset of S3 API used by S3 plugins: upload, download and listing of objects.
+// Requests signed with AWS Signature Version 4.
+type s3Client struct {
+	endpoint string
+	region   string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	client *http.Client
+}
+
+type s3Object struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+}
+
+type s3ListResult struct {
+	Contents              []s3Object
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+func newS3Client(config *S3Config) *s3Client {
+	c := &s3Client{
+		endpoint:     strings.TrimSuffix(config.endpoint, "/"),
+		region:       config.region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	dialer := &net.Dialer{Timeout: s3DialTimeout, KeepAlive: 30 * time.Second}
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   s3DialTimeout,
+			ResponseHeaderTimeout: s3ResponseHeaderTimeout,
+			IdleConnTimeout:       s3IdleConnTimeout,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+
+	if c.region == "" {
+		c.region = os.Getenv("AWS_REGION")
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+
+	return c
+}
+
+// parseS3Path splits `bucket/key` path, `s3://` scheme is optional
+func parseS3Path(path string) (bucket, key string, err error) {
+	path = strings.TrimPrefix(path, "s3://")
+
+	i := strings.Index(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return "", "", fmt.Errorf("S3 path should be in bucket/key format: %q", path)
+	}
+
+	return path[:i], path[i+1:], nil
+}
+
+func (c *s3Client) url(bucket, key string, query url.Values) string {
+	var u string
+	if c.endpoint != "" {
+		u = c.endpoint + "/" + bucket + s3EscapePath(key)
+	} else {
+		u = "https://" + bucket + ".s3." + c.region + ".amazonaws.com" + s3EscapePath(key)
+	}
+
+	if len(query) > 0 {
+		u += "?" + s3EscapeQuery(query)
+	}
+
+	return u
+}
+
+func (c *s3Client) do(req *http.Request) (*http.Response, error) {
+	c.sign(req, "UNSIGNED-PAYLOAD", time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+
+		var e s3Error
+		body, _ := ioutil.ReadAll(resp.Body)
+		if xml.Unmarshal(body, &e) != nil || e.Code == "" {
+			return nil, fmt.Errorf("S3 %s %s: %s", req.Method, req.URL.Path, resp.Status)
+		}
+
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path, e.Code, e.Message)
+	}
+
+	return resp, nil
+}
+
+// PutObject uploads file content
+func (c *s3Client) PutObject(bucket, key string, body io.Reader, size int64) error {
+	req, err := http.NewRequest("PUT", c.url(bucket, key, nil), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// GetObject returns object content starting from offset, which should be closed by caller
+func (c *s3Client) GetObject(bucket, key string, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", c.url(bucket, key, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// ListObjects returns all objects with given key prefix
+func (c *s3Client) ListObjects(bucket, prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	var token string
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := http.NewRequest("GET", c.url(bucket, "", query), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("can't parse S3 list response: %v", err)
+		}
+
+		objects = append(objects, result.Contents...)
+
+		if !result.IsTruncated {
+			return objects, nil
+		}
+
+		if result.NextContinuationToken == "" {
+			return nil, errors.New("S3 list response is truncated, but has no continuation token")
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// sign adds AWS Signature Version 4 to request. All headers set before signing are signed.
+// Requests are not signed if there are no credentials, to access public buckets.
+func (c *s3Client) sign(req *http.Request, payloadHash string, t time.Time) {
+	if c.accessKey == "" {
+		return
+	}
+
+	date := t.UTC().Format("20060102T150405Z")
+	day := date[:8]
+
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(strings.TrimPrefix(req.URL.Path, "/")),
+		s3EscapeQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape encodes string as required by S3: everything except unreserved characters is escaped
+func s3Escape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		parts[i] = s3Escape(p)
+	}
+
+	return "/" + strings.Join(parts, "/")
+}
+
+func s3EscapeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
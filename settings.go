@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,19 +26,27 @@ func (h *MultiOption) Set(value string) error {
 
 // AppSettings is the struct of main configuration
 type AppSettings struct {
+	config    string
+	pipelines []pipelineSettings
+
 	verbose   bool
 	debug     bool
 	stats     bool
 	exitAfter time.Duration
 
+	shutdownTimeout time.Duration
+	restartPolicy   MultiOption
+
 	pprof string
+	admin string
 
 	splitOutput bool
 
-	inputDummy   MultiOption
-	outputDummy  MultiOption
-	outputStdout bool
-	outputNull   bool
+	inputDummy         MultiOption
+	outputDummy        MultiOption
+	outputStdout       bool
+	outputStdoutFormat string
+	outputNull         bool
 
 	inputTCP        MultiOption
 	inputTCPConfig  TCPInputConfig
@@ -45,26 +54,49 @@ type AppSettings struct {
 	outputTCPConfig TCPOutputConfig
 	outputTCPStats  bool
 
+	outputTCPRaw       MultiOption
+	outputTCPRawConfig TCPRawOutputConfig
+
 	inputFile        MultiOption
-	inputFileLoop    bool
+	inputFileConfig  FileInputConfig
 	outputFile       MultiOption
 	outputFileConfig FileOutputConfig
 
-	inputRAW                MultiOption
-	inputRAWEngine          string
-	inputRAWTrackResponse   bool
-	inputRAWRealIPHeader    string
-	inputRAWExpire          time.Duration
-	inputRAWBpfFilter       string
-	inputRAWTimestampType   string
-	copyBufferSize          int
-	inputRAWImmediateMode   bool
-	inputRawBufferSize      int
-	inputRAWOverrideSnapLen bool
+	outputPcap        MultiOption
+	payloadConnection bool
+
+	inputHAR  MultiOption
+	outputHAR MultiOption
+
+	inputRequests       MultiOption
+	inputRequestsConfig RequestsInputConfig
+
+	inputOpenAPI       MultiOption
+	inputOpenAPIConfig OpenAPIInputConfig
+
+	inputS3  MultiOption
+	outputS3 MultiOption
+	s3Config S3Config
+
+	inputRAW              MultiOption
+	inputRAWEngine        string
+	inputRAWTrackResponse bool
+	inputRAWRealIPHeader  string
+	inputRAWExpire        time.Duration
+	inputRAWBpfFilter     string
+	inputRAWTimestampType string
+	copyBufferSize        int
+	inputRawBufferSize    int
+	inputRAWConfig        RAWInputConfig
 
 	middleware string
 
-	inputHTTP  MultiOption
+	inputHTTP       MultiOption
+	inputHTTPConfig HTTPInputConfig
+
+	inputProxy       MultiOption
+	inputProxyConfig ProxyInputConfig
+
 	outputHTTP MultiOption
 
 	prettifyHTTP bool
@@ -72,6 +104,12 @@ type AppSettings struct {
 	outputHTTPConfig HTTPOutputConfig
 	modifierConfig   HTTPModifierConfig
 
+	// Modifier config loaded by the last reload, stored as *HTTPModifierConfig
+	reloadedModifierConfig atomic.Value
+
+	outputDiff       MultiOption
+	outputDiffConfig DiffOutputConfig
+
 	inputKafkaConfig  KafkaConfig
 	outputKafkaConfig KafkaConfig
 }
@@ -88,126 +126,198 @@ func usage() {
 func init() {
 	flag.Usage = usage
 
-	flag.StringVar(&Settings.pprof, "http-pprof", "", "Enable profiling. Starts  http server on specified port, exposing special /debug/pprof endpoint. Example: `:8181`")
-	flag.BoolVar(&Settings.verbose, "verbose", false, "Turn on more verbose output")
-	flag.BoolVar(&Settings.debug, "debug", false, "Turn on debug output, shows all intercepted traffic. Works only when with `verbose` flag")
-	flag.BoolVar(&Settings.stats, "stats", false, "Turn on queue stats output")
-	flag.DurationVar(&Settings.exitAfter, "exit-after", 0, "exit after specified duration")
+	registerFlags(flag.CommandLine, &Settings)
+}
 
-	flag.BoolVar(&Settings.splitOutput, "split-output", false, "By default each output gets same traffic. If set to `true` it splits traffic equally among all outputs.")
+// registerFlags registers all options in given flag set. Each named pipeline gets own flag set and settings.
+func registerFlags(fs *flag.FlagSet, s *AppSettings) {
+	fs.StringVar(&s.config, "config", "", "Load options from YAML or JSON file. Keys are option names without dashes, repeated options set as lists. Options set on command line take priority:\n\tgor --config ./gor.yaml --output-http-timeout 10s")
+	fs.StringVar(&s.pprof, "http-pprof", "", "Enable profiling. Starts  http server on specified port, exposing special /debug/pprof endpoint. Example: `:8181`")
+	fs.StringVar(&s.admin, "http-admin", "", "Starts admin http server on specified address, exposing Prometheus metrics on /metrics and list of active plugins on /plugins. Example: `:8282`")
+	fs.BoolVar(&s.verbose, "verbose", false, "Turn on more verbose output")
+	fs.BoolVar(&s.debug, "debug", false, "Turn on debug output, shows all intercepted traffic. Works only when with `verbose` flag")
+	fs.BoolVar(&s.stats, "stats", false, "Turn on queue stats output")
+	fs.DurationVar(&s.exitAfter, "exit-after", 0, "exit after specified duration")
+	fs.DurationVar(&s.shutdownTimeout, "shutdown-timeout", 10*time.Second, "On exit, how long to wait until middleware and outputs deliver buffered payloads")
+	fs.Var(&s.restartPolicy, "restart-policy", "What to do when plugin fails: 'abort' Gor (default), 'disable' the plugin, or 'retry' to create it again with backoff, 'retry:N' gives up after N restarts in a row. Can be set per plugin, using its option name:\n\tgor --input-raw :80 --output-http staging.com --restart-policy output-http=retry:5 --restart-policy input-raw=disable")
+
+	fs.BoolVar(&s.splitOutput, "split-output", false, "By default each output gets same traffic. If set to `true` it splits traffic equally among all outputs.")
+
+	fs.Var(&s.inputDummy, "input-dummy", "Used for testing outputs. Emits 'Get /' request every 1s")
+	fs.Var(&s.outputDummy, "output-dummy", "DEPRECATED: use --output-stdout instead")
+
+	fs.BoolVar(&s.outputStdout, "output-stdout", false, "Used for testing inputs. Just prints to console data coming from inputs.")
+	fs.StringVar(&s.outputStdoutFormat, "output-stdout-format", CaptureFormatText, "Format of --output-stdout: 'text' as is, or 'json' one JSON object per line, which can be processed with jq:\n\tgor --input-raw :80 --output-stdout --output-stdout-format json | jq 'select(.type == \"request\") | .url'")
+
+	fs.BoolVar(&s.outputNull, "output-null", false, "Used for testing inputs. Drops all requests.")
+
+	fs.Var(&s.inputTCP, "input-tcp", "Used for internal communication between Gor instances. Example: \n\t# Receive requests from other Gor instances on 28020 port, and redirect output to staging\n\tgor --input-tcp :28020 --output-http staging.com")
+	fs.BoolVar(&s.inputTCPConfig.secure, "input-tcp-secure", false, "Turn on TLS security. Do not forget to specify certificate and key files.")
+	fs.StringVar(&s.inputTCPConfig.certificatePath, "input-tcp-certificate", "", "Path to PEM encoded certificate file. Used when TLS turned on.")
+	fs.StringVar(&s.inputTCPConfig.keyPath, "input-tcp-certificate-key", "", "Path to PEM encoded certificate key file. Used when TLS turned on.")
+
+	fs.Var(&s.outputTCP, "output-tcp", "Used for internal communication between Gor instances. Example: \n\t# Listen for requests on 80 port and forward them to other Gor instance on 28020 port\n\tgor --input-raw :80 --output-tcp replay.local:28020")
+	fs.BoolVar(&s.outputTCPConfig.secure, "output-tcp-secure", false, "Use TLS secure connection. --input-file on another end should have TLS turned on as well.")
+	fs.BoolVar(&s.outputTCPStats, "output-tcp-stats", false, "Report TCP output queue stats to console every 5 seconds.")
+
+	fs.Var(&s.outputTCPRaw, "output-tcp-raw", "Replay requests of non-HTTP protocols, captured with --input-raw-protocol other than http. Opens socket per captured client connection, and sends messages in captured order:\n\tgor --input-raw :6379 --input-raw-protocol redis --output-tcp-raw staging-redis:6379")
+	fs.DurationVar(&s.outputTCPRawConfig.timeout, "output-tcp-raw-timeout", time.Minute, "Socket of captured connection is closed when there were no messages during this period.")
+	fs.BoolVar(&s.outputTCPRawConfig.trackResponses, "output-tcp-raw-track-response", false, "If turned on, replayed responses are split by protocol parser of captured traffic, and sent to all outputs like stdout, file and etc. Protocols without parser end response after 100ms of silence.")
+
+	fs.Var(&s.inputFile, "input-file", "Read requests from file: \n\tgor --input-file ./requests.gor --output-http staging.com")
+	fs.BoolVar(&s.inputFileConfig.loop, "input-file-loop", false, "Loop input files, useful for performance testing.")
+	fs.Var(&s.inputFileConfig.start, "input-file-start", "Replay only payloads made at or after given time, in RFC3339, '2006-01-02 15:04:05' local time, or unix timestamp format:\n\tgor --input-file 'requests-*.gor' --input-file-start '2016-05-01 14:00' --input-file-end '2016-05-01 14:15' --output-http staging.com")
+	fs.Var(&s.inputFileConfig.end, "input-file-end", "Replay only payloads made before given time, same format as --input-file-start.")
+	fs.IntVar(&s.inputFileConfig.skip, "input-file-skip", 0, "Skip first N payloads, after --input-file-start.")
+	fs.IntVar(&s.inputFileConfig.limit, "input-file-limit", 0, "Stop after replaying N payloads.")
+	fs.IntVar(&s.inputFileConfig.maxOpen, "input-file-max-open", 256, "When --input-file matches multiple files, how many of them can be open at once while merging payloads by time. 0 means no limit.")
+	fs.StringVar(&s.inputFileConfig.sourceHeader, "input-file-source-header", "", "Add header with name of the file request read from, relative to --input-file pattern. Useful when replaying captures from multiple hosts:\n\tgor --input-file 'captures/*/requests.gor' --input-file-source-header X-Gor-Source --output-http staging.com")
+
+	fs.Var(&s.outputFile, "output-file", "Write incoming requests to file: \n\tgor --input-raw :80 --output-file ./requests.gor")
+	fs.DurationVar(&s.outputFileConfig.flushInterval, "output-file-flush-interval", time.Second, "Interval for forcing buffer flush to the file, default: 1s.")
+	fs.BoolVar(&s.outputFileConfig.append, "output-file-append", false, "The flushed chunk is appended to existence file or not. ")
 
-	flag.Var(&Settings.inputDummy, "input-dummy", "Used for testing outputs. Emits 'Get /' request every 1s")
-	flag.Var(&Settings.outputDummy, "output-dummy", "DEPRECATED: use --output-stdout instead")
+	// Set default
+	s.outputFileConfig.sizeLimit.Set("32mb")
+	fs.Var(&s.outputFileConfig.sizeLimit, "output-file-size-limit", "Size of each chunk. Default: 32mb")
+	fs.IntVar(&s.outputFileConfig.queueLimit, "output-file-queue-limit", 256, "The length of the chunk queue. Default: 256")
+	s.outputFileConfig.outputFileMaxSize.Set("-1")
+	fs.Var(&s.outputFileConfig.outputFileMaxSize, "output-file-max-size-limit", "Max size of output file, Default: 1TB")
+	fs.StringVar(&s.outputFileConfig.format, "output-file-format", CaptureFormatText, "File format: 'text' payloads separated by line, 'binary' indexed format with checksums, which supports any payload content and fast seeking, or 'json' JSON Lines with parsed request line, headers and body. --input-file detects format automatically.")
+	fs.IntVar(&s.outputFileConfig.compressionLevel, "output-file-compression-level", 0, "Compression level of files with .gz (1-9), .zst (1-22) or .lz4 extension, for lz4 any positive level enables high compression mode. Default: codec default level.")
 
-	flag.BoolVar(&Settings.outputStdout, "output-stdout", false, "Used for testing inputs. Just prints to console data coming from inputs.")
+	fs.Var(&s.outputPcap, "output-pcap", "Write requests and responses as TCP/IP packets in pcapng format, or in pcap format if file has .pcap extension, for analysis in Wireshark. Timestamps, client and server addresses captured by --input-raw are kept:\n\tgor --input-raw :80 --input-raw-track-response --output-pcap traffic.pcapng")
+	fs.BoolVar(&s.payloadConnection, "payload-connection", false, "Add client and server addresses of captured connection to payload header, as `client>server` field after latency. Turned on automatically by --output-pcap and --output-tcp-raw, which restore connections.")
 
-	flag.BoolVar(&Settings.outputNull, "output-null", false, "Used for testing inputs. Drops all requests.")
+	fs.Var(&s.inputHAR, "input-har", "Replay requests from HAR file exported by browser or HTTP tool, ordered by startedDateTime. Supports file patterns and --input-file-* options:\n\tgor --input-har session.har --output-http staging.com")
+	fs.Var(&s.outputHAR, "output-har", "Write requests with original and replayed responses to HAR file, which can be opened in browser developer tools. File is completed on exit:\n\tgor --input-raw :80 --input-raw-track-response --output-har traffic.har")
 
-	flag.Var(&Settings.inputTCP, "input-tcp", "Used for internal communication between Gor instances. Example: \n\t# Receive requests from other Gor instances on 28020 port, and redirect output to staging\n\tgor --input-tcp :28020 --output-http staging.com")
-	flag.BoolVar(&Settings.inputTCPConfig.secure, "input-tcp-secure", false, "Turn on TLS security. Do not forget to specify certificate and key files.")
-	flag.StringVar(&Settings.inputTCPConfig.certificatePath, "input-tcp-certificate", "", "Path to PEM encoded certificate file. Used when TLS turned on.")
-	flag.StringVar(&Settings.inputTCPConfig.keyPath, "input-tcp-certificate-key", "", "Path to PEM encoded certificate key file. Used when TLS turned on.")
+	fs.Var(&s.inputRequests, "input-requests", "Replay hand-crafted requests from file of curl commands, or of request blocks separated by '###' as in .http files. Use '|N' to set requests per second, and --input-file-loop to repeat them:\n\tgor --input-requests 'requests.http|100' --input-file-loop --output-http staging.com")
+	fs.Var(&s.inputRequestsConfig.vars, "input-requests-var", "Value of {{name}} variable in --input-requests files, in name=value format. Overrides '@name = value' definitions of the file, and environment variables:\n\tgor --input-requests requests.http --input-requests-var host=staging.com --output-stdout")
 
-	flag.Var(&Settings.outputTCP, "output-tcp", "Used for internal communication between Gor instances. Example: \n\t# Listen for requests on 80 port and forward them to other Gor instance on 28020 port\n\tgor --input-raw :80 --output-tcp replay.local:28020")
-	flag.BoolVar(&Settings.outputTCPConfig.secure, "output-tcp-secure", false, "Use TLS secure connection. --input-file on another end should have TLS turned on as well.")
-	flag.BoolVar(&Settings.outputTCPStats, "output-tcp-stats", false, "Report TCP output queue stats to console every 5 seconds.")
+	fs.Var(&s.inputOpenAPI, "input-openapi", "Generate requests for operations of OpenAPI 3 spec in JSON or YAML format, with parameters and bodies generated from schemas. Useful to load test endpoints without production traffic:\n\tgor --input-openapi api.yaml --input-openapi-rps 100 --output-http staging.com")
+	fs.IntVar(&s.inputOpenAPIConfig.rps, "input-openapi-rps", 10, "Requests per second generated by --input-openapi.")
+	fs.Var(&s.inputOpenAPIConfig.weights, "input-openapi-weight", "Weight of operation in generated traffic, by operationId or 'METHOD /path'. Default weight is 1, or 'x-gor-weight' of operation, and 0 disables operation:\n\tgor --input-openapi api.yaml --input-openapi-weight listUsers=10 --input-openapi-weight 'DELETE /users/{id}=0'")
+	fs.StringVar(&s.inputOpenAPIConfig.server, "input-openapi-server", "", "Base URL of generated requests, by default the first server of the spec: http://staging.com/api/v1")
 
-	flag.Var(&Settings.inputFile, "input-file", "Read requests from file: \n\tgor --input-file ./requests.gor --output-http staging.com")
-	flag.BoolVar(&Settings.inputFileLoop, "input-file-loop", false, "Loop input files, useful for performance testing.")
+	fs.Var(&s.outputS3, "output-s3", "Write requests to S3 compatible storage, in bucket/key format. Key supports the same variables as --output-file, and chunks are uploaded in background when completed, according to --output-file-* options:\n\tgor --input-raw :80 --output-s3 'captures/%Y%m%d/requests-%H.gor.gz'")
+	fs.Var(&s.inputS3, "input-s3", "Read requests from all objects with given prefix in S3 compatible storage, merged by time. Supports --input-file-* options:\n\tgor --input-s3 'captures/20160501/' --output-http staging.com")
+	fs.StringVar(&s.s3Config.endpoint, "s3-endpoint", "", "Endpoint of S3 compatible storage, like MinIO: http://localhost:9000. Credentials taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.")
+	fs.StringVar(&s.s3Config.region, "s3-region", "", "S3 region. Default: AWS_REGION environment variable or us-east-1.")
 
-	flag.Var(&Settings.outputFile, "output-file", "Write incoming requests to file: \n\tgor --input-raw :80 --output-file ./requests.gor")
-	flag.DurationVar(&Settings.outputFileConfig.flushInterval, "output-file-flush-interval", time.Second, "Interval for forcing buffer flush to the file, default: 1s.")
-	flag.BoolVar(&Settings.outputFileConfig.append, "output-file-append", false, "The flushed chunk is appended to existence file or not. ")
+	fs.BoolVar(&s.prettifyHTTP, "prettify-http", false, "If enabled, will automatically decode requests and responses with: Content-Encodning: gzip and Transfer-Encoding: chunked. Useful for debugging, in conjuction with --output-stdout")
 
-	// Set default
-	Settings.outputFileConfig.sizeLimit.Set("32mb")
-	flag.Var(&Settings.outputFileConfig.sizeLimit, "output-file-size-limit", "Size of each chunk. Default: 32mb")
-	flag.IntVar(&Settings.outputFileConfig.queueLimit, "output-file-queue-limit", 256, "The length of the chunk queue. Default: 256")
-	Settings.outputFileConfig.outputFileMaxSize.Set("-1")
-	flag.Var(&Settings.outputFileConfig.outputFileMaxSize, "output-file-max-size-limit", "Max size of output file, Default: 1TB")
+	fs.Var(&s.inputRAW, "input-raw", "Capture traffic from given port (use RAW sockets and require *sudo* access):\n\t# Capture traffic from 8080 port\n\tgor --input-raw :8080 --output-http staging.com")
 
-	flag.BoolVar(&Settings.prettifyHTTP, "prettify-http", false, "If enabled, will automatically decode requests and responses with: Content-Encodning: gzip and Transfer-Encoding: chunked. Useful for debugging, in conjuction with --output-stdout")
+	fs.BoolVar(&s.inputRAWTrackResponse, "input-raw-track-response", false, "If turned on Gor will track responses in addition to requests, and they will be available to middleware and file output.")
 
-	flag.Var(&Settings.inputRAW, "input-raw", "Capture traffic from given port (use RAW sockets and require *sudo* access):\n\t# Capture traffic from 8080 port\n\tgor --input-raw :8080 --output-http staging.com")
+	fs.StringVar(&s.inputRAWEngine, "input-raw-engine", "libpcap", "Intercept traffic using `libpcap` (default), and `raw_socket`")
 
-	flag.BoolVar(&Settings.inputRAWTrackResponse, "input-raw-track-response", false, "If turned on Gor will track responses in addition to requests, and they will be available to middleware and file output.")
+	fs.StringVar(&s.inputRAWRealIPHeader, "input-raw-realip-header", "", "If not blank, injects header with given name and real IP value to the request payload. Usually this header should be named: X-Real-IP. Used by --input-proxy as well.")
+	fs.Var(&s.inputRAWConfig.protocol, "input-raw-protocol", "Protocol of captured traffic: `http` (default), `redis`, `line`, `length:<bytes>[:le]`, or `tcp` for other protocols. Non-HTTP traffic is replayed by --output-tcp-raw. Can be set for all ports, or for single port as `port=protocol`:\n\tgor --input-raw :80 --input-raw :6379 --input-raw-protocol 6379=redis --output-http staging.com --output-tcp-raw staging-redis:6379")
+	fs.DurationVar(&s.inputRAWConfig.tcpGap, "input-raw-tcp-gap", 100*time.Millisecond, "Silence after which message is complete, with --input-raw-protocol tcp.")
+	fs.StringVar(&s.inputRAWConfig.tlsKeyLog, "input-raw-tls-keylog", "", "Decrypt HTTPS traffic using TLS key log file in SSLKEYLOGFILE format, written by the application. TLS 1.3, and TLS 1.2 with AES-GCM or ChaCha20-Poly1305 ciphers are supported:\n\tSSLKEYLOGFILE=/var/run/keys.log ./server &\n\tgor --input-raw :443 --input-raw-tls-keylog /var/run/keys.log --output-http staging.com")
 
-	flag.StringVar(&Settings.inputRAWEngine, "input-raw-engine", "libpcap", "Intercept traffic using `libpcap` (default), and `raw_socket`")
+	fs.DurationVar(&s.inputRAWExpire, "input-raw-expire", time.Second*2, "How much it should wait for the last TCP packet, till consider that TCP message complete.")
 
-	flag.StringVar(&Settings.inputRAWRealIPHeader, "input-raw-realip-header", "", "If not blank, injects header with given name and real IP value to the request payload. Usually this header should be named: X-Real-IP")
+	fs.StringVar(&s.inputRAWBpfFilter, "input-raw-bpf-filter", "", "BPF filter to write custom expressions. Can be useful in case of non standard network interfaces like tunneling or SPAN port. Example: --input-raw-bpf-filter 'dst port 80'")
 
-	flag.DurationVar(&Settings.inputRAWExpire, "input-raw-expire", time.Second*2, "How much it should wait for the last TCP packet, till consider that TCP message complete.")
+	fs.StringVar(&s.inputRAWTimestampType, "input-raw-timestamp-type", "", "Possible values: PCAP_TSTAMP_HOST, PCAP_TSTAMP_HOST_LOWPREC, PCAP_TSTAMP_HOST_HIPREC, PCAP_TSTAMP_ADAPTER, PCAP_TSTAMP_ADAPTER_UNSYNCED. This values not supported on all systems, GoReplay will tell you available values of you put wrong one.")
+	fs.IntVar(&s.copyBufferSize, "copy-buffer-size", 5*1024*1024, "Set the buffer size for an individual request (default 5M)")
+	fs.BoolVar(&s.inputRAWConfig.overrideSnapLen, "input-raw-override-snaplen", false, "Override the capture snaplen to be 64k. Required for some Virtualized environments")
+	fs.BoolVar(&s.inputRAWConfig.immediateMode, "input-raw-immediate-mode", false, "Set pcap interface to immediate mode.")
 
-	flag.StringVar(&Settings.inputRAWBpfFilter, "input-raw-bpf-filter", "", "BPF filter to write custom expressions. Can be useful in case of non standard network interfaces like tunneling or SPAN port. Example: --input-raw-bpf-filter 'dst port 80'")
+	fs.IntVar(&s.inputRawBufferSize, "input-raw-buffer-size", 0, "Controls size of the OS buffer (in bytes) which holds packets until they dispatched. Default value depends by system: in Linux around 2MB. If you see big package drop, increase this value.")
 
-	flag.StringVar(&Settings.inputRAWTimestampType, "input-raw-timestamp-type", "", "Possible values: PCAP_TSTAMP_HOST, PCAP_TSTAMP_HOST_LOWPREC, PCAP_TSTAMP_HOST_HIPREC, PCAP_TSTAMP_ADAPTER, PCAP_TSTAMP_ADAPTER_UNSYNCED. This values not supported on all systems, GoReplay will tell you available values of you put wrong one.")
-	flag.IntVar(&Settings.copyBufferSize, "copy-buffer-size", 5*1024*1024, "Set the buffer size for an individual request (default 5M)")
-	flag.BoolVar(&Settings.inputRAWOverrideSnapLen, "input-raw-override-snaplen", false, "Override the capture snaplen to be 64k. Required for some Virtualized environments")
-	flag.BoolVar(&Settings.inputRAWImmediateMode, "input-raw-immediate-mode", false, "Set pcap interface to immediate mode.")
+	fs.StringVar(&s.middleware, "middleware", "", "Used for modifying traffic using external command")
 
-	flag.IntVar(&Settings.inputRawBufferSize, "input-raw-buffer-size", 0, "Controls size of the OS buffer (in bytes) which holds packets until they dispatched. Default value depends by system: in Linux around 2MB. If you see big package drop, increase this value.")
+	fs.Var(&s.inputHTTP, "input-http", "Read requests from HTTP, should be explicitly sent from your application. Request to any path is recorded as is, POST to /gor/payloads accepts batch of payloads in Gor or JSON Lines format:\n\t# Listen for http on 9000\n\tgor --input-http :9000 --output-http staging.com")
+	fs.Var(&s.inputHTTPConfig.tokens, "input-http-token", "Accept only requests with given token in X-Gor-Token header. Can be specified multiple times, to rotate tokens.")
+	fs.StringVar(&s.inputHTTPConfig.certificatePath, "input-http-certificate", "", "Path to PEM encoded certificate file. Turns on HTTPS.")
+	fs.StringVar(&s.inputHTTPConfig.keyPath, "input-http-certificate-key", "", "Path to PEM encoded certificate key file.")
+	fs.StringVar(&s.inputHTTPConfig.clientCAPath, "input-http-client-ca", "", "Path to PEM encoded CA certificates. Turns on mutual TLS: only clients with certificate signed by these CAs are accepted.")
+	fs.IntVar(&s.inputHTTPConfig.queueSize, "input-http-queue-size", 10000, "Number of payloads waiting for outputs. When queue is full requests are rejected with 429 status, so senders can retry later.")
+	s.inputHTTPConfig.maxBodySize.Set("32mb")
+	fs.Var(&s.inputHTTPConfig.maxBodySize, "input-http-max-body-size", "Maximum size of request body, also after decompression of payload batches. Larger requests are rejected with 413 status. Default: 32mb")
+	fs.DurationVar(&s.inputHTTPConfig.readTimeout, "input-http-read-timeout", time.Minute, "Time to read whole request, including body.")
 
-	flag.StringVar(&Settings.middleware, "middleware", "", "Used for modifying traffic using external command")
+	fs.Var(&s.inputProxy, "input-proxy", "Run reverse proxy on given address in front of application, and capture requests and responses passing through it. Unlike --input-raw does not require root access or libpcap:\n\t# Proxy port 8080 to application on 8081, and shadow traffic to staging\n\tgor --input-proxy :8080 --input-proxy-upstream http://127.0.0.1:8081 --output-http staging.com")
+	fs.StringVar(&s.inputProxyConfig.upstream, "input-proxy-upstream", "", "Address of application to which --input-proxy forwards requests, e.g. http://127.0.0.1:8081")
+	fs.IntVar(&s.inputProxyConfig.queueSize, "input-proxy-queue-size", 10000, "Number of captured payloads waiting for outputs. When queue is full new payloads are dropped, proxied traffic is never delayed.")
 
-	// flag.Var(&Settings.inputHTTP, "input-http", "Read requests from HTTP, should be explicitly sent from your application:\n\t# Listen for http on 9000\n\tgor --input-http :9000 --output-http staging.com")
+	fs.Var(&s.outputHTTP, "output-http", "Forwards incoming requests to given http address.\n\t# Redirect all incoming requests to staging.com address \n\tgor --input-raw :80 --output-http http://staging.com")
+	fs.IntVar(&s.outputHTTPConfig.BufferSize, "output-http-response-buffer", 0, "HTTP response buffer size, all data after this size will be discarded.")
+	fs.BoolVar(&s.outputHTTPConfig.CompatibilityMode, "output-http-compatibility-mode", false, "Use standard Go client, instead of built-in implementation. Can be slower, but more compatible.")
+	fs.BoolVar(&s.outputHTTPConfig.HTTP2, "output-http-http2", false, "Replay requests using HTTP/2, multiplexed over pooled connections. For http:// addresses h2c with prior knowledge is used, for https:// protocol negotiated using TLS ALPN. Responses converted back to HTTP/1.1 format.")
+	fs.DurationVar(&s.outputHTTPConfig.HTTP2PingInterval, "output-http-http2-ping-interval", 30*time.Second, "HTTP/2 connection without frames during this period is checked with ping, and closed if there is no answer in --output-http-timeout. 0 disables checks.")
 
-	flag.Var(&Settings.outputHTTP, "output-http", "Forwards incoming requests to given http address.\n\t# Redirect all incoming requests to staging.com address \n\tgor --input-raw :80 --output-http http://staging.com")
-	flag.IntVar(&Settings.outputHTTPConfig.BufferSize, "output-http-response-buffer", 0, "HTTP response buffer size, all data after this size will be discarded.")
-	flag.BoolVar(&Settings.outputHTTPConfig.CompatibilityMode, "output-http-compatibility-mode", false, "Use standard Go client, instead of built-in implementation. Can be slower, but more compatible.")
+	fs.IntVar(&s.outputHTTPConfig.workersMin, "output-http-workers-min", 0, "Gor uses dynamic worker scaling. Enter a number to set a minimum number of workers. default = 1.")
+	fs.IntVar(&s.outputHTTPConfig.workersMax, "output-http-workers", 0, "Gor uses dynamic worker scaling. Enter a number to set a maximum number of workers. default = 0 = unlimited.")
+	fs.IntVar(&s.outputHTTPConfig.queueLen, "output-http-queue-len", 1000, "Number of requests that can be queued for output, if all workers are busy. default = 1000")
+
+	fs.IntVar(&s.outputHTTPConfig.redirectLimit, "output-http-redirects", 0, "Enable how often redirects should be followed.")
+	fs.DurationVar(&s.outputHTTPConfig.Timeout, "output-http-timeout", 5*time.Second, "Specify HTTP request/response timeout. By default 5s. Example: --output-http-timeout 30s")
+	fs.BoolVar(&s.outputHTTPConfig.TrackResponses, "output-http-track-response", false, "If turned on, HTTP output responses will be set to all outputs like stdout, file and etc.")
+
+	fs.BoolVar(&s.outputHTTPConfig.stats, "output-http-stats", false, "Report http output queue stats to console every N milliseconds. See output-http-stats-ms")
+	fs.IntVar(&s.outputHTTPConfig.statsMs, "output-http-stats-ms", 5000, "Report http output queue stats to console every N milliseconds. default: 5000")
+	fs.BoolVar(&s.outputHTTPConfig.OriginalHost, "http-original-host", false, "Normally gor replaces the Host http header with the host supplied with --output-http.  This option disables that behavior, preserving the original Host header.")
+	fs.BoolVar(&s.outputHTTPConfig.Debug, "output-http-debug", false, "Enables http debug output.")
+
+	fs.Var(&s.outputDiff, "output-diff", "Compare original and replayed responses and write JSON report of differences to the given file, use '-' for stdout. Requires both --input-raw-track-response and --output-http-track-response:\n\tgor --input-raw :80 --input-raw-track-response --output-http staging.com --output-http-track-response --output-diff ./diff.jsonl")
+	// Set default
+	s.outputDiffConfig.ignoreHeaders.Set("Date")
+	fs.Var(&s.outputDiffConfig.ignoreHeaders, "output-diff-ignore-header", "Response header which should not be compared. Default: Date")
+	fs.Var(&s.outputDiffConfig.maskFields, "output-diff-mask-field", "JSON body field which should not be compared, nested fields separated by dot, '*' matches any key or array index:\n\tgor --output-diff ./diff.jsonl --output-diff-mask-field 'data.*.updated_at'")
+	fs.DurationVar(&s.outputDiffConfig.timeout, "output-diff-timeout", 60*time.Second, "How long to wait for both original and replayed responses of the same request.")
 
-	flag.IntVar(&Settings.outputHTTPConfig.workersMin, "output-http-workers-min", 0, "Gor uses dynamic worker scaling. Enter a number to set a minimum number of workers. default = 1.")
-	flag.IntVar(&Settings.outputHTTPConfig.workersMax, "output-http-workers", 0, "Gor uses dynamic worker scaling. Enter a number to set a maximum number of workers. default = 0 = unlimited.")
-	flag.IntVar(&Settings.outputHTTPConfig.queueLen, "output-http-queue-len", 1000, "Number of requests that can be queued for output, if all workers are busy. default = 1000")
+	fs.StringVar(&s.outputHTTPConfig.elasticSearch, "output-http-elasticsearch", "", "Send request and response stats to ElasticSearch:\n\tgor --input-raw :8080 --output-http staging.com --output-http-elasticsearch 'es_host:api_port/index_name'")
 
-	flag.IntVar(&Settings.outputHTTPConfig.redirectLimit, "output-http-redirects", 0, "Enable how often redirects should be followed.")
-	flag.DurationVar(&Settings.outputHTTPConfig.Timeout, "output-http-timeout", 5*time.Second, "Specify HTTP request/response timeout. By default 5s. Example: --output-http-timeout 30s")
-	flag.BoolVar(&Settings.outputHTTPConfig.TrackResponses, "output-http-track-response", false, "If turned on, HTTP output responses will be set to all outputs like stdout, file and etc.")
+	fs.StringVar(&s.outputKafkaConfig.host, "output-kafka-host", "", "Read request and response stats from Kafka:\n\tgor --input-raw :8080 --output-kafka-host '192.168.0.1:9092,192.168.0.2:9092'")
+	fs.StringVar(&s.outputKafkaConfig.topic, "output-kafka-topic", "", "Read request and response stats from Kafka:\n\tgor --input-raw :8080 --output-kafka-topic 'kafka-log'")
+	fs.BoolVar(&s.outputKafkaConfig.useJSON, "output-kafka-json-format", false, "If turned on, it will serialize messages from GoReplay text format to JSON.")
 
-	flag.BoolVar(&Settings.outputHTTPConfig.stats, "output-http-stats", false, "Report http output queue stats to console every N milliseconds. See output-http-stats-ms")
-	flag.IntVar(&Settings.outputHTTPConfig.statsMs, "output-http-stats-ms", 5000, "Report http output queue stats to console every N milliseconds. default: 5000")
-	flag.BoolVar(&Settings.outputHTTPConfig.OriginalHost, "http-original-host", false, "Normally gor replaces the Host http header with the host supplied with --output-http.  This option disables that behavior, preserving the original Host header.")
-	flag.BoolVar(&Settings.outputHTTPConfig.Debug, "output-http-debug", false, "Enables http debug output.")
+	fs.StringVar(&s.inputKafkaConfig.host, "input-kafka-host", "", "Send request and response stats to Kafka:\n\tgor --output-stdout --input-kafka-host '192.168.0.1:9092,192.168.0.2:9092'")
+	fs.StringVar(&s.inputKafkaConfig.topic, "input-kafka-topic", "", "Send request and response stats to Kafka:\n\tgor --output-stdout --input-kafka-topic 'kafka-log'")
+	fs.BoolVar(&s.inputKafkaConfig.useJSON, "input-kafka-json-format", false, "If turned on, it will assume that messages coming in JSON format rather than  GoReplay text format.")
 
-	flag.StringVar(&Settings.outputHTTPConfig.elasticSearch, "output-http-elasticsearch", "", "Send request and response stats to ElasticSearch:\n\tgor --input-raw :8080 --output-http staging.com --output-http-elasticsearch 'es_host:api_port/index_name'")
+	fs.Var(&s.modifierConfig.headers, "http-set-header", "Inject additional headers to http reqest:\n\tgor --input-raw :8080 --output-http staging.com --http-set-header 'User-Agent: Gor'")
+	fs.Var(&s.modifierConfig.headers, "output-http-header", "WARNING: `--output-http-header` DEPRECATED, use `--http-set-header` instead")
 
-	flag.StringVar(&Settings.outputKafkaConfig.host, "output-kafka-host", "", "Read request and response stats from Kafka:\n\tgor --input-raw :8080 --output-kafka-host '192.168.0.1:9092,192.168.0.2:9092'")
-	flag.StringVar(&Settings.outputKafkaConfig.topic, "output-kafka-topic", "", "Read request and response stats from Kafka:\n\tgor --input-raw :8080 --output-kafka-topic 'kafka-log'")
-	flag.BoolVar(&Settings.outputKafkaConfig.useJSON, "output-kafka-json-format", false, "If turned on, it will serialize messages from GoReplay text format to JSON.")
+	fs.Var(&s.modifierConfig.headerRewrite, "http-rewrite-header", "Rewrite the request header based on a mapping:\n\tgor --input-raw :8080 --output-http staging.com --http-rewrite-header Host: (.*).example.com,$1.beta.example.com")
 
-	flag.StringVar(&Settings.inputKafkaConfig.host, "input-kafka-host", "", "Send request and response stats to Kafka:\n\tgor --output-stdout --input-kafka-host '192.168.0.1:9092,192.168.0.2:9092'")
-	flag.StringVar(&Settings.inputKafkaConfig.topic, "input-kafka-topic", "", "Send request and response stats to Kafka:\n\tgor --output-stdout --input-kafka-topic 'kafka-log'")
-	flag.BoolVar(&Settings.inputKafkaConfig.useJSON, "input-kafka-json-format", false, "If turned on, it will assume that messages coming in JSON format rather than  GoReplay text format.")
+	fs.Var(&s.modifierConfig.params, "http-set-param", "Set request url param, if param already exists it will be overwritten:\n\tgor --input-raw :8080 --output-http staging.com --http-set-param api_key=1")
 
-	flag.Var(&Settings.modifierConfig.headers, "http-set-header", "Inject additional headers to http reqest:\n\tgor --input-raw :8080 --output-http staging.com --http-set-header 'User-Agent: Gor'")
-	flag.Var(&Settings.modifierConfig.headers, "output-http-header", "WARNING: `--output-http-header` DEPRECATED, use `--http-set-header` instead")
+	fs.Var(&s.modifierConfig.methods, "http-allow-method", "Whitelist of HTTP methods to replay. Anything else will be dropped:\n\tgor --input-raw :8080 --output-http staging.com --http-allow-method GET --http-allow-method OPTIONS")
+	fs.Var(&s.modifierConfig.methods, "output-http-method", "WARNING: `--output-http-method` DEPRECATED, use `--http-allow-method` instead")
 
-	flag.Var(&Settings.modifierConfig.headerRewrite, "http-rewrite-header", "Rewrite the request header based on a mapping:\n\tgor --input-raw :8080 --output-http staging.com --http-rewrite-header Host: (.*).example.com,$1.beta.example.com")
+	fs.Var(&s.modifierConfig.urlRegexp, "http-allow-url", "A regexp to match requests against. Filter get matched against full url with domain. Anything else will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-url ^www.")
+	fs.Var(&s.modifierConfig.urlRegexp, "output-http-url-regexp", "WARNING: `--output-http-url-regexp` DEPRECATED, use `--http-allow-url` instead")
 
-	flag.Var(&Settings.modifierConfig.params, "http-set-param", "Set request url param, if param already exists it will be overwritten:\n\tgor --input-raw :8080 --output-http staging.com --http-set-param api_key=1")
+	fs.Var(&s.modifierConfig.urlNegativeRegexp, "http-disallow-url", "A regexp to match requests against. Filter get matched against full url with domain. Anything else will be forwarded:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-url ^www.")
 
-	flag.Var(&Settings.modifierConfig.methods, "http-allow-method", "Whitelist of HTTP methods to replay. Anything else will be dropped:\n\tgor --input-raw :8080 --output-http staging.com --http-allow-method GET --http-allow-method OPTIONS")
-	flag.Var(&Settings.modifierConfig.methods, "output-http-method", "WARNING: `--output-http-method` DEPRECATED, use `--http-allow-method` instead")
+	fs.Var(&s.modifierConfig.grpcMethods, "grpc-allow-method", "A regexp to match gRPC method `/package.Service/Method` against. Anything else, including non-gRPC requests, will be dropped:\n\t gor --input-raw :50051 --output-http staging.com:50051 --output-http-http2 --grpc-allow-method ^/helloworld.Greeter/")
 
-	flag.Var(&Settings.modifierConfig.urlRegexp, "http-allow-url", "A regexp to match requests against. Filter get matched against full url with domain. Anything else will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-url ^www.")
-	flag.Var(&Settings.modifierConfig.urlRegexp, "output-http-url-regexp", "WARNING: `--output-http-url-regexp` DEPRECATED, use `--http-allow-url` instead")
+	fs.Var(&s.modifierConfig.grpcNegativeMethods, "grpc-disallow-method", "A regexp to match gRPC method `/package.Service/Method` against. Matching gRPC requests will be dropped:\n\t gor --input-raw :50051 --output-http staging.com:50051 --output-http-http2 --grpc-disallow-method /Delete")
 
-	flag.Var(&Settings.modifierConfig.urlNegativeRegexp, "http-disallow-url", "A regexp to match requests against. Filter get matched against full url with domain. Anything else will be forwarded:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-url ^www.")
+	fs.Var(&s.modifierConfig.keyRegexp, "protocol-allow-key", "A regexp to match request key against, like `GET /path` for HTTP, or `GET user:1` for Redis. Anything else will be dropped:\n\t gor --input-raw :6379 --input-raw-protocol redis --output-tcp-raw staging:6379 --protocol-allow-key '^(GET|HGET) '")
+	fs.Var(&s.modifierConfig.keyNegativeRegexp, "protocol-disallow-key", "A regexp to match request key against, like `GET /path` for HTTP, or `GET user:1` for Redis. Matching requests will be dropped:\n\t gor --input-raw :6379 --input-raw-protocol redis --output-tcp-raw staging:6379 --protocol-disallow-key '^(DEL|FLUSHALL)'")
 
-	flag.Var(&Settings.modifierConfig.urlRewrite, "http-rewrite-url", "Rewrite the request url based on a mapping:\n\tgor --input-raw :8080 --output-http staging.com --http-rewrite-url /v1/user/([^\\/]+)/ping:/v2/user/$1/ping")
-	flag.Var(&Settings.modifierConfig.urlRewrite, "output-http-rewrite-url", "WARNING: `--output-http-rewrite-url` DEPRECATED, use `--http-rewrite-url` instead")
+	fs.Var(&s.modifierConfig.urlRewrite, "http-rewrite-url", "Rewrite the request url based on a mapping:\n\tgor --input-raw :8080 --output-http staging.com --http-rewrite-url /v1/user/([^\\/]+)/ping:/v2/user/$1/ping")
+	fs.Var(&s.modifierConfig.urlRewrite, "output-http-rewrite-url", "WARNING: `--output-http-rewrite-url` DEPRECATED, use `--http-rewrite-url` instead")
 
-	flag.Var(&Settings.modifierConfig.headerFilters, "http-allow-header", "A regexp to match a specific header against. Requests with non-matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-header api-version:^v1")
-	flag.Var(&Settings.modifierConfig.headerFilters, "output-http-header-filter", "WARNING: `--output-http-header-filter` DEPRECATED, use `--http-allow-header` instead")
+	fs.Var(&s.modifierConfig.headerFilters, "http-allow-header", "A regexp to match a specific header against. Requests with non-matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-header api-version:^v1")
+	fs.Var(&s.modifierConfig.headerFilters, "output-http-header-filter", "WARNING: `--output-http-header-filter` DEPRECATED, use `--http-allow-header` instead")
 
-	flag.Var(&Settings.modifierConfig.headerNegativeFilters, "http-disallow-header", "A regexp to match a specific header against. Requests with matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-header \"User-Agent: Replayed by Gor\"")
+	fs.Var(&s.modifierConfig.headerNegativeFilters, "http-disallow-header", "A regexp to match a specific header against. Requests with matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-header \"User-Agent: Replayed by Gor\"")
 
-	flag.Var(&Settings.modifierConfig.headerBasicAuthFilters, "http-basic-auth-filter", "A regexp to match the decoded basic auth string against. Requests with non-matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-basic-auth-filter \"^customer[0-9].*\"")
+	fs.Var(&s.modifierConfig.headerBasicAuthFilters, "http-basic-auth-filter", "A regexp to match the decoded basic auth string against. Requests with non-matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-basic-auth-filter \"^customer[0-9].*\"")
 
-	flag.Var(&Settings.modifierConfig.headerHashFilters, "http-header-limiter", "Takes a fraction of requests, consistently taking or rejecting a request based on the FNV32-1A hash of a specific header:\n\t gor --input-raw :8080 --output-http staging.com --http-header-limiter user-id:25%")
+	fs.Var(&s.modifierConfig.headerHashFilters, "http-header-limiter", "Takes a fraction of requests, consistently taking or rejecting a request based on the FNV32-1A hash of a specific header:\n\t gor --input-raw :8080 --output-http staging.com --http-header-limiter user-id:25%")
 
-	flag.Var(&Settings.modifierConfig.headerHashFilters, "output-http-header-hash-filter", "WARNING: `output-http-header-hash-filter` DEPRECATED, use `--http-header-hash-limiter` instead")
+	fs.Var(&s.modifierConfig.headerHashFilters, "output-http-header-hash-filter", "WARNING: `output-http-header-hash-filter` DEPRECATED, use `--http-header-hash-limiter` instead")
 
-	flag.Var(&Settings.modifierConfig.paramHashFilters, "http-param-limiter", "Takes a fraction of requests, consistently taking or rejecting a request based on the FNV32-1A hash of a specific GET param:\n\t gor --input-raw :8080 --output-http staging.com --http-param-limiter user_id:25%")
+	fs.Var(&s.modifierConfig.paramHashFilters, "http-param-limiter", "Takes a fraction of requests, consistently taking or rejecting a request based on the FNV32-1A hash of a specific GET param:\n\t gor --input-raw :8080 --output-http staging.com --http-param-limiter user_id:25%")
 }
 
 var previousDebugTime int64
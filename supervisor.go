@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginError is a runtime failure of the plugin
+type PluginError struct {
+	Plugin interface{}
+	Err    error
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Plugin, e.Err)
+}
+
+// Errors receives runtime failures of plugins, which happen outside of emitter reads and writes,
+// like lost capture device or crashed middleware. Emitter applies restart policy of failed plugin,
+// and stops with error if plugin has no policy.
+var Errors = make(chan *PluginError, 100)
+
+// OnPluginError, if set, called for every plugin failure before restart policy is applied.
+// Useful when Gor is embedded into another program.
+var OnPluginError func(*PluginError)
+
+// reportError sends runtime failure of the plugin to the central error channel
+func reportError(plugin interface{}, err error) {
+	select {
+	case Errors <- &PluginError{plugin, err}:
+	default:
+		log.Println("Error channel is full, dropping error:", plugin, err)
+	}
+}
+
+// pluginError wraps failed read or write with identity of the plugin.
+// Errors of supervised plugins already handled by their restart policy, and returned as is.
+func pluginError(plugin interface{}, err error) error {
+	if pe, ok := err.(*PluginError); ok {
+		return pe
+	}
+
+	pe := &PluginError{unwrapPlugin(plugin), err}
+	if OnPluginError != nil {
+		OnPluginError(pe)
+	}
+
+	return pe
+}
+
+// unwrapPlugin returns plugin hidden behind limiter, pipeline tee or supervisor
+func unwrapPlugin(plugin interface{}) interface{} {
+	for {
+		switch p := plugin.(type) {
+		case *Limiter:
+			if p.plugin == nil {
+				return p
+			}
+			plugin = p.plugin
+		case *inputTee:
+			plugin = p.Reader
+		case *eofReader:
+			plugin = p.Reader
+		case interface{ instance() interface{} }:
+			plugin = p.instance()
+		default:
+			return plugin
+		}
+	}
+}
+
+// Restart policy actions
+const (
+	// Stop Gor with error, default
+	RestartAbort = "abort"
+	// Close failed plugin and continue without it
+	RestartDisable = "disable"
+	// Close failed plugin and create it again, using same options
+	RestartRetry = "retry"
+)
+
+// Delay before the first restart, doubled after each failed restart up to maxRestartBackoff
+var restartBackoff = time.Second
+
+const maxRestartBackoff = time.Minute
+
+// RestartPolicy defines what to do when plugin fails
+type RestartPolicy struct {
+	Action string
+	// Retry policy gives up, and stops Gor, after this number of restarts in a row. 0 means no limit.
+	MaxRetries int
+}
+
+// parseRestartPolicies parses `--restart-policy` values, like `output-http=retry:5`, `input-raw=disable`, or `retry`.
+// Value without plugin name sets default policy, using `*` key.
+func parseRestartPolicies(options []string) (map[string]RestartPolicy, error) {
+	policies := make(map[string]RestartPolicy)
+
+	for _, option := range options {
+		kind, value := "*", option
+		if i := strings.IndexByte(option, '='); i != -1 {
+			kind, value = option[:i], option[i+1:]
+		}
+
+		var p RestartPolicy
+		p.Action = value
+
+		if strings.HasPrefix(value, RestartRetry+":") {
+			p.Action = RestartRetry
+			n, err := strconv.Atoi(value[len(RestartRetry)+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("wrong number of retries in restart policy %q", option)
+			}
+			p.MaxRetries = n
+		}
+
+		switch p.Action {
+		case RestartAbort, RestartDisable, RestartRetry:
+		default:
+			return nil, fmt.Errorf("unknown restart policy %q, should be one of: abort, disable, retry, retry:N", option)
+		}
+
+		policies[kind] = p
+	}
+
+	return policies, nil
+}
+
+var supervisorsMu sync.Mutex
+
+// Plugin instance -> its supervisor
+var supervisors = make(map[interface{}]*supervisedPlugin)
+
+// supervisedPlugin wraps plugin which has retry or disable restart policy.
+// Failed plugin is closed, and either created again with the same constructor and options, or disabled.
+// While plugin is restarting reads wait, and writes are dropped.
+type supervisedPlugin struct {
+	kind   string
+	policy RestartPolicy
+	create func() (plugin, wrapper interface{}, err error)
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// Instance, used for closing and reporting
+	plugin interface{}
+	// Instance, maybe wrapped by limiter, used for reading and writing
+	wrapper interface{}
+
+	restarting  bool
+	disabled    bool
+	gaveUp      bool
+	retries     int
+	lastRestart time.Time
+}
+
+func newSupervisedPlugin(kind string, policy RestartPolicy, plugin, wrapper interface{}, create func() (interface{}, interface{}, error)) *supervisedPlugin {
+	s := &supervisedPlugin{
+		kind:        kind,
+		policy:      policy,
+		create:      create,
+		plugin:      plugin,
+		wrapper:     wrapper,
+		lastRestart: time.Now(),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	supervisorsMu.Lock()
+	supervisors[plugin] = s
+	supervisorsMu.Unlock()
+
+	return s
+}
+
+// handlePluginError applies restart policy, returns true if Gor should stop
+func handlePluginError(e *PluginError) bool {
+	if OnPluginError != nil {
+		OnPluginError(e)
+	}
+
+	supervisorsMu.Lock()
+	s := supervisors[e.Plugin]
+	supervisorsMu.Unlock()
+
+	if s == nil {
+		return true
+	}
+
+	return s.fail(e.Plugin, e.Err)
+}
+
+// current returns instance used for reading and writing, waiting while plugin restarts if wait is true.
+// Returns nil if plugin disabled.
+func (s *supervisedPlugin) current(wait bool) (plugin, wrapper interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.restarting && wait {
+		s.cond.Wait()
+	}
+
+	if s.disabled || s.restarting {
+		return nil, nil
+	}
+
+	return s.plugin, s.wrapper
+}
+
+// instance returns the current, or the last failed, instance of the plugin
+func (s *supervisedPlugin) instance() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.plugin
+}
+
+// fail applies restart policy to the failed instance. Returns true if Gor should stop.
+func (s *supervisedPlugin) fail(instance interface{}, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gaveUp {
+		return true
+	}
+
+	// Already handled, e.g. both reported error and returned it from Read
+	if instance != s.plugin || s.disabled || s.restarting {
+		return false
+	}
+
+	if s.policy.Action == RestartAbort {
+		return true
+	}
+
+	log.Printf("[SUPERVISOR] %s failed: %v", s.plugin, err)
+
+	if c, ok := s.plugin.(io.Closer); ok {
+		c.Close()
+	}
+
+	if s.policy.Action == RestartDisable {
+		log.Printf("[SUPERVISOR] Disabling %s", s.plugin)
+		s.disabled = true
+		s.cond.Broadcast()
+		return false
+	}
+
+	// Plugin which worked long enough starts counting from scratch
+	if time.Since(s.lastRestart) > maxRestartBackoff {
+		s.retries = 0
+	}
+
+	s.restarting = true
+	go s.restart()
+
+	return false
+}
+
+func (s *supervisedPlugin) restart() {
+	for {
+		s.mu.Lock()
+		s.retries++
+		retries := s.retries
+
+		if s.policy.MaxRetries > 0 && retries > s.policy.MaxRetries {
+			s.gaveUp = true
+			s.restarting = false
+			s.disabled = true
+			s.cond.Broadcast()
+			plugin := s.plugin
+			s.mu.Unlock()
+
+			reportError(plugin, fmt.Errorf("giving up after %d restarts", s.policy.MaxRetries))
+			return
+		}
+		s.mu.Unlock()
+
+		backoff := restartBackoff << uint(retries-1)
+		if backoff > maxRestartBackoff || backoff <= 0 {
+			backoff = maxRestartBackoff
+		}
+
+		log.Printf("[SUPERVISOR] Restarting %s in %s", s.kind, backoff)
+		time.Sleep(backoff)
+
+		plugin, wrapper, err := s.create()
+
+		s.mu.Lock()
+		// Closed during shutdown
+		if s.disabled {
+			s.mu.Unlock()
+			if c, ok := plugin.(io.Closer); ok && err == nil {
+				c.Close()
+			}
+			return
+		}
+
+		if err != nil {
+			s.mu.Unlock()
+			log.Printf("[SUPERVISOR] Can't restart %s: %v", s.kind, err)
+			continue
+		}
+
+		supervisorsMu.Lock()
+		supervisors[plugin] = s
+		supervisorsMu.Unlock()
+
+		s.plugin, s.wrapper = plugin, wrapper
+		s.restarting = false
+		s.lastRestart = time.Now()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+
+		log.Printf("[SUPERVISOR] Restarted %s", plugin)
+		return
+	}
+}
+
+func (s *supervisedPlugin) read(data []byte) (int, error) {
+	for {
+		plugin, wrapper := s.current(true)
+		if wrapper == nil {
+			return 0, io.EOF
+		}
+
+		n, err := wrapper.(io.Reader).Read(data)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		if pe := (&PluginError{plugin, err}); handlePluginError(pe) {
+			return n, pe
+		}
+	}
+}
+
+func (s *supervisedPlugin) write(data []byte) (int, error) {
+	plugin, wrapper := s.current(false)
+	if wrapper == nil {
+		return 0, nil
+	}
+
+	n, err := wrapper.(io.Writer).Write(data)
+	if err != nil {
+		if pe := (&PluginError{plugin, err}); handlePluginError(pe) {
+			return n, pe
+		}
+	}
+
+	return n, nil
+}
+
+// Drain delegates to the current instance
+func (s *supervisedPlugin) Drain(deadline time.Time) error {
+	if plugin, _ := s.current(false); plugin != nil {
+		if d, ok := plugin.(Drainer); ok {
+			return d.Drain(deadline)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the current instance, and stops restarts
+func (s *supervisedPlugin) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wasDisabled := s.disabled
+	s.disabled = true
+	s.cond.Broadcast()
+
+	if c, ok := s.plugin.(io.Closer); ok && !wasDisabled && !s.restarting {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (s *supervisedPlugin) gauges() map[string]int64 {
+	if plugin, _ := s.current(false); plugin != nil {
+		if g, ok := plugin.(pluginGauges); ok {
+			return g.gauges()
+		}
+	}
+
+	return nil
+}
+
+func (s *supervisedPlugin) String() string {
+	return fmt.Sprint(s.instance())
+}
+
+// Supervised plugins expose only Read or Write of the original plugin, so emitter treats them the same way
+
+type supervisedInput struct {
+	*supervisedPlugin
+}
+
+func (s supervisedInput) Read(data []byte) (int, error) {
+	return s.read(data)
+}
+
+type supervisedOutput struct {
+	*supervisedPlugin
+}
+
+func (s supervisedOutput) Write(data []byte) (int, error) {
+	return s.write(data)
+}
+
+// supervisedReadWriter wraps outputs which return responses
+type supervisedReadWriter struct {
+	*supervisedPlugin
+}
+
+func (s supervisedReadWriter) Read(data []byte) (int, error) {
+	return s.read(data)
+}
+
+func (s supervisedReadWriter) Write(data []byte) (int, error) {
+	return s.write(data)
+}
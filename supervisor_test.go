@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyInput emits few requests and then fails
+type flakyInput struct {
+	left   int
+	closed int32
+}
+
+func newFlakyInput(address string, left int) *flakyInput {
+	return &flakyInput{left: left}
+}
+
+func (i *flakyInput) Read(data []byte) (int, error) {
+	if i.left == 0 {
+		return 0, errors.New("connection lost")
+	}
+	i.left--
+
+	payload := append(payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(), -1), []byte("GET / HTTP/1.1\r\n\r\n")...)
+
+	return copy(data, payload), nil
+}
+
+func (i *flakyInput) Close() error {
+	atomic.StoreInt32(&i.closed, 1)
+	return nil
+}
+
+func (i *flakyInput) String() string {
+	return "Flaky input"
+}
+
+// brokenOutput fails on every write
+type brokenOutput struct{}
+
+func newBrokenOutput() *brokenOutput {
+	return &brokenOutput{}
+}
+
+func (o *brokenOutput) Write(data []byte) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (o *brokenOutput) String() string {
+	return "Broken output"
+}
+
+func startTestPlugins(t *testing.T, plugins *InOutPlugins) chan error {
+	Plugins = plugins
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Start(make(chan int))
+	}()
+
+	return done
+}
+
+func TestParseRestartPolicies(t *testing.T) {
+	policies, err := parseRestartPolicies([]string{"disable", "output-http=retry:5", "input-raw=retry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := policies["*"]; p.Action != RestartDisable {
+		t.Error("Policy without plugin name should be default:", p)
+	}
+
+	if p := policies["output-http"]; p.Action != RestartRetry || p.MaxRetries != 5 {
+		t.Error("Wrong output-http policy:", p)
+	}
+
+	if p := policies["input-raw"]; p.Action != RestartRetry || p.MaxRetries != 0 {
+		t.Error("Wrong input-raw policy:", p)
+	}
+
+	for _, wrong := range []string{"restart", "output-http=retry:0", "retry:x"} {
+		if _, err := parseRestartPolicies([]string{wrong}); err == nil {
+			t.Error("Should fail on wrong policy:", wrong)
+		}
+	}
+}
+
+func TestRegisterPluginError(t *testing.T) {
+	plugins := new(InOutPlugins)
+
+	err := plugins.registerPlugin("input-tcp", NewTCPInput, "wrong address", &TCPInputConfig{})
+	if err == nil || !strings.HasPrefix(err.Error(), "--input-tcp wrong address:") {
+		t.Error("Should return constructor error with plugin name:", err)
+	}
+
+	if len(plugins.All) != 0 {
+		t.Error("Failed plugin should not be registered")
+	}
+}
+
+func TestRestartPolicyAbort(t *testing.T) {
+	plugins := new(InOutPlugins)
+	plugins.registerPlugin("input-flaky", newFlakyInput, "", 1)
+	plugins.registerPlugin("output-broken", newBrokenOutput)
+
+	var reported *PluginError
+	OnPluginError = func(e *PluginError) { reported = e }
+	defer func() { OnPluginError = nil }()
+
+	err := <-startTestPlugins(t, plugins)
+
+	pe, ok := err.(*PluginError)
+	if !ok || pe.Plugin != plugins.Outputs[0] || pe.Err.Error() != "connection refused" {
+		t.Error("Should stop with error of failed plugin:", err)
+	}
+
+	if reported != pe {
+		t.Error("Error hook should be called:", reported)
+	}
+}
+
+func TestRestartPolicyRetry(t *testing.T) {
+	backoff := restartBackoff
+	restartBackoff = time.Millisecond
+	defer func() { restartBackoff = backoff }()
+
+	var received int32
+	output := NewTestOutput(func(data []byte) {
+		atomic.AddInt32(&received, 1)
+	})
+
+	plugins := &InOutPlugins{policies: map[string]RestartPolicy{"input-flaky": {RestartRetry, 2}}}
+	plugins.registerPlugin("input-flaky", newFlakyInput, "", 3)
+	plugins.registerPlugin("output-test", func() *TestOutput { return output })
+
+	first := unwrapPlugin(plugins.Inputs[0]).(*flakyInput)
+
+	err := <-startTestPlugins(t, plugins)
+
+	if pe, ok := err.(*PluginError); !ok || !strings.Contains(pe.Error(), "giving up after 2 restarts") {
+		t.Error("Should give up after 2 restarts:", err)
+	}
+
+	if atomic.LoadInt32(&first.closed) != 1 {
+		t.Error("Failed instance should be closed")
+	}
+
+	if n := atomic.LoadInt32(&received); n != 9 {
+		t.Error("Should read from restarted instances:", n)
+	}
+}
+
+func TestRestartPolicyDisable(t *testing.T) {
+	var received int32
+	output := NewTestOutput(func(data []byte) {
+		atomic.AddInt32(&received, 1)
+	})
+
+	plugins := &InOutPlugins{policies: map[string]RestartPolicy{"*": {Action: RestartDisable}}}
+	plugins.registerPlugin("input-flaky", newFlakyInput, "", 3)
+	plugins.registerPlugin("output-broken", newBrokenOutput)
+	plugins.registerPlugin("output-test", func() *TestOutput { return output })
+
+	// Input disabled after failure reaches the end, so emitter stops
+	select {
+	case err := <-startTestPlugins(t, plugins):
+		if err != nil {
+			t.Error("Disabled plugins should not stop Gor:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Should stop when disabled input reached the end")
+	}
+
+	if n := atomic.LoadInt32(&received); n != 3 {
+		t.Error("Other outputs should keep working:", n)
+	}
+
+	if _, err := plugins.Outputs[0].Write([]byte("1 1 1\nGET / HTTP/1.1\r\n\r\n")); err != nil {
+		t.Error("Writes to disabled output should be dropped:", err)
+	}
+}
+
+func TestReportError(t *testing.T) {
+	input := NewTestInput()
+
+	done := startTestPlugins(t, &InOutPlugins{
+		Inputs:  []io.Reader{input},
+		Outputs: []io.Writer{NewNullOutput()},
+		All:     []interface{}{input},
+	})
+
+	reportError(input, errors.New("device is gone"))
+
+	select {
+	case err := <-done:
+		if pe, ok := err.(*PluginError); !ok || pe.Plugin != input {
+			t.Error("Should stop on reported error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Reported error should stop plugin without restart policy")
+	}
+}
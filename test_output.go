@@ -22,5 +22,5 @@ func (i *TestOutput) Write(data []byte) (int, error) {
 }
 
 func (i *TestOutput) String() string {
-	return "Test Input"
+	return "Test Output"
 }